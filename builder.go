@@ -0,0 +1,344 @@
+package goturn
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"time"
+
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/turn"
+)
+
+// MessageBuilder assembles a message from typed attribute values. Setters can
+// be chained, and the first error encountered is reported by Build.
+//
+//	msg, err := goturn.NewMessageBuilder(goturn.AllocateRequest).
+//	  RequestedTransport("udp").
+//	  Lifetime(10 * time.Minute).
+//	  Authenticated().
+//	  Fingerprint().
+//	  Build()
+type MessageBuilder struct {
+	message       common.Message
+	authenticated bool
+	shortTerm     bool
+	fingerprint   bool
+	err           error
+}
+
+// NewMessageBuilder starts building a message of a given type, with a new
+// random transaction ID.
+func NewMessageBuilder(htype common.HeaderType) *MessageBuilder {
+	b := new(MessageBuilder)
+	b.message.Header.Type = htype
+	_, b.err = rand.Read(b.message.Header.Id[:])
+	return b
+}
+
+// NewResponseBuilder starts building a success response to the request with
+// a given header, using the same method and transaction ID.
+func NewResponseBuilder(request common.Header) *MessageBuilder {
+	b := new(MessageBuilder)
+	b.message.Header.Type = request.Type.Response()
+	b.message.Header.Id = request.Id
+	return b
+}
+
+// NewErrorResponseBuilder starts building an error response to the request
+// with a given header, including an ERROR-CODE attribute.
+func NewErrorResponseBuilder(request common.Header, code int, phrase string) *MessageBuilder {
+	b := new(MessageBuilder)
+	b.message.Header.Type = request.Type.ErrorResponse()
+	b.message.Header.Id = request.Id
+	return b.ErrorCode(code, phrase)
+}
+
+// Build provides the assembled message. Attributes appear in the order they
+// were set, followed by authentication attributes and the fingerprint.
+func (b *MessageBuilder) Build() (*common.Message, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	message := b.message
+	message.Attributes = append([]common.Attribute{}, b.message.Attributes...)
+	if b.authenticated {
+		message.Attributes = append(message.Attributes,
+			&stun.NonceAttribute{},
+			&stun.UsernameAttribute{},
+			&stun.RealmAttribute{},
+			&stun.MessageIntegrityAttribute{})
+	} else if b.shortTerm {
+		message.Attributes = append(message.Attributes,
+			&stun.UsernameAttribute{},
+			&stun.MessageIntegrityAttribute{})
+	}
+	if b.fingerprint {
+		message.Attributes = append(message.Attributes, &stun.FingerprintAttribute{})
+	}
+	return &message, nil
+}
+
+// Attribute adds an arbitrary attribute to the message.
+func (b *MessageBuilder) Attribute(attr common.Attribute) *MessageBuilder {
+	b.message.Attributes = append(b.message.Attributes, attr)
+	return b
+}
+
+// Credentials sets the credentials used when serializing the message.
+func (b *MessageBuilder) Credentials(credentials common.Credentials) *MessageBuilder {
+	b.message.Credentials = credentials
+	return b
+}
+
+// Authenticated adds NONCE, USERNAME, REALM and MESSAGE-INTEGRITY attributes to
+// the message. Their values are taken from the credentials of the message when
+// it is serialized.
+func (b *MessageBuilder) Authenticated() *MessageBuilder {
+	b.authenticated = true
+	return b
+}
+
+// ShortTermAuthenticated adds USERNAME and MESSAGE-INTEGRITY attributes to the
+// message, for short-term credentials without a realm or nonce, such as those
+// used by ICE connectivity checks.
+func (b *MessageBuilder) ShortTermAuthenticated() *MessageBuilder {
+	b.shortTerm = true
+	return b
+}
+
+// Fingerprint adds a FINGERPRINT attribute as the last attribute of the message.
+func (b *MessageBuilder) Fingerprint() *MessageBuilder {
+	b.fingerprint = true
+	return b
+}
+
+// addressParts provides the STUN family, port and IP of an address.
+func addressParts(addr net.Addr) (uint16, uint16, net.IP, error) {
+	address := common.Address{addr}
+	host := address.Host()
+	if len(host) == 0 {
+		return 0, 0, nil, errors.New("Address has no IP: " + addr.String())
+	}
+	if host.To4() != nil {
+		return 1, address.Port(), host.To4(), nil
+	}
+	return 2, address.Port(), host.To16(), nil
+}
+
+// MappedAddress adds a MAPPED-ADDRESS attribute to the message.
+func (b *MessageBuilder) MappedAddress(addr net.Addr) *MessageBuilder {
+	family, port, host, err := addressParts(addr)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.Attribute(&stun.MappedAddressAttribute{family, port, host})
+}
+
+// XorMappedAddress adds an XOR-MAPPED-ADDRESS attribute to the message.
+func (b *MessageBuilder) XorMappedAddress(addr net.Addr) *MessageBuilder {
+	family, port, host, err := addressParts(addr)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.Attribute(&stun.XorMappedAddressAttribute{family, port, host})
+}
+
+// XorPeerAddress adds an XOR-PEER-ADDRESS attribute to the message.
+func (b *MessageBuilder) XorPeerAddress(addr net.Addr) *MessageBuilder {
+	family, port, host, err := addressParts(addr)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.Attribute(&turn.XorPeerAddressAttribute{family, port, host})
+}
+
+// XorRelayedAddress adds an XOR-RELAYED-ADDRESS attribute to the message.
+func (b *MessageBuilder) XorRelayedAddress(addr net.Addr) *MessageBuilder {
+	family, port, host, err := addressParts(addr)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.Attribute(&turn.XorRelayedAddressAttribute{family, port, host})
+}
+
+// Software adds a SOFTWARE attribute describing the sending agent.
+func (b *MessageBuilder) Software(software string) *MessageBuilder {
+	return b.Attribute(&stun.SoftwareAttribute{software})
+}
+
+// ErrorCode adds an ERROR-CODE attribute, for a code between 300 and 699.
+func (b *MessageBuilder) ErrorCode(code int, phrase string) *MessageBuilder {
+	if code < 300 || code > 699 {
+		b.err = errors.New("Invalid Error Code")
+		return b
+	}
+	return b.Attribute(&stun.ErrorCodeAttribute{uint8(code / 100), uint8(code % 100), phrase})
+}
+
+// UnknownAttributes adds an UNKNOWN-ATTRIBUTES attribute listing types.
+func (b *MessageBuilder) UnknownAttributes(types ...common.AttributeType) *MessageBuilder {
+	attr := &stun.UnknownAttributesAttribute{}
+	for _, t := range types {
+		attr.Attributes = append(attr.Attributes, uint16(t))
+	}
+	return b.Attribute(attr)
+}
+
+// Lifetime adds a LIFETIME attribute, rounded down to the second.
+func (b *MessageBuilder) Lifetime(lifetime time.Duration) *MessageBuilder {
+	return b.Attribute(&turn.LifetimeAttribute{uint32(lifetime / time.Second)})
+}
+
+// RequestedTransport adds a REQUESTED-TRANSPORT attribute for a network, such
+// as "udp" or "tcp".
+func (b *MessageBuilder) RequestedTransport(network string) *MessageBuilder {
+	transport := uint8(17)
+	if network == "tcp" || network == "tcp4" || network == "tcp6" {
+		transport = 6
+	}
+	return b.Attribute(&turn.RequestedTransportAttribute{transport})
+}
+
+// RequestedAddressFamily adds a REQUESTED-ADDRESS-FAMILY attribute, asking for
+// a relayed address of family, such as turn.FamilyIPv6.
+func (b *MessageBuilder) RequestedAddressFamily(family uint16) *MessageBuilder {
+	return b.Attribute(&turn.RequestedAddressFamilyAttribute{family})
+}
+
+// AdditionalAddressFamily adds an ADDITIONAL-ADDRESS-FAMILY attribute, asking
+// for a relayed address of family in addition to an IPv4 one.
+func (b *MessageBuilder) AdditionalAddressFamily(family uint16) *MessageBuilder {
+	return b.Attribute(&turn.AdditionalAddressFamilyAttribute{family})
+}
+
+// AddressFamilies asks for relayed addresses of families in an Allocate
+// request. A single family is requested with REQUESTED-ADDRESS-FAMILY, while
+// IPv4 and IPv6 together request a dual-stack allocation with
+// ADDITIONAL-ADDRESS-FAMILY, per RFC 8656. Without families, nothing is added
+// and servers relay IPv4.
+func (b *MessageBuilder) AddressFamilies(families ...uint16) *MessageBuilder {
+	switch {
+	case len(families) == 0:
+		return b
+	case len(families) == 1:
+		return b.RequestedAddressFamily(families[0])
+	case len(families) == 2 && families[0] != families[1] &&
+		(families[0] == turn.FamilyIPv4 || families[0] == turn.FamilyIPv6) &&
+		(families[1] == turn.FamilyIPv4 || families[1] == turn.FamilyIPv6):
+		return b.AdditionalAddressFamily(turn.FamilyIPv6)
+	}
+	b.err = errors.New("Unsupported combination of address families")
+	return b
+}
+
+// AddressErrorCode adds an ADDRESS-ERROR-CODE attribute, reporting why a
+// relayed address of family could not be allocated.
+func (b *MessageBuilder) AddressErrorCode(family uint16, code int, phrase string) *MessageBuilder {
+	if code < 300 || code > 699 {
+		b.err = errors.New("Invalid Error Code")
+		return b
+	}
+	return b.Attribute(&turn.AddressErrorCodeAttribute{family, uint8(code / 100), uint8(code % 100), phrase})
+}
+
+// EvenPort adds an EVEN-PORT attribute, asking for a relayed address with an
+// even port, and optionally for the next port to be reserved.
+func (b *MessageBuilder) EvenPort(reserve bool) *MessageBuilder {
+	return b.Attribute(&turn.EvenPortAttribute{reserve})
+}
+
+// DontFragment adds a DONT-FRAGMENT attribute, asking the server to set the
+// Don't Fragment bit on relayed datagrams.
+func (b *MessageBuilder) DontFragment() *MessageBuilder {
+	return b.Attribute(&turn.DontFragmentAttribute{})
+}
+
+// ReservationToken adds a RESERVATION-TOKEN attribute.
+func (b *MessageBuilder) ReservationToken(token uint64) *MessageBuilder {
+	return b.Attribute(&turn.ReservationTokenAttribute{token})
+}
+
+// MobilityTicket adds a MOBILITY-TICKET attribute. An empty ticket asks the
+// server to issue one for a new allocation.
+func (b *MessageBuilder) MobilityTicket(ticket []byte) *MessageBuilder {
+	return b.Attribute(&turn.MobilityTicketAttribute{ticket})
+}
+
+// ChannelNumber adds a CHANNEL-NUMBER attribute.
+func (b *MessageBuilder) ChannelNumber(channel uint16) *MessageBuilder {
+	return b.Attribute(&turn.ChannelNumberAttribute{channel})
+}
+
+// ConnectionId adds a CONNECTION-ID attribute.
+func (b *MessageBuilder) ConnectionId(id uint32) *MessageBuilder {
+	return b.Attribute(&turn.ConnectionIdAttribute{id})
+}
+
+// Data adds a DATA attribute.
+func (b *MessageBuilder) Data(data []byte) *MessageBuilder {
+	return b.Attribute(&turn.DataAttribute{data})
+}
+
+// ChangeRequest adds a CHANGE-REQUEST attribute, asking the server to respond
+// from a different IP and/or port, per RFC 5780.
+func (b *MessageBuilder) ChangeRequest(changeIP, changePort bool) *MessageBuilder {
+	return b.Attribute(&stun.ChangeRequestAttribute{changeIP, changePort})
+}
+
+// ResponsePort adds a RESPONSE-PORT attribute, asking the server to respond to
+// a different port, per RFC 5780.
+func (b *MessageBuilder) ResponsePort(port uint16) *MessageBuilder {
+	return b.Attribute(&stun.ResponsePortAttribute{port})
+}
+
+// Padding adds a PADDING attribute with size bytes of padding.
+func (b *MessageBuilder) Padding(size uint16) *MessageBuilder {
+	return b.Attribute(&stun.PaddingAttribute{size})
+}
+
+// ResponseOrigin adds a RESPONSE-ORIGIN attribute to the message.
+func (b *MessageBuilder) ResponseOrigin(addr net.Addr) *MessageBuilder {
+	family, port, host, err := addressParts(addr)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.Attribute(&stun.ResponseOriginAttribute{family, port, host})
+}
+
+// OtherAddress adds an OTHER-ADDRESS attribute to the message.
+func (b *MessageBuilder) OtherAddress(addr net.Addr) *MessageBuilder {
+	family, port, host, err := addressParts(addr)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.Attribute(&stun.OtherAddressAttribute{family, port, host})
+}
+
+// Priority adds a PRIORITY attribute, used by ICE connectivity checks.
+func (b *MessageBuilder) Priority(priority uint32) *MessageBuilder {
+	return b.Attribute(&stun.PriorityAttribute{priority})
+}
+
+// UseCandidate adds a USE-CANDIDATE attribute, nominating the candidate pair an
+// ICE connectivity check is sent on.
+func (b *MessageBuilder) UseCandidate() *MessageBuilder {
+	return b.Attribute(&stun.UseCandidateAttribute{})
+}
+
+// IceControlling adds an ICE-CONTROLLING attribute with a role tie-breaker.
+func (b *MessageBuilder) IceControlling(tieBreaker uint64) *MessageBuilder {
+	return b.Attribute(&stun.IceControllingAttribute{tieBreaker})
+}
+
+// IceControlled adds an ICE-CONTROLLED attribute with a role tie-breaker.
+func (b *MessageBuilder) IceControlled(tieBreaker uint64) *MessageBuilder {
+	return b.Attribute(&stun.IceControlledAttribute{tieBreaker})
+}
@@ -0,0 +1,135 @@
+package goturn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/turn"
+)
+
+func TestMessageBuilderRoundtrip(t *testing.T) {
+	credentials := common.Credentials{Nonce: []byte("nonce"), Username: "user", Realm: "realm", Password: "pass"}
+	peer := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 4000}
+	msg, err := NewMessageBuilder(AllocateResponse).
+		XorMappedAddress(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 3478}).
+		XorRelayedAddress(&net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 50000}).
+		XorPeerAddress(peer).
+		Lifetime(10 * time.Minute).
+		Software("goturn").
+		Credentials(credentials).
+		Authenticated().
+		Fingerprint().
+		Build()
+	if err != nil {
+		t.Fatalf("Could not build message: %s", err)
+	}
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Could not serialize built message: %s", err)
+	}
+	parsed, err := ParseTurnStrict(data, &credentials)
+	if err != nil {
+		t.Fatalf("Could not parse built message: %s", err)
+	}
+
+	if addr, ok := stun.GetXorMappedAddress(parsed); !ok || addr.String() != "192.0.2.1:3478" {
+		t.Errorf("Unexpected mapped address %s", addr.String())
+	}
+	if addr, ok := turn.GetXorRelayedAddress(parsed); !ok || addr.String() != "198.51.100.1:50000" {
+		t.Errorf("Unexpected relayed address %s", addr.String())
+	}
+	if addr, ok := turn.GetXorPeerAddress(parsed); !ok || addr.String() != peer.String() {
+		t.Errorf("Unexpected peer address %s", addr.String())
+	}
+	if lifetime, ok := turn.GetLifetime(parsed); !ok || lifetime != 10*time.Minute {
+		t.Errorf("Unexpected lifetime %s", lifetime)
+	}
+	if software, ok := stun.GetSoftware(parsed); !ok || software != "goturn" {
+		t.Errorf("Unexpected software %s", software)
+	}
+	if username, ok := stun.GetUsername(parsed); !ok || username != "user" {
+		t.Errorf("Unexpected username %s", username)
+	}
+	if !stun.HasMessageIntegrity(parsed) || !stun.HasFingerprint(parsed) {
+		t.Error("Built message was not authenticated")
+	}
+	if _, ok := turn.GetData(parsed); ok {
+		t.Error("Found data in message without data attribute")
+	}
+}
+
+func TestAddAttributeBeforeIntegrity(t *testing.T) {
+	credentials := common.Credentials{Nonce: []byte("nonce"), Username: "user", Realm: "realm", Password: "pass"}
+	msg, err := NewMessageBuilder(RefreshRequest).
+		Lifetime(time.Minute).
+		Credentials(credentials).
+		Authenticated().
+		Fingerprint().
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.AddAttribute(&stun.SoftwareAttribute{"goturn"})
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseTurnStrict(data, &credentials)
+	if err != nil {
+		t.Fatalf("Could not parse message with added attribute: %s", err)
+	}
+	if software, ok := stun.GetSoftware(parsed); !ok || software != "goturn" {
+		t.Errorf("Unexpected software %s", software)
+	}
+	if !stun.HasMessageIntegrity(parsed) || !stun.HasFingerprint(parsed) {
+		t.Error("Message with added attribute was not authenticated")
+	}
+}
+
+func TestIceCheckRoundtrip(t *testing.T) {
+	credentials := common.Credentials{Username: "remote:local", Password: "password"}
+	msg, err := NewMessageBuilder(BindingRequest).
+		Priority(1845501695).
+		IceControlling(0x0102030405060708).
+		UseCandidate().
+		Credentials(credentials).
+		ShortTermAuthenticated().
+		Fingerprint().
+		Build()
+	if err != nil {
+		t.Fatalf("Could not build message: %s", err)
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatalf("Could not serialize built message: %s", err)
+	}
+	parsed, err := ParseTurnStrict(data, &common.Credentials{Password: "password"})
+	if err != nil {
+		t.Fatalf("Could not parse built message: %s", err)
+	}
+
+	if priority, ok := stun.GetPriority(parsed); !ok || priority != 1845501695 {
+		t.Errorf("Unexpected priority %d", priority)
+	}
+	if tieBreaker, ok := stun.GetIceControlling(parsed); !ok || tieBreaker != 0x0102030405060708 {
+		t.Errorf("Unexpected tie-breaker %x", tieBreaker)
+	}
+	if _, ok := stun.GetIceControlled(parsed); ok {
+		t.Error("Unexpected ICE-CONTROLLED attribute")
+	}
+	if !stun.HasUseCandidate(parsed) {
+		t.Error("Missing USE-CANDIDATE attribute")
+	}
+	if username, ok := stun.GetUsername(parsed); !ok || username != "remote:local" {
+		t.Errorf("Unexpected username %s", username)
+	}
+
+	if _, err := ParseTurnStrict(data, &common.Credentials{Password: "wrong"}); err == nil {
+		t.Error("Accepted check with the wrong password")
+	}
+}
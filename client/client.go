@@ -7,11 +7,22 @@ import (
 	"github.com/willscott/goturn"
 	"github.com/willscott/goturn/common"
 	stunattrs "github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/trace"
 	turnattrs "github.com/willscott/goturn/turn"
 	"net"
 	"time"
 )
 
+const (
+	// maxMessageLength is the largest message body accepted from the server.
+	maxMessageLength = 2048
+	// initialRTO is the retransmission timeout of requests sent over UDP,
+	// which doubles with each retransmission, and requestSends the most times
+	// a request is sent, as described in section 7.2.1 of RFC 5389.
+	initialRTO   = 500 * time.Millisecond
+	requestSends = 7
+)
+
 // StunClient maintains state on a connection with a stun/turn server.
 // New StunClient's should be created either by wrapping an existing net.Conn
 // Connection to a Stun Server (as shown in the getIP and reflexiveTurn
@@ -27,20 +38,49 @@ type StunClient struct {
 	// created to wrap the current net.Conn.
 	reader *bufio.Reader
 
-	// A net.Dialer is used to provide additional flexibility when Connect() is
+	// A Dialer is used to provide additional flexibility when Connect() is
 	// called, since a new connection to the server will need to be created. The
 	// StunClient defaults to using the same transport method for these subsequent
-	// connections, but this can be further specified with a custom Dialer.
-	*net.Dialer
+	// connections with a net.Dialer, but this can be further specified with a
+	// custom Dialer.
+	Dialer Dialer
 
 	// Credentials used for authenticating communication with the server.
 	*stun.Credentials
 
-	// Timeout until the active connection expires.
+	// Timeout until the active connection expires. Requests over UDP are
+	// retransmitted while waiting for their response until then; without a
+	// Timeout, they are sent once.
 	Timeout time.Duration
 
 	// Time until the next message must be received.
 	Deadline time.Time
+
+	// Ask TURN servers to set the Don't Fragment bit on datagrams relayed to
+	// peers, in Allocate requests and in the Send indications of a RelayConn.
+	DontFragment bool
+
+	// Ask TURN servers for a mobility ticket when allocating, with which the
+	// allocation can be moved to a new connection by Migrate, per RFC 8016.
+	Mobility bool
+	// The latest mobility ticket issued by the server for the allocation.
+	MobilityTicket []byte
+
+	// The SOFTWARE attribute added to requests, describing the client.
+	Software string
+	// The SOFTWARE value of the latest response from the server which had one.
+	ServerSoftware string
+	// Workarounds for server implementations, selected by ServerSoftware, such
+	// as those provided by DefaultQuirks.
+	Quirks QuirksTable
+
+	// Metrics is notified of the requests of the client and its RelayConns,
+	// including their retransmissions, when it is set.
+	Metrics Metrics
+	// Tracer is given each message sent and received, when it is set.
+	Tracer trace.Tracer
+	// The request awaiting a response, tracked for retransmission and Metrics.
+	pending *transaction
 }
 
 // deriveConnection creates a new connection to the same remote endpoint,
@@ -51,7 +91,11 @@ func (s *StunClient) deriveConnection() (*StunClient, error) {
 	other.Credentials = s.Credentials.ForNewConnection()
 	other.Timeout = s.Timeout
 
-	conn, err := s.Dialer.Dial(s.Conn.RemoteAddr().Network(), s.Conn.RemoteAddr().String())
+	dialer := s.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{Timeout: s.Timeout}
+	}
+	conn, err := dialer.Dial(s.Conn.RemoteAddr().Network(), s.Conn.RemoteAddr().String())
 	if err != nil {
 		return nil, err
 	}
@@ -65,6 +109,7 @@ func (s *StunClient) send(packet *stun.Message, err error) error {
 		return err
 	}
 	packet.Credentials = *s.Credentials
+	s.identify(packet)
 
 	message, err := packet.Serialize()
 	if err != nil {
@@ -76,14 +121,58 @@ func (s *StunClient) send(packet *stun.Message, err error) error {
 	if _, err = s.Conn.Write(message); err != nil {
 		return err
 	}
+	s.trace(trace.Sent, s.Conn, message)
+	s.sent(packet, message)
 	return nil
 }
 
+// trace describes a message sent or received over conn to the Tracer of the
+// client.
+func (s *StunClient) trace(direction trace.Direction, conn net.Conn, data []byte) {
+	if s.Tracer != nil {
+		s.Tracer.Trace(trace.NewEvent(direction, data, conn.LocalAddr(), conn.RemoteAddr()))
+	}
+}
+
+// identify adds the SOFTWARE attribute of the client to a request.
+func (s *StunClient) identify(packet *stun.Message) {
+	if s.Software != "" && packet.Header.Type.Class() == stun.ClassRequest {
+		packet.AddAttribute(&stunattrs.SoftwareAttribute{s.Software})
+	}
+}
+
+// observe records the SOFTWARE value a message from the server identifies it
+// with.
+func (s *StunClient) observe(msg *stun.Message, err error) (*stun.Message, error) {
+	if err != nil {
+		return nil, err
+	}
+	if software, ok := stunattrs.GetSoftware(msg); ok {
+		s.ServerSoftware = software
+	}
+	return msg, nil
+}
+
 // readStunPacket Reads the next packet off of the connection abstracted by the
 // client.
 // Returns either the next message, or an error if the next set of bytes
 // do not represent a valid message.
 func (s *StunClient) readStunPacket() (*stun.Message, error) {
+	data, err := s.readPacket()
+	for data == nil && s.retransmit(err) {
+		data, err = s.readPacket()
+	}
+	var msg *stun.Message
+	if data != nil {
+		s.trace(trace.Received, s.Conn, data)
+		msg, err = s.observe(goturn.ParseTurn(data, s.Credentials))
+	}
+	s.received(msg, err)
+	return msg, err
+}
+
+// readPacket reads the next packet for readStunPacket.
+func (s *StunClient) readPacket() ([]byte, error) {
 	// Set up timeouts for reading.
 	if s.reader == nil {
 		s.reader = bufio.NewReader(s.Conn)
@@ -93,7 +182,11 @@ func (s *StunClient) readStunPacket() (*stun.Message, error) {
 		if s.Deadline.IsZero() {
 			s.Deadline = time.Now().Add(s.Timeout)
 		}
-		s.Conn.SetReadDeadline(s.Deadline)
+		if retry, ok := s.retransmission(); ok {
+			s.Conn.SetReadDeadline(retry)
+		} else {
+			s.Conn.SetReadDeadline(s.Deadline)
+		}
 	}
 
 	// Start by reading the header to learn the length of the packet.
@@ -106,9 +199,13 @@ func (s *StunClient) readStunPacket() (*stun.Message, error) {
 		return nil, err
 	}
 	if header.Length == 0 {
-		return goturn.ParseTurn(h, s.Credentials)
+		buffer := append([]byte(nil), h...)
+		s.reader.Discard(20)
+		return buffer, nil
 	}
-	if header.Length > 2048 {
+	if header.Length > maxMessageLength {
+		// Discard the rest of the datagram so that the next one can be read.
+		s.reader.Discard(s.reader.Buffered())
 		return nil, errors.New("Packet length too long.")
 	}
 	buffer := make([]byte, 20+header.Length)
@@ -121,7 +218,41 @@ func (s *StunClient) readStunPacket() (*stun.Message, error) {
 		s.Deadline = time.Now().Add(s.Timeout)
 	}
 
-	return goturn.ParseTurn(buffer, s.Credentials)
+	return buffer, nil
+}
+
+// retransmission provides when the pending request is next retransmitted, if
+// that is before the deadline of the client.
+func (s *StunClient) retransmission() (time.Time, bool) {
+	p := s.pending
+	if s.Timeout <= 0 || p == nil || p.data == nil || p.sends >= requestSends || !p.retry.Before(s.Deadline) {
+		return time.Time{}, false
+	}
+	return p.retry, true
+}
+
+// retransmit sends the pending request again when reading failed because it
+// was due to be retransmitted, reporting whether it was.
+func (s *StunClient) retransmit(err error) bool {
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return false
+	}
+	if _, ok := s.retransmission(); !ok {
+		return false
+	}
+	p := s.pending
+	if _, err := s.Conn.Write(p.data); err != nil {
+		return false
+	}
+	s.trace(trace.Sent, s.Conn, p.data)
+	p.sends++
+	p.rto *= 2
+	p.retry = time.Now().Add(p.rto)
+	if s.Metrics != nil {
+		s.Metrics.Request(p.method, true)
+	}
+	return true
 }
 
 // Bind Requests a Stun "Binding" to retrieve the Internet-visible address of
@@ -148,6 +279,7 @@ func (s *StunClient) Bind() (net.Addr, error) {
 	if err != nil {
 		return nil, err
 	}
+	s.identify(packet)
 
 	message, err := packet.Serialize()
 	if err != nil {
@@ -158,6 +290,8 @@ func (s *StunClient) Bind() (net.Addr, error) {
 	if _, err = s.Conn.Write(message); err != nil {
 		return nil, err
 	}
+	s.trace(trace.Sent, s.Conn, message)
+	s.sent(packet, message)
 
 	response, err := s.readStunPacket()
 	if err != nil {
@@ -167,25 +301,15 @@ func (s *StunClient) Bind() (net.Addr, error) {
 	if response.Header.Type != goturn.BindingResponse {
 		return nil, errors.New("Unexpected response type.")
 	}
-	attr := response.GetAttribute(stunattrs.MappedAddress)
-	port := uint16(0)
-	address := net.IP{}
-
-  // extract the address if there is one.
-	if attr != nil {
-		addr := (*attr).(*stunattrs.MappedAddressAttribute)
-		port = addr.Port
-		address = addr.Address
-	} else {
-		attr = response.GetAttribute(stunattrs.XorMappedAddress)
-		if attr == nil {
+	// extract the address if there is one.
+	address, ok := stunattrs.GetMappedAddress(response)
+	if !ok {
+		address, ok = stunattrs.GetXorMappedAddress(response)
+		if !ok {
 			return nil, errors.New("No Mapped Address provided.")
 		}
-		addr := (*attr).(*stunattrs.XorMappedAddressAttribute)
-		port = addr.Port
-		address = addr.Address
 	}
-	return stun.NewAddress(s.Conn.RemoteAddr().Network(), address, port), nil
+	return stun.NewAddress(s.Conn.RemoteAddr().Network(), address.IP, uint16(address.Port)), nil
 }
 
 // allocateUnauthenticated sends a TURN Allocate request (A request for
@@ -213,8 +337,7 @@ func (s *StunClient) allocateUnauthenticated() error {
 	if len(response.Credentials.Realm) > 0 {
 		s.Credentials.Realm = response.Credentials.Realm
 	}
-	msgerr := stunattrs.GetError(response)
-	if msgerr.Error() > 0 && msgerr.Error() != 401 {
+	if msgerr, ok := stunattrs.GetError(response); ok && msgerr.Error() != 401 {
 		return errors.New("Initial Connection failed " + msgerr.String())
 	}
 	return nil
@@ -222,8 +345,69 @@ func (s *StunClient) allocateUnauthenticated() error {
 
 // Allocate Requests to connect to a TURN server. The TURN protocol uses the
 // term allocation to refer to an authenticated connection with the server.
-// Returns the bound address.
-func (s *StunClient) Allocate(c *stun.Credentials) (net.Addr, error) {
+// Address families, such as turn.FamilyIPv6, may be requested for the relayed
+// addresses; asking for both IPv4 and IPv6 makes a dual-stack allocation.
+// Returns every relayed address of the allocation. A dual-stack allocation
+// succeeds with a single address when the server can only relay one family.
+func (s *StunClient) Allocate(c *stun.Credentials, families ...uint16) ([]net.Addr, error) {
+	response, err := s.allocate(c, func(request *goturn.MessageBuilder) {
+		if s.ServerQuirks().NoAddressFamilies {
+			for _, family := range families {
+				if family == turnattrs.FamilyIPv4 {
+					return
+				}
+			}
+		}
+		request.AddressFamilies(families...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.relayedAddresses(response)
+}
+
+// AllocateEvenPort requests an allocation with an even relayed port, as used
+// by RTP. When reserve is set, the server also reserves the next port, and the
+// token returned can be used with AllocateReserved, from another connection,
+// to claim it for RTCP.
+func (s *StunClient) AllocateEvenPort(c *stun.Credentials, reserve bool) (net.Addr, uint64, error) {
+	response, err := s.allocate(c, func(request *goturn.MessageBuilder) {
+		request.EvenPort(reserve)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	addrs, err := s.relayedAddresses(response)
+	if err != nil {
+		return nil, 0, err
+	}
+	token, ok := turnattrs.GetReservationToken(response)
+	if reserve && !ok {
+		return nil, 0, errors.New("No Reservation Token provided.")
+	}
+	return addrs[0], token, nil
+}
+
+// AllocateReserved requests an allocation of the relayed port reserved by a
+// previous AllocateEvenPort.
+func (s *StunClient) AllocateReserved(c *stun.Credentials, token uint64) (net.Addr, error) {
+	response, err := s.allocate(c, func(request *goturn.MessageBuilder) {
+		request.ReservationToken(token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := s.relayedAddresses(response)
+	if err != nil {
+		return nil, err
+	}
+	return addrs[0], nil
+}
+
+// allocate authenticates with the server and sends an authenticated Allocate
+// request for the network of the connection, with attributes added by options,
+// returning the successful response.
+func (s *StunClient) allocate(c *stun.Credentials, options func(*goturn.MessageBuilder)) (*stun.Message, error) {
 	s.Credentials = c
 
 	if s.Credentials.Nonce == nil {
@@ -231,7 +415,17 @@ func (s *StunClient) Allocate(c *stun.Credentials) (net.Addr, error) {
 			return nil, err
 		}
 	}
-	if err := s.send(goturn.NewAllocateRequest(s.Conn.RemoteAddr().Network(), true)); err != nil {
+	request := goturn.NewMessageBuilder(goturn.AllocateRequest).
+		RequestedTransport(s.Conn.RemoteAddr().Network())
+	options(request)
+	quirks := s.ServerQuirks()
+	if s.DontFragment && !quirks.NoDontFragment {
+		request.DontFragment()
+	}
+	if s.Mobility && !quirks.NoMobility {
+		request.MobilityTicket(nil)
+	}
+	if err := s.send(request.Authenticated().Fingerprint().Build()); err != nil {
 		return nil, err
 	}
 	response, err := s.readStunPacket()
@@ -240,17 +434,103 @@ func (s *StunClient) Allocate(c *stun.Credentials) (net.Addr, error) {
 	}
 
 	if response.Header.Type != goturn.AllocateResponse {
-		msgerr := stunattrs.GetError(response)
-		if msgerr.Error() == 442 {
+		if msgerr, ok := stunattrs.GetError(response); ok && msgerr.Error() == 442 {
 			// TODO: bad transport; retry w/ other protocol.
 		}
-		return nil, errors.New("Connection failed: " + msgerr.String())
+		return nil, errors.New("Connection failed: " + stunattrs.ErrorReason(response))
 	}
+	if ticket, ok := turnattrs.GetMobilityTicket(response); ok {
+		s.MobilityTicket = ticket
+	}
+	return response, nil
+}
+
+// Migrate moves the allocation of the client to a new connection with the
+// server, such as one made after the local address of the client changed,
+// using the mobility ticket obtained when allocating with Mobility set. The
+// previous connection is closed once the allocation has moved, and is kept if
+// it could not be.
+func (s *StunClient) Migrate(conn net.Conn) error {
+	if s.MobilityTicket == nil {
+		return errors.New("No Mobility Ticket for the allocation.")
+	}
+	previous, reader := s.Conn, s.reader
+	s.Conn, s.reader = conn, nil
+
+	err := errors.New("Migration failed: Stale Nonce")
+	for attempt := 0; attempt < 2; attempt++ {
+		var response *stun.Message
+		if err = s.send(goturn.NewMobilityRefreshRequest(s.MobilityTicket)); err != nil {
+			break
+		}
+		if response, err = s.readStunPacket(); err != nil {
+			break
+		}
+		if response.Header.Type == goturn.RefreshResponse {
+			if ticket, ok := turnattrs.GetMobilityTicket(response); ok {
+				s.MobilityTicket = ticket
+			}
+			previous.Close()
+			return nil
+		}
+		// The nonce of the server is usually bound to the previous address.
+		err = errors.New("Migration failed: " + stunattrs.ErrorReason(response))
+		if msgerr, ok := stunattrs.GetError(response); ok && msgerr.Error() == 438 && response.Credentials.Nonce != nil {
+			s.Credentials.Nonce = response.Credentials.Nonce
+			continue
+		}
+		break
+	}
+	s.Conn, s.reader = previous, reader
+	return err
+}
 
-	relayAddr := response.GetAttribute(turnattrs.XorRelayedAddress)
-	relayAddress := (*relayAddr).(*turnattrs.XorRelayedAddressAttribute)
+// Refresh extends the lifetime of the allocation of the client, or deletes it
+// when lifetime is zero, returning the lifetime granted by the server.
+func (s *StunClient) Refresh(lifetime time.Duration) (time.Duration, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := s.send(goturn.NewRefreshRequest(lifetime)); err != nil {
+			return 0, err
+		}
+		response, err := s.readStunPacket()
+		if err != nil {
+			return 0, err
+		}
+		if response.Header.Type == goturn.RefreshResponse {
+			granted, _ := turnattrs.GetLifetime(response)
+			return granted, nil
+		}
+		if msgerr, ok := stunattrs.GetError(response); ok && msgerr.Error() == 438 && response.Credentials.Nonce != nil {
+			s.Credentials.Nonce = response.Credentials.Nonce
+			continue
+		}
+		return 0, errors.New("Refresh failed: " + stunattrs.ErrorReason(response))
+	}
+	return 0, errors.New("Refresh failed: Stale Nonce")
+}
 
-	return stun.NewAddress(s.Conn.RemoteAddr().Network(), relayAddress.Address, relayAddress.Port), nil
+// relayedAddresses provides the relayed addresses of an Allocate response.
+func (s *StunClient) relayedAddresses(response *stun.Message) ([]net.Addr, error) {
+	relayed := turnattrs.GetXorRelayedAddresses(response)
+	if len(relayed) == 0 {
+		if codes := turnattrs.GetAddressErrorCodes(response); len(codes) > 0 {
+			return nil, errors.New("Connection failed: " + codes[0].String())
+		}
+		return nil, errors.New("No Relayed Address provided.")
+	}
+
+	// The relayed addresses use the transport of the allocation, whatever the
+	// address family of the connection with the server.
+	network := s.Conn.RemoteAddr().Network()
+	addrs := make([]net.Addr, 0, len(relayed))
+	for _, addr := range relayed {
+		if network == "tcp" || network == "tcp4" || network == "tcp6" {
+			addrs = append(addrs, &net.TCPAddr{IP: addr.IP, Port: addr.Port})
+		} else {
+			addrs = append(addrs, &net.UDPAddr{IP: addr.IP, Port: addr.Port})
+		}
+	}
+	return addrs, nil
 }
 
 // RequestPermission secures permission to send data with a remote address. The
@@ -267,7 +547,7 @@ func (s *StunClient) RequestPermission(with net.Addr) error {
 	}
 
 	if response.Header.Type != goturn.CreatePermissionResponse {
-		return errors.New("Connection failed: " + stunattrs.GetError(response).String())
+		return errors.New("Connection failed: " + stunattrs.ErrorReason(response))
 	}
 	return nil
 }
@@ -285,15 +565,14 @@ func (s *StunClient) Connect(to net.Addr) (net.Conn, error) {
 	}
 
 	if response.Header.Type != goturn.ConnectResponse {
-		return nil, errors.New("Connection failed: " + stunattrs.GetError(response).String())
+		return nil, errors.New("Connection failed: " + stunattrs.ErrorReason(response))
 	}
 
 	// extract Connection-id
-	connID := response.GetAttribute(turnattrs.ConnectionId)
-	if connID == nil {
+	connectionID, ok := turnattrs.GetConnectionId(response)
+	if !ok {
 		return nil, errors.New("No Connection ID provided.")
 	}
-	connectionID := (*connID).(*turnattrs.ConnectionIdAttribute).ConnectionId
 
 	// create the data connection.
 	conn, err := s.deriveConnection()
@@ -330,7 +609,7 @@ func (s *StunClient) Connect(to net.Addr) (net.Conn, error) {
 
 	if response.Header.Type != goturn.ConnectionBindResponse {
 		conn.Conn.Close()
-		return nil, errors.New("Connection failed: " + stunattrs.GetError(response).String())
+		return nil, errors.New("Connection failed: " + stunattrs.ErrorReason(response))
 	}
 
 	return conn.Conn, nil
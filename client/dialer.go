@@ -6,6 +6,12 @@ import (
 	"time"
 )
 
+// A Dialer creates connections to the server, such as a net.Dialer, or a host
+// of a vnet.Network.
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
 // A TURN Dialer is an implementation of net.Dialer which connects through a
 // TURN relay.
 type TurnDialer struct {
@@ -28,11 +34,11 @@ func NewDialer(credentials *stun.Credentials, control net.Conn) (d *TurnDialer,
 	d = new(TurnDialer)
 	d.StunClient.Conn = control
 
-	addr, err := d.StunClient.Allocate(credentials)
+	addrs, err := d.StunClient.Allocate(credentials)
 	if err != nil {
 		return nil, err
 	}
-	d.LocalAddr = addr
+	d.LocalAddr = addrs[0]
 
 	//TODO: functional cancel channel.
 
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/trace"
+)
+
+// Keepalive periodically sends Binding messages over the connection of a
+// StunClient, so that the NAT binding used by the connection is not dropped
+// while it is idle, and reports when the mapped address of the connection
+// changes.
+//
+// While a Keepalive is running it reads from the connection, so the client
+// should not be used for other requests until it is stopped.
+//
+//	keepalive := &client.Keepalive{
+//	  Interval: 15 * time.Second,
+//	  OnChange: func(previous, current net.Addr) {
+//	    log.Printf("Mapped address changed from %s to %s", previous, current)
+//	  },
+//	}
+//	if err := keepalive.Start(stunClient); err != nil {
+//	  log.Fatal(err)
+//	}
+//	defer keepalive.Stop()
+type Keepalive struct {
+	// The time between Binding messages.
+	Interval time.Duration
+
+	// Send Binding indications, which the server does not answer, rather than
+	// requests. Indications use less traffic, but cannot detect changes to the
+	// mapped address or probe the binding lifetime.
+	Indications bool
+
+	// Called with the previous and current mapped address when the mapped
+	// address of the connection changes, such as when the NAT has dropped an
+	// idle binding and created a new one.
+	OnChange func(previous, current net.Addr)
+
+	// Called when a keepalive message cannot be sent or is not answered.
+	OnError func(err error)
+
+	// Probe for the lifetime of idle NAT bindings by doubling the interval after
+	// each request until the mapped address changes. The longest interval which
+	// kept the binding is reported to OnLifetime, and three quarters of it is
+	// then used as the keepalive interval.
+	//
+	// Expiry can only be detected when the NAT assigns a different address to
+	// the new binding; NATs which reuse the same port appear to never expire.
+	Probe bool
+
+	// The longest interval tried while probing. Defaults to 10 minutes.
+	MaxInterval time.Duration
+
+	// Called once probing has determined the binding lifetime.
+	OnLifetime func(lifetime time.Duration)
+
+	client  *StunClient
+	address net.Addr
+	lock    sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// defaultMaxInterval bounds the interval when probing binding lifetimes.
+const defaultMaxInterval = 10 * time.Minute
+
+// Start begins sending keepalives over the connection of a client. Unless
+// Indications are used, a Binding request is sent immediately to learn the
+// current mapped address, which is available from Address.
+func (k *Keepalive) Start(client *StunClient) error {
+	if k.Interval <= 0 {
+		return errors.New("Keepalive interval must be positive.")
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.stop != nil {
+		return errors.New("Keepalive already started.")
+	}
+	k.client = client
+	if !k.Indications {
+		address, err := k.bind()
+		if err != nil {
+			return err
+		}
+		k.address = address
+	}
+	k.stop = make(chan struct{})
+	k.done = make(chan struct{})
+	go k.run(k.stop, k.done)
+	return nil
+}
+
+// Stop ends the keepalive, waiting for any message in flight to complete.
+func (k *Keepalive) Stop() {
+	k.lock.Lock()
+	stop, done := k.stop, k.done
+	k.stop = nil
+	k.lock.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// Address provides the most recently learned mapped address.
+func (k *Keepalive) Address() net.Addr {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.address
+}
+
+// run sends keepalives until stop is closed.
+func (k *Keepalive) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	interval := k.Interval
+	probing := k.Probe && !k.Indications
+	maxInterval := k.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = defaultMaxInterval
+	}
+	// The longest interval known to keep the binding, and the shortest known
+	// to lose it.
+	var kept, lost time.Duration
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		if k.Indications {
+			if err := k.indicate(); err != nil && k.OnError != nil {
+				k.OnError(err)
+			}
+			timer.Reset(interval)
+			continue
+		}
+
+		changed, err := k.refresh()
+		if err != nil {
+			if k.OnError != nil {
+				k.OnError(err)
+			}
+			timer.Reset(interval)
+			continue
+		}
+
+		if probing {
+			if changed {
+				lost = interval
+			} else {
+				kept = interval
+			}
+			switch {
+			case kept > 0 && (lost > 0 || kept >= maxInterval):
+				probing = false
+				if k.OnLifetime != nil {
+					k.OnLifetime(kept)
+				}
+				interval = kept * 3 / 4
+			case lost > 0:
+				interval = lost / 2
+			default:
+				interval *= 2
+				if interval > maxInterval {
+					interval = maxInterval
+				}
+			}
+		}
+		timer.Reset(interval)
+	}
+}
+
+// refresh sends a Binding request, reporting whether the mapped address has
+// changed since the last one.
+func (k *Keepalive) refresh() (bool, error) {
+	address, err := k.bind()
+	if err != nil {
+		return false, err
+	}
+
+	k.lock.Lock()
+	previous := k.address
+	k.address = address
+	k.lock.Unlock()
+
+	if previous != nil && previous.String() == address.String() {
+		return false, nil
+	}
+	if k.OnChange != nil {
+		k.OnChange(previous, address)
+	}
+	return previous != nil, nil
+}
+
+// bind sends a Binding request, waiting for the client's Timeout, or the
+// keepalive Interval if the client has none, for the response.
+func (k *Keepalive) bind() (net.Addr, error) {
+	if k.client.Timeout > 0 {
+		k.client.Deadline = time.Now().Add(k.client.Timeout)
+	} else {
+		k.client.Conn.SetReadDeadline(time.Now().Add(k.Interval))
+		defer k.client.Conn.SetReadDeadline(time.Time{})
+	}
+	return k.client.Bind()
+}
+
+// indicate sends a Binding indication.
+func (k *Keepalive) indicate() error {
+	packet, err := goturn.NewBindingIndication()
+	if err != nil {
+		return err
+	}
+	message, err := packet.Serialize()
+	if err != nil {
+		return err
+	}
+	if _, err = k.client.Conn.Write(message); err != nil {
+		return err
+	}
+	k.client.trace(trace.Sent, k.client.Conn, message)
+	return nil
+}
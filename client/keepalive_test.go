@@ -0,0 +1,95 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/common"
+)
+
+// natServer answers Binding requests like a server behind a NAT which drops
+// bindings idle for longer than lifetime, reporting a new mapped port after
+// each expiry.
+func natServer(t *testing.T, lifetime time.Duration) net.PacketConn {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 2048)
+		port := 10000
+		var last time.Time
+		for {
+			n, from, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			request, err := goturn.ParseStun(buffer[0:n])
+			if err != nil || request.Header.Type != goturn.BindingRequest {
+				continue
+			}
+			if !last.IsZero() && time.Since(last) > lifetime {
+				port++
+			}
+			last = time.Now()
+			msg, _ := goturn.NewResponseBuilder(request.Header).
+				XorMappedAddress(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: port}).
+				Build()
+			data, _ := msg.Serialize()
+			conn.WriteTo(data, from)
+		}
+	}()
+	return conn
+}
+
+func TestKeepaliveProbe(t *testing.T) {
+	server := natServer(t, 300*time.Millisecond)
+	defer server.Close()
+	conn, err := net.Dial("udp4", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	changes := make(chan net.Addr, 10)
+	lifetimes := make(chan time.Duration, 1)
+	keepalive := &Keepalive{
+		Interval: 100 * time.Millisecond,
+		Probe:    true,
+		OnChange: func(previous, current net.Addr) {
+			changes <- current
+		},
+		OnLifetime: func(lifetime time.Duration) {
+			lifetimes <- lifetime
+		},
+		OnError: func(err error) {
+			t.Errorf("Keepalive failed: %s", err)
+		},
+	}
+	if err := keepalive.Start(&StunClient{Conn: conn, Credentials: &stun.Credentials{}}); err != nil {
+		t.Fatal(err)
+	}
+	defer keepalive.Stop()
+	if keepalive.Address().String() != "192.0.2.1:10000" {
+		t.Fatalf("Unexpected initial address %s", keepalive.Address())
+	}
+
+	select {
+	case lifetime := <-lifetimes:
+		if lifetime != 200*time.Millisecond {
+			t.Errorf("Unexpected binding lifetime %s", lifetime)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Binding lifetime not reported")
+	}
+	select {
+	case addr := <-changes:
+		if addr.String() != "192.0.2.1:10001" {
+			t.Errorf("Unexpected new address %s", addr)
+		}
+	default:
+		t.Error("Address change not reported")
+	}
+}
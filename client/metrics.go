@@ -0,0 +1,99 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/willscott/goturn/common"
+	stunattrs "github.com/willscott/goturn/stun"
+)
+
+// Metrics is notified of the transactions of a StunClient and its RelayConns,
+// such as to export them as metrics. Its methods may be called concurrently.
+type Metrics interface {
+	// Request is called when a request is sent, and again for each time it is
+	// retransmitted. Requests over UDP are retransmitted by RelayConns, and by
+	// StunClients with a Timeout.
+	Request(method stun.Method, retransmission bool)
+	// Response is called when the response to a request arrives, with the
+	// time since the request was first sent, and the error code of error
+	// responses, or 0 for success responses.
+	Response(method stun.Method, rtt time.Duration, code int)
+	// Timeout is called when no response to a request arrives.
+	Timeout(method stun.Method)
+}
+
+// transaction is the request of a StunClient awaiting its response.
+type transaction struct {
+	id     [12]byte
+	method stun.Method
+	sent   time.Time
+	// The serialized request, kept to retransmit requests sent over UDP.
+	data []byte
+	// How many times the request has been sent, the current retransmission
+	// timeout, and when the request is next retransmitted.
+	sends int
+	rto   time.Duration
+	retry time.Time
+}
+
+// sent notes a request sent by the client as data, to retransmit it over UDP
+// and for the metrics of the client.
+func (s *StunClient) sent(packet *stun.Message, data []byte) {
+	if packet.Header.Type.Class() != stun.ClassRequest {
+		return
+	}
+	now := time.Now()
+	s.pending = &transaction{
+		id:     packet.Header.Id,
+		method: packet.Header.Type.Method(),
+		sent:   now,
+		sends:  1,
+		rto:    initialRTO,
+		retry:  now.Add(initialRTO),
+	}
+	if strings.HasPrefix(s.Conn.RemoteAddr().Network(), "udp") {
+		s.pending.data = data
+	}
+	if s.Metrics != nil {
+		s.Metrics.Request(s.pending.method, false)
+	}
+}
+
+// received notes the response to the pending request, or the failure to
+// receive one, ending its retransmission and for the metrics of the client.
+func (s *StunClient) received(msg *stun.Message, err error) {
+	if s.pending == nil {
+		return
+	}
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			if s.Metrics != nil {
+				s.Metrics.Timeout(s.pending.method)
+			}
+			s.pending = nil
+		}
+		return
+	}
+	if msg == nil || msg.Header.Id != s.pending.id {
+		return
+	}
+	if s.Metrics != nil {
+		s.Metrics.Response(s.pending.method, time.Since(s.pending.sent), responseCode(msg))
+	}
+	s.pending = nil
+}
+
+// responseCode provides the error code of an error response, or 0.
+func responseCode(msg *stun.Message) int {
+	if msg.Header.Type.Class() != stun.ClassError {
+		return 0
+	}
+	if code, ok := stunattrs.GetError(msg); ok {
+		return code.Error()
+	}
+	return 0
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/internal/sockopt"
+	"github.com/willscott/goturn/trace"
+)
+
+const (
+	// defaultProbeTimeout bounds each path MTU probe when the client has no
+	// Timeout.
+	defaultProbeTimeout = time.Second
+	// probeAttempts is how many times a probe is sent before its size is
+	// considered too large for the path.
+	probeAttempts = 2
+	// minProbeSize is the smallest probe, a Binding request with an empty
+	// PADDING attribute.
+	minProbeSize = 24
+)
+
+// DiscoverPathMTU finds the largest UDP payload, between min and max bytes,
+// which reaches the server and back without fragmentation. It binary searches
+// with Binding requests carrying PADDING attributes, as described by RFC 5780,
+// treating sizes which are not answered as too large. The Don't Fragment bit
+// is set on the connection while probing where the platform allows it, and
+// its previous setting restored afterwards; elsewhere fragments are only
+// detected if a middlebox drops them. Servers echo the padding in their
+// responses, so max is limited to the largest message the client reads.
+//
+// The result only describes the path between the client and the server. The
+// path from a TURN server to its peers is not measured, and may be smaller;
+// datagrams sent through a RelayConn also carry 36 bytes of Send indication
+// overhead on the path to the server. The connection must not be in use by a
+// RelayConn.
+func (s *StunClient) DiscoverPathMTU(min, max int) (int, error) {
+	if min < minProbeSize {
+		min = minProbeSize
+	}
+	if max > 20+maxMessageLength {
+		max = 20 + maxMessageLength
+	}
+	if max < min {
+		return 0, errors.New("Invalid probe size range.")
+	}
+	if conn, ok := s.Conn.(*net.UDPConn); ok {
+		if restore, err := sockopt.SetDontFragment(conn); err == nil {
+			defer restore()
+		}
+	}
+	if s.Timeout <= 0 {
+		defer s.Conn.SetReadDeadline(time.Time{})
+	}
+
+	// Probes are a multiple of 4 bytes, the granularity of STUN attributes.
+	low, high := min&^3, max&^3
+	if low < min {
+		low += 4
+	}
+	if !s.probe(low) {
+		return 0, errors.New("No response to smallest probe.")
+	}
+	for low < high {
+		mid := (low + (high-low)/2 + 4) &^ 3
+		if mid > high {
+			mid = high
+		}
+		if s.probe(mid) {
+			low = mid
+		} else {
+			high = mid - 4
+		}
+	}
+	return low, nil
+}
+
+// probe sends a Binding request padded to size bytes, reporting whether it
+// was answered.
+func (s *StunClient) probe(size int) bool {
+	msg, err := goturn.NewMessageBuilder(goturn.BindingRequest).
+		Padding(uint16(size - minProbeSize)).
+		Build()
+	if err != nil {
+		return false
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		return false
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	// Probes are retried here rather than retransmitted by the client, and
+	// earlier requests are no longer awaited.
+	s.pending = nil
+	for attempt := 0; attempt < probeAttempts; attempt++ {
+		// Datagrams larger than the MTU known to the kernel fail to send once
+		// the Don't Fragment bit is set.
+		if _, err := s.Conn.Write(data); err != nil {
+			return false
+		}
+		s.trace(trace.Sent, s.Conn, data)
+		deadline := time.Now().Add(timeout)
+		if s.Timeout > 0 {
+			s.Deadline = deadline
+		} else {
+			s.Conn.SetReadDeadline(deadline)
+		}
+		for time.Now().Before(deadline) {
+			response, err := s.readStunPacket()
+			if err != nil {
+				if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+					break
+				}
+				continue
+			}
+			if response.Header.Id == msg.Header.Id {
+				return true
+			}
+		}
+	}
+	return false
+}
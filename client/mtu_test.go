@@ -0,0 +1,91 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/stun"
+)
+
+// mtuServer answers Binding requests no larger than limit bytes, like a server
+// behind a path which drops larger datagrams, echoing their padding.
+func mtuServer(t *testing.T, limit int) net.PacketConn {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 65536)
+		for {
+			n, from, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if n > limit {
+				continue
+			}
+			request, err := goturn.ParseStun(buffer[0:n])
+			if err != nil || request.Header.Type != goturn.BindingRequest {
+				continue
+			}
+			response := goturn.NewResponseBuilder(request.Header).XorMappedAddress(from)
+			if padding, ok := stun.GetPadding(request); ok {
+				response.Padding(padding)
+			}
+			msg, _ := response.Build()
+			data, _ := msg.Serialize()
+			conn.WriteTo(data, from)
+		}
+	}()
+	return conn
+}
+
+func TestDiscoverPathMTU(t *testing.T) {
+	server := mtuServer(t, 1202)
+	defer server.Close()
+
+	c, err := net.Dial("udp4", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := &StunClient{Conn: c, Timeout: 50 * time.Millisecond}
+
+	mtu, err := client.DiscoverPathMTU(576, 1500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtu != 1200 {
+		t.Errorf("Expected a path MTU of 1200, got %d", mtu)
+	}
+
+	if _, err := client.DiscoverPathMTU(1300, 1500); err == nil {
+		t.Error("Expected probing above the path MTU to fail")
+	}
+}
+
+func TestDiscoverPathMTULarge(t *testing.T) {
+	server := mtuServer(t, 65536)
+	defer server.Close()
+
+	c, err := net.Dial("udp4", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := &StunClient{Conn: c, Timeout: 50 * time.Millisecond}
+
+	// Responses to the largest probes are too long for the client to read.
+	mtu, err := client.DiscoverPathMTU(576, 4000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mtu < 2000 || mtu > 20+maxMessageLength {
+		t.Errorf("Expected a path MTU limited to the longest message, got %d", mtu)
+	}
+	if _, err := client.Bind(); err != nil {
+		t.Errorf("Client unusable after probing: %s", err)
+	}
+}
@@ -0,0 +1,308 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/common"
+	stunattrs "github.com/willscott/goturn/stun"
+)
+
+// NATMapping classifies how a NAT assigns external addresses to the traffic of
+// an internal endpoint, using the terms of RFC 4787.
+type NATMapping int
+
+const (
+	// MappingUnknown indicates the mapping behavior could not be determined.
+	MappingUnknown NATMapping = iota
+	// MappingNone indicates there is no NAT: the mapped address is local.
+	MappingNone
+	// MappingEndpointIndependent indicates the same external address is used
+	// for traffic to every destination.
+	MappingEndpointIndependent
+	// MappingAddressDependent indicates a new external address is used for
+	// each destination IP address.
+	MappingAddressDependent
+	// MappingAddressPortDependent indicates a new external address is used for
+	// each destination IP address and port, sometimes called a symmetric NAT.
+	MappingAddressPortDependent
+)
+
+func (m NATMapping) String() string {
+	switch m {
+	case MappingNone:
+		return "No NAT"
+	case MappingEndpointIndependent:
+		return "Endpoint-Independent"
+	case MappingAddressDependent:
+		return "Address-Dependent"
+	case MappingAddressPortDependent:
+		return "Address and Port-Dependent"
+	default:
+		return "Unknown"
+	}
+}
+
+// NATFiltering classifies which inbound traffic a NAT or firewall allows to
+// reach an internal endpoint, using the terms of RFC 4787.
+type NATFiltering int
+
+const (
+	// FilteringUnknown indicates the filtering behavior could not be determined.
+	FilteringUnknown NATFiltering = iota
+	// FilteringEndpointIndependent indicates traffic from any address is
+	// allowed once the endpoint has sent traffic to any destination.
+	FilteringEndpointIndependent
+	// FilteringAddressDependent indicates traffic is only allowed from IP
+	// addresses the endpoint has sent traffic to.
+	FilteringAddressDependent
+	// FilteringAddressPortDependent indicates traffic is only allowed from the
+	// exact IP addresses and ports the endpoint has sent traffic to.
+	FilteringAddressPortDependent
+)
+
+func (f NATFiltering) String() string {
+	switch f {
+	case FilteringEndpointIndependent:
+		return "Endpoint-Independent"
+	case FilteringAddressDependent:
+		return "Address-Dependent"
+	case FilteringAddressPortDependent:
+		return "Address and Port-Dependent"
+	default:
+		return "Unknown"
+	}
+}
+
+// NATBehavior is the result of RFC 5780 NAT behavior discovery.
+type NATBehavior struct {
+	// The primary address of the STUN server the tests were run against.
+	Server net.Addr
+	// The local address the tests were run from.
+	LocalAddress net.Addr
+	// The address the server saw requests coming from.
+	MappedAddress net.Addr
+	// The alternate address of the server, if it supports RFC 5780.
+	OtherAddress net.Addr
+
+	Mapping   NATMapping
+	Filtering NATFiltering
+}
+
+// errNoResponse indicates that no response arrived within the timeout, which
+// is an expected outcome of some of the behavior tests.
+var errNoResponse = errors.New("No response received.")
+
+// DiscoverNATBehavior runs the mapping and filtering tests of RFC 5780 against
+// a server supporting them. conn should be an unconnected UDP socket which is
+// not being read by anything else for the duration of the tests. timeout
+// bounds how long each test waits for a response.
+//
+// Servers which do not support RFC 5780 provide no OTHER-ADDRESS; the mapped
+// address is still reported, but the behavior will be unknown.
+func DiscoverNATBehavior(conn net.PacketConn, server net.Addr, timeout time.Duration) (*NATBehavior, error) {
+	behavior := &NATBehavior{Server: server, LocalAddress: conn.LocalAddr()}
+
+	// Test I: learn the mapped address and whether the server supports RFC 5780.
+	response, err := behaviorTest(conn, server, goturn.NewMessageBuilder(goturn.BindingRequest), timeout)
+	if err != nil {
+		return nil, err
+	}
+	mapped, ok := mappedAddress(response)
+	if !ok {
+		return nil, errors.New("No Mapped Address provided.")
+	}
+	behavior.MappedAddress = mapped
+	other, ok := stunattrs.GetOtherAddress(response)
+	if !ok {
+		return behavior, nil
+	}
+	behavior.OtherAddress = &other
+
+	// Filtering is tested first, since the mapping tests send to the alternate
+	// address and would open address-dependent filters to it.
+	if behavior.Filtering, err = discoverFiltering(conn, server, timeout); err != nil {
+		return nil, err
+	}
+
+	if isLocalAddress(mapped, conn.LocalAddr()) {
+		behavior.Mapping = MappingNone
+	} else if behavior.Mapping, err = discoverMapping(conn, server, mapped, &other, timeout); err != nil {
+		return nil, err
+	}
+	return behavior, nil
+}
+
+// discoverMapping runs tests II and III of section 4.3 of RFC 5780, comparing
+// the addresses mapped when sending to the alternate address of the server.
+func discoverMapping(conn net.PacketConn, server net.Addr, mapped *net.UDPAddr, other *net.UDPAddr, timeout time.Duration) (NATMapping, error) {
+	primary := stun.Address{server}
+	alternateIP := &net.UDPAddr{IP: other.IP, Port: int(primary.Port())}
+	response, err := behaviorTest(conn, alternateIP, goturn.NewMessageBuilder(goturn.BindingRequest), timeout)
+	if err == errNoResponse {
+		return MappingUnknown, nil
+	} else if err != nil {
+		return MappingUnknown, err
+	}
+	mapped2, ok := mappedAddress(response)
+	if !ok {
+		return MappingUnknown, nil
+	}
+	if sameAddress(mapped, mapped2) {
+		return MappingEndpointIndependent, nil
+	}
+
+	response, err = behaviorTest(conn, other, goturn.NewMessageBuilder(goturn.BindingRequest), timeout)
+	if err == errNoResponse {
+		return MappingUnknown, nil
+	} else if err != nil {
+		return MappingUnknown, err
+	}
+	mapped3, ok := mappedAddress(response)
+	if !ok {
+		return MappingUnknown, nil
+	}
+	if sameAddress(mapped2, mapped3) {
+		return MappingAddressDependent, nil
+	}
+	return MappingAddressPortDependent, nil
+}
+
+// discoverFiltering runs tests II and III of section 4.4 of RFC 5780, asking
+// the server to respond from its alternate addresses.
+func discoverFiltering(conn net.PacketConn, server net.Addr, timeout time.Duration) (NATFiltering, error) {
+	_, err := behaviorTest(conn, server, goturn.NewMessageBuilder(goturn.BindingRequest).ChangeRequest(true, true), timeout)
+	if err == nil {
+		return FilteringEndpointIndependent, nil
+	} else if err != errNoResponse {
+		return FilteringUnknown, err
+	}
+
+	_, err = behaviorTest(conn, server, goturn.NewMessageBuilder(goturn.BindingRequest).ChangeRequest(false, true), timeout)
+	if err == nil {
+		return FilteringAddressDependent, nil
+	} else if err != errNoResponse {
+		return FilteringUnknown, err
+	}
+	return FilteringAddressPortDependent, nil
+}
+
+// behaviorTest sends a request to a server, retransmitting it until a response
+// with a matching transaction ID arrives from any address, or timeout passes.
+func behaviorTest(conn net.PacketConn, to net.Addr, request *goturn.MessageBuilder, timeout time.Duration) (*stun.Message, error) {
+	msg, err := request.Build()
+	if err != nil {
+		return nil, err
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	buffer := make([]byte, 2048)
+	deadline := time.Now().Add(timeout)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.WriteTo(data, to); err != nil {
+			return nil, err
+		}
+		retransmit := time.Now().Add(timeout / 3)
+		if retransmit.After(deadline) {
+			retransmit = deadline
+		}
+		conn.SetReadDeadline(retransmit)
+		for {
+			n, _, err := conn.ReadFrom(buffer)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			response, err := goturn.ParseTurn(buffer[0:n], nil)
+			if err != nil || !bytes.Equal(response.Header.Id[:], msg.Header.Id[:]) {
+				continue
+			}
+			if response.Header.Type != goturn.BindingResponse {
+				return nil, errors.New("Behavior test failed: " + stunattrs.ErrorReason(response))
+			}
+			return response, nil
+		}
+	}
+	return nil, errNoResponse
+}
+
+// mappedAddress extracts the mapped address from a Binding response.
+func mappedAddress(response *stun.Message) (*net.UDPAddr, bool) {
+	addr, ok := stunattrs.GetXorMappedAddress(response)
+	if !ok {
+		addr, ok = stunattrs.GetMappedAddress(response)
+	}
+	return &addr, ok
+}
+
+// sameAddress compares two mapped addresses.
+func sameAddress(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+// isLocalAddress determines whether a mapped address is the address of the
+// local socket, meaning there is no NAT between it and the server.
+func isLocalAddress(mapped *net.UDPAddr, local net.Addr) bool {
+	addr := stun.Address{local}
+	if int(addr.Port()) != mapped.Port {
+		return false
+	}
+	if !addr.Host().IsUnspecified() {
+		return addr.Host().Equal(mapped.IP)
+	}
+	interfaces, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, iface := range interfaces {
+		if ipnet, ok := iface.(*net.IPNet); ok && ipnet.IP.Equal(mapped.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// String provides a report of the discovered behavior, explaining its
+// consequences for peer-to-peer connectivity.
+func (b *NATBehavior) String() string {
+	report := fmt.Sprintf("NAT behavior discovered using %s\n", b.Server)
+	report += fmt.Sprintf("Local address:  %s\n", b.LocalAddress)
+	report += fmt.Sprintf("Mapped address: %s\n", b.MappedAddress)
+	if b.OtherAddress == nil {
+		report += "The server does not support NAT behavior discovery (RFC 5780), so the behavior could not be determined.\n"
+		return report
+	}
+	report += fmt.Sprintf("Mapping:   %s\n", b.Mapping)
+	report += fmt.Sprintf("Filtering: %s\n", b.Filtering)
+
+	switch b.Mapping {
+	case MappingNone:
+		report += "The host has a public address, so peers can reach it at its local address.\n"
+	case MappingEndpointIndependent:
+		report += "The NAT uses the same external address for every destination, so the server-reflexive address is usable by peers and hole punching should succeed.\n"
+	case MappingAddressDependent, MappingAddressPortDependent:
+		report += "The NAT uses a different external address for each destination, so peers cannot use the server-reflexive address learned from STUN. " +
+			"Direct connections will only succeed if the peer has endpoint-independent filtering; otherwise a TURN relay is needed.\n"
+	default:
+		report += "The mapping behavior could not be determined because responses from the alternate address were lost.\n"
+	}
+
+	switch b.Filtering {
+	case FilteringEndpointIndependent:
+		report += "Inbound traffic is accepted from any address once the host has sent traffic, so peers can connect without coordination.\n"
+	case FilteringAddressDependent, FilteringAddressPortDependent:
+		report += "Inbound traffic is only accepted from addresses the host has already sent to, so both peers must send to each other at the same time (as ICE does) to connect.\n"
+	default:
+		report += "The filtering behavior could not be determined.\n"
+	}
+	return report
+}
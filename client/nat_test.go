@@ -0,0 +1,43 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn/server"
+)
+
+func TestDiscoverNATBehavior(t *testing.T) {
+	conns, err := server.ListenBehavior("udp4",
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)})
+	if err != nil {
+		t.Skipf("Could not listen on loopback addresses: %s", err)
+	}
+	s := server.NewServer()
+	go s.ServeBehavior(conns)
+	defer s.Close()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	behavior, err := DiscoverNATBehavior(conn, conns[0][0].LocalAddr(), time.Second)
+	if err != nil {
+		t.Fatalf("Discovery failed: %s", err)
+	}
+	primary := conns[0][0].LocalAddr().(*net.UDPAddr)
+	other := conns[1][1].LocalAddr().(*net.UDPAddr)
+	if conns[1][0].LocalAddr().(*net.UDPAddr).Port != primary.Port || other.Port == primary.Port {
+		t.Errorf("Behavior sockets did not share ports: %v", conns)
+	}
+	if behavior.OtherAddress.String() != other.String() {
+		t.Errorf("Unexpected other address %s", behavior.OtherAddress)
+	}
+	if behavior.Mapping != MappingNone || behavior.Filtering != FilteringEndpointIndependent {
+		t.Errorf("Unexpected behavior on loopback:\n%s", behavior)
+	}
+}
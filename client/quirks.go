@@ -0,0 +1,68 @@
+package client
+
+import (
+	"strings"
+)
+
+// Quirks are workarounds for the behavior of a particular server
+// implementation, applied by a StunClient once the SOFTWARE attribute of a
+// response from the server identifies it.
+type Quirks struct {
+	// Omit DONT-FRAGMENT from Allocate requests and Send indications, for
+	// servers which refuse allocations asking for it.
+	NoDontFragment bool
+	// Omit MOBILITY-TICKET from Allocate requests, for servers which refuse
+	// allocations asking for one rather than ignoring it.
+	NoMobility bool
+	// Omit REQUESTED-ADDRESS-FAMILY and ADDITIONAL-ADDRESS-FAMILY from Allocate
+	// requests which include IPv4, for servers predating RFC 8656 which reject
+	// them. Such allocations only relay IPv4.
+	NoAddressFamilies bool
+}
+
+// QuirksTable holds the quirks of server implementations, by a prefix of the
+// SOFTWARE value identifying them. Which workarounds an implementation needs
+// often depends on its version and configuration, so applications may extend
+// or replace the DefaultQuirks, such as for coturn servers configured without
+// DONT-FRAGMENT support:
+//
+//	client.QuirksTable{
+//	  "Coturn-": {NoDontFragment: true, NoMobility: true},
+//	}
+//
+// An empty prefix holds the quirks of servers which match no other prefix,
+// including those which do not send SOFTWARE.
+type QuirksTable map[string]Quirks
+
+// DefaultQuirks provides conservative workarounds for widely deployed server
+// implementations, which only give up optional extensions the servers are
+// known to refuse. Each call returns a new table, which the caller may modify.
+// It is not used unless set as the Quirks of a StunClient.
+func DefaultQuirks() QuirksTable {
+	return QuirksTable{
+		// coturn answers Allocate requests carrying MOBILITY-TICKET with 405
+		// (Mobility Forbidden) unless started with --mobility, which is not
+		// the default.
+		"Coturn-": {NoMobility: true},
+	}
+}
+
+// Lookup finds the quirks of the implementation identified by a SOFTWARE
+// value, from the longest matching prefix in the table.
+func (t QuirksTable) Lookup(software string) (Quirks, bool) {
+	var quirks Quirks
+	longest := -1
+	for prefix, q := range t {
+		if len(prefix) > longest && strings.HasPrefix(software, prefix) {
+			quirks, longest = q, len(prefix)
+		}
+	}
+	return quirks, longest >= 0
+}
+
+// ServerQuirks provides the quirks of the server, found in the Quirks of the
+// client by the SOFTWARE value the server last identified itself with.
+func (s *StunClient) ServerQuirks() Quirks {
+	quirks, _ := s.Quirks.Lookup(s.ServerSoftware)
+	return quirks
+}
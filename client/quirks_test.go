@@ -0,0 +1,82 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn/server"
+)
+
+func TestQuirksLookup(t *testing.T) {
+	table := QuirksTable{
+		"":            {NoAddressFamilies: true},
+		"Example":     {NoMobility: true},
+		"Example-2.0": {NoDontFragment: true},
+	}
+	if q, ok := table.Lookup("Example-2.0.1"); !ok || !q.NoDontFragment || q.NoMobility {
+		t.Errorf("Unexpected quirks for the longest prefix: %+v", q)
+	}
+	if q, ok := table.Lookup("Example-1.9"); !ok || !q.NoMobility {
+		t.Errorf("Unexpected quirks for a shorter prefix: %+v", q)
+	}
+	if q, ok := table.Lookup(""); !ok || !q.NoAddressFamilies {
+		t.Errorf("Unexpected default quirks: %+v", q)
+	}
+	if _, ok := QuirksTable(nil).Lookup("Example"); ok {
+		t.Error("Found quirks in an empty table")
+	}
+}
+
+func TestDefaultQuirks(t *testing.T) {
+	quirks := DefaultQuirks()
+	if q, ok := quirks.Lookup("Coturn-4.6.2 'Gorst'"); !ok || !q.NoMobility {
+		t.Errorf("Unexpected default quirks for coturn: %+v", q)
+	}
+	if _, ok := quirks.Lookup("goturn"); ok {
+		t.Error("Found default quirks for an unlisted server")
+	}
+
+	delete(quirks, "Coturn-")
+	if _, ok := DefaultQuirks().Lookup("Coturn-4.6.2 'Gorst'"); !ok {
+		t.Error("Changes to a default quirks table were shared")
+	}
+}
+
+func TestServerSoftware(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server.Server{
+		Software: "goturn-test 1.0",
+		Auth: func(username string) (string, bool) {
+			return "pass", username == "user"
+		},
+	}
+	go s.Serve(conn)
+	defer s.Close()
+
+	control, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer control.Close()
+	stunClient := &StunClient{
+		Conn:     control,
+		Timeout:  time.Second,
+		Software: "goturn-client",
+		Mobility: true,
+		Quirks:   QuirksTable{"goturn-test": {NoMobility: true}},
+	}
+	credentials := LongtermCredentials("user", "pass")
+	if _, err := stunClient.Allocate(&credentials); err != nil {
+		t.Fatal(err)
+	}
+	if stunClient.ServerSoftware != "goturn-test 1.0" {
+		t.Errorf("Unexpected server software %q", stunClient.ServerSoftware)
+	}
+	if stunClient.MobilityTicket != nil {
+		t.Error("Mobility ticket requested despite the quirks of the server")
+	}
+}
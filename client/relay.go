@@ -0,0 +1,436 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/common"
+	stunattrs "github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/trace"
+	turnattrs "github.com/willscott/goturn/turn"
+)
+
+const (
+	// relayRefreshInterval is how often the allocation and permissions of a
+	// RelayConn are refreshed. Permissions last 5 minutes, and allocations are
+	// requested for 10.
+	relayRefreshInterval = 4 * time.Minute
+	relayLifetime        = 10 * time.Minute
+
+	// defaultRelayTimeout bounds requests when the client has no Timeout.
+	defaultRelayTimeout = 3 * time.Second
+)
+
+// datagram is a packet received from a peer through the relay.
+type datagram struct {
+	data []byte
+	from net.Addr
+}
+
+// RelayConn is a net.PacketConn which exchanges datagrams with peers through
+// a UDP allocation on a TURN server, using Send and Data indications.
+// Permissions for peers are created when datagrams are first sent to them, and
+// the allocation and permissions are refreshed while the RelayConn is open.
+type RelayConn struct {
+	client  *StunClient
+	relayed net.Addr
+
+	lock            sync.Mutex
+	permissions     map[string]time.Time
+	pending         map[[12]byte]chan *stun.Message
+	readDeadline    time.Time
+	deadlineChanged chan struct{}
+
+	incoming  chan datagram
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRelayConn relays datagrams through the allocation of a client, which must
+// have been made over UDP with Allocate, returning a relayed address. The
+// RelayConn reads from the connection of the client, so the client should not
+// be used directly once the RelayConn has been created.
+//
+//	relayed, err := stunClient.Allocate(&credentials)
+//	if err != nil {
+//	  log.Fatal(err)
+//	}
+//	conn := client.NewRelayConn(stunClient, relayed[0])
+//	conn.WriteTo([]byte("hello"), peer)
+func NewRelayConn(client *StunClient, relayed net.Addr) *RelayConn {
+	r := &RelayConn{
+		client:          client,
+		relayed:         relayed,
+		permissions:     make(map[string]time.Time),
+		pending:         make(map[[12]byte]chan *stun.Message),
+		deadlineChanged: make(chan struct{}),
+		incoming:        make(chan datagram, 64),
+		closed:          make(chan struct{}),
+	}
+	// The RelayConn reads the connection from now on, without the deadlines
+	// the client read responses with.
+	client.Conn.SetReadDeadline(time.Time{})
+	go r.read(client.Conn)
+	go r.refresh()
+	return r
+}
+
+// ReadFrom reads the next datagram relayed from a peer.
+func (r *RelayConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		r.lock.Lock()
+		deadline, changed := r.readDeadline, r.deadlineChanged
+		r.lock.Unlock()
+
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+
+		var d datagram
+		var err error
+		select {
+		case d = <-r.incoming:
+		case <-expired:
+			err = os.ErrDeadlineExceeded
+		case <-changed:
+			// Wait again with the new deadline.
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		case <-r.closed:
+			err = net.ErrClosed
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		return copy(b, d.data), d.from, nil
+	}
+}
+
+// WriteTo relays a datagram to a peer, first creating a permission for the
+// peer if needed.
+func (r *RelayConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-r.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	peer := stun.Address{addr}
+	if err := r.permit(peer.Host()); err != nil {
+		return 0, err
+	}
+	indication := goturn.NewMessageBuilder(goturn.SendIndication).
+		XorPeerAddress(&net.UDPAddr{IP: peer.Host(), Port: int(peer.Port())}).
+		Data(b)
+	r.lock.Lock()
+	dontFragment := r.client.DontFragment && !r.client.ServerQuirks().NoDontFragment
+	r.lock.Unlock()
+	if dontFragment {
+		indication.DontFragment()
+	}
+	msg, err := indication.Build()
+	if err != nil {
+		return 0, err
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		return 0, err
+	}
+	conn := r.conn()
+	if _, err := conn.Write(data); err != nil {
+		return 0, err
+	}
+	r.client.trace(trace.Sent, conn, data)
+	return len(b), nil
+}
+
+// Close deletes the allocation and closes the connection to the server.
+func (r *RelayConn) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		r.request(func() (*stun.Message, error) {
+			return goturn.NewRefreshRequest(0)
+		})
+		close(r.closed)
+		err = r.conn().Close()
+	})
+	return err
+}
+
+// Migrate moves the allocation to a new connection with the server, such as
+// one made after the local address changed, using the mobility ticket issued
+// when the client allocated with Mobility set. The previous connection is
+// closed once the allocation has moved. If it could not be moved, the new
+// connection is closed instead and the RelayConn continues with the previous.
+func (r *RelayConn) Migrate(conn net.Conn) error {
+	r.lock.Lock()
+	previous, ticket := r.client.Conn, r.client.MobilityTicket
+	if ticket != nil {
+		r.client.Conn = conn
+	}
+	r.lock.Unlock()
+	if ticket == nil {
+		return errors.New("No Mobility Ticket for the allocation.")
+	}
+
+	go r.read(conn)
+	response, err := r.request(func() (*stun.Message, error) {
+		return goturn.NewMobilityRefreshRequest(ticket)
+	})
+	if err != nil {
+		r.lock.Lock()
+		r.client.Conn = previous
+		r.lock.Unlock()
+		conn.Close()
+		return err
+	}
+	if next, ok := turnattrs.GetMobilityTicket(response); ok {
+		r.lock.Lock()
+		r.client.MobilityTicket = next
+		r.lock.Unlock()
+	}
+	return previous.Close()
+}
+
+// LocalAddr provides the relayed address of the allocation, at which peers
+// can reach the RelayConn.
+func (r *RelayConn) LocalAddr() net.Addr {
+	return r.relayed
+}
+
+// SetDeadline sets the read and write deadlines of the RelayConn.
+func (r *RelayConn) SetDeadline(t time.Time) error {
+	r.SetReadDeadline(t)
+	return r.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the time after which ReadFrom fails with a timeout.
+func (r *RelayConn) SetReadDeadline(t time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.readDeadline = t
+	close(r.deadlineChanged)
+	r.deadlineChanged = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for writes to the server.
+func (r *RelayConn) SetWriteDeadline(t time.Time) error {
+	return r.conn().SetWriteDeadline(t)
+}
+
+// conn provides the current connection to the server.
+func (r *RelayConn) conn() net.Conn {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.client.Conn
+}
+
+// permit ensures a permission exists for a peer IP.
+func (r *RelayConn) permit(ip net.IP) error {
+	r.lock.Lock()
+	expiry, ok := r.permissions[ip.String()]
+	r.lock.Unlock()
+	if ok && time.Now().Before(expiry) {
+		return nil
+	}
+
+	if _, err := r.request(func() (*stun.Message, error) {
+		return goturn.NewPermissionRequest(&net.UDPAddr{IP: ip})
+	}); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	r.permissions[ip.String()] = time.Now().Add(relayRefreshInterval)
+	r.lock.Unlock()
+	return nil
+}
+
+// refresh keeps the allocation and permissions alive until the RelayConn is
+// closed.
+func (r *RelayConn) refresh() {
+	ticker := time.NewTicker(relayRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-ticker.C:
+		}
+		r.request(func() (*stun.Message, error) {
+			return goturn.NewRefreshRequest(relayLifetime)
+		})
+
+		r.lock.Lock()
+		var peers []net.IP
+		for ip := range r.permissions {
+			peers = append(peers, net.ParseIP(ip))
+			delete(r.permissions, ip)
+		}
+		r.lock.Unlock()
+		for _, ip := range peers {
+			r.permit(ip)
+		}
+	}
+}
+
+// request sends an authenticated request to the server, retransmitting it until
+// a response arrives. Requests rejected because the nonce is stale are retried
+// with the new nonce provided by the server.
+func (r *RelayConn) request(build func() (*stun.Message, error)) (*stun.Message, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		msg, err := build()
+		if err != nil {
+			return nil, err
+		}
+		r.lock.Lock()
+		msg.Credentials = *r.client.Credentials
+		r.client.identify(msg)
+		r.lock.Unlock()
+
+		response, err := r.transact(msg)
+		if err != nil {
+			return nil, err
+		}
+		if response.Header.Type.Class() != stun.ClassError {
+			return response, nil
+		}
+		if msgerr, ok := stunattrs.GetError(response); ok && msgerr.Error() == 438 && response.Credentials.Nonce != nil {
+			r.lock.Lock()
+			r.client.Credentials.Nonce = response.Credentials.Nonce
+			r.lock.Unlock()
+			continue
+		}
+		return nil, errors.New("Request failed: " + stunattrs.ErrorReason(response))
+	}
+	return nil, errors.New("Request failed: Stale Nonce")
+}
+
+// transact sends a request and waits for the reader to receive its response.
+func (r *RelayConn) transact(msg *stun.Message) (*stun.Message, error) {
+	data, err := msg.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	responses := make(chan *stun.Message, 1)
+	r.lock.Lock()
+	r.pending[msg.Header.Id] = responses
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		delete(r.pending, msg.Header.Id)
+		r.lock.Unlock()
+	}()
+
+	timeout := r.client.Timeout
+	if timeout <= 0 {
+		timeout = defaultRelayTimeout
+	}
+	metrics, method, sent := r.client.Metrics, msg.Header.Type.Method(), time.Now()
+	for attempt := 0; attempt < 3; attempt++ {
+		conn := r.conn()
+		if _, err := conn.Write(data); err != nil {
+			return nil, err
+		}
+		r.client.trace(trace.Sent, conn, data)
+		if metrics != nil {
+			metrics.Request(method, attempt > 0)
+		}
+		select {
+		case response := <-responses:
+			if metrics != nil {
+				metrics.Response(method, time.Since(sent), responseCode(response))
+			}
+			return response, nil
+		case <-time.After(timeout / 3):
+		case <-r.closed:
+			return nil, net.ErrClosed
+		}
+	}
+	if metrics != nil {
+		metrics.Timeout(method)
+	}
+	return nil, errors.New("No response received.")
+}
+
+// read receives messages from a connection to the server, delivering Data
+// indications to ReadFrom and responses to the requests waiting for them.
+func (r *RelayConn) read(conn net.Conn) {
+	buffer := make([]byte, 65536)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			if conn != r.conn() {
+				// The connection was replaced by Migrate.
+				return
+			}
+			select {
+			case <-r.closed:
+			default:
+				r.closeOnce.Do(func() {
+					close(r.closed)
+					conn.Close()
+				})
+			}
+			return
+		}
+
+		r.client.trace(trace.Received, conn, buffer[0:n])
+
+		r.lock.Lock()
+		credentials := *r.client.Credentials
+		r.lock.Unlock()
+		msg, err := goturn.ParseTurn(buffer[0:n], &credentials)
+		if err != nil {
+			continue
+		}
+		if msg.Header.Type.Class() != stun.ClassIndication {
+			r.lock.Lock()
+			r.client.observe(msg, nil)
+			r.lock.Unlock()
+		}
+
+		switch msg.Header.Type.Class() {
+		case stun.ClassIndication:
+			if msg.Header.Type != goturn.DataIndication {
+				continue
+			}
+			peer, ok := turnattrs.GetXorPeerAddress(msg)
+			data, hasData := turnattrs.GetData(msg)
+			if !ok || !hasData {
+				continue
+			}
+			select {
+			case r.incoming <- datagram{data, &peer}:
+			default:
+				// Datagrams are dropped when the application falls behind.
+			}
+		case stun.ClassSuccess, stun.ClassError:
+			r.lock.Lock()
+			responses, ok := r.pending[msg.Header.Id]
+			r.lock.Unlock()
+			if ok {
+				select {
+				case responses <- msg:
+				default:
+				}
+			}
+		}
+	}
+}
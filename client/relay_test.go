@@ -0,0 +1,183 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn/server"
+	"github.com/willscott/goturn/trace"
+)
+
+// relayServer starts a TURN server on the IPv4 loopback, accepting the user
+// "user" with the password "pass" and permitting peers on the loopback.
+func relayServer(t *testing.T) (*server.Server, net.PacketConn) {
+	return serveRelay(t, &server.Server{})
+}
+
+// serveRelay starts s as a relayServer, once it is otherwise configured.
+func serveRelay(t *testing.T, s *server.Server) (*server.Server, net.PacketConn) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loopback, _ := server.ParseNetworks("127.0.0.0/8")
+	s.Auth = func(username string) (string, bool) {
+		return "pass", username == "user"
+	}
+	s.Peers = func(string) *server.PeerPolicy {
+		return &server.PeerPolicy{Allow: loopback}
+	}
+	go s.Serve(conn)
+	return s, conn
+}
+
+// exchange checks datagrams are relayed in both directions between a
+// RelayConn and a peer.
+func exchange(t *testing.T, relay *RelayConn, peer net.PacketConn) {
+	peer.SetDeadline(time.Now().Add(time.Second))
+	relay.SetDeadline(time.Now().Add(time.Second))
+
+	if _, err := relay.WriteTo([]byte("ping"), peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 64)
+	n, from, err := peer.ReadFrom(buffer)
+	if err != nil || string(buffer[0:n]) != "ping" {
+		t.Fatalf("Peer did not receive relayed data: %v", err)
+	}
+	if from.String() != relay.LocalAddr().String() {
+		t.Errorf("Data relayed from %s rather than %s", from, relay.LocalAddr())
+	}
+
+	if _, err := peer.WriteTo([]byte("pong"), from); err != nil {
+		t.Fatal(err)
+	}
+	n, from, err = relay.ReadFrom(buffer)
+	if err != nil || string(buffer[0:n]) != "pong" {
+		t.Fatalf("Did not receive data from peer: %v", err)
+	}
+	if from.String() != peer.LocalAddr().String() {
+		t.Errorf("Data received from %s rather than %s", from, peer.LocalAddr())
+	}
+}
+
+func TestRelayConn(t *testing.T) {
+	s, conn := relayServer(t)
+	defer s.Close()
+
+	control, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stunClient := &StunClient{Conn: control, Timeout: time.Second}
+	credentials := LongtermCredentials("user", "pass")
+	relayed, err := stunClient.Allocate(&credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := NewRelayConn(stunClient, relayed[0])
+	defer relay.Close()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	exchange(t, relay, peer)
+}
+
+func TestRelayConnMigrate(t *testing.T) {
+	s, conn := relayServer(t)
+	defer s.Close()
+
+	control, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stunClient := &StunClient{Conn: control, Timeout: time.Second, Mobility: true}
+	credentials := LongtermCredentials("user", "pass")
+	relayed, err := stunClient.Allocate(&credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := NewRelayConn(stunClient, relayed[0])
+	defer relay.Close()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	exchange(t, relay, peer)
+
+	moved, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := relay.Migrate(moved); err != nil {
+		t.Fatal(err)
+	}
+	exchange(t, relay, peer)
+}
+
+// recorder is a tracer recording the types of the messages traced.
+type recorder struct {
+	lock  sync.Mutex
+	types []string
+}
+
+func (r *recorder) Trace(event *trace.Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.types = append(r.types, event.Direction.String()+" "+event.Message.Header.Type.String())
+}
+
+func (r *recorder) traced() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return strings.Join(r.types, ", ")
+}
+
+func TestTracer(t *testing.T) {
+	serverTrace := &recorder{}
+	s, conn := serveRelay(t, &server.Server{Tracer: serverTrace})
+	defer s.Close()
+
+	control, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTrace := &recorder{}
+	stunClient := &StunClient{Conn: control, Timeout: time.Second, Tracer: clientTrace}
+	credentials := LongtermCredentials("user", "pass")
+	relayed, err := stunClient.Allocate(&credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := NewRelayConn(stunClient, relayed[0])
+	defer relay.Close()
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	exchange(t, relay, peer)
+
+	expected := "sent Allocate Request, received Allocate Error Response, " +
+		"sent Allocate Request, received Allocate Success Response, " +
+		"sent CreatePermission Request, received CreatePermission Success Response, " +
+		"sent Send Indication, received Data Indication"
+	if traced := clientTrace.traced(); traced != expected {
+		t.Errorf("Client traced %s", traced)
+	}
+	expected = "received Allocate Request, sent Allocate Error Response, " +
+		"received Allocate Request, sent Allocate Success Response, " +
+		"received CreatePermission Request, sent CreatePermission Success Response, " +
+		"received Send Indication, sent Data Indication"
+	if traced := serverTrace.traced(); traced != expected {
+		t.Errorf("Server traced %s", traced)
+	}
+}
@@ -0,0 +1,333 @@
+// Command turnprobe exercises STUN and TURN servers, printing every message it
+// exchanges with them as decoded by the library.
+//
+//	turnprobe [flags] bind stun:stun.example.org
+//	turnprobe [flags] nat-type stun:stun.example.org
+//	turnprobe -user u -password p allocate turn:turn.example.org
+//	turnprobe -user u -password p refresh turn:turn.example.org [lifetime]
+//	turnprobe -user u -password p permit turn:turn.example.org peer:port
+//	turnprobe -user u -password p send turn:turn.example.org peer:port message
+//	turnprobe -user u -password p connect "turn:turn.example.org?transport=tcp" peer:port
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/willscott/goturn/client"
+	"github.com/willscott/goturn/trace"
+	"github.com/willscott/goturn/turn"
+)
+
+var (
+	username = flag.String("user", "", "Username of long-term TURN credentials")
+	password = flag.String("password", "", "Password of long-term TURN credentials")
+	timeout  = flag.Duration("timeout", 5*time.Second, "How long to wait for each response")
+	family   = flag.String("family", "", "Address family to allocate: ipv4, ipv6 or dual")
+	software = flag.String("software", "goturn turnprobe", "SOFTWARE attribute sent in requests")
+	quiet    = flag.Bool("quiet", false, "Only print results, not the messages exchanged")
+)
+
+// commands are the subcommands, with the number of arguments they take after
+// the server URI, and whether they need a TURN server.
+var commands = map[string]struct {
+	run      func(*probe, []string) error
+	args     int
+	optional int
+	turn     bool
+}{
+	"bind":     {(*probe).bind, 0, 0, false},
+	"nat-type": {(*probe).natType, 0, 0, false},
+	"allocate": {(*probe).allocate, 0, 0, true},
+	"refresh":  {(*probe).refresh, 0, 1, true},
+	"permit":   {(*probe).permit, 1, 0, true},
+	"send":     {(*probe).send, 2, 0, true},
+	"connect":  {(*probe).connect, 1, 0, true},
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: turnprobe [flags] command uri [arguments]
+
+Commands:
+  bind uri                       Learn the mapped address of the client
+  nat-type uri                   Discover the NAT behavior of the network (RFC 5780)
+  allocate uri                   Allocate a relayed address
+  refresh uri [lifetime]         Allocate, then refresh the allocation
+  permit uri peer:port           Allocate, then create a permission for a peer
+  send uri peer:port message     Allocate, then relay a message to a peer and await a reply
+  connect uri peer:port          Allocate over TCP, then relay stdin and stdout with a peer (RFC 6062)
+
+URIs are of the form stun:host[:port] or turn:host[:port][?transport=udp|tcp],
+with stuns: and turns: for TLS.
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("turnprobe: ")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, ok := commands[args[0]]
+	if !ok || len(args)-2 < command.args || len(args)-2 > command.args+command.optional {
+		usage()
+		os.Exit(2)
+	}
+	uri, err := parseURI(args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if command.turn && !uri.turn {
+		log.Fatal("A turn: URI is needed for ", args[0])
+	}
+
+	p := &probe{uri: uri, out: os.Stdout}
+	if !*quiet {
+		p.tracer = &printer{out: os.Stdout}
+	}
+	if err := command.run(p, args[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// probe runs commands against a server.
+type probe struct {
+	uri    *serverURI
+	out    io.Writer
+	tracer trace.Tracer
+}
+
+// dial connects a client to the server, tracing the messages it exchanges.
+func (p *probe) dial() (*client.StunClient, error) {
+	conn, err := p.uri.dial(*timeout)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(p.out, "Connected to %s over %s from %s\n", conn.RemoteAddr(), p.uri.transport, conn.LocalAddr())
+	return &client.StunClient{
+		Conn:     conn,
+		Dialer:   &net.Dialer{Timeout: *timeout},
+		Timeout:  *timeout,
+		Software: *software,
+		Tracer:   p.tracer,
+	}, nil
+}
+
+// families provides the address families requested with the family flag.
+func families() ([]uint16, error) {
+	switch *family {
+	case "":
+		return nil, nil
+	case "ipv4":
+		return []uint16{turn.FamilyIPv4}, nil
+	case "ipv6":
+		return []uint16{turn.FamilyIPv6}, nil
+	case "dual":
+		return []uint16{turn.FamilyIPv4, turn.FamilyIPv6}, nil
+	}
+	return nil, errors.New("Unknown address family: " + *family)
+}
+
+// allocation connects to the server and allocates relayed addresses.
+func (p *probe) allocation() (*client.StunClient, []net.Addr, error) {
+	if *username == "" {
+		return nil, nil, errors.New("Credentials are needed for TURN; set -user and -password.")
+	}
+	requested, err := families()
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := p.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	credentials := client.LongtermCredentials(*username, *password)
+	relayed, err := c.Allocate(&credentials, requested...)
+	if err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	if c.ServerSoftware != "" {
+		fmt.Fprintf(p.out, "Server software: %s\n", c.ServerSoftware)
+	}
+	for _, addr := range relayed {
+		fmt.Fprintf(p.out, "Relayed address: %s\n", addr)
+	}
+	return c, relayed, nil
+}
+
+// release deletes an allocation and closes the connection to the server.
+func (p *probe) release(c *client.StunClient) {
+	if _, err := c.Refresh(0); err != nil {
+		log.Print("Could not delete allocation: ", err)
+	}
+	c.Close()
+}
+
+func (p *probe) bind(_ []string) error {
+	c, err := p.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	mapped, err := c.Bind()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "Mapped address: %s\n", mapped)
+	return nil
+}
+
+func (p *probe) natType(_ []string) error {
+	if p.uri.transport != "udp" {
+		return errors.New("NAT behavior discovery needs a UDP server.")
+	}
+	server, err := net.ResolveUDPAddr("udp", p.uri.address())
+	if err != nil {
+		return err
+	}
+	network := "udp4"
+	if server.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenPacket(network, ":0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var socket net.PacketConn = conn
+	if p.tracer != nil {
+		socket = &packetTracer{PacketConn: conn, tracer: p.tracer}
+	}
+	behavior, err := client.DiscoverNATBehavior(socket, server, *timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(p.out, behavior)
+	return nil
+}
+
+func (p *probe) allocate(_ []string) error {
+	c, _, err := p.allocation()
+	if err != nil {
+		return err
+	}
+	p.release(c)
+	return nil
+}
+
+func (p *probe) refresh(args []string) error {
+	lifetime := 10 * time.Minute
+	if len(args) > 0 {
+		var err error
+		if lifetime, err = time.ParseDuration(args[0]); err != nil {
+			return err
+		}
+	}
+	c, _, err := p.allocation()
+	if err != nil {
+		return err
+	}
+	defer p.release(c)
+	granted, err := c.Refresh(lifetime)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "Lifetime: %s\n", granted)
+	return nil
+}
+
+func (p *probe) permit(args []string) error {
+	peer, err := net.ResolveUDPAddr("udp", args[0])
+	if err != nil {
+		return err
+	}
+	c, _, err := p.allocation()
+	if err != nil {
+		return err
+	}
+	defer p.release(c)
+	if err := c.RequestPermission(peer); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "Permission created for %s\n", peer.IP)
+	return nil
+}
+
+func (p *probe) send(args []string) error {
+	if p.uri.transport != "udp" {
+		return errors.New("Relaying datagrams needs a UDP allocation.")
+	}
+	peer, err := net.ResolveUDPAddr("udp", args[0])
+	if err != nil {
+		return err
+	}
+	c, relayed, err := p.allocation()
+	if err != nil {
+		return err
+	}
+	relay := client.NewRelayConn(c, relayed[0])
+	defer relay.Close()
+
+	if _, err := relay.WriteTo([]byte(args[1]), peer); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "Sent %d bytes to %s\n", len(args[1]), peer)
+
+	relay.SetReadDeadline(time.Now().Add(*timeout))
+	buffer := make([]byte, 65536)
+	n, from, err := relay.ReadFrom(buffer)
+	if err != nil {
+		fmt.Fprintf(p.out, "No reply from %s: %s\n", peer, err)
+		return nil
+	}
+	fmt.Fprintf(p.out, "Reply from %s: %q\n", from, buffer[0:n])
+	return nil
+}
+
+func (p *probe) connect(args []string) error {
+	if p.uri.transport != "tcp" || p.uri.secure {
+		return errors.New("Connect needs a TURN URI with transport=tcp, without TLS.")
+	}
+	peer, err := net.ResolveTCPAddr("tcp", args[0])
+	if err != nil {
+		return err
+	}
+	c, _, err := p.allocation()
+	if err != nil {
+		return err
+	}
+	defer p.release(c)
+	if err := c.RequestPermission(peer); err != nil {
+		return err
+	}
+	conn, err := c.Connect(peer)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	fmt.Fprintf(os.Stderr, "Connected to %s; relaying stdin and stdout\n", peer)
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(os.Stdout, conn)
+		close(done)
+	}()
+	io.Copy(conn, os.Stdin)
+	<-done
+	return nil
+}
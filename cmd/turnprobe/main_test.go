@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/willscott/goturn/turntest"
+)
+
+func TestProbe(t *testing.T) {
+	ts := turntest.NewServer()
+	defer ts.Close()
+	*username, *password = turntest.Username, turntest.Password
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	go func() {
+		buffer := make([]byte, 64)
+		n, from, err := peer.ReadFrom(buffer)
+		if err == nil {
+			peer.WriteTo(buffer[0:n], from)
+		}
+	}()
+
+	uri, err := parseURI("turn:" + ts.Addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, trace bytes.Buffer
+	p := &probe{uri: uri, out: &out, tracer: &printer{out: &trace}}
+	if err := p.send([]string{peer.LocalAddr().String(), "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Relayed address: 127.0.0.1:") || !strings.Contains(out.String(), `Reply from `+peer.LocalAddr().String()+`: "hello"`) {
+		t.Errorf("Unexpected output:\n%s", out.String())
+	}
+	for _, message := range []string{"-> Allocate Request", "<- Allocate Error", "<- Allocate Success", "-> CreatePermission Request", "-> Send Indication", "<- Data Indication", "-> Refresh Request"} {
+		direction, name, _ := strings.Cut(message, " ")
+		if !strings.Contains(trace.String(), direction+" "+ts.Addr.String()+" "+name) {
+			t.Errorf("Trace has no %q:\n%s", message, trace.String())
+		}
+	}
+	if !strings.Contains(trace.String(), "MESSAGE-INTEGRITY "+strings.Repeat("0", 40)) {
+		t.Errorf("Message integrity was not redacted:\n%s", trace.String())
+	}
+}
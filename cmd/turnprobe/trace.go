@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/willscott/goturn/trace"
+)
+
+// printer prints traced messages, with arrows showing whether they were sent
+// to or received from the server.
+type printer struct {
+	out  io.Writer
+	lock sync.Mutex
+}
+
+func (p *printer) Trace(event *trace.Event) {
+	direction := "->"
+	if event.Direction == trace.Received {
+		direction = "<-"
+	}
+	var description string
+	switch data := event.Data; {
+	case event.Message != nil:
+		description = event.Message.String()
+	case len(data) >= 4 && data[0]&0xC0 == 0x40:
+		description = fmt.Sprintf("ChannelData channel=0x%04x length=%d",
+			binary.BigEndian.Uint16(data[0:2]), binary.BigEndian.Uint16(data[2:4]))
+	default:
+		description = fmt.Sprintf("Undecodable message (%d bytes)", len(data))
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	fmt.Fprintln(p.out, direction, event.Remote, description)
+}
+
+// packetTracer traces the messages exchanged over an unconnected socket.
+type packetTracer struct {
+	net.PacketConn
+	tracer trace.Tracer
+}
+
+func (t *packetTracer) WriteTo(b []byte, addr net.Addr) (int, error) {
+	t.tracer.Trace(trace.NewEvent(trace.Sent, b, t.LocalAddr(), addr))
+	return t.PacketConn.WriteTo(b, addr)
+}
+
+func (t *packetTracer) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := t.PacketConn.ReadFrom(b)
+	if n > 0 {
+		t.tracer.Trace(trace.NewEvent(trace.Received, b[0:n], t.LocalAddr(), addr))
+	}
+	return n, addr, err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default ports of STUN and TURN servers, per RFC 7064 and RFC 7065.
+const (
+	defaultPort       = 3478
+	defaultSecurePort = 5349
+)
+
+// serverURI is a stun:, stuns:, turn: or turns: URI of a server.
+type serverURI struct {
+	turn   bool
+	secure bool
+	host   string
+	port   int
+	// The transport to the server, "udp" or "tcp".
+	transport string
+}
+
+// parseURI reads a STUN or TURN URI, as described by RFC 7064 and RFC 7065,
+// such as "turn:example.org:3478?transport=tcp".
+func parseURI(uri string) (*serverURI, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	s := &serverURI{}
+	switch u.Scheme {
+	case "stun":
+	case "stuns":
+		s.secure = true
+	case "turn":
+		s.turn = true
+	case "turns":
+		s.turn, s.secure = true, true
+	default:
+		return nil, errors.New("Unsupported URI scheme: " + uri)
+	}
+
+	hostport := u.Opaque
+	if hostport == "" {
+		hostport = u.Host
+	}
+	if hostport == "" {
+		return nil, errors.New("No host in URI: " + uri)
+	}
+	s.port = defaultPort
+	if s.secure {
+		s.port = defaultSecurePort
+	}
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		if s.port, err = strconv.Atoi(port); err != nil {
+			return nil, errors.New("Invalid port in URI: " + uri)
+		}
+		s.host = host
+	} else {
+		s.host = strings.Trim(hostport, "[]")
+	}
+
+	s.transport = "udp"
+	if s.secure {
+		s.transport = "tcp"
+	}
+	if transport := u.Query().Get("transport"); transport != "" {
+		if !s.turn {
+			return nil, errors.New("Transport is only specified for TURN URIs: " + uri)
+		}
+		if transport != "udp" && transport != "tcp" {
+			return nil, errors.New("Unsupported transport: " + transport)
+		}
+		s.transport = transport
+	}
+	if s.secure && s.transport == "udp" {
+		return nil, errors.New("DTLS is not supported: " + uri)
+	}
+	return s, nil
+}
+
+// address provides the host and port of the server.
+func (s *serverURI) address() string {
+	return net.JoinHostPort(s.host, strconv.Itoa(s.port))
+}
+
+// dial connects to the server with its transport, over TLS for stuns: and
+// turns: URIs.
+func (s *serverURI) dial(timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if s.secure {
+		return tls.DialWithDialer(dialer, s.transport, s.address(), &tls.Config{ServerName: s.host})
+	}
+	return dialer.Dial(s.transport, s.address())
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseURI(t *testing.T) {
+	cases := []struct {
+		uri       string
+		turn      bool
+		secure    bool
+		address   string
+		transport string
+	}{
+		{"stun:example.org", false, false, "example.org:3478", "udp"},
+		{"stuns:example.org", false, true, "example.org:5349", "tcp"},
+		{"turn:192.0.2.1:8000", true, false, "192.0.2.1:8000", "udp"},
+		{"turn:example.org?transport=tcp", true, false, "example.org:3478", "tcp"},
+		{"turns:[2001:db8::1]:443?transport=tcp", true, true, "[2001:db8::1]:443", "tcp"},
+		{"turn:[2001:db8::1]", true, false, "[2001:db8::1]:3478", "udp"},
+	}
+	for _, c := range cases {
+		u, err := parseURI(c.uri)
+		if err != nil {
+			t.Errorf("Could not parse %s: %s", c.uri, err)
+			continue
+		}
+		if u.turn != c.turn || u.secure != c.secure || u.address() != c.address || u.transport != c.transport {
+			t.Errorf("Unexpected parse of %s: %+v", c.uri, u)
+		}
+	}
+
+	for _, uri := range []string{
+		"http://example.org",
+		"example.org:3478",
+		"stun:example.org?transport=tcp",
+		"turn:example.org?transport=sctp",
+		"turns:example.org?transport=udp",
+		"turn:example.org:port",
+	} {
+		if _, err := parseURI(uri); err == nil {
+			t.Errorf("Parsed invalid URI %s", uri)
+		}
+	}
+}
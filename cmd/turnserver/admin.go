@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/willscott/goturn/server"
+)
+
+// admin serves the HTTP admin interface, through which quotas are inspected
+// and adjusted while the server runs:
+//
+//	GET    /quotas               The quota settings, and the usage of users
+//	PUT    /quotas               Replace the quota settings
+//	PUT    /quotas/users/{name}  Replace the limits of a user
+//	DELETE /quotas/users/{name}  Give a user the default limits
+//
+// Settings and limits are JSON encoded, as server.QuotaSettings and
+// server.Limits. Changes last until the configuration is reloaded.
+type admin struct {
+	daemon *daemon
+}
+
+// quotaStatus is the response to GET /quotas.
+type quotaStatus struct {
+	Settings server.QuotaSettings    `json:"settings"`
+	Users    map[string]server.Usage `json:"users"`
+	Realm    server.Usage            `json:"realm"`
+}
+
+func (a *admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := a.daemon.current().Admin.Token
+	if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	quotas := a.daemon.server.Quotas
+
+	switch path := r.URL.Path; {
+	case path == "/quotas" && r.Method == http.MethodGet:
+		var status quotaStatus
+		status.Settings = quotas.Settings()
+		status.Users, status.Realm = quotas.Usage()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	case path == "/quotas" && r.Method == http.MethodPut:
+		var settings server.QuotaSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		quotas.Set(settings)
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(path, "/quotas/users/") && len(path) > len("/quotas/users/"):
+		username := strings.TrimPrefix(path, "/quotas/users/")
+		switch r.Method {
+		case http.MethodPut:
+			var limits server.Limits
+			if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			quotas.SetUser(username, &limits)
+		case http.MethodDelete:
+			quotas.SetUser(username, nil)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdmin(t *testing.T) {
+	config, err := LoadConfig("turnserver.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Admin.Token = "token"
+	d := newDaemon("turnserver.yaml", config)
+	handler := &admin{d}
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	var status quotaStatus
+	w := request(http.MethodGet, "/quotas", "")
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Settings.Capacity != 10000 || status.Settings.Users["monitor"].Allocations != 100 {
+		t.Errorf("Unexpected settings %+v", status.Settings)
+	}
+
+	if w := request(http.MethodPut, "/quotas/users/alice", `{"allocations": 3}`); w.Code != http.StatusNoContent {
+		t.Errorf("Setting limits failed with %d", w.Code)
+	}
+	if w := request(http.MethodDelete, "/quotas/users/monitor", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Removing limits failed with %d", w.Code)
+	}
+	users := d.server.Quotas.Settings().Users
+	if _, ok := users["monitor"]; ok || users["alice"].Allocations != 3 {
+		t.Errorf("Unexpected limits %v", users)
+	}
+	if w := request(http.MethodPut, "/quotas", `{"capacity": 5}`); w.Code != http.StatusNoContent || d.server.Quotas.Settings().Capacity != 5 {
+		t.Errorf("Replacing settings failed with %d", w.Code)
+	}
+	if w := request(http.MethodPut, "/quotas", `{"capacity": `); w.Code != http.StatusBadRequest {
+		t.Errorf("Invalid settings were answered with %d", w.Code)
+	}
+
+	// Requests without the token are refused.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/quotas", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Request without token was answered with %d", w.Code)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// password provides the password of a user. Users listed in the
+// configuration are checked first, and then TURN REST API credentials if a
+// secret is set.
+func (c *Config) password(username string, now time.Time) (string, bool) {
+	if password, ok := c.users[username]; ok {
+		return password, true
+	}
+	if c.Auth.Secret == "" {
+		return "", false
+	}
+	return restPassword(c.Auth.Secret, username, now)
+}
+
+// restPassword provides the password of TURN REST API credentials, whose
+// username is an expiry time in seconds since the epoch, optionally followed
+// by ":" and a user id. The password is the base64 HMAC-SHA1 of the username
+// keyed by the shared secret. Expired credentials are refused.
+func restPassword(secret, username string, now time.Time) (string, bool) {
+	expiry, _, _ := strings.Cut(username, ":")
+	seconds, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Unix(seconds, 0).Before(now) {
+		return "", false
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), true
+}
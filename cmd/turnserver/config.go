@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/willscott/goturn/server"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration file of the server.
+type Config struct {
+	// The sockets the server answers clients on.
+	Listen []Listener `yaml:"listen"`
+	Relay  Relay      `yaml:"relay"`
+	// The realm of the long-term credentials of clients.
+	Realm string `yaml:"realm"`
+	// The SOFTWARE attribute added to responses.
+	Software string `yaml:"software"`
+	Auth     Auth   `yaml:"auth"`
+	// The longest lifetime granted to allocations.
+	MaxLifetime time.Duration `yaml:"max_lifetime"`
+	Peers       Peers         `yaml:"peers"`
+	// The limits of users and of the server. Limits set through the admin
+	// interface are replaced when the configuration is reloaded.
+	Quotas server.QuotaSettings `yaml:"quotas"`
+	Admin  Admin                `yaml:"admin"`
+
+	// The passwords of users, from Auth.Users and Auth.UsersFile.
+	users map[string]string
+	// The parsed peer policies, of all users and by username.
+	peers     *server.PeerPolicy
+	userPeers map[string]*server.PeerPolicy
+}
+
+// Listener is an address the server answers clients on.
+type Listener struct {
+	Address string `yaml:"address"`
+	// The transport clients connect with. Only "udp" is supported.
+	Transport string `yaml:"transport"`
+}
+
+// Relay describes the relayed sockets opened for allocations.
+type Relay struct {
+	// The addresses relayed sockets are opened on, chosen at random for each
+	// allocation among those of its family. When there are none of a family,
+	// the address a client connected to is used.
+	Addresses []RelayAddress `yaml:"addresses"`
+	// The range of ports relayed sockets are opened on. Ports are chosen by
+	// the system when it is unset.
+	Ports PortRange `yaml:"ports"`
+	// How long a port rests once its allocation ends before it is reused.
+	CoolDown time.Duration `yaml:"cooldown"`
+}
+
+// RelayAddress is an address relayed sockets are opened on.
+type RelayAddress struct {
+	IP string `yaml:"ip"`
+	// The address advertised to clients, when the server is behind a 1:1 NAT.
+	External string `yaml:"external"`
+}
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+// Auth describes how the credentials of users are checked. Users may be
+// listed in the configuration or in a separate file, and a shared secret may
+// be set to accept the time-limited credentials of the TURN REST API.
+type Auth struct {
+	// The passwords of users, by username.
+	Users map[string]string `yaml:"users"`
+	// A file of "username:password" lines, one per user. Lines starting with
+	// "#" are ignored.
+	UsersFile string `yaml:"users_file"`
+	// The secret shared with the service issuing TURN REST API credentials.
+	Secret string `yaml:"secret"`
+}
+
+// Admin describes the HTTP admin interface, which is served when Listen is
+// set. Requests must carry Token as a bearer token when it is set. A Token is
+// required unless Listen is a loopback address.
+type Admin struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"`
+}
+
+// Peers restricts the peer addresses clients may relay to.
+type Peers struct {
+	PeerNetworks `yaml:",inline"`
+	// Policies of particular users, by username. The lists set for a user
+	// replace those of all users.
+	Users map[string]PeerNetworks `yaml:"users"`
+}
+
+// PeerNetworks are the networks, in CIDR notation, peers are allowed or denied
+// in. Peers are permitted when they are allowed, or otherwise when they are
+// not denied. Deny defaults to the loopback, private, link-local and other
+// internal networks when it is unset; an empty list denies nothing.
+type PeerNetworks struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// LoadConfig reads and checks a configuration file, along with the users file
+// it names.
+func LoadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	config := &Config{}
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := config.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := config.loadUsers(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// check validates the configuration, filling in defaults.
+func (c *Config) check() error {
+	if len(c.Listen) == 0 {
+		c.Listen = []Listener{{Address: ":3478"}}
+	}
+	for i := range c.Listen {
+		l := &c.Listen[i]
+		if l.Transport == "" {
+			l.Transport = "udp"
+		}
+		if l.Transport != "udp" {
+			return errors.New("Unsupported transport " + l.Transport + " for " + l.Address + "; the server only relays over UDP.")
+		}
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			return fmt.Errorf("Invalid listen address: %w", err)
+		}
+	}
+	for _, address := range c.Relay.Addresses {
+		ip := net.ParseIP(address.IP)
+		if ip == nil {
+			return errors.New("Invalid relay address: " + address.IP)
+		}
+		if external := address.External; external != "" {
+			if e := net.ParseIP(external); e == nil || (e.To4() == nil) != (ip.To4() == nil) {
+				return errors.New("Invalid external address " + external + " of " + address.IP)
+			}
+		}
+	}
+	if p := c.Relay.Ports; p != (PortRange{}) && (p.Min < 1 || p.Max > 65535 || p.Min > p.Max) {
+		return fmt.Errorf("Invalid relay port range %d-%d", p.Min, p.Max)
+	}
+	if q := c.Quotas; q.Capacity < 0 || negative(q.Default) || negative(q.Realm) {
+		return errors.New("Negative quota")
+	}
+	for username, limits := range c.Quotas.Users {
+		if negative(limits) {
+			return errors.New("Negative quota for " + username)
+		}
+	}
+	if c.Admin.Listen != "" {
+		host, _, err := net.SplitHostPort(c.Admin.Listen)
+		if err != nil {
+			return fmt.Errorf("Invalid admin address: %w", err)
+		}
+		if ip := net.ParseIP(host); c.Admin.Token == "" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return errors.New("Admin token required to listen on " + c.Admin.Listen)
+		}
+	}
+	if c.Relay.CoolDown < 0 {
+		return errors.New("Negative relay cooldown")
+	}
+	if c.MaxLifetime < 0 {
+		return errors.New("Negative max_lifetime")
+	}
+	if len(c.Auth.Users) == 0 && c.Auth.UsersFile == "" && c.Auth.Secret == "" {
+		return errors.New("No auth backend; set users, users_file or secret.")
+	}
+	var err error
+	if c.peers, err = c.Peers.PeerNetworks.policy(nil); err != nil {
+		return err
+	}
+	c.userPeers = make(map[string]*server.PeerPolicy, len(c.Peers.Users))
+	for username, networks := range c.Peers.Users {
+		if c.userPeers[username], err = networks.policy(c.peers); err != nil {
+			return fmt.Errorf("Peers of %s: %w", username, err)
+		}
+	}
+	return nil
+}
+
+func negative(l server.Limits) bool {
+	return l.Allocations < 0 || l.Permissions < 0 || l.Bandwidth < 0 || l.Burst < 0
+}
+
+// policy parses the networks into a peer policy. Unset lists are inherited
+// from base, or for a nil base, Deny defaults to server.DefaultDeniedPeers.
+func (n PeerNetworks) policy(base *server.PeerPolicy) (*server.PeerPolicy, error) {
+	policy := &server.PeerPolicy{Deny: server.DefaultDeniedPeers}
+	if base != nil {
+		*policy = *base
+	}
+	var err error
+	if n.Allow != nil {
+		if policy.Allow, err = server.ParseNetworks(n.Allow...); err != nil {
+			return nil, err
+		}
+	}
+	if n.Deny != nil {
+		if policy.Deny, err = server.ParseNetworks(n.Deny...); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// peerPolicy provides the policy of the peers a user may relay to.
+func (c *Config) peerPolicy(username string) *server.PeerPolicy {
+	if policy, ok := c.userPeers[username]; ok {
+		return policy
+	}
+	return c.peers
+}
+
+// loadUsers gathers the passwords of users from the configuration and from
+// the users file.
+func (c *Config) loadUsers() error {
+	c.users = make(map[string]string, len(c.Auth.Users))
+	for username, password := range c.Auth.Users {
+		c.users[username] = password
+	}
+	if c.Auth.UsersFile == "" {
+		return nil
+	}
+	file, err := os.Open(c.Auth.UsersFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		username, password, ok := strings.Cut(text, ":")
+		if !ok || username == "" {
+			return fmt.Errorf("%s:%d: Expected username:password", c.Auth.UsersFile, line)
+		}
+		c.users[username] = password
+	}
+	return scanner.Err()
+}
+
+// portAllocator creates the allocator of relayed sockets.
+func (c *Config) portAllocator() *server.PortAllocator {
+	ports := &server.PortAllocator{
+		Min:      c.Relay.Ports.Min,
+		Max:      c.Relay.Ports.Max,
+		CoolDown: c.Relay.CoolDown,
+	}
+	for _, address := range c.Relay.Addresses {
+		ports.Addresses = append(ports.Addresses, server.RelayAddress{
+			IP:       net.ParseIP(address.IP),
+			External: net.ParseIP(address.External),
+		})
+	}
+	return ports
+}
+
+// restartNeeded describes the settings which differ from next and cannot be
+// changed without restarting the server.
+func (c *Config) restartNeeded(next *Config) []string {
+	var changed []string
+	if fmt.Sprint(c.Listen) != fmt.Sprint(next.Listen) {
+		changed = append(changed, "listen")
+	}
+	if fmt.Sprint(c.Relay) != fmt.Sprint(next.Relay) {
+		changed = append(changed, "relay")
+	}
+	if c.Realm != next.Realm {
+		changed = append(changed, "realm")
+	}
+	if c.Software != next.Software {
+		changed = append(changed, "software")
+	}
+	if c.MaxLifetime != next.MaxLifetime {
+		changed = append(changed, "max_lifetime")
+	}
+	if c.Admin.Listen != next.Admin.Listen {
+		changed = append(changed, "admin listen")
+	}
+	return changed
+}
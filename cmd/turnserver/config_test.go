@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn/client"
+)
+
+// writeConfig writes a configuration file in a temporary directory.
+func writeConfig(t *testing.T, dir, config string) string {
+	path := filepath.Join(dir, "turnserver.yaml")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	config, err := LoadConfig("turnserver.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Listen) != 2 || config.Listen[1].Transport != "udp" {
+		t.Errorf("Unexpected listeners %v", config.Listen)
+	}
+	if config.Relay.Ports != (PortRange{49152, 65535}) || config.MaxLifetime != time.Hour {
+		t.Errorf("Unexpected relay %v or lifetime %s", config.Relay, config.MaxLifetime)
+	}
+	ports := config.portAllocator()
+	if len(ports.Addresses) != 2 || !ports.Addresses[1].External.Equal(net.IPv4(192, 0, 2, 11)) || ports.CoolDown != time.Minute {
+		t.Errorf("Unexpected port allocator %+v", ports)
+	}
+	if _, ok := config.password("alice", time.Now()); !ok {
+		t.Error("Configured user was not accepted")
+	}
+}
+
+func TestPeerPolicies(t *testing.T) {
+	config, err := LoadConfig("turnserver.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		username, ip string
+		permitted    bool
+	}{
+		{"alice", "192.0.2.1", true},
+		{"alice", "10.20.0.1", true},
+		{"alice", "10.1.0.1", false},
+		{"alice", "127.0.0.1", false},
+		{"monitor", "10.1.0.1", true},
+		{"monitor", "169.254.169.254", false},
+	} {
+		if config.peerPolicy(c.username).Permits(net.ParseIP(c.ip)) != c.permitted {
+			t.Errorf("Expected %s to be permitted to %s: %v", c.username, c.ip, c.permitted)
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	configs := map[string]string{
+		"transport":  "listen: [{address: ':3478', transport: tcp}]\nauth: {secret: s}",
+		"address":    "listen: [{address: 'localhost'}]\nauth: {secret: s}",
+		"relay":      "relay: {addresses: [{ip: 10.0.0.300}]}\nauth: {secret: s}",
+		"external":   "relay: {addresses: [{ip: 10.0.0.1, external: '::1'}]}\nauth: {secret: s}",
+		"ports":      "relay: {ports: {min: 6000, max: 5000}}\nauth: {secret: s}",
+		"auth":       "realm: example.org",
+		"field":      "relm: example.org\nauth: {secret: s}",
+		"peers":      "peers: {deny: [10.0.0.0/40]}\nauth: {secret: s}",
+		"user peers": "peers: {users: {bob: {allow: [bad]}}}\nauth: {secret: s}",
+		"users":      "auth: {users_file: " + filepath.Join(dir, "missing") + "}",
+		"admin":      "admin: {listen: ':8081'}\nauth: {secret: s}",
+	}
+	for name, config := range configs {
+		if _, err := LoadConfig(writeConfig(t, dir, config)); err == nil {
+			t.Errorf("Invalid %s was accepted", name)
+		}
+	}
+}
+
+func TestUsersFile(t *testing.T) {
+	dir := t.TempDir()
+	users := filepath.Join(dir, "users")
+	os.WriteFile(users, []byte("# Users\nbob:pa:ss\n\ncarol:secret\n"), 0600)
+	config, err := LoadConfig(writeConfig(t, dir, "auth: {users: {alice: a}, users_file: "+users+"}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for username, expected := range map[string]string{"alice": "a", "bob": "pa:ss", "carol": "secret"} {
+		if password, ok := config.password(username, time.Now()); !ok || password != expected {
+			t.Errorf("Unexpected password %q for %s", password, username)
+		}
+	}
+}
+
+func TestRestPassword(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	password, ok := restPassword("secret", "1700000600:alice", now)
+	if !ok || password != "5PrY1fcLQr5jls8N4ZU7fEnmVNE=" {
+		t.Errorf("Unexpected password %q", password)
+	}
+	if _, ok := restPassword("secret", "1699999999:alice", now); ok {
+		t.Error("Expired credentials were accepted")
+	}
+	if _, ok := restPassword("secret", "alice", now); ok {
+		t.Error("Credentials without an expiry were accepted")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, "listen: [{address: '127.0.0.1:0'}]\nrealm: one\nauth: {users: {alice: a}}")
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDaemon(path, config)
+	if _, err := d.listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.server.Close()
+
+	addr := d.addrs[0].String()
+	allocate := func(username, password string) (*client.StunClient, error) {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &client.StunClient{Conn: conn, Timeout: time.Second}
+		credentials := client.LongtermCredentials(username, password)
+		_, err = c.Allocate(&credentials)
+		return c, err
+	}
+	alice, err := allocate("alice", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+	if c, err := allocate("bob", "b"); err == nil {
+		c.Close()
+		t.Fatal("Unknown user was allocated")
+	}
+
+	writeConfig(t, dir, "listen: [{address: '127.0.0.1:0'}]\nrealm: two\nauth: {users: {alice: a, bob: b}}")
+	if err := d.reload(); err != nil {
+		t.Fatal(err)
+	}
+	bob, err := allocate("bob", "b")
+	if err != nil {
+		t.Fatal("User added by the reload was refused: ", err)
+	}
+	bob.Close()
+	if _, err := alice.Refresh(time.Minute); err != nil {
+		t.Error("Allocation was not kept across the reload: ", err)
+	}
+	if d.current().Realm != "one" {
+		t.Error("Realm changed without a restart")
+	}
+
+	// A configuration which fails to load leaves the current one in effect.
+	writeConfig(t, dir, "auth: {}")
+	if err := d.reload(); err == nil || !strings.Contains(err.Error(), "auth") {
+		t.Errorf("Expected the reload to fail, got %v", err)
+	}
+	if _, ok := d.current().password("bob", time.Now()); !ok {
+		t.Error("Failed reload replaced the configuration")
+	}
+}
@@ -0,0 +1,168 @@
+// Command turnserver runs a STUN and TURN server configured from a YAML file.
+//
+//	turnserver -config /etc/goturn/turnserver.yaml
+//
+// The configuration is reloaded on SIGHUP without dropping allocations. Users,
+// the auth secret, peer policies and quotas take effect for the requests which
+// follow, while changes to the listen and admin addresses, relay settings,
+// realm, software and lifetime limit need a restart. A
+// configuration which fails to load is reported and the previous one kept.
+// See turnserver.yaml for an example.
+//
+// When an admin address is configured, quotas may be inspected and adjusted
+// over HTTP while the server runs:
+//
+//	curl -H "Authorization: Bearer $TOKEN" http://127.0.0.1:8081/quotas
+//	curl -X PUT -d '{"allocations": 20}' http://127.0.0.1:8081/quotas/users/alice
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/willscott/goturn/server"
+)
+
+var configPath = flag.String("config", "turnserver.yaml", "Path of the configuration file")
+
+func main() {
+	log.SetFlags(log.LstdFlags)
+	log.SetPrefix("turnserver: ")
+	flag.Parse()
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d := newDaemon(*configPath, config)
+	errs, err := d.listen()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.Admin.Listen != "" {
+		go func() {
+			log.Printf("Serving admin interface on %s", config.Admin.Listen)
+			errs <- http.ListenAndServe(config.Admin.Listen, &admin{d})
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				d.reload()
+				continue
+			}
+			log.Printf("Received %s, shutting down", sig)
+			d.server.Close()
+			return
+		case err := <-errs:
+			d.server.Close()
+			log.Fatal(err)
+		}
+	}
+}
+
+// daemon is a server along with the configuration it is running with.
+type daemon struct {
+	path   string
+	server *server.Server
+	// The addresses the server is listening on.
+	addrs []net.Addr
+
+	lock   sync.Mutex
+	config *Config
+}
+
+// newDaemon creates a server from a configuration. Its hooks consult the
+// current configuration, so that they follow reloads.
+func newDaemon(path string, config *Config) *daemon {
+	d := &daemon{path: path, config: config}
+	d.server = &server.Server{
+		Realm:       config.Realm,
+		Ports:       config.portAllocator(),
+		MaxLifetime: config.MaxLifetime,
+		Software:    config.Software,
+		Quotas:      server.NewQuotas(config.Quotas),
+		Auth: func(username string) (string, bool) {
+			return d.current().password(username, time.Now())
+		},
+		Peers: func(username string) *server.PeerPolicy {
+			return d.current().peerPolicy(username)
+		},
+	}
+	return d
+}
+
+// current provides the configuration in effect.
+func (d *daemon) current() *Config {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.config
+}
+
+// listen opens the sockets of the configuration and serves them, providing
+// the errors of sockets which fail.
+func (d *daemon) listen() (chan error, error) {
+	listeners := d.current().Listen
+	conns := make([]net.PacketConn, 0, len(listeners))
+	for _, l := range listeners {
+		conn, err := net.ListenPacket(l.Transport, l.Address)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+
+	errs := make(chan error, len(conns)+1)
+	for _, conn := range conns {
+		d.addrs = append(d.addrs, conn.LocalAddr())
+		log.Printf("Listening on %s/%s", conn.LocalAddr(), conn.LocalAddr().Network())
+		go func(conn net.PacketConn) {
+			if err := d.server.Serve(conn); err != nil {
+				errs <- err
+			}
+		}(conn)
+	}
+	return errs, nil
+}
+
+// reload reads the configuration file again and applies it to the requests
+// which follow. Existing allocations are kept.
+func (d *daemon) reload() error {
+	next, err := LoadConfig(d.path)
+	if err != nil {
+		log.Print("Keeping previous configuration: ", err)
+		return err
+	}
+	d.lock.Lock()
+	previous := d.config
+	restart := previous.restartNeeded(next)
+	// Settings which cannot change are kept, so that the configuration in
+	// effect stays accurate.
+	next.Listen, next.Admin.Listen = previous.Listen, previous.Admin.Listen
+	next.Relay = previous.Relay
+	next.Realm, next.Software, next.MaxLifetime = previous.Realm, previous.Software, previous.MaxLifetime
+	d.config = next
+	d.lock.Unlock()
+	d.server.Quotas.Set(next.Quotas)
+
+	if len(restart) > 0 {
+		log.Printf("Changes to %s need a restart to take effect", strings.Join(restart, ", "))
+	}
+	log.Printf("Reloaded %s", d.path)
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"strconv"
+	"sync"
 )
 
 // AttributeType is the numeric representation of a STUN attribute.
@@ -28,10 +30,81 @@ type Attribute interface {
 	Length(*Message) uint16
 }
 
+// Appender is implemented by attributes which can be serialized directly onto
+// the end of a message being built, without an intermediate buffer. Attributes
+// such as MESSAGE-INTEGRITY and FINGERPRINT use it to calculate their checksum
+// over the message serialized so far.
+type Appender interface {
+	// Append writes the encoded attribute, including its attribute header, onto
+	// the end of b. The message serialized so far is b[start:], and the length
+	// in its header already accounts for the appended attribute.
+	Append(b []byte, start int, msg *Message) ([]byte, error)
+}
+
 // AttributeSet represents the mapping of known attribute types that a parser
 // will use when parsing a STUN message.
 type AttributeSet map[AttributeType]func() Attribute
 
+var (
+	attributesLock sync.RWMutex
+	attributeNames = make(map[AttributeType]string)
+	attributeTypes = make(map[string]AttributeType)
+	// The constructors of registered attributes, used to decode messages from
+	// JSON.
+	registeredAttributes = make(AttributeSet)
+)
+
+// RegisterAttribute records the name of an AttributeType, such as "USERNAME",
+// and how to construct it, used when printing messages and decoding them from
+// JSON. Packages defining STUN attributes should register them when
+// initialized.
+func RegisterAttribute(t AttributeType, name string, constructor func() Attribute) {
+	attributesLock.Lock()
+	defer attributesLock.Unlock()
+	attributeNames[t] = name
+	attributeTypes[name] = t
+	registeredAttributes[t] = constructor
+}
+
+// String provides the registered name of an AttributeType, or its number in
+// hexadecimal, such as "0x8055", if it has none.
+func (t AttributeType) String() string {
+	attributesLock.RLock()
+	defer attributesLock.RUnlock()
+	if name, ok := attributeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", uint16(t))
+}
+
+// ParseAttributeType provides the AttributeType with a registered name, or
+// written as a number, such as "0x8055".
+func ParseAttributeType(name string) (AttributeType, error) {
+	attributesLock.RLock()
+	t, ok := attributeTypes[name]
+	attributesLock.RUnlock()
+	if ok {
+		return t, nil
+	}
+	n, err := strconv.ParseUint(name, 0, 16)
+	if err != nil {
+		return 0, errors.New("Unknown Attribute type " + name)
+	}
+	return AttributeType(n), nil
+}
+
+// newRegisteredAttribute constructs an attribute of a registered type, or an
+// unknown attribute of the type.
+func newRegisteredAttribute(t AttributeType) Attribute {
+	attributesLock.RLock()
+	constructor, ok := registeredAttributes[t]
+	attributesLock.RUnlock()
+	if !ok {
+		return &UnknownStunAttribute{ClaimedType: t}
+	}
+	return constructor()
+}
+
 // WriteHeader will append a STUN attribute header onto a byte buffer for a
 // given attribute and message pair.
 func WriteAttributeHeader(buf *bytes.Buffer, a Attribute, msg *Message) error {
@@ -40,6 +113,13 @@ func WriteAttributeHeader(buf *bytes.Buffer, a Attribute, msg *Message) error {
 	return binary.Write(buf, binary.BigEndian, header)
 }
 
+// AppendAttributeHeader appends a STUN attribute header onto b for a given
+// attribute and message pair.
+func AppendAttributeHeader(b []byte, a Attribute, msg *Message) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(a.Type()))
+	return binary.BigEndian.AppendUint16(b, a.Length(msg))
+}
+
 // DecodeAttribute returns a parsed Attribute representation of data based
 // upon the known AttributeType's mapped by attrs.
 func DecodeAttribute(data []byte, attrs AttributeSet, parser *Parser) (*Attribute, error) {
@@ -50,6 +130,9 @@ func DecodeAttribute(data []byte, attrs AttributeSet, parser *Parser) (*Attribut
 		attrConstructor = NewUnknownAttribute
 	}
 	result := attrConstructor()
+	if unknown, ok := result.(*UnknownStunAttribute); ok {
+		unknown.ClaimedType = AttributeType(attributeType)
+	}
 
 	err := result.Decode(data[4:], length, parser)
 	if err != nil {
@@ -0,0 +1,173 @@
+package stun
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Attribute types whose values are held in the Credentials of a message
+// rather than in the attribute, which are rendered from the credentials.
+const (
+	usernameType AttributeType = 0x6
+	realmType    AttributeType = 0x14
+	nonceType    AttributeType = 0x15
+)
+
+// attributes provides every attribute of a message, decoding those of
+// messages read with ParseInto without retaining them in Attributes.
+func (m *Message) attributes() []Attribute {
+	if m.decoded || m.parser.Data == nil {
+		return m.Attributes
+	}
+	attrs := make([]Attribute, 0, len(m.raw))
+	for i := range m.raw {
+		att, err := m.parser.decode(&m.raw[i])
+		if err != nil {
+			att = &UnknownStunAttribute{m.raw[i].Type, m.raw[i].Value}
+		}
+		attrs = append(attrs, att)
+	}
+	return attrs
+}
+
+// credential provides the value of an attribute held in the credentials of
+// the message, if it is one.
+func (m *Message) credential(t AttributeType) (string, bool) {
+	switch t {
+	case usernameType:
+		return m.Credentials.Username, true
+	case realmType:
+		return m.Credentials.Realm, true
+	case nonceType:
+		return string(m.Credentials.Nonce), true
+	}
+	return "", false
+}
+
+// String provides a textual representation of a message for logging or
+// debugging, with its type and an attribute on each following line, such as
+//
+//	Binding Success Response id=b7e7a701bc34d686fa87dfae
+//	  XOR-MAPPED-ADDRESS 192.0.2.1:32853
+//	  SOFTWARE example server
+func (m *Message) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s id=%x", m.Header.Type, m.Header.Id)
+	for _, attr := range m.attributes() {
+		b.WriteString("\n  ")
+		b.WriteString(attr.Type().String())
+		value, ok := m.credential(attr.Type())
+		if ok {
+			value = fmt.Sprintf("%q", value)
+		} else if s, ok := attr.(fmt.Stringer); ok {
+			value = s.String()
+		}
+		if value != "" {
+			b.WriteString(" ")
+			b.WriteString(value)
+		}
+	}
+	return b.String()
+}
+
+// jsonMessage is the JSON representation of a Message.
+type jsonMessage struct {
+	Type        string          `json:"type"`
+	Transaction string          `json:"transaction"`
+	Attributes  []jsonAttribute `json:"attributes"`
+}
+
+type jsonAttribute struct {
+	Type string `json:"type"`
+	// The value of the attribute, omitted for attributes without one such as
+	// MESSAGE-INTEGRITY.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MarshalJSON encodes a message as an object with its type, transaction ID
+// and attributes, such as
+//
+//	{"type": "Allocate Request", "transaction": "b7e7a701bc34d686fa87dfae",
+//	 "attributes": [{"type": "REQUESTED-TRANSPORT", "value": "udp"},
+//	                {"type": "USERNAME", "value": "alice"},
+//	                {"type": "MESSAGE-INTEGRITY"}]}
+//
+// The username, realm and nonce of the credentials of the message are the
+// values of their attributes. The password is never encoded.
+func (m *Message) MarshalJSON() ([]byte, error) {
+	encoded := jsonMessage{
+		Type:        m.Header.Type.String(),
+		Transaction: hex.EncodeToString(m.Header.Id[:]),
+		Attributes:  []jsonAttribute{},
+	}
+	for _, attr := range m.attributes() {
+		var value []byte
+		var err error
+		if credential, ok := m.credential(attr.Type()); ok {
+			value, err = json.Marshal(credential)
+		} else {
+			value, err = json.Marshal(attr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if string(value) == "null" {
+			value = nil
+		}
+		encoded.Attributes = append(encoded.Attributes, jsonAttribute{attr.Type().String(), value})
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON. Attributes are
+// constructed as registered with RegisterAttribute, and unregistered ones are
+// decoded as UnknownStunAttributes. The message may be serialized once a
+// password is set in its Credentials, if it has a MESSAGE-INTEGRITY.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var encoded jsonMessage
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	typ, err := ParseHeaderType(encoded.Type)
+	if err != nil {
+		return err
+	}
+	id, err := hex.DecodeString(encoded.Transaction)
+	if err != nil || len(id) != len(m.Header.Id) {
+		return errors.New("Invalid transaction ID " + encoded.Transaction)
+	}
+
+	m.Reset()
+	m.Header.Type = typ
+	copy(m.Header.Id[:], id)
+	for _, a := range encoded.Attributes {
+		t, err := ParseAttributeType(a.Type)
+		if err != nil {
+			return err
+		}
+		attr := newRegisteredAttribute(t)
+		if _, ok := m.credential(t); ok && a.Value != nil {
+			var value string
+			if err := json.Unmarshal(a.Value, &value); err != nil {
+				return fmt.Errorf("%s: %w", a.Type, err)
+			}
+			switch t {
+			case usernameType:
+				m.Credentials.Username = value
+			case realmType:
+				m.Credentials.Realm = value
+			case nonceType:
+				m.Credentials.Nonce = []byte(value)
+			}
+		} else if a.Value != nil {
+			if err := json.Unmarshal(a.Value, attr); err != nil {
+				return fmt.Errorf("%s: %w", a.Type, err)
+			}
+		}
+		m.Attributes = append(m.Attributes, attr)
+	}
+	return nil
+}
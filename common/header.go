@@ -1,7 +1,6 @@
 package stun
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -26,28 +25,23 @@ type Header struct {
 	Id [12]byte
 }
 
-// String provides a textual representation of a STUN header for logging or debugging.
+// String provides a textual representation of a STUN header for logging or
+// debugging, such as "Binding Request id=... len=8".
 func (h Header) String() string {
-	return fmt.Sprintf("Header(%#v id=%x len=%d)", h.Type, h.Id, h.Length)
+	return fmt.Sprintf("%s id=%x len=%d", h.Type, h.Id, h.Length)
 }
 
 // Encode the byte representation of a STUN header.
 func (h *Header) Encode() ([]byte, error) {
-	buf := new(bytes.Buffer)
-
-	err := binary.Write(buf, binary.BigEndian, h.Type)
-	err = binary.Write(buf, binary.BigEndian, h.Length)
-	err = binary.Write(buf, binary.BigEndian, MagicCookie)
-	err = binary.Write(buf, binary.BigEndian, h.Id)
-
-	if len(h.Id) != 12 {
-		return nil, errors.New("Unsupported Transaction ID Length")
-	}
+	return h.Append(make([]byte, 0, 20)), nil
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+// Append writes the byte representation of a STUN header onto the end of b.
+func (h *Header) Append(b []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(h.Type))
+	b = binary.BigEndian.AppendUint16(b, h.Length)
+	b = binary.BigEndian.AppendUint32(b, MagicCookie)
+	return append(b, h.Id[:]...)
 }
 
 // Decode parses a header from its byte representation.
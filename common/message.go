@@ -1,9 +1,24 @@
 package stun
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"sync"
 )
 
+// Attribute types whose placement within a message is constrained by RFC 5389.
+// They are defined here, rather than with their attribute implementations, so
+// that the parser can enforce ordering in strict mode.
+const (
+	messageIntegrityType AttributeType = 0x8
+	fingerprintType      AttributeType = 0x8028
+)
+
+// ErrUnknownAttribute is reported by strict parsing when a message contains a
+// comprehension-required attribute that is not in the AttributeSet.
+var ErrUnknownAttribute = errors.New("Unknown comprehension-required Attribute")
+
 // Message represents a single STUN Message.
 type Message struct {
 	// A message has a header, with the message ID and type.
@@ -13,47 +28,223 @@ type Message struct {
 	Credentials
 	// A message has a set of Attributes, representing the body of the message.
 	Attributes []Attribute
+
+	// Views of the attributes of a parsed message, in the order they were found.
+	raw []RawAttribute
+	// The parser that read the message, used to decode raw attributes on demand.
+	parser Parser
+	// Whether raw attributes have all been decoded into Attributes.
+	decoded bool
+}
+
+// RawAttribute is an undecoded view of an attribute within the buffer a message
+// was parsed from. It is only valid until that buffer is modified or reused.
+type RawAttribute struct {
+	// Type is the AttributeType of the attribute.
+	Type AttributeType
+	// Offset is the position of the attribute header within the message.
+	Offset uint16
+	// Value is the body of the attribute, without padding.
+	Value []byte
+
+	// The decoded attribute, once it has been accessed.
+	attr Attribute
+}
+
+var (
+	repeatableLock sync.RWMutex
+	repeatable     = make(map[AttributeType]bool)
+)
+
+// RegisterRepeatable records that an attribute type may appear more than once
+// in a message, such as the XOR-RELAYED-ADDRESS of each address family in a
+// dual-stack allocation. Strict parsing otherwise rejects repeated attributes.
+func RegisterRepeatable(t AttributeType) {
+	repeatableLock.Lock()
+	defer repeatableLock.Unlock()
+	repeatable[t] = true
+}
+
+// isRepeatable indicates whether an attribute type has been registered with
+// RegisterRepeatable.
+func isRepeatable(t AttributeType) bool {
+	repeatableLock.RLock()
+	defer repeatableLock.RUnlock()
+	return repeatable[t]
+}
+
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		return new(Message)
+	},
+}
+
+// AcquireMessage provides an empty Message from a shared pool, for use with
+// ParseInto. It should be returned with ReleaseMessage once no longer needed.
+func AcquireMessage() *Message {
+	return messagePool.Get().(*Message)
+}
+
+// ReleaseMessage resets m and returns it to the shared pool. Neither m nor its
+// attributes may be used after it has been released.
+func ReleaseMessage(m *Message) {
+	m.Reset()
+	messagePool.Put(m)
+}
+
+// Reset clears a message so that it may be reused, retaining the storage
+// allocated for its attributes.
+func (m *Message) Reset() {
+	m.Header = Header{}
+	m.Credentials = Credentials{}
+	for i := range m.Attributes {
+		m.Attributes[i] = nil
+	}
+	m.Attributes = m.Attributes[:0]
+	for i := range m.raw {
+		m.raw[i] = RawAttribute{}
+	}
+	m.raw = m.raw[:0]
+	m.parser = Parser{}
+	m.decoded = false
 }
 
 // Serialize encodes the []byte representation of a STUN Message.
 func (m *Message) Serialize() ([]byte, error) {
-	var bodylength uint16
-	for _, att := range m.Attributes {
-		len := uint16(4 * int((att.Length(m)+7)/4))
-		bodylength += len
-	}
+	return m.AppendTo(nil)
+}
 
-	body := make([]byte, bodylength)
-	bodylength = 0
+// AppendTo encodes the STUN Message onto the end of b in a single pass. The
+// header length is updated as each attribute is written, so that attributes
+// implementing Appender, like MESSAGE-INTEGRITY and FINGERPRINT, can compute
+// their checksum over the message prefix in place.
+func (m *Message) AppendTo(b []byte) ([]byte, error) {
+	start := len(b)
+	b = m.Header.Append(b)
+	binary.BigEndian.PutUint16(b[start+2:], 0)
 
 	// Each attribute is serialized in sequence.
+	var err error
 	for _, att := range m.Attributes {
-		attLen := uint16(4 * int((att.Length(m)+7)/4))
-		if attBody, err := att.Encode(m); err != nil {
-			return nil, err
+		attLen := int(att.Length(m))
+		padded := 4 * ((attLen + 7) / 4)
+		bodyLen := len(b) - start - 20 + padded
+		if bodyLen > 0xffff {
+			return nil, errors.New("Message is too long")
+		}
+		binary.BigEndian.PutUint16(b[start+2:], uint16(bodyLen))
+
+		attStart := len(b)
+		if appender, ok := att.(Appender); ok {
+			b, err = appender.Append(b, start, m)
 		} else {
-			copy(body[bodylength:bodylength+attLen], attBody[:])
+			var attBody []byte
+			attBody, err = att.Encode(m)
+			b = append(b, attBody...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(b)-attStart != 4+attLen {
+			return nil, fmt.Errorf("Incorrect Length encoded for %T", att)
+		}
+		for len(b)-attStart < padded {
+			b = append(b, 0)
 		}
-		bodylength += attLen
 	}
 
-	// Calculate message length for the header.
-	m.Header.Length = uint16(len(body))
-	head, err := m.Header.Encode()
-	if err != nil {
-		return nil, err
-	}
-	data := append(head, body...)
-	return data, nil
+	m.Header.Length = uint16(len(b) - start - 20)
+	return b, nil
 }
 
-// GetAttribute extracts a single Attribute from the body of a Message
+// GetAttribute extracts a single Attribute from the body of a Message. Parse
+// and ParseStrict decode every attribute, failing if any cannot be decoded.
+// For messages read with ParseInto, the attribute is decoded when first
+// requested, and nil is returned if it cannot be decoded; DecodeAttributes
+// reports why.
 func (m *Message) GetAttribute(typ AttributeType) *Attribute {
 	for _, att := range m.Attributes {
 		if att.Type() == typ {
 			return &att
 		}
 	}
+	if m.decoded {
+		return nil
+	}
+	for i := range m.raw {
+		if m.raw[i].Type == typ {
+			att, err := m.parser.decode(&m.raw[i])
+			if err != nil {
+				return nil
+			}
+			return &att
+		}
+	}
+	return nil
+}
+
+// GetAttributes extracts every Attribute of a type from the body of a Message,
+// in the order they appear, for attributes registered with RegisterRepeatable.
+// Attributes which cannot be decoded are skipped.
+func (m *Message) GetAttributes(typ AttributeType) []Attribute {
+	var attrs []Attribute
+	if m.decoded || len(m.raw) == 0 {
+		for _, att := range m.Attributes {
+			if att.Type() == typ {
+				attrs = append(attrs, att)
+			}
+		}
+		return attrs
+	}
+	for i := range m.raw {
+		if m.raw[i].Type == typ {
+			if att, err := m.parser.decode(&m.raw[i]); err == nil {
+				attrs = append(attrs, att)
+			}
+		}
+	}
+	return attrs
+}
+
+// AddAttribute adds an attribute to a message being built, before any
+// MESSAGE-INTEGRITY and FINGERPRINT attributes so that they continue to cover
+// the message.
+func (m *Message) AddAttribute(attr Attribute) {
+	i := len(m.Attributes)
+	for i > 0 {
+		t := m.Attributes[i-1].Type()
+		if t != messageIntegrityType && t != fingerprintType {
+			break
+		}
+		i--
+	}
+	m.Attributes = append(m.Attributes, nil)
+	copy(m.Attributes[i+1:], m.Attributes[i:])
+	m.Attributes[i] = attr
+}
+
+// RawAttributes provides views of the undecoded attributes of a parsed message,
+// in the order they appear. The views reference the buffer the message was
+// parsed from, and must not be used once that buffer is reused.
+func (m *Message) RawAttributes() []RawAttribute {
+	return m.raw
+}
+
+// DecodeAttributes instantiates every attribute of a message read with
+// ParseInto, populating Attributes in the order they appear in the message.
+func (m *Message) DecodeAttributes() error {
+	if m.decoded || m.parser.Data == nil {
+		return nil
+	}
+	m.Attributes = m.Attributes[:0]
+	for i := range m.raw {
+		att, err := m.parser.decode(&m.raw[i])
+		if err != nil {
+			return err
+		}
+		m.Attributes = append(m.Attributes, att)
+	}
+	m.decoded = true
 	return nil
 }
 
@@ -69,42 +260,201 @@ type Parser struct {
 	Data []byte
 	// Which subset of the Data is represented by Message.
 	Offset uint16
+	// Strict parsers reject messages which do not follow the ordering, padding
+	// and type rules of RFC 5389, rather than parsing as much as they can.
+	Strict bool
+	// The message types accepted by a strict parser. When nil, any type is
+	// accepted.
+	Types map[HeaderType]bool
+}
+
+// ParseError describes why a message could not be parsed, and where in the
+// data the problem was found.
+type ParseError struct {
+	// Offset is the byte offset into the message where the problem was found.
+	Offset int
+	// Attribute is the type of the attribute being parsed, or 0 if the problem
+	// was not with an attribute.
+	Attribute AttributeType
+	// Err is the underlying problem.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.Attribute != 0 {
+		return fmt.Sprintf("offset %d: attribute %#x: %s", e.Offset, uint16(e.Attribute), e.Err)
+	}
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
 }
 
 // Parse creates a Message representation of a data byte stream given provided
 // credentials and a known mapping of Attributes.
 func Parse(data []byte, credentials *Credentials, attrs AttributeSet) (*Message, error) {
-	parser := Parser{new(Message), credentials, attrs, data, 0}
-	err := parser.parse()
+	m := new(Message)
+	m.parser = Parser{Message: m, Credentials: credentials, AttributeSet: attrs, Data: data}
+	err := m.parser.parse()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ParseStrict creates a Message representation of a data byte stream, like
+// Parse, but rejects any message that does not strictly follow RFC 5389:
+// attributes after MESSAGE-INTEGRITY other than FINGERPRINT, attributes after
+// FINGERPRINT, repeated attributes not registered with RegisterRepeatable,
+// non-zero padding, unknown comprehension-required attributes, more than 128
+// attributes, and message types not listed in types. It is intended for input
+// from untrusted sources. These checks are made on the attribute headers
+// alone, and attribute bodies are only decoded once the whole message has
+// passed them.
+func ParseStrict(data []byte, credentials *Credentials, attrs AttributeSet, types []HeaderType) (*Message, error) {
+	m := new(Message)
+	m.parser = Parser{Message: m, Credentials: credentials, AttributeSet: attrs, Data: data, Strict: true}
+	if types != nil {
+		m.parser.Types = make(map[HeaderType]bool, len(types))
+		for _, t := range types {
+			m.parser.Types[t] = true
+		}
+	}
+	err := m.parser.parse()
 	if err != nil {
 		return nil, err
 	}
-	return parser.Message, nil
+	return m, nil
+}
+
+// ParseInto parses data into a caller-owned Message, reusing the storage of m
+// rather than allocating a new message. Only the header and the
+// MESSAGE-INTEGRITY and FINGERPRINT attributes are decoded immediately; other
+// attributes remain views into data until requested with GetAttribute or
+// DecodeAttributes, so data must not be modified while m is in use. Messages
+// from a pool can be obtained with AcquireMessage.
+func ParseInto(m *Message, data []byte, credentials *Credentials, attrs AttributeSet) error {
+	m.Reset()
+	m.parser = Parser{Message: m, Credentials: credentials, AttributeSet: attrs, Data: data}
+	if err := m.parser.scan(); err != nil {
+		return err
+	}
+	for i := range m.raw {
+		if m.raw[i].Type == messageIntegrityType || m.raw[i].Type == fingerprintType {
+			if _, err := m.parser.decode(&m.raw[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fail constructs a ParseError at the current position of the parser.
+func (p *Parser) fail(attr AttributeType, err error) error {
+	return &ParseError{int(p.Offset), attr, err}
 }
 
 func (p *Parser) parse() error {
+	if err := p.scan(); err != nil {
+		return err
+	}
+	p.Message.Attributes = []Attribute{}
+	return p.Message.DecodeAttributes()
+}
+
+// scan validates the structure of the message in p.Data, decoding its header
+// and recording a view of each attribute without decoding attribute bodies.
+func (p *Parser) scan() error {
 	if p.Credentials != nil {
 		p.Message.Credentials = *p.Credentials
 	}
-	p.Message.Attributes = []Attribute{}
+	p.Message.raw = p.Message.raw[:0]
 	if err := p.Message.Header.Decode(p.Data); err != nil {
-		return err
+		return p.fail(0, err)
+	}
+	if p.Strict && p.Types != nil && !p.Types[p.Message.Header.Type] {
+		return p.fail(0, fmt.Errorf("Message type %#x not allowed", uint16(p.Message.Header.Type)))
 	}
 	data := p.Data[20:]
 	p.Offset = 20
 	if len(data) != int(p.Message.Header.Length) {
-		return errors.New("Message has incorrect Length")
+		return &ParseError{Offset: 2, Err: errors.New("Message has incorrect Length")}
+	}
+	var seen map[AttributeType]bool
+	if p.Strict {
+		seen = make(map[AttributeType]bool)
 	}
 	for len(data) > 0 {
-		attribute, err := DecodeAttribute(data, p.AttributeSet, p)
-		if err != nil {
-			return err
+		if len(data) < 4 {
+			return p.fail(0, errors.New("Truncated Attribute Header"))
 		}
-		p.Message.Attributes = append(p.Message.Attributes, *attribute)
+		attrType := AttributeType(binary.BigEndian.Uint16(data))
 		// 4 byte header and rounded up to next multiple of 4
-		len := 4 * int(((*attribute).Length(p.Message)+7)/4)
-		p.Offset += uint16(len)
-		data = data[len:]
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		padded := 4 * ((length + 7) / 4)
+		if padded > len(data) {
+			return p.fail(attrType, errors.New("Attribute extends past end of Message"))
+		}
+		if p.Strict {
+			if len(p.Message.raw) == maxAttributes {
+				return p.fail(attrType, errors.New("Too many Attributes"))
+			}
+			if err := p.checkPlacement(attrType, seen); err != nil {
+				return p.fail(attrType, err)
+			}
+			for i := 4 + length; i < padded; i++ {
+				if data[i] != 0 {
+					return &ParseError{int(p.Offset) + i, attrType, errors.New("Non-zero Attribute padding")}
+				}
+			}
+			seen[attrType] = true
+		}
+
+		p.Message.raw = append(p.Message.raw, RawAttribute{
+			Type:   attrType,
+			Offset: p.Offset,
+			Value:  data[4 : 4+length],
+		})
+		p.Offset += uint16(padded)
+		data = data[padded:]
+	}
+	return nil
+}
+
+// maxAttributes bounds the number of attributes a strict parser accepts in a
+// single message, limiting the work done for hostile input.
+const maxAttributes = 128
+
+// checkPlacement validates, for strict parsing, that an attribute of type
+// attrType may follow the attributes already seen in the message.
+func (p *Parser) checkPlacement(attrType AttributeType, seen map[AttributeType]bool) error {
+	if seen[fingerprintType] {
+		return errors.New("Attribute follows Fingerprint")
+	}
+	if seen[messageIntegrityType] && attrType != fingerprintType {
+		return errors.New("Attribute follows Message Integrity")
+	}
+	if seen[attrType] && !isRepeatable(attrType) {
+		return errors.New("Duplicate Attribute")
+	}
+	if _, ok := p.AttributeSet[attrType]; !ok && attrType < 0x8000 {
+		return ErrUnknownAttribute
 	}
 	return nil
 }
+
+// decode instantiates the Attribute viewed by r, caching the result in r.
+func (p *Parser) decode(r *RawAttribute) (Attribute, error) {
+	if r.attr != nil {
+		return r.attr, nil
+	}
+	p.Offset = r.Offset
+	end := int(r.Offset) + 4*((len(r.Value)+7)/4)
+	attribute, err := DecodeAttribute(p.Data[r.Offset:end], p.AttributeSet, p)
+	if err != nil {
+		return nil, p.fail(r.Type, err)
+	}
+	r.attr = *attribute
+	return r.attr, nil
+}
@@ -0,0 +1,144 @@
+package stun
+
+import (
+	"errors"
+	"testing"
+)
+
+// serializeAttributes creates the wire format of a binding request containing
+// unparsed attributes of the given types, each with a 1 byte body.
+func serializeAttributes(t *testing.T, types ...AttributeType) []byte {
+	m := Message{Header: Header{Type: 0x0001}}
+	for _, typ := range types {
+		m.Attributes = append(m.Attributes, &UnknownStunAttribute{typ, []byte{1}})
+	}
+	data, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Could not serialize message: %s", err)
+	}
+	return data
+}
+
+var testAttributes = AttributeSet{
+	0x1:    NewUnknownAttribute,
+	0x2:    NewUnknownAttribute,
+	0x8:    NewUnknownAttribute,
+	0x8028: NewUnknownAttribute,
+}
+
+func TestParseTruncatedAttribute(t *testing.T) {
+	data := serializeAttributes(t, 0x1)
+	// Claim a longer body than the message holds.
+	data[23] = 12
+
+	for _, strict := range []bool{false, true} {
+		var err error
+		if strict {
+			_, err = ParseStrict(data, nil, testAttributes, nil)
+		} else {
+			_, err = Parse(data, nil, testAttributes)
+		}
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Offset != 20 {
+			t.Errorf("Expected truncation error at offset 20, got %v", err)
+		}
+	}
+}
+
+func TestStrictParse(t *testing.T) {
+	cases := []struct {
+		name   string
+		types  []AttributeType
+		offset int
+	}{
+		{"valid", []AttributeType{0x1, 0x2, 0x8, 0x8028}, -1},
+		{"duplicate", []AttributeType{0x1, 0x2, 0x1}, 36},
+		{"after integrity", []AttributeType{0x1, 0x8, 0x2}, 36},
+		{"after fingerprint", []AttributeType{0x1, 0x8028, 0x8}, 36},
+		{"comprehension required", []AttributeType{0x1, 0x3}, 28},
+		{"comprehension optional", []AttributeType{0x1, 0x8003}, -1},
+	}
+
+	for _, c := range cases {
+		data := serializeAttributes(t, c.types...)
+		if _, err := Parse(data, nil, testAttributes); err != nil {
+			t.Errorf("%s: Lax parse failed: %s", c.name, err)
+		}
+		_, err := ParseStrict(data, nil, testAttributes, nil)
+		if c.offset < 0 {
+			if err != nil {
+				t.Errorf("%s: Strict parse failed: %s", c.name, err)
+			}
+			continue
+		}
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Offset != c.offset {
+			t.Errorf("%s: Expected error at offset %d, got %v", c.name, c.offset, err)
+		}
+	}
+}
+
+func TestStrictParsePadding(t *testing.T) {
+	data := serializeAttributes(t, 0x1)
+	data[26] = 0xff
+
+	if _, err := Parse(data, nil, testAttributes); err != nil {
+		t.Errorf("Lax parse failed: %s", err)
+	}
+	_, err := ParseStrict(data, nil, testAttributes, nil)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Offset != 26 {
+		t.Errorf("Expected padding error at offset 26, got %v", err)
+	}
+}
+
+func TestStrictParseAttributeCount(t *testing.T) {
+	types := make([]AttributeType, maxAttributes+1)
+	for i := range types {
+		types[i] = AttributeType(0xC000 + i)
+	}
+
+	if _, err := ParseStrict(serializeAttributes(t, types[:maxAttributes]...), nil, testAttributes, nil); err != nil {
+		t.Errorf("Strict parse of %d attributes failed: %s", maxAttributes, err)
+	}
+	_, err := ParseStrict(serializeAttributes(t, types...), nil, testAttributes, nil)
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Offset != 20+8*maxAttributes {
+		t.Errorf("Expected error at offset %d, got %v", 20+8*maxAttributes, err)
+	}
+}
+
+func TestStrictParseType(t *testing.T) {
+	data := serializeAttributes(t, 0x1)
+
+	if _, err := ParseStrict(data, nil, testAttributes, []HeaderType{0x0001}); err != nil {
+		t.Errorf("Strict parse of allowed type failed: %s", err)
+	}
+	if _, err := ParseStrict(data, nil, testAttributes, []HeaderType{0x0101}); err == nil {
+		t.Error("Strict parse accepted disallowed message type")
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	m := AcquireMessage()
+	defer ReleaseMessage(m)
+
+	for _, types := range [][]AttributeType{{0x1, 0x2}, {0x2}} {
+		data := serializeAttributes(t, types...)
+		if err := ParseInto(m, data, nil, testAttributes); err != nil {
+			t.Fatalf("Could not parse message: %s", err)
+		}
+		if len(m.RawAttributes()) != len(types) || len(m.Attributes) != 0 {
+			t.Fatalf("Expected %d undecoded attributes, got %d", len(types), len(m.RawAttributes()))
+		}
+		if attr := m.GetAttribute(0x2); attr == nil || (*attr).Type() != 0x2 {
+			t.Error("Could not lazily decode attribute")
+		}
+		if attr := m.GetAttribute(0x3); attr != nil {
+			t.Error("Decoded attribute not present in message")
+		}
+		if err := m.DecodeAttributes(); err != nil || len(m.Attributes) != len(types) {
+			t.Errorf("Could not decode attributes: %v", err)
+		}
+	}
+}
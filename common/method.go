@@ -0,0 +1,142 @@
+package stun
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Method is the operation of a STUN message, such as a Binding or an Allocate,
+// independent of whether the message is a request or a response.
+type Method uint16
+
+// Class indicates whether a STUN message is a request, an indication, or a
+// success or error response.
+type Class uint8
+
+// The four classes of STUN message defined by RFC 5389.
+const (
+	ClassRequest    Class = 0
+	ClassIndication Class = 1
+	ClassSuccess    Class = 2
+	ClassError      Class = 3
+)
+
+var (
+	methodNamesLock sync.RWMutex
+	methodNames     = make(map[Method]string)
+)
+
+// RegisterMethod records a name for a Method, used when printing messages.
+// Packages defining STUN methods should register them when initialized.
+func RegisterMethod(m Method, name string) {
+	methodNamesLock.Lock()
+	defer methodNamesLock.Unlock()
+	methodNames[m] = name
+}
+
+// Known indicates whether the Method has been registered.
+func (m Method) Known() bool {
+	methodNamesLock.RLock()
+	defer methodNamesLock.RUnlock()
+	_, ok := methodNames[m]
+	return ok
+}
+
+// String provides the registered name of a Method.
+func (m Method) String() string {
+	methodNamesLock.RLock()
+	defer methodNamesLock.RUnlock()
+	if name, ok := methodNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("Method(%#03x)", uint16(m))
+}
+
+// String provides a textual representation of a message Class.
+func (c Class) String() string {
+	switch c {
+	case ClassRequest:
+		return "Request"
+	case ClassIndication:
+		return "Indication"
+	case ClassSuccess:
+		return "Success Response"
+	case ClassError:
+		return "Error Response"
+	default:
+		return fmt.Sprintf("Class(%d)", uint8(c))
+	}
+}
+
+// NewHeaderType combines a Method and Class into a message type. The bits of
+// the class are interleaved with those of the method as defined in section 6 of
+// RFC 5389.
+func NewHeaderType(m Method, c Class) HeaderType {
+	t := uint16(m&0x000f) | uint16(m&0x0070)<<1 | uint16(m&0x0f80)<<2
+	t |= uint16(c&1)<<4 | uint16(c&2)<<7
+	return HeaderType(t)
+}
+
+// Method extracts the Method of a message type.
+func (h HeaderType) Method() Method {
+	return Method(h&0x000f | (h&0x00e0)>>1 | (h&0x3e00)>>2)
+}
+
+// Class extracts the Class of a message type.
+func (h HeaderType) Class() Class {
+	return Class((h>>4)&1 | (h>>7)&2)
+}
+
+// Response provides the success response type matching a request type.
+func (h HeaderType) Response() HeaderType {
+	return NewHeaderType(h.Method(), ClassSuccess)
+}
+
+// ErrorResponse provides the error response type matching a request type.
+func (h HeaderType) ErrorResponse() HeaderType {
+	return NewHeaderType(h.Method(), ClassError)
+}
+
+// String provides a textual representation of a message type, such as
+// "Binding Request".
+func (h HeaderType) String() string {
+	return h.Method().String() + " " + h.Class().String()
+}
+
+// classNames are the textual representations of the classes, as provided by
+// Class.String.
+var classNames = map[string]Class{
+	"Request":          ClassRequest,
+	"Indication":       ClassIndication,
+	"Success Response": ClassSuccess,
+	"Error Response":   ClassError,
+}
+
+// ParseHeaderType provides the message type with a textual representation
+// provided by HeaderType.String, such as "Binding Request".
+func ParseHeaderType(s string) (HeaderType, error) {
+	fail := errors.New("Unknown message type " + s)
+	name, class, ok := strings.Cut(s, " ")
+	c, known := classNames[class]
+	if !ok || !known {
+		return 0, fail
+	}
+	if number, ok := strings.CutPrefix(name, "Method("); ok {
+		n, err := strconv.ParseUint(strings.TrimSuffix(number, ")"), 0, 12)
+		if err != nil {
+			return 0, fail
+		}
+		return NewHeaderType(Method(n), c), nil
+	}
+	methodNamesLock.RLock()
+	defer methodNamesLock.RUnlock()
+	for m, registered := range methodNames {
+		if registered == name {
+			return NewHeaderType(m, c), nil
+		}
+	}
+	return 0, fail
+}
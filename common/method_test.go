@@ -0,0 +1,48 @@
+package stun
+
+import (
+	"testing"
+)
+
+func TestHeaderTypeDecomposition(t *testing.T) {
+	for m := Method(0); m < 0x1000; m++ {
+		for c := ClassRequest; c <= ClassError; c++ {
+			h := NewHeaderType(m, c)
+			if h>>14 != 0 {
+				t.Fatalf("%#x/%d produced type %#x with high bits set", uint16(m), c, uint16(h))
+			}
+			if h.Method() != m || h.Class() != c {
+				t.Fatalf("%#x/%d produced type %#x, which splits as %#x/%d",
+					uint16(m), c, uint16(h), uint16(h.Method()), h.Class())
+			}
+		}
+	}
+}
+
+func TestHeaderTypeString(t *testing.T) {
+	RegisterMethod(0x001, "Binding")
+	if s := HeaderType(0x0111).String(); s != "Binding Error Response" {
+		t.Errorf("Unexpected name %q for binding error", s)
+	}
+	if s := HeaderType(0x0022).String(); s != "Method(0x012) Request" {
+		t.Errorf("Unexpected name %q for unknown request", s)
+	}
+	if r := HeaderType(0x0001).Response(); r != 0x0101 {
+		t.Errorf("Unexpected response type %#x for binding request", uint16(r))
+	}
+}
+
+func TestParseHeaderType(t *testing.T) {
+	RegisterMethod(0x001, "Binding")
+	for _, h := range []HeaderType{0x0001, 0x0111, 0x0022, 0x0015} {
+		parsed, err := ParseHeaderType(h.String())
+		if err != nil || parsed != h {
+			t.Errorf("%q parsed as %#x (%v), expected %#x", h.String(), uint16(parsed), err, uint16(h))
+		}
+	}
+	for _, s := range []string{"Binding", "Binding Response", "Unregistered Request", "Method(0x1000) Request"} {
+		if _, err := ParseHeaderType(s); err == nil {
+			t.Errorf("Parsed invalid message type %q", s)
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package stun
 
 import (
-	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 )
 
@@ -25,14 +26,12 @@ func (h *UnknownStunAttribute) Type() AttributeType {
 }
 
 func (h *UnknownStunAttribute) Encode(msg *Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := WriteAttributeHeader(buf, Attribute(h), msg)
-	buf.Write(h.Data)
+	return h.Append(nil, 0, msg)
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+func (h *UnknownStunAttribute) Append(b []byte, _ int, msg *Message) ([]byte, error) {
+	b = AppendAttributeHeader(b, h, msg)
+	return append(b, h.Data...), nil
 }
 
 func (h *UnknownStunAttribute) Decode(data []byte, length uint16, _ *Parser) error {
@@ -46,3 +45,26 @@ func (h *UnknownStunAttribute) Decode(data []byte, length uint16, _ *Parser) err
 func (h *UnknownStunAttribute) Length(_ *Message) uint16 {
 	return uint16(len(h.Data))
 }
+
+// String provides the body of the attribute in hexadecimal.
+func (h *UnknownStunAttribute) String() string {
+	return hex.EncodeToString(h.Data)
+}
+
+// MarshalJSON encodes the body of the attribute as a hexadecimal string.
+func (h *UnknownStunAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h.Data))
+}
+
+func (h *UnknownStunAttribute) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	body, err := hex.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	h.Data = body
+	return nil
+}
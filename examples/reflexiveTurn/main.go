@@ -213,6 +213,6 @@ func main() {
 	if bytes.Compare(dataAttr.Data, message) == 0 {
 		log.Printf("Successfully sent and received \"hello world\".")
 	} else {
-		log.Fatal("Received data didn't match what was expected. Got: %s.", dataAttr.Data)
+		log.Fatalf("Received data didn't match what was expected. Got: %s.", dataAttr.Data)
 	}
 }
@@ -0,0 +1,104 @@
+package goturn
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/turn"
+)
+
+func TestMessageString(t *testing.T) {
+	msg, err := NewMessageBuilder(AllocateResponse).
+		XorRelayedAddress(&net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 50000}).
+		Lifetime(10 * time.Minute).
+		Credentials(common.Credentials{Nonce: []byte("nonce"), Username: "user", Realm: "realm", Password: "pass"}).
+		Authenticated().
+		Build()
+	if err != nil {
+		t.Fatalf("Could not build message: %s", err)
+	}
+	copy(msg.Header.Id[:], "0123456789ab")
+
+	expected := "Allocate Success Response id=303132333435363738396162\n" +
+		"  XOR-RELAYED-ADDRESS 198.51.100.1:50000\n" +
+		"  LIFETIME 10m0s\n" +
+		"  NONCE \"nonce\"\n" +
+		"  USERNAME \"user\"\n" +
+		"  REALM \"realm\"\n" +
+		"  MESSAGE-INTEGRITY"
+	if s := msg.String(); s != expected {
+		t.Errorf("Unexpected rendering of message:\n%s", s)
+	}
+	if s := common.AttributeType(0x8055).String(); s != "0x8055" {
+		t.Errorf("Unexpected name %q for unregistered attribute", s)
+	}
+}
+
+func TestMessageJSONRoundtrip(t *testing.T) {
+	credentials := common.Credentials{Nonce: []byte("nonce"), Username: "user", Realm: "realm", Password: "pass"}
+	request, err := NewMessageBuilder(AllocateRequest).
+		RequestedTransport("udp").
+		AddressFamilies(turn.FamilyIPv4, turn.FamilyIPv6).
+		EvenPort(true).
+		DontFragment().
+		Lifetime(time.Hour).
+		Credentials(credentials).
+		Authenticated().
+		Fingerprint().
+		Build()
+	if err != nil {
+		t.Fatalf("Could not build message: %s", err)
+	}
+	response, err := NewErrorResponseBuilder(request.Header, 420, "Unknown Attribute").
+		UnknownAttributes(0x8055, turn.Lifetime).
+		XorMappedAddress(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 3478}).
+		AddressErrorCode(turn.FamilyIPv6, 440, "Address Family not Supported").
+		ReservationToken(0xfedcba9876543210).
+		Software("goturn").
+		Build()
+	if err != nil {
+		t.Fatalf("Could not build message: %s", err)
+	}
+
+	for _, msg := range []*common.Message{request, response} {
+		encoded, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("Could not encode %s: %s", msg.Header.Type, err)
+		}
+		if strings.Contains(string(encoded), "pass") {
+			t.Errorf("Password was encoded in %s", encoded)
+		}
+
+		decoded := new(common.Message)
+		if err := json.Unmarshal(encoded, decoded); err != nil {
+			t.Fatalf("Could not decode %s: %s", encoded, err)
+		}
+		if decoded.String() != msg.String() {
+			t.Errorf("Decoded message\n%s\ndiffers from\n%s", decoded, msg)
+		}
+		reencoded, err := json.Marshal(decoded)
+		if err != nil || string(reencoded) != string(encoded) {
+			t.Errorf("Decoded message encoded as %s, expected %s", reencoded, encoded)
+		}
+
+		// Decoded messages serialize as the original once given the password.
+		decoded.Credentials.Password = credentials.Password
+		data, err := decoded.Serialize()
+		if err != nil {
+			t.Fatalf("Could not serialize decoded message: %s", err)
+		}
+		original, _ := msg.Serialize()
+		if string(data) != string(original) {
+			t.Errorf("Decoded %s serialized differently from the original", msg.Header.Type)
+		}
+	}
+
+	var msg common.Message
+	if err := json.Unmarshal([]byte(`{"type":"Binding Request","transaction":"00","attributes":[]}`), &msg); err == nil {
+		t.Error("Decoded message with a short transaction ID")
+	}
+}
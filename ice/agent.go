@@ -0,0 +1,720 @@
+package ice
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/willscott/goturn"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+)
+
+const (
+	// defaultCheckInterval is the pacing of connectivity checks (Ta).
+	defaultCheckInterval = 50 * time.Millisecond
+	// defaultConnectTimeout bounds how long Connect waits for a selected pair.
+	defaultConnectTimeout = 10 * time.Second
+	// checkTimeout is the time after which an unanswered check is resent.
+	checkTimeout = 500 * time.Millisecond
+	// maxCheckAttempts is the number of times a check is sent before the pair
+	// is considered to have failed.
+	maxCheckAttempts = 7
+)
+
+// pairState is the progress of the connectivity checks of a candidate pair.
+type pairState int
+
+const (
+	pairWaiting pairState = iota
+	pairInProgress
+	pairSucceeded
+	pairFailed
+)
+
+// candidatePair is an entry in the checklist of an Agent.
+type candidatePair struct {
+	local  *Candidate
+	remote *Candidate
+
+	priority uint64
+	state    pairState
+	// A check on the pair should be sent before ordinary checks, because the
+	// peer has sent a check on it.
+	triggered bool
+	// The controlling agent is nominating the pair with USE-CANDIDATE.
+	nominating bool
+	// The controlled agent has received a USE-CANDIDATE for the pair.
+	nominated bool
+
+	// The outstanding check.
+	transaction [12]byte
+	request     []byte
+	controlling bool
+	sent        time.Time
+	attempts    int
+}
+
+// outgoing is a packet to be sent once the lock of the Agent is released.
+type outgoing struct {
+	conn net.PacketConn
+	data []byte
+	to   net.Addr
+}
+
+// Agent runs ICE connectivity checks between gathered local candidates and the
+// candidates of a peer for a single component, and provides a connection over
+// the pair of candidates it selects. It implements the checks, role conflict
+// resolution, and regular nomination of RFC 8445, without freezing pairs by
+// foundation.
+//
+// Local and remote ICE credentials, and candidates, are exchanged with the peer
+// by the application, typically in SDP:
+//
+//	agent, err := ice.NewAgent(true, candidates)
+//	ufrag, pwd := agent.LocalCredentials()
+//	// ... signal ufrag, pwd and candidates to the peer, and learn theirs.
+//	agent.SetRemoteCredentials(remoteUfrag, remotePwd)
+//	for _, c := range remoteCandidates {
+//	  agent.AddRemoteCandidate(c)
+//	}
+//	conn, err := agent.Connect()
+type Agent struct {
+	// The time between connectivity checks (Ta). Defaults to 50ms.
+	Interval time.Duration
+	// How long Connect waits for a pair to be selected. Defaults to 10 seconds.
+	Timeout time.Duration
+	// The average time between consent freshness requests on the selected
+	// pair. Defaults to 5 seconds.
+	ConsentInterval time.Duration
+	// How long the peer's consent lasts without a response. Defaults to 30
+	// seconds.
+	ConsentTimeout time.Duration
+
+	localUfrag string
+	localPwd   string
+	tieBreaker uint64
+
+	lock        sync.Mutex
+	remoteUfrag string
+	remotePwd   string
+	controlling bool
+	local       []*Candidate
+	remote      []*Candidate
+	pairs       []*candidatePair
+	nominating  *candidatePair
+	selected    *candidatePair
+	consent     *ConsentMonitor
+	running     bool
+
+	incoming     chan datagram
+	selectedChan chan struct{}
+	closed       chan struct{}
+	closeOnce    sync.Once
+	readers      sync.WaitGroup
+}
+
+// NewAgent creates an Agent for gathered local candidates, in the controlling
+// or controlled role. The controlling agent, usually the one which initiated
+// the session, decides which pair is used. The Agent reads from the sockets
+// of the candidates until it is closed.
+func NewAgent(controlling bool, local []*Candidate) (*Agent, error) {
+	a := &Agent{
+		controlling:  controlling,
+		local:        local,
+		incoming:     make(chan datagram, 64),
+		selectedChan: make(chan struct{}),
+		closed:       make(chan struct{}),
+	}
+	var err error
+	if a.localUfrag, err = randomString(6); err != nil {
+		return nil, err
+	}
+	if a.localPwd, err = randomString(18); err != nil {
+		return nil, err
+	}
+	var tieBreaker [8]byte
+	if _, err = rand.Read(tieBreaker[:]); err != nil {
+		return nil, err
+	}
+	a.tieBreaker = binary.BigEndian.Uint64(tieBreaker[:])
+
+	for _, base := range a.bases() {
+		if base.conn == nil {
+			return nil, errors.New("Candidate has no socket: " + base.String())
+		}
+		a.readers.Add(1)
+		go a.read(base.conn)
+	}
+	return a, nil
+}
+
+// randomString generates an ICE ufrag or password from n random bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// LocalCredentials provides the username fragment and password the peer must
+// use in connectivity checks sent to the Agent.
+func (a *Agent) LocalCredentials() (ufrag, pwd string) {
+	return a.localUfrag, a.localPwd
+}
+
+// SetRemoteCredentials sets the username fragment and password of the peer.
+func (a *Agent) SetRemoteCredentials(ufrag, pwd string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.remoteUfrag = ufrag
+	a.remotePwd = pwd
+}
+
+// LocalCandidates provides the candidates of the Agent, to be sent to the peer.
+func (a *Agent) LocalCandidates() []*Candidate {
+	return a.local
+}
+
+// Controlling indicates whether the Agent currently has the controlling role,
+// which may change if the peer claims the same role.
+func (a *Agent) Controlling() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.controlling
+}
+
+// AddRemoteCandidate adds a candidate of the peer, pairing it with the local
+// candidates of the same component and address family. Candidates may be
+// added while Connect is running.
+func (a *Agent) AddRemoteCandidate(remote *Candidate) error {
+	if remote.Address == nil {
+		return errors.New("Candidate has no address.")
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.addRemote(remote)
+	return nil
+}
+
+// bases provides the local candidates checks are sent from. Server reflexive
+// candidates share the socket of their host candidate, so pairs with them are
+// redundant, as described in section 6.1.2.4 of RFC 8445.
+func (a *Agent) bases() []*Candidate {
+	var bases []*Candidate
+	for _, c := range a.local {
+		if c.Type == Host || c.Type == Relayed {
+			bases = append(bases, c)
+		}
+	}
+	return bases
+}
+
+// addRemote records a remote candidate and forms its pairs. The lock must be
+// held.
+func (a *Agent) addRemote(remote *Candidate) {
+	a.remote = append(a.remote, remote)
+	for _, local := range a.bases() {
+		if local.Component != remote.Component || (local.Address.IP.To4() == nil) != (remote.Address.IP.To4() == nil) {
+			continue
+		}
+		a.pairs = append(a.pairs, &candidatePair{local: local, remote: remote})
+	}
+	a.prioritize()
+}
+
+// prioritize computes pair priorities as in section 6.1.2.3 of RFC 8445 and
+// sorts the checklist. The lock must be held.
+func (a *Agent) prioritize() {
+	for _, p := range a.pairs {
+		g, d := uint64(p.local.Priority), uint64(p.remote.Priority)
+		if !a.controlling {
+			g, d = d, g
+		}
+		min, max := g, d
+		if min > max {
+			min, max = max, min
+		}
+		p.priority = min<<32 + 2*max
+		if g > d {
+			p.priority++
+		}
+	}
+	sort.SliceStable(a.pairs, func(i, j int) bool {
+		return a.pairs[i].priority > a.pairs[j].priority
+	})
+}
+
+// setControlling switches the role of the Agent after a role conflict. The
+// lock must be held.
+func (a *Agent) setControlling(controlling bool) {
+	if a.controlling == controlling {
+		return
+	}
+	a.controlling = controlling
+	if a.nominating != nil {
+		a.nominating.nominating = false
+		a.nominating = nil
+	}
+	a.prioritize()
+}
+
+// Connect runs connectivity checks until a pair is selected, and provides a
+// connection over it. The remote credentials must be set first.
+func (a *Agent) Connect() (*Conn, error) {
+	a.lock.Lock()
+	if a.remoteUfrag == "" || a.remotePwd == "" {
+		a.lock.Unlock()
+		return nil, errors.New("Remote credentials are not set.")
+	}
+	if !a.running {
+		a.running = true
+		go a.run()
+	}
+	a.lock.Unlock()
+
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = defaultConnectTimeout
+	}
+	select {
+	case <-a.selectedChan:
+	case <-time.After(timeout):
+		return nil, errors.New("ICE connectivity checks failed.")
+	case <-a.closed:
+		return nil, net.ErrClosed
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return newConn(a, a.selected), nil
+}
+
+// Close stops the Agent. The sockets of the candidates remain owned by the
+// Gatherer which created them.
+func (a *Agent) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.closed)
+		a.lock.Lock()
+		consent := a.consent
+		a.lock.Unlock()
+		if consent != nil {
+			consent.Stop()
+		}
+		// Wake the readers, which stop once they see the Agent is closed, and
+		// leave the sockets without a deadline for the Gatherer.
+		bases := a.bases()
+		for _, base := range bases {
+			base.conn.SetReadDeadline(time.Now())
+		}
+		a.readers.Wait()
+		for _, base := range bases {
+			base.conn.SetReadDeadline(time.Time{})
+		}
+	})
+	return nil
+}
+
+// run paces connectivity checks until a pair is selected.
+func (a *Agent) run() {
+	interval := a.Interval
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.closed:
+			return
+		case <-a.selectedChan:
+			return
+		case <-ticker.C:
+		}
+		for _, o := range a.nextChecks() {
+			o.conn.WriteTo(o.data, o.to)
+		}
+	}
+}
+
+// nextChecks retransmits unanswered checks, and starts the next check: a
+// triggered check if there is one, or else the highest priority waiting pair.
+func (a *Agent) nextChecks() []outgoing {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var out []outgoing
+	now := time.Now()
+	for _, p := range a.pairs {
+		if p.state != pairInProgress || now.Sub(p.sent) < checkTimeout {
+			continue
+		}
+		if p.attempts >= maxCheckAttempts {
+			a.fail(p)
+			continue
+		}
+		p.sent = now
+		p.attempts++
+		out = append(out, outgoing{p.local.conn, p.request, p.remote.Address})
+	}
+
+	a.nominate()
+
+	var next *candidatePair
+	for _, p := range a.pairs {
+		if p.state == pairWaiting && p.triggered {
+			next = p
+			break
+		}
+	}
+	if next == nil {
+		for _, p := range a.pairs {
+			if p.state == pairWaiting {
+				next = p
+				break
+			}
+		}
+	}
+	if next != nil {
+		if o, err := a.startCheck(next); err == nil {
+			out = append(out, o)
+		} else {
+			a.fail(next)
+		}
+	}
+	return out
+}
+
+// nominate has the controlling agent nominate the best succeeded pair, once no
+// pair of higher priority is still being checked. The lock must be held.
+func (a *Agent) nominate() {
+	if !a.controlling || a.nominating != nil || a.selected != nil {
+		return
+	}
+	for _, p := range a.pairs {
+		switch p.state {
+		case pairWaiting, pairInProgress:
+			// A better pair may yet succeed.
+			return
+		case pairSucceeded:
+			p.nominating = true
+			p.state = pairWaiting
+			p.triggered = true
+			a.nominating = p
+			return
+		}
+	}
+}
+
+// fail marks a pair as failed. The lock must be held.
+func (a *Agent) fail(p *candidatePair) {
+	p.state = pairFailed
+	if a.nominating == p {
+		p.nominating = false
+		a.nominating = nil
+	}
+}
+
+// startCheck builds a connectivity check for a pair. The lock must be held.
+func (a *Agent) startCheck(p *candidatePair) (outgoing, error) {
+	preference := uint16(p.local.Priority >> 8)
+	builder := goturn.NewMessageBuilder(goturn.BindingRequest).
+		Priority(Priority(PeerReflexive, preference, p.local.Component))
+	if a.controlling {
+		builder.IceControlling(a.tieBreaker)
+		if p.nominating {
+			builder.UseCandidate()
+		}
+	} else {
+		builder.IceControlled(a.tieBreaker)
+	}
+	msg, err := builder.
+		Credentials(common.Credentials{Username: a.remoteUfrag + ":" + a.localUfrag, Password: a.remotePwd}).
+		ShortTermAuthenticated().
+		Fingerprint().
+		Build()
+	if err != nil {
+		return outgoing{}, err
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		return outgoing{}, err
+	}
+
+	p.state = pairInProgress
+	p.triggered = false
+	p.transaction = msg.Header.Id
+	p.request = data
+	p.controlling = a.controlling
+	p.sent = time.Now()
+	p.attempts = 1
+	return outgoing{p.local.conn, data, p.remote.Address}, nil
+}
+
+// selectPair completes the checks with the pair to use. The lock must be held.
+func (a *Agent) selectPair(p *candidatePair) {
+	if a.selected != nil {
+		return
+	}
+	a.selected = p
+	close(a.selectedChan)
+
+	// Keep checking that the peer consents to receive traffic on the pair.
+	a.consent = NewConsentMonitor(p.local.conn, p.remote.Address, a.remoteUfrag+":"+a.localUfrag, a.remotePwd)
+	a.consent.Interval = a.ConsentInterval
+	a.consent.Timeout = a.ConsentTimeout
+	a.consent.Priority = Priority(PeerReflexive, uint16(p.local.Priority>>8), p.local.Component)
+	controlling, tieBreaker := a.controlling, a.tieBreaker
+	a.consent.decorate = func(b *goturn.MessageBuilder) {
+		if controlling {
+			b.IceControlling(tieBreaker)
+		} else {
+			b.IceControlled(tieBreaker)
+		}
+	}
+	a.consent.Start()
+}
+
+// read receives packets on the socket of a local candidate, handling STUN
+// messages and delivering other data to the connection.
+func (a *Agent) read(base net.PacketConn) {
+	defer a.readers.Done()
+	buffer := make([]byte, 65536)
+	for {
+		n, from, err := base.ReadFrom(buffer)
+		select {
+		case <-a.closed:
+			return
+		default:
+		}
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+
+		var header common.Header
+		if header.Decode(buffer[0:n]) != nil {
+			a.deliver(from, buffer[0:n])
+			continue
+		}
+		switch header.Type {
+		case goturn.BindingRequest:
+			a.handleCheck(base, from, buffer[0:n])
+		case goturn.BindingResponse, goturn.BindingError:
+			a.lock.Lock()
+			consent := a.consent
+			a.lock.Unlock()
+			if consent != nil && consent.HandleResponse(from, buffer[0:n]) {
+				continue
+			}
+			a.handleResponse(base, from, header.Id, buffer[0:n])
+		}
+	}
+}
+
+// deliver passes data received from a known remote candidate to the
+// connection.
+func (a *Agent) deliver(from net.Addr, data []byte) {
+	a.lock.Lock()
+	known := a.findRemote(from) != nil
+	a.lock.Unlock()
+	if !known {
+		return
+	}
+	select {
+	case a.incoming <- datagram{append([]byte(nil), data...), from}:
+	default:
+		// Datagrams are dropped when the application falls behind.
+	}
+}
+
+// findRemote locates the remote candidate with an address. The lock must be
+// held.
+func (a *Agent) findRemote(addr net.Addr) *Candidate {
+	for _, c := range a.remote {
+		if sameAddress(c.Address, addr) {
+			return c
+		}
+	}
+	return nil
+}
+
+// findPair locates the pair of a local base and remote candidate. The lock
+// must be held.
+func (a *Agent) findPair(base net.PacketConn, remote *Candidate) *candidatePair {
+	for _, p := range a.pairs {
+		if p.local.conn == base && p.remote == remote {
+			return p
+		}
+	}
+	return nil
+}
+
+// handleCheck answers a connectivity check from the peer, as described in
+// section 7.3 of RFC 8445.
+func (a *Agent) handleCheck(base net.PacketConn, from net.Addr, data []byte) {
+	msg, err := goturn.ParseTurn(data, &common.Credentials{Password: a.localPwd})
+	if err != nil || !stun.HasMessageIntegrity(msg) {
+		return
+	}
+	username, ok := stun.GetUsername(msg)
+	if !ok || !strings.HasPrefix(username, a.localUfrag+":") {
+		return
+	}
+	priority, ok := stun.GetPriority(msg)
+	if !ok {
+		a.respond(base, from, goturn.NewErrorResponseBuilder(msg.Header, 400, "Bad Request"))
+		return
+	}
+
+	a.lock.Lock()
+	// Resolve role conflicts using the tie-breakers, per section 7.3.1.1.
+	conflict := false
+	if theirs, ok := stun.GetIceControlling(msg); ok && a.controlling {
+		if a.tieBreaker >= theirs {
+			conflict = true
+		} else {
+			a.setControlling(false)
+		}
+	} else if theirs, ok := stun.GetIceControlled(msg); ok && !a.controlling {
+		if a.tieBreaker >= theirs {
+			a.setControlling(true)
+		} else {
+			conflict = true
+		}
+	}
+	if conflict {
+		a.lock.Unlock()
+		a.respond(base, from, goturn.NewErrorResponseBuilder(msg.Header, 487, "Role Conflict"))
+		return
+	}
+
+	// Checks from unknown addresses reveal peer reflexive candidates.
+	remote := a.findRemote(from)
+	if remote == nil {
+		address := udpAddress(from)
+		remote = &Candidate{
+			Foundation: Foundation(PeerReflexive, address.IP, nil, "udp"),
+			Component:  1,
+			Protocol:   "udp",
+			Priority:   priority,
+			Address:    address,
+			Type:       PeerReflexive,
+		}
+		for _, local := range a.bases() {
+			if local.conn == base {
+				remote.Component = local.Component
+			}
+		}
+		a.addRemote(remote)
+	}
+
+	if p := a.findPair(base, remote); p != nil {
+		if stun.HasUseCandidate(msg) && !a.controlling {
+			p.nominated = true
+			if p.state == pairSucceeded {
+				a.selectPair(p)
+			}
+		}
+		if p.state != pairSucceeded && p.state != pairInProgress {
+			p.state = pairWaiting
+			p.triggered = true
+		}
+	}
+	a.lock.Unlock()
+
+	a.respond(base, from, goturn.NewResponseBuilder(msg.Header).XorMappedAddress(from))
+}
+
+// respond sends a response to a connectivity check, authenticated with the
+// local password.
+func (a *Agent) respond(base net.PacketConn, to net.Addr, response *goturn.MessageBuilder) {
+	msg, err := response.
+		Credentials(common.Credentials{Password: a.localPwd}).
+		Attribute(&stun.MessageIntegrityAttribute{}).
+		Fingerprint().
+		Build()
+	if err != nil {
+		return
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		return
+	}
+	base.WriteTo(data, to)
+}
+
+// handleResponse processes the response to a connectivity check, as described
+// in section 7.2.5 of RFC 8445.
+func (a *Agent) handleResponse(base net.PacketConn, from net.Addr, id [12]byte, data []byte) {
+	a.lock.Lock()
+	var pair *candidatePair
+	for _, p := range a.pairs {
+		if p.state == pairInProgress && p.transaction == id {
+			pair = p
+			break
+		}
+	}
+	remotePwd := a.remotePwd
+	a.lock.Unlock()
+	if pair == nil {
+		return
+	}
+
+	msg, err := goturn.ParseTurn(data, &common.Credentials{Password: remotePwd})
+	if err != nil || !stun.HasMessageIntegrity(msg) {
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if pair.state != pairInProgress || pair.transaction != id {
+		return
+	}
+	// Responses must come from the address the check was sent to.
+	if base != pair.local.conn || !sameAddress(pair.remote.Address, from) {
+		a.fail(pair)
+		return
+	}
+
+	if msg.Header.Type == goturn.BindingError {
+		if code, ok := stun.GetError(msg); ok && code.Error() == 487 {
+			a.setControlling(!pair.controlling)
+			pair.state = pairWaiting
+			pair.triggered = true
+			return
+		}
+		a.fail(pair)
+		return
+	}
+
+	pair.state = pairSucceeded
+	if a.nominating == pair || (!a.controlling && pair.nominated) {
+		a.selectPair(pair)
+	}
+}
+
+// udpAddress converts an address received on a candidate socket.
+func udpAddress(addr net.Addr) *net.UDPAddr {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		return udp
+	}
+	address := common.Address{addr}
+	return &net.UDPAddr{IP: address.Host(), Port: int(address.Port())}
+}
+
+// sameAddress compares a candidate address with an address packets arrived
+// from.
+func sameAddress(a *net.UDPAddr, b net.Addr) bool {
+	addr := udpAddress(b)
+	return a.IP.Equal(addr.IP) && a.Port == addr.Port
+}
@@ -0,0 +1,212 @@
+package ice
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// loopbackAgent gathers a host candidate on the loopback interface, and
+// creates an agent for it.
+func loopbackAgent(t *testing.T, controlling bool) (*Agent, *Gatherer) {
+	gatherer := &Gatherer{Addresses: []net.IP{net.IPv4(127, 0, 0, 1)}}
+	candidates, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent, err := NewAgent(controlling, candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent, gatherer
+}
+
+// signal exchanges credentials and candidates between agents, as SDP.
+func signal(t *testing.T, from, to *Agent) {
+	ufrag, pwd := from.LocalCredentials()
+	to.SetRemoteCredentials(ufrag, pwd)
+	for _, c := range from.LocalCandidates() {
+		remote, err := ParseCandidate(c.SDP())
+		if err != nil {
+			t.Fatal(err)
+		}
+		to.AddRemoteCandidate(remote)
+	}
+}
+
+// connectAgents connects two agents, and checks data flows between them.
+func connectAgents(t *testing.T, a, b *Agent) (*Conn, *Conn) {
+	signal(t, a, b)
+	signal(t, b, a)
+
+	conns := make(chan *Conn, 2)
+	for _, agent := range []*Agent{a, b} {
+		go func(agent *Agent) {
+			conn, err := agent.Connect()
+			if err != nil {
+				t.Error(err)
+			}
+			conns <- conn
+		}(agent)
+	}
+	first, second := <-conns, <-conns
+	if first == nil || second == nil {
+		t.FailNow()
+	}
+
+	if _, err := first.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 100)
+	n, err := second.Read(buffer)
+	if err != nil || string(buffer[0:n]) != "hello" {
+		t.Fatalf("Data not received over selected pair: %v %q", err, buffer[0:n])
+	}
+	return first, second
+}
+
+func TestAgentConnect(t *testing.T) {
+	a, ga := loopbackAgent(t, true)
+	defer ga.Close()
+	defer a.Close()
+	b, gb := loopbackAgent(t, false)
+	defer gb.Close()
+	defer b.Close()
+
+	connectAgents(t, a, b)
+	if !a.Controlling() || b.Controlling() {
+		t.Error("Agents changed roles without a conflict")
+	}
+}
+
+func TestConnReadsSelectedPair(t *testing.T) {
+	a, ga := loopbackAgent(t, true)
+	defer ga.Close()
+	defer a.Close()
+	b, gb := loopbackAgent(t, false)
+	defer gb.Close()
+	defer b.Close()
+
+	first, second := connectAgents(t, a, b)
+
+	// Another candidate of the peer, which is not part of the selected pair.
+	stray, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stray.Close()
+	second.agent.AddRemoteCandidate(&Candidate{
+		Foundation: "stray",
+		Component:  1,
+		Protocol:   "udp",
+		Priority:   1,
+		Address:    stray.LocalAddr().(*net.UDPAddr),
+		Type:       Host,
+	})
+	local, _ := second.Candidates()
+	if _, err := stray.WriteTo([]byte("stray"), local.Address); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := first.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 100)
+	n, err := second.Read(buffer)
+	if err != nil || string(buffer[0:n]) != "hello" {
+		t.Errorf("Expected data from the selected pair, got %v %q", err, buffer[0:n])
+	}
+}
+
+func TestAgentRoleConflict(t *testing.T) {
+	a, ga := loopbackAgent(t, true)
+	defer ga.Close()
+	defer a.Close()
+	b, gb := loopbackAgent(t, true)
+	defer gb.Close()
+	defer b.Close()
+
+	connectAgents(t, a, b)
+	if a.Controlling() == b.Controlling() {
+		t.Error("Role conflict was not resolved")
+	}
+}
+
+func TestConsentExpiry(t *testing.T) {
+	a, ga := loopbackAgent(t, true)
+	defer ga.Close()
+	defer a.Close()
+	b, gb := loopbackAgent(t, false)
+	defer gb.Close()
+	defer b.Close()
+	for _, agent := range []*Agent{a, b} {
+		agent.ConsentInterval = 20 * time.Millisecond
+		agent.ConsentTimeout = 200 * time.Millisecond
+	}
+
+	first, second := connectAgents(t, a, b)
+	conn := first
+	if first.agent != a {
+		conn = second
+	}
+
+	// Consent is kept while the peer answers.
+	select {
+	case state := <-conn.Consent():
+		t.Fatalf("Consent changed to %s while peer was answering", state)
+	case <-time.After(400 * time.Millisecond):
+	}
+	if _, err := conn.Write([]byte("still here")); err != nil {
+		t.Fatalf("Write failed with consent: %s", err)
+	}
+
+	// Once the peer stops answering, consent expires and sending stops.
+	b.Close()
+	select {
+	case state := <-conn.Consent():
+		if state != ConsentExpired {
+			t.Errorf("Unexpected consent state %s", state)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Consent did not expire")
+	}
+	if _, err := conn.Write([]byte("gone")); err != ErrConsentExpired {
+		t.Errorf("Write after expiry returned %v", err)
+	}
+}
+
+func TestAgentCloseReleasesSockets(t *testing.T) {
+	a, ga := loopbackAgent(t, true)
+	defer ga.Close()
+	a.Close()
+
+	base := a.bases()[0].conn
+	sender, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if _, err := sender.WriteTo([]byte("hello"), base.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The socket is readable by its owner once the Agent is closed.
+	done := make(chan error, 1)
+	go func() {
+		buffer := make([]byte, 100)
+		_, _, err := base.ReadFrom(buffer)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Could not read from candidate socket after Close: %s", err)
+		}
+	case <-time.After(time.Second):
+		base.SetReadDeadline(time.Now())
+		t.Error("Candidate socket not read after Close")
+	}
+}
@@ -0,0 +1,183 @@
+// Package ice gathers and connects Interactive Connectivity Establishment
+// (RFC 8445) candidates, using STUN and TURN servers to discover addresses at
+// which peers behind NATs can reach each other.
+package ice
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// CandidateType indicates how the address of a candidate was learned.
+type CandidateType int
+
+const (
+	// Host candidates are addresses of local interfaces.
+	Host CandidateType = iota
+	// ServerReflexive candidates are addresses of a host candidate as seen by
+	// a STUN server, typically the external address of a NAT.
+	ServerReflexive
+	// PeerReflexive candidates are addresses learned from the connectivity
+	// checks of a peer.
+	PeerReflexive
+	// Relayed candidates are addresses allocated on a TURN server.
+	Relayed
+)
+
+// String provides the name of a candidate type used in SDP.
+func (t CandidateType) String() string {
+	switch t {
+	case Host:
+		return "host"
+	case ServerReflexive:
+		return "srflx"
+	case PeerReflexive:
+		return "prflx"
+	case Relayed:
+		return "relay"
+	default:
+		return "unknown"
+	}
+}
+
+// Preference provides the type preference of a candidate type recommended by
+// section 5.1.2.2 of RFC 8445.
+func (t CandidateType) Preference() uint32 {
+	switch t {
+	case Host:
+		return 126
+	case PeerReflexive:
+		return 110
+	case ServerReflexive:
+		return 100
+	default:
+		return 0
+	}
+}
+
+// Priority computes the priority of a candidate as defined in section 5.1.2.1
+// of RFC 8445, from its type, the local preference of its interface, and its
+// component ID.
+func Priority(t CandidateType, localPreference uint16, component int) uint32 {
+	return t.Preference()<<24 | uint32(localPreference)<<8 | uint32(256-component)
+}
+
+// Foundation computes the foundation of a candidate, which is shared by
+// candidates of the same type, from the same base IP, learned from the same
+// STUN or TURN server, as described in section 5.1.1.3 of RFC 8445.
+func Foundation(t CandidateType, base net.IP, server net.IP, protocol string) string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s|%s|%s|%s", t, base, server, protocol)
+	return strconv.FormatUint(uint64(h.Sum32()), 10)
+}
+
+// Candidate is a transport address which may be used to communicate with a
+// peer.
+type Candidate struct {
+	Foundation string
+	Component  int
+	Protocol   string
+	Priority   uint32
+	Address    *net.UDPAddr
+	Type       CandidateType
+	// The related address: the base of server reflexive candidates and the
+	// local address of the connection to the TURN server for relayed
+	// candidates. Nil for host candidates.
+	Related *net.UDPAddr
+
+	// The base traffic for the candidate is sent from: the socket of host
+	// candidates, which is shared with the server reflexive candidates learned
+	// through them, or the TURN allocation of relayed candidates.
+	conn net.PacketConn
+}
+
+// String provides the candidate in the format of an SDP candidate attribute
+// value, as defined in section 5.1 of RFC 8839.
+func (c *Candidate) String() string {
+	line := fmt.Sprintf("candidate:%s %d %s %d %s %d typ %s",
+		c.Foundation, c.Component, c.Protocol, c.Priority, c.Address.IP, c.Address.Port, c.Type)
+	if c.Related != nil {
+		line += fmt.Sprintf(" raddr %s rport %d", c.Related.IP, c.Related.Port)
+	}
+	return line
+}
+
+// SDP provides the candidate as an SDP "a=candidate" line.
+func (c *Candidate) SDP() string {
+	return "a=" + c.String()
+}
+
+// ParseCandidate reads a candidate from an SDP candidate attribute, with or
+// without the "a=" prefix.
+func ParseCandidate(line string) (*Candidate, error) {
+	line = strings.TrimPrefix(strings.TrimSpace(line), "a=")
+	if !strings.HasPrefix(line, "candidate:") {
+		return nil, errors.New("Not a candidate attribute.")
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, "candidate:"))
+	if len(fields) < 8 || fields[6] != "typ" {
+		return nil, errors.New("Malformed candidate attribute.")
+	}
+
+	c := &Candidate{Foundation: fields[0], Protocol: strings.ToLower(fields[2])}
+	component, err := strconv.Atoi(fields[1])
+	if err != nil || component < 1 || component > 256 {
+		return nil, errors.New("Invalid candidate component.")
+	}
+	c.Component = component
+	priority, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return nil, errors.New("Invalid candidate priority.")
+	}
+	c.Priority = uint32(priority)
+	if c.Address, err = parseCandidateAddress(fields[4], fields[5]); err != nil {
+		return nil, err
+	}
+	switch fields[7] {
+	case "host":
+		c.Type = Host
+	case "srflx":
+		c.Type = ServerReflexive
+	case "prflx":
+		c.Type = PeerReflexive
+	case "relay":
+		c.Type = Relayed
+	default:
+		return nil, errors.New("Unknown candidate type: " + fields[7])
+	}
+
+	// Extension attributes are name/value pairs, of which only the related
+	// address is understood.
+	var raddr, rport string
+	for i := 8; i+1 < len(fields); i += 2 {
+		switch fields[i] {
+		case "raddr":
+			raddr = fields[i+1]
+		case "rport":
+			rport = fields[i+1]
+		}
+	}
+	if raddr != "" && rport != "" {
+		if c.Related, err = parseCandidateAddress(raddr, rport); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// parseCandidateAddress reads the IP and port fields of a candidate.
+func parseCandidateAddress(host, port string) (*net.UDPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.New("Invalid candidate address: " + host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errors.New("Invalid candidate port: " + port)
+	}
+	return &net.UDPAddr{IP: ip, Port: int(p)}, nil
+}
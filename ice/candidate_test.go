@@ -0,0 +1,60 @@
+package ice
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPriority(t *testing.T) {
+	if p := Priority(Host, 65535, 1); p != 2130706431 {
+		t.Errorf("Unexpected host priority %d", p)
+	}
+	if p := Priority(ServerReflexive, 65535, 1); p != 1694498815 {
+		t.Errorf("Unexpected server reflexive priority %d", p)
+	}
+	if p := Priority(Relayed, 65535, 2); p != 16777214 {
+		t.Errorf("Unexpected relayed priority %d", p)
+	}
+}
+
+func TestFoundation(t *testing.T) {
+	base := net.IPv4(10, 0, 0, 1)
+	server := net.IPv4(192, 0, 2, 1)
+	if Foundation(ServerReflexive, base, server, "udp") != Foundation(ServerReflexive, base, server, "udp") {
+		t.Error("Foundations of equivalent candidates differ")
+	}
+	if Foundation(ServerReflexive, base, server, "udp") == Foundation(Host, base, nil, "udp") {
+		t.Error("Foundations of different types match")
+	}
+	if Foundation(ServerReflexive, base, server, "udp") == Foundation(ServerReflexive, base, net.IPv4(192, 0, 2, 2), "udp") {
+		t.Error("Foundations from different servers match")
+	}
+}
+
+func TestCandidateSDP(t *testing.T) {
+	c := &Candidate{
+		Foundation: "1234",
+		Component:  1,
+		Protocol:   "udp",
+		Priority:   Priority(ServerReflexive, 65535, 1),
+		Address:    &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000},
+		Type:       ServerReflexive,
+		Related:    &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000},
+	}
+	line := "a=candidate:1234 1 udp 1694498815 192.0.2.1 50000 typ srflx raddr 10.0.0.1 rport 4000"
+	if c.SDP() != line {
+		t.Fatalf("Unexpected SDP %q", c.SDP())
+	}
+
+	parsed, err := ParseCandidate(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != c.String() {
+		t.Errorf("Candidate did not round trip: %s", parsed)
+	}
+
+	if _, err := ParseCandidate("a=candidate:1 1 udp 1 192.0.2.1 5000 typ bogus"); err == nil {
+		t.Error("Accepted unknown candidate type")
+	}
+}
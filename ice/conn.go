@@ -0,0 +1,147 @@
+package ice
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// datagram is a packet of application data received from the peer.
+type datagram struct {
+	data []byte
+	from net.Addr
+}
+
+// Conn is a net.Conn exchanging datagrams with the peer over the candidate
+// pair selected by an Agent. Relayed candidates send through their TURN
+// allocation.
+type Conn struct {
+	agent   *Agent
+	local   *Candidate
+	remote  *Candidate
+	consent *ConsentMonitor
+
+	lock            sync.Mutex
+	readDeadline    time.Time
+	deadlineChanged chan struct{}
+}
+
+func newConn(agent *Agent, pair *candidatePair) *Conn {
+	return &Conn{
+		agent:           agent,
+		local:           pair.local,
+		remote:          pair.remote,
+		consent:         agent.consent,
+		deadlineChanged: make(chan struct{}),
+	}
+}
+
+// Candidates provides the local and remote candidates of the selected pair.
+func (c *Conn) Candidates() (local, remote *Candidate) {
+	return c.local, c.remote
+}
+
+// Read reads the next datagram received from the peer over the selected pair.
+// Datagrams from other remote candidates of the peer are discarded.
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.lock.Lock()
+		deadline, changed := c.readDeadline, c.deadlineChanged
+		c.lock.Unlock()
+
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+
+		var d datagram
+		var err error
+		select {
+		case d = <-c.agent.incoming:
+		case <-expired:
+			err = os.ErrDeadlineExceeded
+		case <-changed:
+			// Wait again with the new deadline.
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		case <-c.agent.closed:
+			err = net.ErrClosed
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !sameAddress(c.remote.Address, d.from) {
+			continue
+		}
+		return copy(b, d.data), nil
+	}
+}
+
+// Write sends a datagram to the peer. Once the consent of the peer has expired,
+// Write fails with ErrConsentExpired.
+func (c *Conn) Write(b []byte) (int, error) {
+	select {
+	case <-c.agent.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if !c.consent.Granted() {
+		return 0, ErrConsentExpired
+	}
+	return c.local.conn.WriteTo(b, c.remote.Address)
+}
+
+// Consent provides a channel receiving changes to the consent of the peer to
+// receive traffic, as described by RFC 7675.
+func (c *Conn) Consent() <-chan ConsentState {
+	return c.consent.State()
+}
+
+// Close stops the Agent the connection belongs to.
+func (c *Conn) Close() error {
+	return c.agent.Close()
+}
+
+// LocalAddr provides the address of the local candidate.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local.Address
+}
+
+// RemoteAddr provides the address of the remote candidate.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote.Address
+}
+
+// SetDeadline sets the read and write deadlines of the connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the time after which Read fails with a timeout.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readDeadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of the socket of the local
+// candidate.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.local.conn.SetWriteDeadline(t)
+}
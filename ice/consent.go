@@ -0,0 +1,250 @@
+package ice
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/willscott/goturn"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+)
+
+const (
+	// defaultConsentInterval is the average time between consent requests.
+	defaultConsentInterval = 5 * time.Second
+	// defaultConsentTimeout is how long consent lasts without a response.
+	defaultConsentTimeout = 30 * time.Second
+)
+
+// ErrConsentExpired is returned when sending to a peer which has not renewed
+// its consent to receive traffic.
+var ErrConsentExpired = errors.New("Consent to send expired.")
+
+// ConsentState is the state of the consent of a peer to receive traffic.
+type ConsentState int
+
+const (
+	// ConsentGranted indicates the peer has recently confirmed its consent.
+	ConsentGranted ConsentState = iota
+	// ConsentExpired indicates the peer has not confirmed its consent in time,
+	// and nothing more should be sent to it.
+	ConsentExpired
+)
+
+func (s ConsentState) String() string {
+	switch s {
+	case ConsentGranted:
+		return "Granted"
+	case ConsentExpired:
+		return "Expired"
+	default:
+		return "Unknown"
+	}
+}
+
+// ConsentMonitor verifies that a peer still consents to receive traffic, as
+// described by RFC 7675. It sends Binding requests authenticated with the
+// short-term credentials of the peer at randomized intervals, and considers
+// consent expired when none have been answered for the timeout.
+//
+// The monitor only sends requests; responses arriving on the connection must
+// be passed to HandleResponse by whatever reads from it.
+type ConsentMonitor struct {
+	// The average time between consent requests, which are randomized by 20%.
+	// Defaults to 5 seconds.
+	Interval time.Duration
+	// How long consent lasts without a response. Defaults to 30 seconds.
+	Timeout time.Duration
+	// The priority included in requests, as ICE agents expect in connectivity
+	// checks. Omitted when zero.
+	Priority uint32
+
+	conn        net.PacketConn
+	remote      net.Addr
+	credentials common.Credentials
+	// Adds attributes to each request, such as the ICE role of an Agent.
+	decorate func(*goturn.MessageBuilder)
+
+	lock        sync.Mutex
+	state       ConsentState
+	confirmed   time.Time
+	outstanding map[[12]byte]time.Time
+	changes     chan ConsentState
+	stop        chan struct{}
+	stopOnce    sync.Once
+	done        chan struct{}
+}
+
+// NewConsentMonitor creates a monitor of the consent of remote, reached over
+// conn, which may be a UDP socket or the relayed connection of a TURN
+// allocation. Requests use the ICE username ("remote:local" fragments) and
+// the password of the peer.
+func NewConsentMonitor(conn net.PacketConn, remote net.Addr, username, password string) *ConsentMonitor {
+	return &ConsentMonitor{
+		conn:        conn,
+		remote:      remote,
+		credentials: common.Credentials{Username: username, Password: password},
+		outstanding: make(map[[12]byte]time.Time),
+		changes:     make(chan ConsentState, 1),
+	}
+}
+
+// Start begins sending consent requests. Consent is considered granted from
+// when the monitor is started, since it follows a successful ICE check.
+func (m *ConsentMonitor) Start() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stop != nil {
+		return
+	}
+	m.confirmed = time.Now()
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.run(m.stop, m.done)
+}
+
+// Stop ends the monitor, closing the channel of State.
+func (m *ConsentMonitor) Stop() {
+	m.lock.Lock()
+	stop, done := m.stop, m.done
+	m.lock.Unlock()
+	if stop == nil {
+		return
+	}
+	m.stopOnce.Do(func() { close(stop) })
+	<-done
+}
+
+// State provides a channel receiving changes to the consent state. It is
+// closed when the monitor stops, including after consent expires.
+func (m *ConsentMonitor) State() <-chan ConsentState {
+	return m.changes
+}
+
+// Granted indicates whether the peer currently consents to receive traffic.
+func (m *ConsentMonitor) Granted() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.state == ConsentGranted
+}
+
+// HandleResponse processes a STUN message received on the connection,
+// reporting whether it was the response to a consent request.
+func (m *ConsentMonitor) HandleResponse(from net.Addr, data []byte) bool {
+	var header common.Header
+	if header.Decode(data) != nil {
+		return false
+	}
+	m.lock.Lock()
+	_, ok := m.outstanding[header.Id]
+	m.lock.Unlock()
+	if !ok {
+		return false
+	}
+
+	// Only authenticated success responses from the peer renew consent.
+	msg, err := goturn.ParseTurn(data, &common.Credentials{Password: m.credentials.Password})
+	if err != nil || !stun.HasMessageIntegrity(msg) || msg.Header.Type != goturn.BindingResponse {
+		return true
+	}
+	if !sameAddress(udpAddress(m.remote), from) {
+		return true
+	}
+	m.lock.Lock()
+	delete(m.outstanding, header.Id)
+	if m.state == ConsentGranted {
+		m.confirmed = time.Now()
+	}
+	m.lock.Unlock()
+	return true
+}
+
+func (m *ConsentMonitor) interval() time.Duration {
+	if m.Interval > 0 {
+		return m.Interval
+	}
+	return defaultConsentInterval
+}
+
+func (m *ConsentMonitor) timeout() time.Duration {
+	if m.Timeout > 0 {
+		return m.Timeout
+	}
+	return defaultConsentTimeout
+}
+
+// run sends consent requests until stop is closed or consent expires.
+func (m *ConsentMonitor) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	defer close(m.changes)
+
+	// Requests are sent at intervals uniformly distributed over 0.8 to 1.2
+	// times the interval, per section 5.1 of RFC 7675.
+	jitter := func() time.Duration {
+		return time.Duration(float64(m.interval()) * (0.8 + 0.4*rand.Float64()))
+	}
+	next := time.NewTimer(jitter())
+	defer next.Stop()
+	expiry := time.NewTimer(m.timeout())
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-next.C:
+			m.send()
+			next.Reset(jitter())
+		case <-expiry.C:
+			m.lock.Lock()
+			remaining := m.timeout() - time.Since(m.confirmed)
+			if remaining > 0 {
+				m.lock.Unlock()
+				expiry.Reset(remaining)
+				continue
+			}
+			m.state = ConsentExpired
+			m.lock.Unlock()
+			m.changes <- ConsentExpired
+			return
+		}
+	}
+}
+
+// send transmits a consent request, forgetting requests too old to matter.
+func (m *ConsentMonitor) send() {
+	builder := goturn.NewMessageBuilder(goturn.BindingRequest)
+	if m.Priority != 0 {
+		builder.Priority(m.Priority)
+	}
+	if m.decorate != nil {
+		m.decorate(builder)
+	}
+	msg, err := builder.
+		Credentials(m.credentials).
+		ShortTermAuthenticated().
+		Fingerprint().
+		Build()
+	if err != nil {
+		return
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		return
+	}
+
+	m.lock.Lock()
+	now := time.Now()
+	for id, sent := range m.outstanding {
+		if now.Sub(sent) > m.timeout() {
+			delete(m.outstanding, id)
+		}
+	}
+	m.outstanding[msg.Header.Id] = now
+	m.lock.Unlock()
+
+	m.conn.WriteTo(data, m.remote)
+}
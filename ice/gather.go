@@ -0,0 +1,378 @@
+package ice
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/client"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+)
+
+// TURNServer identifies a TURN server and the long-term credentials used to
+// allocate relayed candidates on it.
+type TURNServer struct {
+	// The address of the server, as host:port.
+	Address  string
+	Username string
+	Password string
+}
+
+// Gatherer collects the host, server reflexive and relayed candidates of a
+// component. The sockets and allocations backing the candidates stay open
+// until the Gatherer is closed.
+//
+//	gatherer := &ice.Gatherer{STUNServers: []string{"stun.example.com:3478"}}
+//	candidates, err := gatherer.Gather()
+//	if err != nil {
+//	  log.Fatal(err)
+//	}
+//	defer gatherer.Close()
+//	for _, c := range candidates {
+//	  fmt.Println(c.SDP())
+//	}
+type Gatherer struct {
+	// STUN servers, as host:port, queried for server reflexive candidates.
+	STUNServers []string
+	// TURN servers on which relayed candidates are allocated.
+	TURNServers []TURNServer
+
+	// The local addresses to gather host candidates on. When empty, the
+	// addresses of all interfaces which are up are used.
+	Addresses []net.IP
+	// Gather candidates on loopback interfaces, which RFC 8445 excludes.
+	IncludeLoopback bool
+
+	// The component ID of the candidates. Defaults to 1.
+	Component int
+	// How long to wait for servers to respond. Defaults to 5 seconds.
+	Timeout time.Duration
+
+	// Called for each server which could not provide a candidate.
+	OnError func(server string, err error)
+
+	lock  sync.Mutex
+	conns []net.PacketConn
+}
+
+// defaultGatherTimeout bounds gathering when no Timeout is configured.
+const defaultGatherTimeout = 5 * time.Second
+
+// Gather opens a socket on each local address, and queries the configured STUN
+// and TURN servers in parallel. The candidates are returned in order of
+// decreasing priority. Servers which fail are reported to OnError; gathering
+// only fails if no local socket could be opened.
+func (g *Gatherer) Gather() ([]*Candidate, error) {
+	ips := g.Addresses
+	if len(ips) == 0 {
+		var err error
+		if ips, err = g.interfaceIPs(); err != nil {
+			return nil, err
+		}
+	}
+
+	var hosts []*Candidate
+	for i, ip := range ips {
+		network := "udp4"
+		if ip.To4() == nil {
+			network = "udp6"
+		}
+		conn, err := net.ListenUDP(network, &net.UDPAddr{IP: ip})
+		if err != nil {
+			g.report(ip.String(), err)
+			continue
+		}
+		g.lock.Lock()
+		g.conns = append(g.conns, conn)
+		g.lock.Unlock()
+
+		address := conn.LocalAddr().(*net.UDPAddr)
+		hosts = append(hosts, &Candidate{
+			Foundation: Foundation(Host, address.IP, nil, "udp"),
+			Component:  g.component(),
+			Protocol:   "udp",
+			Priority:   Priority(Host, localPreference(i), g.component()),
+			Address:    address,
+			Type:       Host,
+			conn:       conn,
+		})
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("No local addresses available.")
+	}
+
+	servers := g.resolveSTUNServers()
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	candidates := append([]*Candidate{}, hosts...)
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(host *Candidate, preference uint16) {
+			defer wg.Done()
+			found := g.reflexive(host, preference, servers)
+			lock.Lock()
+			candidates = append(candidates, found...)
+			lock.Unlock()
+		}(host, localPreference(i))
+	}
+	for i, server := range g.TURNServers {
+		wg.Add(1)
+		go func(server TURNServer, preference uint16) {
+			defer wg.Done()
+			relayed, err := g.relayed(server, preference)
+			if err != nil {
+				g.report(server.Address, err)
+				return
+			}
+			lock.Lock()
+			candidates = append(candidates, relayed)
+			lock.Unlock()
+		}(server, localPreference(i))
+	}
+	wg.Wait()
+
+	candidates = removeRedundant(candidates)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Priority > candidates[j].Priority
+	})
+	return candidates, nil
+}
+
+// Close releases the sockets and TURN allocations backing the candidates.
+func (g *Gatherer) Close() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	var err error
+	for _, conn := range g.conns {
+		if cerr := conn.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	g.conns = nil
+	return err
+}
+
+func (g *Gatherer) component() int {
+	if g.Component > 0 {
+		return g.Component
+	}
+	return 1
+}
+
+func (g *Gatherer) timeout() time.Duration {
+	if g.Timeout > 0 {
+		return g.Timeout
+	}
+	return defaultGatherTimeout
+}
+
+func (g *Gatherer) report(server string, err error) {
+	if g.OnError != nil {
+		g.OnError(server, err)
+	}
+}
+
+// localPreference ranks the local addresses or servers a candidate came from,
+// preferring those listed first.
+func localPreference(index int) uint16 {
+	if index > 65535 {
+		return 0
+	}
+	return uint16(65535 - index)
+}
+
+// interfaceIPs lists the addresses of local interfaces usable for host
+// candidates. IPv6 link-local addresses are excluded, as recommended by
+// section 5.1.1.1 of RFC 8445.
+func (g *Gatherer) interfaceIPs() ([]net.IP, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if iface.Flags&net.FlagLoopback != 0 && !g.IncludeLoopback {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || (ipnet.IP.To4() == nil && ipnet.IP.IsLinkLocalUnicast()) {
+				continue
+			}
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return ips, nil
+}
+
+// resolveSTUNServers looks up the IPv4 and IPv6 addresses of the STUN servers.
+func (g *Gatherer) resolveSTUNServers() map[string][]*net.UDPAddr {
+	servers := make(map[string][]*net.UDPAddr)
+	for _, server := range g.STUNServers {
+		resolved := false
+		for _, network := range []string{"udp4", "udp6"} {
+			if addr, err := net.ResolveUDPAddr(network, server); err == nil {
+				servers[network] = append(servers[network], addr)
+				resolved = true
+			}
+		}
+		if !resolved {
+			g.report(server, errors.New("Could not resolve server."))
+		}
+	}
+	return servers
+}
+
+// reflexive learns the server reflexive candidates of a host candidate by
+// sending Binding requests to each STUN server of the same address family,
+// retransmitting them until each is answered or the timeout passes.
+func (g *Gatherer) reflexive(host *Candidate, preference uint16, servers map[string][]*net.UDPAddr) []*Candidate {
+	network := "udp4"
+	if host.Address.IP.To4() == nil {
+		network = "udp6"
+	}
+
+	pending := make(map[string]*net.UDPAddr)
+	requests := make(map[string][]byte)
+	for _, server := range servers[network] {
+		msg, err := goturn.NewBindingRequest()
+		if err != nil {
+			g.report(server.String(), err)
+			continue
+		}
+		data, err := msg.Serialize()
+		if err != nil {
+			g.report(server.String(), err)
+			continue
+		}
+		pending[string(msg.Header.Id[:])] = server
+		requests[string(msg.Header.Id[:])] = data
+	}
+	defer host.conn.SetReadDeadline(time.Time{})
+
+	var candidates []*Candidate
+	buffer := make([]byte, 2048)
+	deadline := time.Now().Add(g.timeout())
+	for len(pending) > 0 && time.Now().Before(deadline) {
+		for id, server := range pending {
+			if _, err := host.conn.WriteTo(requests[id], server); err != nil {
+				g.report(server.String(), err)
+				delete(pending, id)
+			}
+		}
+		retransmit := time.Now().Add(g.timeout() / 4)
+		if retransmit.After(deadline) {
+			retransmit = deadline
+		}
+		host.conn.SetReadDeadline(retransmit)
+		for len(pending) > 0 {
+			n, _, err := host.conn.ReadFrom(buffer)
+			if err != nil {
+				break
+			}
+			response, err := goturn.ParseTurn(buffer[0:n], nil)
+			if err != nil {
+				continue
+			}
+			id := string(response.Header.Id[:])
+			server, ok := pending[id]
+			if !ok {
+				continue
+			}
+			delete(pending, id)
+			if response.Header.Type != goturn.BindingResponse {
+				g.report(server.String(), errors.New("Binding failed: "+stun.ErrorReason(response)))
+				continue
+			}
+			mapped, ok := stun.GetXorMappedAddress(response)
+			if !ok {
+				if mapped, ok = stun.GetMappedAddress(response); !ok {
+					g.report(server.String(), errors.New("No Mapped Address provided."))
+					continue
+				}
+			}
+			candidates = append(candidates, &Candidate{
+				Foundation: Foundation(ServerReflexive, host.Address.IP, server.IP, "udp"),
+				Component:  host.Component,
+				Protocol:   "udp",
+				Priority:   Priority(ServerReflexive, preference, host.Component),
+				Address:    &mapped,
+				Type:       ServerReflexive,
+				Related:    host.Address,
+				conn:       host.conn,
+			})
+		}
+	}
+	for _, server := range pending {
+		g.report(server.String(), errors.New("No response received."))
+	}
+	return candidates
+}
+
+// relayed allocates a relayed candidate on a TURN server. Traffic for the
+// candidate is sent through a RelayConn over the allocation.
+func (g *Gatherer) relayed(server TURNServer, preference uint16) (*Candidate, error) {
+	control, err := net.DialTimeout("udp", server.Address, g.timeout())
+	if err != nil {
+		return nil, err
+	}
+	relay := &client.StunClient{Conn: control, Timeout: g.timeout()}
+	credentials := client.LongtermCredentials(server.Username, server.Password)
+	addrs, err := relay.Allocate(&credentials)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	addr := addrs[0]
+	conn := client.NewRelayConn(relay, addr)
+	g.lock.Lock()
+	g.conns = append(g.conns, conn)
+	g.lock.Unlock()
+
+	relayed := common.Address{addr}
+	local := control.LocalAddr().(*net.UDPAddr)
+	remote := control.RemoteAddr().(*net.UDPAddr)
+	return &Candidate{
+		Foundation: Foundation(Relayed, local.IP, remote.IP, "udp"),
+		Component:  g.component(),
+		Protocol:   "udp",
+		Priority:   Priority(Relayed, preference, g.component()),
+		Address:    &net.UDPAddr{IP: relayed.Host(), Port: int(relayed.Port())},
+		Type:       Relayed,
+		Related:    local,
+		conn:       conn,
+	}, nil
+}
+
+// removeRedundant drops server reflexive candidates with the same address as
+// another candidate sharing their base, which occurs when there is no NAT or
+// several servers report the same mapping, as described in section 5.1.3 of
+// RFC 8445.
+func removeRedundant(candidates []*Candidate) []*Candidate {
+	var kept []*Candidate
+	for _, c := range candidates {
+		redundant := false
+		for _, k := range kept {
+			if k.conn == c.conn && c.conn != nil && k.Address.IP.Equal(c.Address.IP) && k.Address.Port == c.Address.Port {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,41 @@
+package ice
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn/server"
+)
+
+func TestGatherLoopback(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.NewServer()
+	go s.Serve(conn)
+	defer s.Close()
+
+	gatherer := &Gatherer{
+		STUNServers: []string{conn.LocalAddr().String()},
+		Addresses:   []net.IP{net.IPv4(127, 0, 0, 1)},
+		Timeout:     time.Second,
+		OnError: func(server string, err error) {
+			t.Errorf("Gathering from %s failed: %s", server, err)
+		},
+	}
+	candidates, err := gatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gatherer.Close()
+
+	// Without a NAT, the server reflexive candidate is redundant.
+	if len(candidates) != 1 || candidates[0].Type != Host {
+		t.Fatalf("Unexpected candidates %v", candidates)
+	}
+	if candidates[0].Priority != Priority(Host, 65535, 1) || !candidates[0].Address.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Unexpected host candidate %s", candidates[0])
+	}
+}
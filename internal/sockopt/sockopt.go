@@ -0,0 +1,50 @@
+// Package sockopt sets socket options the standard library does not expose,
+// such as the Don't Fragment bit of UDP datagrams.
+package sockopt
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrUnsupported is returned when a socket option cannot be set on the current
+// platform.
+var ErrUnsupported = errors.New("Socket option not supported on this platform")
+
+// SetDontFragment sets the Don't Fragment bit on datagrams sent from conn, so
+// that those too large for the path are dropped, or fail to send when larger
+// than the MTU known to the kernel, rather than being fragmented. It returns a
+// function which restores the previous setting.
+func SetDontFragment(conn *net.UDPConn) (restore func() error, err error) {
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	ipv6 := local.IP.To4() == nil
+	var mode int
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if mode, serr = pmtuMode(fd, ipv6); serr == nil {
+			serr = setDontFragment(fd, ipv6)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	return func() error {
+		err := raw.Control(func(fd uintptr) {
+			serr = setPMTUMode(fd, ipv6, mode)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}, nil
+}
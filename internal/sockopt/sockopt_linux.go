@@ -0,0 +1,31 @@
+package sockopt
+
+import (
+	"syscall"
+)
+
+func setDontFragment(fd uintptr, ipv6 bool) error {
+	if ipv6 {
+		return setPMTUMode(fd, ipv6, syscall.IPV6_PMTUDISC_DO)
+	}
+	return setPMTUMode(fd, ipv6, syscall.IP_PMTUDISC_DO)
+}
+
+// pmtuOption provides the level and name of the socket option controlling
+// path MTU discovery, which decides whether the Don't Fragment bit is set.
+func pmtuOption(ipv6 bool) (int, int) {
+	if ipv6 {
+		return syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER
+	}
+	return syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER
+}
+
+func pmtuMode(fd uintptr, ipv6 bool) (int, error) {
+	level, name := pmtuOption(ipv6)
+	return syscall.GetsockoptInt(int(fd), level, name)
+}
+
+func setPMTUMode(fd uintptr, ipv6 bool, mode int) error {
+	level, name := pmtuOption(ipv6)
+	return syscall.SetsockoptInt(int(fd), level, name, mode)
+}
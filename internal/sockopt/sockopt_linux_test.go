@@ -0,0 +1,41 @@
+package sockopt
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestSetDontFragment(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	restore, err := SetDontFragment(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	raw.Control(func(fd uintptr) {
+		value, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER)
+	})
+	if err != nil || value != syscall.IP_PMTUDISC_DO {
+		t.Errorf("Don't Fragment not set: %d, %v", value, err)
+	}
+
+	if err := restore(); err != nil {
+		t.Fatal(err)
+	}
+	raw.Control(func(fd uintptr) {
+		value, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER)
+	})
+	if err != nil || value == syscall.IP_PMTUDISC_DO {
+		t.Errorf("Don't Fragment not restored: %d, %v", value, err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package sockopt
+
+func setDontFragment(fd uintptr, ipv6 bool) error {
+	return ErrUnsupported
+}
+
+func pmtuMode(fd uintptr, ipv6 bool) (int, error) {
+	return 0, ErrUnsupported
+}
+
+func setPMTUMode(fd uintptr, ipv6 bool, mode int) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,180 @@
+// Package metrics exports the activity of TURN clients and servers as
+// Prometheus metrics. The client and server packages only define hooks, so
+// that programs which do not import this package do not depend on Prometheus.
+//
+//	collector := metrics.NewServer(turnServer)
+//	turnServer.Metrics = collector
+//	prometheus.MustRegister(collector)
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/server"
+)
+
+// Client collects the metrics of the transactions of StunClients, and of their
+// RelayConns, by being set as their Metrics. One Client may be shared by many
+// StunClients.
+type Client struct {
+	requests        *prometheus.CounterVec
+	retransmissions *prometheus.CounterVec
+	responses       *prometheus.CounterVec
+	timeouts        *prometheus.CounterVec
+	rtt             *prometheus.HistogramVec
+}
+
+// NewClient creates the collector of client metrics.
+func NewClient() *Client {
+	return &Client{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goturn_client_requests_total",
+			Help: "Requests sent, excluding retransmissions, by method.",
+		}, []string{"method"}),
+		retransmissions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goturn_client_retransmissions_total",
+			Help: "Requests retransmitted for lack of a response, by method.",
+		}, []string{"method"}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goturn_client_responses_total",
+			Help: "Responses received, by method and error code, which is 0 for success responses.",
+		}, []string{"method", "code"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goturn_client_timeouts_total",
+			Help: "Requests which received no response, by method.",
+		}, []string{"method"}),
+		rtt: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "goturn_client_transaction_rtt_seconds",
+			Help:    "Time from sending a request to receiving its response, by method.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"method"}),
+	}
+}
+
+// Request counts a request sent.
+func (c *Client) Request(method common.Method, retransmission bool) {
+	if retransmission {
+		c.retransmissions.WithLabelValues(method.String()).Inc()
+	} else {
+		c.requests.WithLabelValues(method.String()).Inc()
+	}
+}
+
+// Response counts a response received, and observes the round trip time.
+func (c *Client) Response(method common.Method, rtt time.Duration, code int) {
+	c.responses.WithLabelValues(method.String(), strconv.Itoa(code)).Inc()
+	c.rtt.WithLabelValues(method.String()).Observe(rtt.Seconds())
+}
+
+// Timeout counts a request which received no response.
+func (c *Client) Timeout(method common.Method) {
+	c.timeouts.WithLabelValues(method.String()).Inc()
+}
+
+// Describe implements prometheus.Collector.
+func (c *Client) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.retransmissions.Describe(ch)
+	c.responses.Describe(ch)
+	c.timeouts.Describe(ch)
+	c.rtt.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Client) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.retransmissions.Collect(ch)
+	c.responses.Collect(ch)
+	c.timeouts.Collect(ch)
+	c.rtt.Collect(ch)
+}
+
+// Server collects the metrics of a server, by being set as its Metrics, along
+// with gauges of its active allocations, permissions and channels.
+type Server struct {
+	server       *server.Server
+	responses    *prometheus.CounterVec
+	authFailures prometheus.Counter
+	bytes        *prometheus.CounterVec
+	packets      *prometheus.CounterVec
+
+	allocations *prometheus.Desc
+	permissions *prometheus.Desc
+	channels    *prometheus.Desc
+}
+
+// NewServer creates the collector of the metrics of s. It must also be set as
+// the Metrics of s to count responses and relayed data.
+func NewServer(s *server.Server) *Server {
+	return &Server{
+		server: s,
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goturn_server_responses_total",
+			Help: "Responses sent, by method and error code, which is 0 for success responses.",
+		}, []string{"method", "code"}),
+		authFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "goturn_server_auth_failures_total",
+			Help: "Requests with credentials which could not be verified.",
+		}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goturn_server_relayed_bytes_total",
+			Help: "Bytes of data relayed, by direction: to the peer or to the client.",
+		}, []string{"direction"}),
+		packets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "goturn_server_relayed_packets_total",
+			Help: "Datagrams relayed, by direction: to the peer or to the client.",
+		}, []string{"direction"}),
+		allocations: prometheus.NewDesc("goturn_server_allocations",
+			"Active allocations.", nil, nil),
+		permissions: prometheus.NewDesc("goturn_server_permissions",
+			"Active permissions of all allocations.", nil, nil),
+		channels: prometheus.NewDesc("goturn_server_channels",
+			"Active channel bindings of all allocations.", nil, nil),
+	}
+}
+
+// Response counts a response sent.
+func (s *Server) Response(method common.Method, code int) {
+	s.responses.WithLabelValues(method.String(), strconv.Itoa(code)).Inc()
+}
+
+// AuthFailure counts a request failing authentication.
+func (s *Server) AuthFailure() {
+	s.authFailures.Inc()
+}
+
+// Relayed counts a datagram relayed.
+func (s *Server) Relayed(toPeer bool, bytes int) {
+	direction := "client"
+	if toPeer {
+		direction = "peer"
+	}
+	s.bytes.WithLabelValues(direction).Add(float64(bytes))
+	s.packets.WithLabelValues(direction).Inc()
+}
+
+// Describe implements prometheus.Collector.
+func (s *Server) Describe(ch chan<- *prometheus.Desc) {
+	s.responses.Describe(ch)
+	s.authFailures.Describe(ch)
+	s.bytes.Describe(ch)
+	s.packets.Describe(ch)
+	ch <- s.allocations
+	ch <- s.permissions
+	ch <- s.channels
+}
+
+// Collect implements prometheus.Collector.
+func (s *Server) Collect(ch chan<- prometheus.Metric) {
+	s.responses.Collect(ch)
+	s.authFailures.Collect(ch)
+	s.bytes.Collect(ch)
+	s.packets.Collect(ch)
+	stats := s.server.Stats()
+	ch <- prometheus.MustNewConstMetric(s.allocations, prometheus.GaugeValue, float64(stats.Allocations))
+	ch <- prometheus.MustNewConstMetric(s.permissions, prometheus.GaugeValue, float64(stats.Permissions))
+	ch <- prometheus.MustNewConstMetric(s.channels, prometheus.GaugeValue, float64(stats.Channels))
+}
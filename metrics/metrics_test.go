@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/client"
+	"github.com/willscott/goturn/turntest"
+)
+
+func TestMetrics(t *testing.T) {
+	ts := turntest.NewUnstartedServer()
+	serverMetrics := NewServer(ts.Config)
+	ts.Config.Metrics = serverMetrics
+	ts.Start()
+	defer ts.Close()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	conn, err := net.Dial("udp", ts.Addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	clientMetrics := NewClient()
+	stunClient := &client.StunClient{Conn: conn, Timeout: time.Second, Metrics: clientMetrics}
+	credentials := ts.Credentials(turntest.Username)
+	relayed, err := stunClient.Allocate(&credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := client.NewRelayConn(stunClient, relayed[0])
+	defer relay.Close()
+
+	// Data is relayed to the peer and back.
+	if _, err := relay.WriteTo([]byte("hello"), peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 100)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := peer.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.WriteTo(buffer[:n], from); err != nil {
+		t.Fatal(err)
+	}
+	relay.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := relay.ReadFrom(buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first Allocate is challenged.
+	allocate := goturn.AllocateMethod.String()
+	if got := testutil.ToFloat64(clientMetrics.requests.WithLabelValues(allocate)); got != 2 {
+		t.Errorf("Expected 2 Allocate requests, counted %v", got)
+	}
+	if got := testutil.ToFloat64(clientMetrics.responses.WithLabelValues(allocate, "401")); got != 1 {
+		t.Errorf("Expected 1 challenge to Allocate, counted %v", got)
+	}
+	if got := testutil.ToFloat64(clientMetrics.responses.WithLabelValues(allocate, "0")); got != 1 {
+		t.Errorf("Expected 1 successful Allocate, counted %v", got)
+	}
+	if got := testutil.CollectAndCount(clientMetrics, "goturn_client_transaction_rtt_seconds"); got == 0 {
+		t.Error("No round trip times observed")
+	}
+	if got := testutil.ToFloat64(serverMetrics.responses.WithLabelValues(allocate, "0")); got != 1 {
+		t.Errorf("Expected 1 successful Allocate response, counted %v", got)
+	}
+	for _, direction := range []string{"peer", "client"} {
+		if got := testutil.ToFloat64(serverMetrics.bytes.WithLabelValues(direction)); got != 5 {
+			t.Errorf("Expected 5 bytes relayed to the %s, counted %v", direction, got)
+		}
+		if got := testutil.ToFloat64(serverMetrics.packets.WithLabelValues(direction)); got != 1 {
+			t.Errorf("Expected 1 datagram relayed to the %s, counted %v", direction, got)
+		}
+	}
+
+	expected := `
+# HELP goturn_server_allocations Active allocations.
+# TYPE goturn_server_allocations gauge
+goturn_server_allocations 1
+# HELP goturn_server_permissions Active permissions of all allocations.
+# TYPE goturn_server_permissions gauge
+goturn_server_permissions 1
+`
+	if err := testutil.CollectAndCompare(serverMetrics, strings.NewReader(expected), "goturn_server_allocations", "goturn_server_permissions"); err != nil {
+		t.Error(err)
+	}
+
+	// A wrong password is an authentication failure.
+	wrong, err := net.Dial("udp", ts.Addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wrong.Close()
+	credentials = client.LongtermCredentials(turntest.Username, "wrong")
+	if _, err := (&client.StunClient{Conn: wrong, Timeout: time.Second}).Allocate(&credentials); err == nil {
+		t.Fatal("Allocation succeeded with a wrong password")
+	}
+	if got := testutil.ToFloat64(serverMetrics.authFailures); got == 0 {
+		t.Error("No authentication failure counted")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	// Nothing answers on the address.
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	conn, err := net.Dial("udp", silent.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	metrics := NewClient()
+	stunClient := &client.StunClient{Conn: conn, Timeout: 50 * time.Millisecond, Metrics: metrics}
+	if _, err := stunClient.Bind(); err == nil {
+		t.Fatal("Bind succeeded without a server")
+	}
+	if got := testutil.ToFloat64(metrics.timeouts.WithLabelValues(goturn.BindingMethod.String())); got != 1 {
+		t.Errorf("Expected 1 timeout, counted %v", got)
+	}
+}
+
+func TestClientRetransmission(t *testing.T) {
+	// The server ignores the first request it receives.
+	lossy, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lossy.Close()
+	go func() {
+		buffer := make([]byte, 1500)
+		for received := 0; ; received++ {
+			n, from, err := lossy.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			request, err := goturn.ParseStun(buffer[:n])
+			if err != nil || received == 0 {
+				continue
+			}
+			msg, _ := goturn.NewResponseBuilder(request.Header).XorMappedAddress(from).Build()
+			data, _ := msg.Serialize()
+			lossy.WriteTo(data, from)
+		}
+	}()
+	conn, err := net.Dial("udp", lossy.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	metrics := NewClient()
+	stunClient := &client.StunClient{Conn: conn, Timeout: 2 * time.Second, Metrics: metrics}
+	if _, err := stunClient.Bind(); err != nil {
+		t.Fatal(err)
+	}
+	binding := goturn.BindingMethod.String()
+	if got := testutil.ToFloat64(metrics.requests.WithLabelValues(binding)); got != 1 {
+		t.Errorf("Expected 1 Binding request, counted %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.retransmissions.WithLabelValues(binding)); got != 1 {
+		t.Errorf("Expected 1 retransmission, counted %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.responses.WithLabelValues(binding, "0")); got != 1 {
+		t.Errorf("Expected 1 successful Binding, counted %v", got)
+	}
+}
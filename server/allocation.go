@@ -0,0 +1,437 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/willscott/goturn"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/internal/sockopt"
+	"github.com/willscott/goturn/trace"
+)
+
+// Lifetimes of allocations, permissions and channel bindings, per RFC 8656.
+const (
+	defaultLifetime    = 10 * time.Minute
+	defaultMaxLifetime = time.Hour
+	permissionLifetime = 5 * time.Minute
+	channelLifetime    = 10 * time.Minute
+)
+
+// The range of channel numbers clients may bind.
+const (
+	minChannel = 0x4000
+	maxChannel = 0x7FFF
+)
+
+// maxRelayedSize is the largest datagram read from a peer.
+const maxRelayedSize = 65535
+
+// fiveTuple identifies the allocation of a client, by the server socket it
+// talks to and its address.
+type fiveTuple struct {
+	conn   net.PacketConn
+	client string
+}
+
+// allocation is the state of a client's TURN allocation: the relayed sockets,
+// one for each address family, and the peers the client may exchange data with.
+type allocation struct {
+	server   *Server
+	username string
+
+	// The 5-tuple the allocation is registered under, and the mobility
+	// tickets with which it can be moved, guarded by the lock of the server.
+	// The previous ticket is kept in case the response issuing the latest was
+	// lost.
+	key     fiveTuple
+	tickets []string
+
+	// The transaction which created the allocation, and its response, resent
+	// if the request is retransmitted.
+	transaction [12]byte
+	response    []byte
+
+	relays []net.PacketConn
+
+	lock sync.Mutex
+	// The server socket and address of the client, which change when the
+	// allocation is moved with a mobility ticket.
+	conn        net.PacketConn
+	client      net.Addr
+	expiry      *time.Timer
+	permissions map[string]time.Time
+	channels    map[uint16]*channel
+	// Whether the Don't Fragment bit is set on the relayed sockets.
+	dontFragment bool
+	closed       bool
+}
+
+// channel is the peer bound to a channel number.
+type channel struct {
+	peer    *net.UDPAddr
+	expires time.Time
+}
+
+func newAllocation(s *Server, conn net.PacketConn, client net.Addr, username string, relays []net.PacketConn) *allocation {
+	return &allocation{
+		server:      s,
+		key:         fiveTuple{conn, client.String()},
+		conn:        conn,
+		client:      client,
+		username:    username,
+		relays:      relays,
+		permissions: make(map[string]time.Time),
+		channels:    make(map[uint16]*channel),
+	}
+}
+
+// allocation finds the allocation of a 5-tuple, if there is one.
+func (s *Server) allocation(key fiveTuple) *allocation {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.allocations[key]
+}
+
+// addAllocation begins relaying for an allocation, which is deleted once its
+// lifetime passes. It fails if the server has been closed.
+func (s *Server) addAllocation(a *allocation, lifetime time.Duration) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.allocations == nil {
+		s.allocations = make(map[fiveTuple]*allocation)
+	}
+	s.allocations[a.key] = a
+	for _, ticket := range a.tickets {
+		if s.tickets == nil {
+			s.tickets = make(map[string]*allocation)
+		}
+		s.tickets[ticket] = a
+	}
+	a.lock.Lock()
+	a.expiry = time.AfterFunc(lifetime, func() {
+		s.removeAllocation(a)
+	})
+	a.lock.Unlock()
+	for _, relay := range a.relays {
+		go a.relay(relay)
+	}
+	return true
+}
+
+// removeAllocation deletes an allocation, closing its relayed sockets.
+func (s *Server) removeAllocation(a *allocation) {
+	s.lock.Lock()
+	if s.allocations[a.key] == a {
+		delete(s.allocations, a.key)
+	}
+	for _, ticket := range a.tickets {
+		delete(s.tickets, ticket)
+	}
+	s.lock.Unlock()
+	a.close()
+}
+
+// moveAllocation moves an allocation to a new 5-tuple, replacing its mobility
+// tickets with next. It fails if the allocation has been deleted, or another
+// allocation has the 5-tuple.
+func (s *Server) moveAllocation(a *allocation, conn net.PacketConn, client net.Addr, next string) bool {
+	s.lock.Lock()
+	key := fiveTuple{conn, client.String()}
+	if s.allocations[a.key] != a || (s.allocations[key] != nil && s.allocations[key] != a) {
+		s.lock.Unlock()
+		return false
+	}
+	delete(s.allocations, a.key)
+	s.allocations[key] = a
+	a.key = key
+	a.tickets = append(a.tickets, next)
+	s.tickets[next] = a
+	if len(a.tickets) > 2 {
+		delete(s.tickets, a.tickets[0])
+		a.tickets = a.tickets[1:]
+	}
+	s.lock.Unlock()
+
+	a.lock.Lock()
+	a.conn, a.client = conn, client
+	a.lock.Unlock()
+	return true
+}
+
+// ticketed finds the allocation a mobility ticket was issued for.
+func (s *Server) ticketed(ticket []byte) *allocation {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tickets[string(ticket)]
+}
+
+// newTicket creates a random mobility ticket.
+func newTicket() []byte {
+	ticket := make([]byte, 16)
+	rand.Read(ticket)
+	return ticket
+}
+
+// closeAllocations deletes every allocation, when the server is closed.
+func (s *Server) closeAllocations() {
+	s.lock.Lock()
+	allocations := s.allocations
+	s.allocations = nil
+	s.tickets = nil
+	s.lock.Unlock()
+	for _, a := range allocations {
+		a.close()
+	}
+}
+
+// close releases the relayed sockets and quota of the allocation.
+func (a *allocation) close() {
+	a.lock.Lock()
+	if a.closed {
+		a.lock.Unlock()
+		return
+	}
+	a.closed = true
+	if a.expiry != nil {
+		a.expiry.Stop()
+	}
+	a.lock.Unlock()
+	for _, relay := range a.relays {
+		relay.Close()
+	}
+	a.server.Quotas.release(a.username)
+}
+
+// endpoint provides the server socket and address of the client.
+func (a *allocation) endpoint() (net.PacketConn, net.Addr) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.conn, a.client
+}
+
+// refresh extends the lifetime of the allocation.
+func (a *allocation) refresh(lifetime time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.expiry.Reset(lifetime)
+}
+
+// retransmitted answers an Allocate request on the 5-tuple of an existing
+// allocation. The response is repeated if the request which created the
+// allocation was retransmitted, and otherwise the request is refused.
+func (a *allocation) retransmitted(conn net.PacketConn, from net.Addr, request *common.Message, credentials *common.Credentials) {
+	if request.Header.Id == a.transaction {
+		if _, err := conn.WriteTo(a.response, from); err == nil {
+			a.server.trace(trace.Sent, conn, from, a.response)
+		}
+		return
+	}
+	a.server.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, 437, "Allocation Mismatch"), credentials)
+}
+
+// socket provides the relayed socket for exchanging data with a peer IP, or nil
+// if the allocation has no relayed address of its family.
+func (a *allocation) socket(ip net.IP) net.PacketConn {
+	for _, relay := range a.relays {
+		address := common.Address{relay.LocalAddr()}
+		local := address.Host()
+		if (local.To4() != nil) == (ip.To4() != nil) {
+			return relay
+		}
+	}
+	return nil
+}
+
+// setDontFragment sets the Don't Fragment bit on the relayed sockets, as asked
+// by a Send indication. It stays set for the rest of the allocation, and false
+// is returned if it cannot be set.
+func (a *allocation) setDontFragment() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.dontFragment {
+		return true
+	}
+	for _, relay := range a.relays {
+		if setDontFragment(relay) != nil {
+			return false
+		}
+	}
+	a.dontFragment = true
+	return true
+}
+
+// setDontFragment sets the Don't Fragment bit on datagrams sent from a relayed
+// socket.
+func setDontFragment(relay net.PacketConn) error {
+	if r, ok := relay.(*relaySocket); ok {
+		relay = r.PacketConn
+	}
+	conn, ok := relay.(*net.UDPConn)
+	if !ok {
+		return sockopt.ErrUnsupported
+	}
+	_, err := sockopt.SetDontFragment(conn)
+	return err
+}
+
+// permit installs or refreshes the permissions for peer IPs. None are
+// installed if the allocation would then have more than limit permissions,
+// unless limit is 0.
+func (a *allocation) permit(limit int, ips ...net.IP) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := time.Now()
+	if limit > 0 {
+		added := make(map[string]bool)
+		for _, ip := range ips {
+			if _, ok := a.permissions[ip.String()]; !ok {
+				added[ip.String()] = true
+			}
+		}
+		if len(a.permissions)+len(added) > limit {
+			for peer, expires := range a.permissions {
+				if now.After(expires) {
+					delete(a.permissions, peer)
+				}
+			}
+			if len(a.permissions)+len(added) > limit {
+				return false
+			}
+		}
+	}
+	for _, ip := range ips {
+		a.permissions[ip.String()] = now.Add(permissionLifetime)
+	}
+	return true
+}
+
+// permitted indicates whether data may be exchanged with a peer IP.
+func (a *allocation) permitted(ip net.IP) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	expires, ok := a.permissions[ip.String()]
+	if ok && time.Now().After(expires) {
+		delete(a.permissions, ip.String())
+		return false
+	}
+	return ok
+}
+
+// bindable indicates whether a channel may be bound to a peer. A channel may
+// only be rebound to the same peer, and a peer may only be bound to one
+// channel.
+func (a *allocation) bindable(number uint16, peer *net.UDPAddr) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := time.Now()
+	for n, c := range a.channels {
+		if now.After(c.expires) {
+			delete(a.channels, n)
+			continue
+		}
+		same := c.peer.IP.Equal(peer.IP) && c.peer.Port == peer.Port
+		if (n == number) != same {
+			return false
+		}
+	}
+	return true
+}
+
+// bind installs or refreshes a channel binding, which must be bindable. The
+// permission for the peer is installed separately.
+func (a *allocation) bind(number uint16, peer *net.UDPAddr) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.channels[number] = &channel{peer, time.Now().Add(channelLifetime)}
+}
+
+// channelPeer provides the peer bound to a channel number, if it is bound.
+func (a *allocation) channelPeer(number uint16) *net.UDPAddr {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if c, ok := a.channels[number]; ok && time.Now().Before(c.expires) {
+		return c.peer
+	}
+	return nil
+}
+
+// channelNumber provides the channel bound to a peer, if there is one.
+func (a *allocation) channelNumber(peer *net.UDPAddr) (uint16, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	now := time.Now()
+	for n, c := range a.channels {
+		if c.peer.IP.Equal(peer.IP) && c.peer.Port == peer.Port && now.Before(c.expires) {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// send relays data from the client to a peer, if the peer is permitted and the
+// bandwidth of the user allows.
+func (a *allocation) send(data []byte, peer *net.UDPAddr) {
+	socket := a.socket(peer.IP)
+	if socket == nil || !a.permitted(peer.IP) || !a.server.Quotas.relay(a.username, len(data)) {
+		return
+	}
+	if _, err := socket.WriteTo(data, peer); err == nil && a.server.Metrics != nil {
+		a.server.Metrics.Relayed(true, len(data))
+	}
+}
+
+// relay forwards datagrams from permitted peers on a relayed socket to the
+// client, within the bandwidth of the user, in ChannelData messages for bound peers and Data indications
+// otherwise, until the socket is closed.
+func (a *allocation) relay(socket net.PacketConn) {
+	buffer := make([]byte, maxRelayedSize)
+	for {
+		n, from, err := socket.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		peer, ok := from.(*net.UDPAddr)
+		if !ok || !a.permitted(peer.IP) || !a.server.Quotas.relay(a.username, n) {
+			continue
+		}
+
+		if number, ok := a.channelNumber(peer); ok {
+			frame := make([]byte, 4+n)
+			binary.BigEndian.PutUint16(frame[0:2], number)
+			binary.BigEndian.PutUint16(frame[2:4], uint16(n))
+			copy(frame[4:], buffer[0:n])
+			conn, client := a.endpoint()
+			if _, err := conn.WriteTo(frame, client); err == nil && a.server.Metrics != nil {
+				a.server.Metrics.Relayed(false, n)
+			}
+			continue
+		}
+
+		msg, err := goturn.NewMessageBuilder(goturn.DataIndication).
+			XorPeerAddress(peer).
+			Data(buffer[0:n]).
+			Build()
+		if err != nil {
+			continue
+		}
+		data, err := msg.Serialize()
+		if err != nil {
+			continue
+		}
+		conn, client := a.endpoint()
+		if _, err := conn.WriteTo(data, client); err != nil {
+			continue
+		}
+		a.server.trace(trace.Sent, conn, client, data)
+		if a.server.Metrics != nil {
+			a.server.Metrics.Relayed(false, n)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net"
+)
+
+// ListenBehavior creates the four UDP sockets needed to serve RFC 5780 NAT
+// behavior discovery: the primary and alternate IP addresses, each listening
+// on both the primary and alternate ports. The sockets are indexed by
+// [ip][port], where index 0 is the primary and 1 is the alternate. The port of
+// alternate is used as the alternate port; ports of 0 are chosen by the system.
+func ListenBehavior(network string, primary, alternate *net.UDPAddr) ([2][2]net.PacketConn, error) {
+	var conns [2][2]net.PacketConn
+	ips := [2]net.IP{primary.IP, alternate.IP}
+	ports := [2]int{primary.Port, alternate.Port}
+	for i := range ips {
+		for p := range ports {
+			conn, err := net.ListenUDP(network, &net.UDPAddr{IP: ips[i], Port: ports[p]})
+			if err != nil {
+				for _, row := range conns {
+					for _, c := range row {
+						if c != nil {
+							c.Close()
+						}
+					}
+				}
+				return [2][2]net.PacketConn{}, err
+			}
+			conns[i][p] = conn
+			// Ports chosen by the system for the primary IP are reused for the
+			// alternate IP.
+			ports[p] = conn.LocalAddr().(*net.UDPAddr).Port
+		}
+	}
+	return conns, nil
+}
+
+// ServeBehavior answers requests on the four sockets created by ListenBehavior,
+// supporting the CHANGE-REQUEST and RESPONSE-PORT attributes and reporting
+// an OTHER-ADDRESS to clients so that they can discover the behavior of their
+// NAT. It blocks until the server is closed, or one of the sockets fails.
+func (s *Server) ServeBehavior(conns [2][2]net.PacketConn) error {
+	s.lock.Lock()
+	s.behavior = &conns
+	s.lock.Unlock()
+
+	errs := make(chan error, 4)
+	for _, row := range conns {
+		for _, conn := range row {
+			go func(conn net.PacketConn) {
+				errs <- s.Serve(conn)
+			}(conn)
+		}
+	}
+
+	// Wait for the first socket to stop, then stop the rest.
+	err := <-errs
+	s.Close()
+	for i := 1; i < 4; i++ {
+		<-errs
+	}
+	return err
+}
+
+// behaviorIndex locates a connection among the behavior discovery sockets.
+func (s *Server) behaviorIndex(conn net.PacketConn) (int, int) {
+	for i, row := range s.behavior {
+		for p, c := range row {
+			if c == conn {
+				return i, p
+			}
+		}
+	}
+	return 0, 0
+}
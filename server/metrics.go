@@ -0,0 +1,83 @@
+package server
+
+import (
+	"time"
+
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+)
+
+// Metrics is notified of the activity of a Server, such as to export it as
+// metrics. Its methods may be called concurrently.
+type Metrics interface {
+	// Response is called for each response the server sends, with the method
+	// of the request it answers, and the error code of error responses, or 0
+	// for success responses.
+	Response(method common.Method, code int)
+	// AuthFailure is called when a request carries credentials which cannot
+	// be verified, because the user or realm is unknown or the
+	// MESSAGE-INTEGRITY is wrong.
+	AuthFailure()
+	// Relayed is called for each datagram relayed, towards the peer or
+	// towards the client, with its size.
+	Relayed(toPeer bool, bytes int)
+}
+
+// Stats is a snapshot of the state of a server.
+type Stats struct {
+	Allocations int
+	// The permissions and channel bindings of all allocations which have not
+	// expired.
+	Permissions int
+	Channels    int
+}
+
+// Stats provides a snapshot of the allocations of the server.
+func (s *Server) Stats() Stats {
+	s.lock.Lock()
+	allocations := make([]*allocation, 0, len(s.allocations))
+	for _, a := range s.allocations {
+		allocations = append(allocations, a)
+	}
+	s.lock.Unlock()
+
+	stats := Stats{Allocations: len(allocations)}
+	now := time.Now()
+	for _, a := range allocations {
+		a.lock.Lock()
+		for _, expires := range a.permissions {
+			if now.Before(expires) {
+				stats.Permissions++
+			}
+		}
+		for _, c := range a.channels {
+			if now.Before(c.expires) {
+				stats.Channels++
+			}
+		}
+		a.lock.Unlock()
+	}
+	return stats
+}
+
+// counted notes a response for the metrics of the server.
+func (s *Server) counted(response *common.Message) {
+	if s.Metrics == nil {
+		return
+	}
+	code := 0
+	if response.Header.Type.Class() == common.ClassError {
+		if e, ok := stun.GetError(response); ok {
+			code = e.Error()
+		}
+	}
+	s.Metrics.Response(response.Header.Type.Method(), code)
+}
+
+// authFailed notes a request failing authentication for the metrics of the
+// server.
+func (s *Server) authFailed() {
+	if s.Metrics != nil {
+		s.Metrics.AuthFailure()
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	s := NewServer()
+	now := time.Now()
+	s.allocations = map[fiveTuple]*allocation{
+		{client: "192.0.2.1:1000"}: {
+			permissions: map[string]time.Time{"198.51.100.1": now.Add(time.Minute), "198.51.100.2": now.Add(-time.Second)},
+			channels:    map[uint16]*channel{0x4000: {expires: now.Add(time.Minute)}},
+		},
+		{client: "192.0.2.2:1000"}: {
+			permissions: map[string]time.Time{"198.51.100.1": now.Add(time.Minute)},
+			channels:    map[uint16]*channel{0x4000: {expires: now.Add(-time.Second)}},
+		},
+	}
+	if stats := s.Stats(); stats != (Stats{Allocations: 2, Permissions: 2, Channels: 1}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
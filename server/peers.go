@@ -0,0 +1,156 @@
+package server
+
+import (
+	"net"
+)
+
+// PeerPolicy decides which peer addresses clients may relay to, to keep
+// clients from reaching the network of the server through it. An address is
+// permitted when it is within an Allow network, or otherwise when it is not
+// within a Deny network. An empty policy permits every address other than
+// those of the server itself, which must be allowed explicitly.
+type PeerPolicy struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// DefaultDeniedPeers are the networks clients may not relay to unless
+// allowed: the unspecified, loopback, private, shared, link-local, multicast,
+// discard and reserved ranges of each family, along with the IPv4-compatible,
+// NAT64, 6to4 and Teredo IPv6 ranges which embed IPv4 addresses. Link-local
+// ranges include the metadata services of cloud providers, such as
+// 169.254.169.254.
+var DefaultDeniedPeers = mustParseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/96",
+	"64:ff9b::/96",
+	"64:ff9b:1::/48",
+	"100::/64",
+	"2001::/32",
+	"2002::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// DefaultPeerPolicy is applied when a server has no Peers policy, or its
+// Peers function provides none for a user.
+var DefaultPeerPolicy = &PeerPolicy{Deny: DefaultDeniedPeers}
+
+// ParseNetworks reads networks in CIDR notation, such as "10.0.0.0/8". Single
+// addresses are read as networks of one address.
+func ParseNetworks(cidrs ...string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	networks, err := ParseNetworks(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// Permits indicates whether clients may relay to ip. IPv4-mapped IPv6
+// addresses are treated as the IPv4 addresses they hold.
+func (p *PeerPolicy) Permits(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return contains(p.Allow, ip) || !contains(p.Deny, ip)
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerPolicy provides the policy of the peers a user may relay to.
+func (s *Server) peerPolicy(username string) *PeerPolicy {
+	if s.Peers != nil {
+		if policy := s.Peers(username); policy != nil {
+			return policy
+		}
+	}
+	return DefaultPeerPolicy
+}
+
+// permitsPeer indicates whether policy lets a client relay to ip. The
+// addresses of the server itself are denied unless the policy allows them, so
+// that clients cannot relay to the server.
+func (s *Server) permitsPeer(policy *PeerPolicy, ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if contains(policy.Allow, ip) {
+		return true
+	}
+	return policy.Permits(ip) && !s.ownAddress(ip)
+}
+
+// ownAddress indicates whether ip is an address of the server: one it serves
+// clients on, opens relayed sockets on or advertises as relayed addresses.
+// Servers listening on an unspecified address serve on every address of the
+// host.
+func (s *Server) ownAddress(ip net.IP) bool {
+	addresses := []net.IP{s.RelayIPv4, s.RelayIPv6}
+	if s.Ports != nil {
+		for _, address := range s.Ports.Addresses {
+			addresses = append(addresses, address.IP, address.External)
+		}
+	}
+	unspecified := false
+	s.lock.Lock()
+	for conn := range s.conns {
+		if local, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			addresses = append(addresses, local.IP)
+			unspecified = unspecified || local.IP == nil || local.IP.IsUnspecified()
+		}
+	}
+	s.lock.Unlock()
+	if unspecified {
+		if host, err := net.InterfaceAddrs(); err == nil {
+			for _, addr := range host {
+				if network, ok := addr.(*net.IPNet); ok {
+					addresses = append(addresses, network.IP)
+				}
+			}
+		}
+	}
+	for _, address := range addresses {
+		if address != nil && address.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDefaultPeerPolicy(t *testing.T) {
+	denied := []string{"0.0.0.0", "127.0.0.1", "10.1.2.3", "172.31.0.1", "192.168.1.1", "100.64.0.1",
+		"169.254.169.254", "224.0.0.1", "::", "::1", "fe80::1", "fd00:ec2::254", "::ffff:127.0.0.1", "64:ff9b::a00:1",
+		"::192.0.2.1", "64:ff9b:1::a00:1", "100::1", "2002:a00:1::1", "2001:0:4136:e378:8000:63bf:3fff:fdd2"}
+	for _, ip := range denied {
+		if DefaultPeerPolicy.Permits(net.ParseIP(ip)) {
+			t.Errorf("%s is permitted by default", ip)
+		}
+	}
+	permitted := []string{"192.0.2.1", "8.8.8.8", "172.32.0.1", "2001:db8::1", "::ffff:8.8.8.8"}
+	for _, ip := range permitted {
+		if !DefaultPeerPolicy.Permits(net.ParseIP(ip)) {
+			t.Errorf("%s is denied by default", ip)
+		}
+	}
+}
+
+func TestPeerPolicyAllow(t *testing.T) {
+	allow, err := ParseNetworks("10.0.0.0/24", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &PeerPolicy{Allow: allow, Deny: DefaultDeniedPeers}
+	for ip, expected := range map[string]bool{"10.0.0.7": true, "10.0.1.7": false, "127.0.0.1": true, "127.0.0.2": false} {
+		if policy.Permits(net.ParseIP(ip)) != expected {
+			t.Errorf("Expected %s to be permitted: %v", ip, expected)
+		}
+	}
+	if !(&PeerPolicy{}).Permits(net.ParseIP("127.0.0.1")) {
+		t.Error("Empty policy denied a peer")
+	}
+	if _, err := ParseNetworks("10.0.0.0/33"); err == nil {
+		t.Error("Invalid network was parsed")
+	}
+}
+
+// localConn is a connection the server is listening on, at an address.
+type localConn struct {
+	net.PacketConn
+	addr net.Addr
+}
+
+func (c *localConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func TestOwnAddressesDenied(t *testing.T) {
+	s := &Server{
+		RelayIPv4: net.ParseIP("192.0.2.10"),
+		RelayIPv6: net.ParseIP("2001:db8::10"),
+		Ports: &PortAllocator{Addresses: []RelayAddress{
+			{IP: net.ParseIP("192.0.2.11"), External: net.ParseIP("198.51.100.7")},
+		}},
+	}
+	if err := s.track(&localConn{addr: &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 3478}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"192.0.2.10", "2001:db8::10", "192.0.2.11", "198.51.100.7", "203.0.113.5", "::ffff:203.0.113.5"} {
+		if s.permitsPeer(DefaultPeerPolicy, net.ParseIP(ip)) {
+			t.Errorf("Server address %s is permitted", ip)
+		}
+		if s.permitsPeer(&PeerPolicy{}, net.ParseIP(ip)) {
+			t.Errorf("Server address %s is permitted by an empty policy", ip)
+		}
+	}
+	if !s.permitsPeer(DefaultPeerPolicy, net.ParseIP("192.0.2.12")) {
+		t.Error("Peer sharing a network with the server is denied")
+	}
+	allow, err := ParseNetworks("198.51.100.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.permitsPeer(&PeerPolicy{Allow: allow, Deny: DefaultDeniedPeers}, net.ParseIP("198.51.100.7")) {
+		t.Error("Allowed server address is denied")
+	}
+}
+
+func TestOwnAddressesUnspecified(t *testing.T) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil || len(addrs) == 0 {
+		t.Skip("No interface addresses")
+	}
+	host, ok := addrs[0].(*net.IPNet)
+	if !ok {
+		t.Skip("No interface addresses")
+	}
+	s := new(Server)
+	if err := s.track(&localConn{addr: &net.UDPAddr{IP: net.IPv4zero, Port: 3478}}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.ownAddress(host.IP) {
+		t.Errorf("Interface address %s of a server listening on all addresses is not its own", host.IP)
+	}
+	if s.ownAddress(net.ParseIP("192.0.2.1")) {
+		t.Error("Address of another host is the server's own")
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/willscott/goturn/turn"
+)
+
+// defaultPortCoolDown is how long a relayed port rests once its socket is
+// closed, unless a PortAllocator sets its own CoolDown.
+const defaultPortCoolDown = time.Minute
+
+// RelayAddress is an address relayed sockets are opened on.
+type RelayAddress struct {
+	// The address sockets are bound to.
+	IP net.IP
+	// The address advertised to clients as their relayed address, of the same
+	// family as IP, for servers behind a 1:1 NAT such as in cloud networks.
+	// Defaults to IP.
+	External net.IP
+}
+
+// PortAllocator chooses the addresses and ports of relayed sockets. Each
+// allocation is given an address of its family at random, and a random port
+// of the range which is neither in use nor cooling down from a recent
+// allocation, so that relayed addresses cannot be predicted and late
+// datagrams for one allocation are not delivered to the next.
+type PortAllocator struct {
+	// The addresses relayed sockets are opened on. When there are none of a
+	// family, the RelayIPv4 or RelayIPv6 of the server is used as for servers
+	// without an allocator.
+	Addresses []RelayAddress
+	// The inclusive range of ports relayed sockets are opened on. Ports are
+	// chosen by the system when Min is 0, or the range is not within 1 to
+	// 65535 with Min no greater than Max.
+	Min, Max int
+	// How long a port rests once its socket is closed. Defaults to 1 minute.
+	CoolDown time.Duration
+
+	lock sync.Mutex
+	// The ports of the range in use, or cooling down until the time they may
+	// be reused, by address and port.
+	busy map[string]time.Time
+}
+
+// address chooses an address of a family at random, if there is one.
+func (p *PortAllocator) address(ipv4 bool) *RelayAddress {
+	var candidates []RelayAddress
+	for _, address := range p.Addresses {
+		if (address.IP.To4() != nil) == ipv4 {
+			candidates = append(candidates, address)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return &candidates[randomIndex(len(candidates))]
+}
+
+// ranged indicates whether ports are chosen from a valid range, rather than
+// by the system.
+func (p *PortAllocator) ranged() bool {
+	return p.Min > 0 && p.Min <= p.Max && p.Max <= 65535
+}
+
+// claim marks a free port of the range on ip as in use, and the port after it
+// when reserve is set. Ports are chosen at random, and are even when even is
+// set.
+func (p *PortAllocator) claim(ip net.IP, even, reserve bool) (int, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.busy == nil {
+		p.busy = make(map[string]time.Time)
+	}
+	now := time.Now()
+	free := func(port int) bool {
+		key := portKey(ip, port)
+		until, busy := p.busy[key]
+		if busy && !until.IsZero() && now.After(until) {
+			delete(p.busy, key)
+			return true
+		}
+		return !busy
+	}
+
+	if !p.ranged() {
+		return 0, false
+	}
+	size := p.Max - p.Min + 1
+	start := randomIndex(size)
+	for i := 0; i < size; i++ {
+		port := p.Min + (start+i)%size
+		if (even && port%2 != 0) || (reserve && port+1 > p.Max) {
+			continue
+		}
+		if !free(port) || (reserve && !free(port+1)) {
+			continue
+		}
+		p.busy[portKey(ip, port)] = time.Time{}
+		if reserve {
+			p.busy[portKey(ip, port+1)] = time.Time{}
+		}
+		return port, true
+	}
+	return 0, false
+}
+
+// release lets a port be reused once it has cooled down.
+func (p *PortAllocator) release(ip net.IP, port int, coolDown bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !coolDown {
+		delete(p.busy, portKey(ip, port))
+		return
+	}
+	rest := p.CoolDown
+	if rest <= 0 {
+		rest = defaultPortCoolDown
+	}
+	p.busy[portKey(ip, port)] = time.Now().Add(rest)
+}
+
+// randomIndex provides an unpredictable number in [0, n).
+func randomIndex(n int) int {
+	var b [8]byte
+	rand.Read(b[:])
+	return int(binary.BigEndian.Uint64(b[:]) % uint64(n))
+}
+
+func portKey(ip net.IP, port int) string {
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
+
+// listen opens a relayed socket on a port of the range, and the next port
+// when reserve is set.
+func (p *PortAllocator) listen(s *Server, address RelayAddress, even, reserve bool) (net.PacketConn, net.PacketConn, error) {
+	for attempt := 0; attempt < maxEvenPortAttempts; attempt++ {
+		port, ok := p.claim(address.IP, even, reserve)
+		if !ok {
+			return nil, nil, errors.New("No relay port available")
+		}
+		// Ports which cannot be opened, such as those used by other programs,
+		// are left to cool down so that others are tried.
+		relay, err := s.listenPacket(address.IP, port)
+		if err != nil {
+			p.release(address.IP, port, true)
+			if reserve {
+				p.release(address.IP, port+1, false)
+			}
+			continue
+		}
+		if !reserve {
+			return p.track(relay, address, port), nil, nil
+		}
+		next, err := s.listenPacket(address.IP, port+1)
+		if err != nil {
+			relay.Close()
+			p.release(address.IP, port, false)
+			p.release(address.IP, port+1, true)
+			continue
+		}
+		return p.track(relay, address, port), p.track(next, address, port+1), nil
+	}
+	return nil, nil, errors.New("No relay port available")
+}
+
+// track wraps a relayed socket so that its port cools down once it is closed.
+func (p *PortAllocator) track(socket net.PacketConn, address RelayAddress, port int) net.PacketConn {
+	return &relaySocket{
+		PacketConn: socket,
+		external:   address.External,
+		release: func() {
+			p.release(address.IP, port, true)
+		},
+	}
+}
+
+// relaySocket is a relayed socket opened for an address with an external
+// address, or on a port of a PortAllocator.
+type relaySocket struct {
+	net.PacketConn
+	external net.IP
+	release  func()
+	once     sync.Once
+}
+
+func (r *relaySocket) Close() error {
+	err := r.PacketConn.Close()
+	if r.release != nil {
+		r.once.Do(r.release)
+	}
+	return err
+}
+
+// advertised provides the relayed address of a socket reported to clients.
+func advertised(relay net.PacketConn) net.Addr {
+	if r, ok := relay.(*relaySocket); ok && r.external != nil {
+		if local, ok := r.LocalAddr().(*net.UDPAddr); ok {
+			return &net.UDPAddr{IP: r.external, Port: local.Port}
+		}
+	}
+	return relay.LocalAddr()
+}
+
+// relayAddress provides the address relayed sockets of a family are opened on
+// for clients of conn, or nil if the server cannot relay the family.
+func (s *Server) relayAddress(conn net.PacketConn, family uint16) *RelayAddress {
+	if s.Ports != nil {
+		if address := s.Ports.address(family == turn.FamilyIPv4); address != nil {
+			return address
+		}
+	}
+	if ip := s.relayIP(conn, family); ip != nil {
+		return &RelayAddress{IP: ip}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn/client"
+)
+
+func TestPortAllocatorClaim(t *testing.T) {
+	p := &PortAllocator{Min: 5000, Max: 5003, CoolDown: time.Hour}
+	ip := net.IPv4(127, 0, 0, 1)
+
+	// A reserved pair starts on an even port.
+	port, ok := p.claim(ip, true, true)
+	if !ok || port%2 != 0 {
+		t.Fatalf("Unexpected pair at %d", port)
+	}
+	other, ok := p.claim(ip, true, true)
+	if !ok || other == port || other%2 != 0 {
+		t.Fatalf("Unexpected second pair at %d", other)
+	}
+	if _, ok := p.claim(ip, false, false); ok {
+		t.Error("Claimed a port beyond the range")
+	}
+	if _, ok := p.claim(net.IPv4(127, 0, 0, 2), false, false); !ok {
+		t.Error("Ports of another address were exhausted")
+	}
+
+	// Released ports rest before they are reused.
+	p.release(ip, port, true)
+	if _, ok := p.claim(ip, false, false); ok {
+		t.Error("Claimed a port cooling down")
+	}
+	p.release(ip, other, false)
+	if reused, ok := p.claim(ip, false, false); !ok || reused != other {
+		t.Errorf("Released port %d was not reused, got %d", other, reused)
+	}
+}
+
+func TestPortAllocatorInvalidRange(t *testing.T) {
+	// Ranges which are empty, or only have a Min, fall back to ports chosen by
+	// the system.
+	for _, r := range [][2]int{{1, 0}, {49152, 0}, {50000, 70000}} {
+		p := &PortAllocator{Min: r[0], Max: r[1]}
+		if _, ok := p.claim(net.IPv4(127, 0, 0, 1), false, false); ok {
+			t.Errorf("Claimed a port of the invalid range %d-%d", r[0], r[1])
+		}
+
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Server{
+			Auth: func(username string) (string, bool) {
+				return "pass", true
+			},
+			Ports: p,
+		}
+		go s.Serve(conn)
+		defer s.Close()
+		c, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		stunClient := &client.StunClient{Conn: c, Timeout: time.Second}
+		credentials := client.LongtermCredentials("user", "pass")
+		if _, err := stunClient.Allocate(&credentials); err != nil {
+			t.Errorf("Allocation failed with the invalid range %d-%d: %s", r[0], r[1], err)
+		}
+	}
+}
+
+func TestPortAllocatorAddresses(t *testing.T) {
+	p := &PortAllocator{Addresses: []RelayAddress{
+		{IP: net.IPv4(198, 51, 100, 1)},
+		{IP: net.IPv4(198, 51, 100, 2)},
+		{IP: net.ParseIP("2001:db8::1")},
+	}}
+	chosen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		chosen[p.address(true).IP.String()] = true
+	}
+	if len(chosen) != 2 {
+		t.Errorf("Expected both IPv4 addresses to be chosen, got %v", chosen)
+	}
+	if address := p.address(false); address == nil || address.IP.To4() != nil {
+		t.Errorf("Unexpected IPv6 address %v", address)
+	}
+}
+
+func TestAllocatePortRange(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	external := net.IPv4(192, 0, 2, 7)
+	s := &Server{
+		Auth: func(username string) (string, bool) {
+			return "pass", true
+		},
+		Ports: &PortAllocator{
+			Addresses: []RelayAddress{{IP: net.IPv4(127, 0, 0, 1), External: external}},
+			Min:       46000,
+			Max:       46099,
+			CoolDown:  time.Hour,
+		},
+	}
+	go s.Serve(conn)
+	defer s.Close()
+
+	dial := func() *client.StunClient {
+		c, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return &client.StunClient{Conn: c, Timeout: time.Second}
+	}
+	check := func(addr net.Addr) int {
+		t.Helper()
+		relayed, ok := addr.(*net.UDPAddr)
+		if !ok || !relayed.IP.Equal(external) || relayed.Port < 46000 || relayed.Port > 46099 {
+			t.Fatalf("Unexpected relayed address %v", addr)
+		}
+		return relayed.Port
+	}
+
+	first := dial()
+	credentials := client.LongtermCredentials("user", "pass")
+	relayed, err := first.Allocate(&credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := check(relayed[0])
+	if _, err := first.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The port of the deleted allocation is cooling down, and is not reused.
+	for i := 0; i < 5; i++ {
+		credentials := client.LongtermCredentials("user", "pass")
+		relayed, err := dial().Allocate(&credentials)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if check(relayed[0]) == port {
+			t.Errorf("Port %d was reused while cooling down", port)
+		}
+	}
+
+	credentials = client.LongtermCredentials("user", "pass")
+	addr, token, err := dial().AllocateEvenPort(&credentials, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if even := check(addr); even%2 != 0 {
+		t.Errorf("Port %d is not even", even)
+	}
+	credentials = client.LongtermCredentials("user", "pass")
+	reserved, err := dial().AllocateReserved(&credentials, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check(reserved) != check(addr)+1 {
+		t.Errorf("Reserved address %v does not follow %v", reserved, addr)
+	}
+}
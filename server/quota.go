@@ -0,0 +1,275 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits bound the resources used by the allocations of a user, or of all the
+// users of a realm. Zero values are unlimited.
+type Limits struct {
+	// The most allocations held at once. Allocate requests beyond it are
+	// refused with a 486.
+	Allocations int `json:"allocations"`
+	// The most peers each allocation may have permissions for. Requests
+	// creating permissions beyond it are refused with a 508. It does not apply
+	// to realms.
+	Permissions int `json:"permissions"`
+	// The rate data may be relayed at, in bytes per second, counting both
+	// directions. Datagrams beyond it are dropped.
+	Bandwidth int `json:"bandwidth"`
+	// The most bytes which may be relayed at once when the rate has not been
+	// used. Defaults to Bandwidth, and should be at least the size of the
+	// largest datagram relayed.
+	Burst int `json:"burst"`
+}
+
+// QuotaSettings are the limits applied by Quotas.
+type QuotaSettings struct {
+	// The limits of each user without limits of their own.
+	Default Limits `json:"default"`
+	// Limits of particular users, by username.
+	Users map[string]Limits `json:"users,omitempty"`
+	// The limits of all the users of the realm together.
+	Realm Limits `json:"realm"`
+	// The most allocations the server holds at once. Allocate requests beyond
+	// it are refused with a 508.
+	Capacity int `json:"capacity"`
+}
+
+// Usage is the resources used by the allocations of a user or realm.
+type Usage struct {
+	Allocations int `json:"allocations"`
+	// The bytes relayed, and dropped for exceeding the bandwidth limit.
+	RelayedBytes uint64 `json:"relayed_bytes"`
+	DroppedBytes uint64 `json:"dropped_bytes"`
+}
+
+// Quotas limits the allocations, permissions and bandwidth of the users of a
+// server. Its settings may be changed while the server is running, and apply
+// to the requests and data which follow.
+type Quotas struct {
+	// The lock guards the settings, the users and the allocations they hold,
+	// while the data relayed is guarded by the lock of each usage, so that
+	// data relayed for different users is not serialized.
+	lock     sync.RWMutex
+	settings QuotaSettings
+	users    map[string]*usage
+	realm    usage
+	// The bytes relayed and dropped for the realm, counted without its lock.
+	relayed atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// usage is the resources used by a user or realm, with the bucket of tokens
+// bandwidth is taken from. The bytes and bucket are guarded by its lock, and
+// the bytes of the realm are counted by the Quotas instead.
+type usage struct {
+	Usage
+	lock   sync.Mutex
+	tokens float64
+	filled time.Time
+}
+
+// snapshot provides the resources used by a user. The lock of the Quotas,
+// which guards Allocations, must be held for reading; the lock of the usage,
+// which guards the byte counts, is taken here.
+func (u *usage) snapshot() Usage {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.Usage
+}
+
+// NewQuotas creates quotas applying settings. Zero Quotas apply no limits.
+func NewQuotas(settings QuotaSettings) *Quotas {
+	q := &Quotas{}
+	q.Set(settings)
+	return q
+}
+
+// Settings provides the settings in effect.
+func (q *Quotas) Settings() QuotaSettings {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	settings := q.settings
+	settings.Users = make(map[string]Limits, len(q.settings.Users))
+	for username, limits := range q.settings.Users {
+		settings.Users[username] = limits
+	}
+	return settings
+}
+
+// Set replaces the settings in effect. Allocations beyond new limits are kept,
+// but further ones are refused until usage falls below them.
+func (q *Quotas) Set(settings QuotaSettings) {
+	users := make(map[string]Limits, len(settings.Users))
+	for username, limits := range settings.Users {
+		users[username] = limits
+	}
+	settings.Users = users
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.settings = settings
+}
+
+// SetUser replaces the limits of a user. The user is given the default limits
+// when limits is nil.
+func (q *Quotas) SetUser(username string, limits *Limits) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if limits == nil {
+		delete(q.settings.Users, username)
+		return
+	}
+	if q.settings.Users == nil {
+		q.settings.Users = make(map[string]Limits)
+	}
+	q.settings.Users[username] = *limits
+}
+
+// Usage provides the resources used by each user with allocations, and by
+// the realm.
+func (q *Quotas) Usage() (map[string]Usage, Usage) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	users := make(map[string]Usage, len(q.users))
+	for username, u := range q.users {
+		users[username] = u.snapshot()
+	}
+	realm := Usage{Allocations: q.realm.Allocations, RelayedBytes: q.relayed.Load(), DroppedBytes: q.dropped.Load()}
+	return users, realm
+}
+
+// limits provides the limits of a user, with the lock held.
+func (q *Quotas) limits(username string) Limits {
+	if limits, ok := q.settings.Users[username]; ok {
+		return limits
+	}
+	return q.settings.Default
+}
+
+// admit counts an allocation for a user, or provides the error it is refused
+// with. Nil quotas admit every allocation.
+func (q *Quotas) admit(username string) (int, string, bool) {
+	if q == nil {
+		return 0, "", true
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	u := q.users[username]
+	if u == nil {
+		u = &usage{}
+	}
+	limits := q.limits(username)
+	switch {
+	case q.settings.Capacity > 0 && q.realm.Allocations >= q.settings.Capacity:
+		return 508, "Insufficient Capacity", false
+	case limits.Allocations > 0 && u.Allocations >= limits.Allocations,
+		q.settings.Realm.Allocations > 0 && q.realm.Allocations >= q.settings.Realm.Allocations:
+		return 486, "Allocation Quota Reached", false
+	}
+	if q.users == nil {
+		q.users = make(map[string]*usage)
+	}
+	q.users[username] = u
+	u.Allocations++
+	q.realm.Allocations++
+	return 0, "", true
+}
+
+// release uncounts an allocation of a user admitted earlier.
+func (q *Quotas) release(username string) {
+	if q == nil {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	u := q.users[username]
+	if u == nil {
+		return
+	}
+	u.Allocations--
+	q.realm.Allocations--
+	if u.Allocations <= 0 {
+		delete(q.users, username)
+	}
+}
+
+// permissions provides the most permissions an allocation of a user may hold,
+// or 0 if it is unlimited.
+func (q *Quotas) permissions(username string) int {
+	if q == nil {
+		return 0
+	}
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	return q.limits(username).Permissions
+}
+
+// relay takes the bandwidth to relay n bytes for a user, indicating whether
+// they are within the limits of the user and realm. Only the bucket of the
+// user is locked, and that of the realm as well when the realm is limited.
+func (q *Quotas) relay(username string, n int) bool {
+	if q == nil {
+		return true
+	}
+	q.lock.RLock()
+	u := q.users[username]
+	limits, realmLimits := q.limits(username), q.settings.Realm
+	q.lock.RUnlock()
+	if u == nil {
+		// The allocation was released while data was in flight.
+		return false
+	}
+
+	now := time.Now()
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	relayed := u.fill(limits, n, now)
+	if relayed && realmLimits.Bandwidth > 0 {
+		q.realm.lock.Lock()
+		if relayed = q.realm.fill(realmLimits, n, now); relayed {
+			q.realm.take(realmLimits, n)
+		}
+		q.realm.lock.Unlock()
+	}
+	if !relayed {
+		u.DroppedBytes += uint64(n)
+		q.dropped.Add(uint64(n))
+		return false
+	}
+	u.take(limits, n)
+	u.RelayedBytes += uint64(n)
+	q.relayed.Add(uint64(n))
+	return true
+}
+
+// fill adds the tokens accrued since the bucket was last filled, indicating
+// whether there are enough to relay n bytes.
+func (u *usage) fill(limits Limits, n int, now time.Time) bool {
+	if limits.Bandwidth <= 0 {
+		return true
+	}
+	burst := float64(limits.Burst)
+	if burst <= 0 {
+		burst = float64(limits.Bandwidth)
+	}
+	if u.filled.IsZero() {
+		u.tokens = burst
+	} else {
+		u.tokens += now.Sub(u.filled).Seconds() * float64(limits.Bandwidth)
+	}
+	if u.tokens > burst {
+		u.tokens = burst
+	}
+	u.filled = now
+	return u.tokens >= float64(n)
+}
+
+// take takes n bytes from the bucket if bandwidth is limited.
+func (u *usage) take(limits Limits, n int) {
+	if limits.Bandwidth > 0 {
+		u.tokens -= float64(n)
+	}
+}
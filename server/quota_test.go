@@ -0,0 +1,166 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn/client"
+)
+
+func TestQuotas(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotas := NewQuotas(QuotaSettings{
+		Default:  Limits{Allocations: 1, Permissions: 2},
+		Capacity: 2,
+	})
+	s := &Server{
+		Auth: func(username string) (string, bool) {
+			return "pass", true
+		},
+		Quotas: quotas,
+	}
+	go s.Serve(conn)
+	defer s.Close()
+
+	allocate := func(username string) (*client.StunClient, error) {
+		c, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		stunClient := &client.StunClient{Conn: c, Timeout: time.Second}
+		credentials := client.LongtermCredentials(username, "pass")
+		_, err = stunClient.Allocate(&credentials)
+		return stunClient, err
+	}
+	expectError := func(err error, code string) {
+		t.Helper()
+		if err == nil || !strings.Contains(err.Error(), code) {
+			t.Errorf("Expected a %s error, got %v", code, err)
+		}
+	}
+
+	alice, err := allocate("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = allocate("alice")
+	expectError(err, "486")
+	if _, err := allocate("bob"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = allocate("carol")
+	expectError(err, "508")
+	if users, realm := quotas.Usage(); users["alice"].Allocations != 1 || realm.Allocations != 2 {
+		t.Errorf("Unexpected usage %v of %v", realm, users)
+	}
+
+	// Permissions are limited for each allocation, counting each peer once.
+	for i := 1; i <= 3; i++ {
+		err := alice.RequestPermission(&net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i)), Port: 9})
+		if i < 3 && err != nil {
+			t.Fatal(err)
+		} else if i == 3 {
+			expectError(err, "508")
+		}
+	}
+	if err := alice.RequestPermission(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9}); err != nil {
+		t.Errorf("Refreshing a permission failed: %v", err)
+	}
+
+	// Quota is returned when allocations are deleted, and limits may be
+	// raised while the server runs.
+	if _, err := alice.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	quotas.SetUser("carol", &Limits{Allocations: 2})
+	settings := quotas.Settings()
+	settings.Capacity = 3
+	quotas.Set(settings)
+	for i := 0; i < 2; i++ {
+		if _, err := allocate("carol"); err != nil {
+			t.Errorf("Allocation within raised limits failed: %v", err)
+		}
+	}
+	if users, realm := quotas.Usage(); users["alice"].Allocations != 0 || realm.Allocations != 3 {
+		t.Errorf("Unexpected usage %v of %v", realm, users)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	limits := Limits{Bandwidth: 1000, Burst: 1500}
+	u := &usage{}
+	now := time.Now()
+	if !u.fill(limits, 1500, now) {
+		t.Fatal("Burst was not available")
+	}
+	u.take(limits, 1500)
+	if u.fill(limits, 100, now.Add(50*time.Millisecond)) {
+		t.Error("Data beyond the rate was relayed")
+	}
+	if !u.fill(limits, 100, now.Add(100*time.Millisecond)) {
+		t.Error("Tokens were not refilled")
+	}
+	if u.fill(limits, 1600, now.Add(time.Hour)) {
+		t.Error("Tokens accrued beyond the burst")
+	}
+
+	// Data of users is dropped once the realm is out of bandwidth.
+	q := NewQuotas(QuotaSettings{Realm: Limits{Bandwidth: 1000}})
+	q.admit("alice")
+	q.admit("bob")
+	if !q.relay("alice", 1000) || q.relay("bob", 10) {
+		t.Error("Realm bandwidth was not shared")
+	}
+	if users, realm := q.Usage(); users["bob"].DroppedBytes != 10 || realm.RelayedBytes != 1000 {
+		t.Errorf("Unexpected usage %v of %v", realm, users)
+	}
+}
+
+func TestBandwidthConcurrent(t *testing.T) {
+	q := NewQuotas(QuotaSettings{Default: Limits{Bandwidth: 1 << 30}})
+	users := []string{"alice", "bob", "carol", "dave"}
+	var wg sync.WaitGroup
+	for _, username := range users {
+		q.admit(username)
+		wg.Add(1)
+		go func(username string) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				q.relay(username, 100)
+			}
+		}(username)
+	}
+	wg.Wait()
+	usage, realm := q.Usage()
+	for _, username := range users {
+		if usage[username].RelayedBytes != 100000 {
+			t.Errorf("Unexpected usage %v of %s", usage[username], username)
+		}
+	}
+	if realm.RelayedBytes != 400000 {
+		t.Errorf("Unexpected usage %v of the realm", realm)
+	}
+}
+
+// BenchmarkRelayParallel relays datagrams of many users at once, which should
+// not contend on a lock shared by the users.
+func BenchmarkRelayParallel(b *testing.B) {
+	q := NewQuotas(QuotaSettings{Default: Limits{Bandwidth: 1 << 40}})
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		username := strconv.FormatInt(next.Add(1), 10)
+		q.admit(username)
+		for pb.Next() {
+			q.relay(username, 1200)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	// reservationLifetime is how long a reserved port is held for an
+	// allocation to claim it, per RFC 8656.
+	reservationLifetime = 30 * time.Second
+	// maxEvenPortAttempts bounds how many sockets are opened looking for an
+	// even port.
+	maxEvenPortAttempts = 32
+)
+
+// reservation is a relayed socket held for a later allocation.
+type reservation struct {
+	socket net.PacketConn
+	expiry *time.Timer
+}
+
+// listenRelay opens a relayed socket on an address. When even is set, the
+// socket has an even port, and when reserve is also set the next port is
+// opened as well, to be held for a reservation. Ports are chosen by the port
+// allocator of the server if it has a valid range, and by the system
+// otherwise.
+func (s *Server) listenRelay(address RelayAddress, even, reserve bool) (net.PacketConn, net.PacketConn, error) {
+	if s.Ports != nil && s.Ports.ranged() {
+		return s.Ports.listen(s, address, even, reserve)
+	}
+	relay, next, err := s.listenSystemRelay(address.IP, even, reserve)
+	if err != nil || address.External == nil {
+		return relay, next, err
+	}
+	relay = &relaySocket{PacketConn: relay, external: address.External}
+	if next != nil {
+		next = &relaySocket{PacketConn: next, external: address.External}
+	}
+	return relay, next, nil
+}
+
+// listenSystemRelay opens a relayed socket on ip with a port chosen by the
+// system, as for listenRelay.
+func (s *Server) listenSystemRelay(ip net.IP, even, reserve bool) (net.PacketConn, net.PacketConn, error) {
+	if !even {
+		relay, err := s.listenPacket(ip, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		return relay, nil, nil
+	}
+	for attempt := 0; attempt < maxEvenPortAttempts; attempt++ {
+		relay, err := s.listenPacket(ip, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		port := relay.LocalAddr().(*net.UDPAddr).Port
+		if port%2 != 0 {
+			relay.Close()
+			continue
+		}
+		if !reserve {
+			return relay, nil, nil
+		}
+		next, err := s.listenPacket(ip, port+1)
+		if err != nil {
+			relay.Close()
+			continue
+		}
+		return relay, next, nil
+	}
+	return nil, nil, errors.New("No even port available")
+}
+
+// listenPacket opens a UDP socket on ip and port, with ListenPacket if it is
+// set.
+func (s *Server) listenPacket(ip net.IP, port int) (net.PacketConn, error) {
+	address := &net.UDPAddr{IP: ip, Port: port}
+	if s.ListenPacket != nil {
+		return s.ListenPacket("udp", address.String())
+	}
+	return net.ListenUDP("udp", address)
+}
+
+// reserve holds a socket for a later allocation, returning the token which
+// claims it. Unclaimed reservations are released after 30 seconds.
+func (s *Server) reserve(socket net.PacketConn) uint64 {
+	var id [8]byte
+	rand.Read(id[:])
+	token := binary.BigEndian.Uint64(id[:])
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.reservations == nil {
+		s.reservations = make(map[uint64]*reservation)
+	}
+	s.reservations[token] = &reservation{
+		socket: socket,
+		expiry: time.AfterFunc(reservationLifetime, func() {
+			if socket := s.claimReservation(token); socket != nil {
+				socket.Close()
+			}
+		}),
+	}
+	return token
+}
+
+// claimReservation provides the socket held for a reservation token, or nil if
+// the token is unknown or has expired.
+func (s *Server) claimReservation(token uint64) net.PacketConn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.reservations[token]
+	if !ok {
+		return nil
+	}
+	r.expiry.Stop()
+	delete(s.reservations, token)
+	return r.socket
+}
+
+// closeReservations releases every reserved socket, when the server is closed.
+func (s *Server) closeReservations() {
+	s.lock.Lock()
+	reservations := s.reservations
+	s.reservations = nil
+	s.lock.Unlock()
+	for _, r := range reservations {
+		r.expiry.Stop()
+		r.socket.Close()
+	}
+}
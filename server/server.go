@@ -0,0 +1,287 @@
+// Package server provides a STUN and TURN server, answering requests from
+// clients over UDP.
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/willscott/goturn"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/trace"
+)
+
+// maxPacketSize is the largest datagram the server will read, which is large
+// enough for any Send indication or ChannelData message relayed over UDP.
+const maxPacketSize = 65535
+
+// Server answers STUN requests received on one or more packet connections.
+// When Auth is set, it also relays UDP for TURN clients authenticating with
+// long-term credentials, with dual-stack allocations per RFC 8656. A zero
+// Server is ready to use.
+type Server struct {
+	// The realm of the long-term credentials of TURN clients. Defaults to
+	// "goturn".
+	Realm string
+	// Auth provides the password of a TURN user, or false if the user is
+	// unknown. TURN requests are refused when it is nil.
+	Auth func(username string) (password string, ok bool)
+	// The addresses relayed sockets of each address family are opened on. When
+	// unset, the address of the socket a client connected to is used if it is
+	// of the family, and allocations of the family fail otherwise.
+	RelayIPv4 net.IP
+	RelayIPv6 net.IP
+	// Ports chooses the addresses and ports of relayed sockets, in place of
+	// RelayIPv4 and RelayIPv6 for the families it has addresses of. Ports are
+	// chosen by the system when it is nil.
+	Ports *PortAllocator
+	// Peers provides the policy of the peers a user may relay to, which is
+	// checked when permissions are created and channels bound. Requests for
+	// peers it does not permit are refused with a 403. When Peers is nil or
+	// provides nil, DefaultPeerPolicy is applied, denying the loopback,
+	// private and link-local networks of the server. The addresses of the
+	// server itself are denied unless the policy allows them.
+	Peers func(username string) *PeerPolicy
+	// Quotas limits the allocations, permissions and bandwidth of users, and
+	// the allocations of the server. Nothing is limited when it is nil.
+	Quotas *Quotas
+	// The longest lifetime granted to allocations. Defaults to 1 hour.
+	MaxLifetime time.Duration
+	// ListenPacket opens the relayed sockets of allocations, such as on a
+	// simulated network. Defaults to net.ListenUDP.
+	ListenPacket func(network, address string) (net.PacketConn, error)
+	// The SOFTWARE attribute added to responses, describing the server. None
+	// is added when it is empty.
+	Software string
+	// Metrics is notified of responses, authentication failures and relayed
+	// datagrams, when it is set.
+	Metrics Metrics
+	// Tracer is given each STUN message received from and sent to clients,
+	// including Send and Data indications, when it is set. ChannelData is not
+	// traced.
+	Tracer trace.Tracer
+
+	lock        sync.Mutex
+	conns       map[net.PacketConn]bool
+	closed      bool
+	allocations map[fiveTuple]*allocation
+	// Allocations by the mobility tickets which can move them.
+	tickets map[string]*allocation
+	// Relayed sockets held for allocations claiming them, by token.
+	reservations map[uint64]*reservation
+	// The key nonces are signed with.
+	secret []byte
+
+	// The sockets used for RFC 5780 behavior discovery, indexed by [ip][port],
+	// when the server is serving with an alternate address.
+	behavior *[2][2]net.PacketConn
+}
+
+// NewServer creates a new STUN server.
+func NewServer() *Server {
+	return new(Server)
+}
+
+// track records a connection being served, so that it will be closed with the
+// server. It fails if the server has already been closed.
+func (s *Server) track(conn net.PacketConn) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return errors.New("Server closed")
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.PacketConn]bool)
+	}
+	s.conns[conn] = true
+	return nil
+}
+
+// Serve answers requests arriving on conn until the server is closed or conn
+// fails. It returns nil once the server has been closed.
+func (s *Server) Serve(conn net.PacketConn) error {
+	if err := s.track(conn); err != nil {
+		return err
+	}
+
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, from, err := conn.ReadFrom(buffer)
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.handle(conn, from, buffer[0:n])
+	}
+}
+
+// Close stops the server, closing all of the connections it is serving and
+// deleting all allocations.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	for conn := range s.conns {
+		if cerr := conn.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	s.lock.Unlock()
+	s.closeAllocations()
+	s.closeReservations()
+	return err
+}
+
+// handle processes a single packet received from a client.
+func (s *Server) handle(conn net.PacketConn, from net.Addr, data []byte) {
+	// ChannelData messages start with a channel number, where STUN messages
+	// start with two zero bits.
+	if len(data) > 0 && data[0]&0xC0 == 0x40 {
+		if s.Auth != nil {
+			s.handleChannelData(conn, from, data)
+		}
+		return
+	}
+
+	var header common.Header
+	if err := header.Decode(data); err != nil {
+		return
+	}
+	s.trace(trace.Received, conn, from, data)
+
+	var credentials *common.Credentials
+	if s.Auth != nil && isTurnRequest(header.Type) {
+		var ok bool
+		if credentials, ok = s.authenticate(conn, from, header, data); !ok {
+			return
+		}
+	}
+
+	request, err := goturn.ParseTurnStrict(data, credentials)
+	if err != nil {
+		// Requests with unknown comprehension-required attributes must be
+		// answered with a 420 listing them, and those failing authentication
+		// with a 401. Other malformed messages are silently dropped.
+		var perr *common.ParseError
+		if header.Type.Class() == common.ClassRequest && errors.As(err, &perr) {
+			if errors.Is(err, common.ErrUnknownAttribute) {
+				s.respond(conn, from, goturn.NewErrorResponseBuilder(header, 420, "Unknown Attribute").
+					UnknownAttributes(perr.Attribute))
+			} else if credentials != nil && perr.Attribute == stun.MessageIntegrity {
+				s.authFailed()
+				s.challenge(conn, from, header, 401, "Unauthorized")
+			}
+		}
+		return
+	}
+
+	switch {
+	case request.Header.Type == goturn.BindingRequest:
+		s.handleBinding(conn, from, request)
+	case request.Header.Type == goturn.BindingIndication:
+		// Indications are used to keep NAT bindings alive, and need no response.
+	case credentials != nil:
+		s.handleTurn(conn, from, request, credentials)
+	case request.Header.Type == goturn.SendIndication && s.Auth != nil:
+		s.handleSend(conn, from, request)
+	default:
+		if request.Header.Type.Class() == common.ClassRequest {
+			s.respond(conn, from, goturn.NewErrorResponseBuilder(request.Header, 400, "Bad Request"))
+		}
+	}
+}
+
+// respond serializes and sends a response to a client.
+func (s *Server) respond(conn net.PacketConn, to net.Addr, response *goturn.MessageBuilder) error {
+	data, err := s.serialize(response)
+	if err != nil {
+		return err
+	}
+	if _, err = conn.WriteTo(data, to); err != nil {
+		return err
+	}
+	s.trace(trace.Sent, conn, to, data)
+	return nil
+}
+
+// trace describes a message exchanged with a client on conn to the Tracer of
+// the server.
+func (s *Server) trace(direction trace.Direction, conn net.PacketConn, client net.Addr, data []byte) {
+	if s.Tracer != nil {
+		s.Tracer.Trace(trace.NewEvent(direction, data, conn.LocalAddr(), client))
+	}
+}
+
+// serialize encodes a response, with the SOFTWARE of the server and a
+// fingerprint.
+func (s *Server) serialize(response *goturn.MessageBuilder) ([]byte, error) {
+	msg, err := response.Fingerprint().Build()
+	if err != nil {
+		return nil, err
+	}
+	if s.Software != "" {
+		msg.AddAttribute(&stun.SoftwareAttribute{s.Software})
+	}
+	s.counted(msg)
+	return msg.Serialize()
+}
+
+// handleBinding answers a Binding request with the address it came from.
+func (s *Server) handleBinding(conn net.PacketConn, from net.Addr, request *common.Message) {
+	responder := conn
+	var other net.Addr
+
+	changeIP, changePort, change := stun.GetChangeRequest(request)
+	if s.behavior != nil {
+		ip, port := s.behaviorIndex(conn)
+		other = s.behavior[1-ip][1-port].LocalAddr()
+		if change {
+			if changeIP {
+				ip = 1 - ip
+			}
+			if changePort {
+				port = 1 - port
+			}
+		}
+		responder = s.behavior[ip][port]
+	} else if change && (changeIP || changePort) {
+		s.respond(conn, from, goturn.NewErrorResponseBuilder(request.Header, 420, "Unknown Attribute").
+			UnknownAttributes(stun.ChangeRequest))
+		return
+	}
+
+	// Padded responses test the path back to the client, so they may not be
+	// sent elsewhere, per RFC 5780.
+	padding, padded := stun.GetPadding(request)
+	to := from
+	if port, ok := stun.GetResponsePort(request); ok {
+		if padded {
+			s.respond(conn, from, goturn.NewErrorResponseBuilder(request.Header, 400, "Bad Request"))
+			return
+		}
+		host := common.Address{from}
+		to = &net.UDPAddr{IP: host.Host(), Port: int(port)}
+	}
+
+	response := goturn.NewResponseBuilder(request.Header).
+		XorMappedAddress(from)
+	if s.behavior != nil {
+		response.ResponseOrigin(responder.LocalAddr()).OtherAddress(other)
+	}
+	if padded {
+		response.Padding(padding)
+	}
+	s.respond(responder, to, response)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+)
+
+func TestBinding(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	go s.Serve(conn)
+	defer s.Close()
+
+	c, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second))
+
+	cases := []struct {
+		request  *goturn.MessageBuilder
+		response uint16
+		code     int
+	}{
+		{goturn.NewMessageBuilder(goturn.BindingRequest), uint16(goturn.BindingResponse), 0},
+		{goturn.NewMessageBuilder(goturn.BindingRequest).ChangeRequest(true, false), uint16(goturn.BindingError), 420},
+		{goturn.NewMessageBuilder(goturn.AllocateRequest).RequestedTransport("udp"), uint16(goturn.AllocateError), 400},
+	}
+	for _, tc := range cases {
+		msg, err := tc.request.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := msg.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1500)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatalf("No response to %s: %s", msg.Header.Type, err)
+		}
+		response, err := goturn.ParseTurnStrict(buf[0:n], nil)
+		if err != nil {
+			t.Fatalf("Could not parse response to %s: %s", msg.Header.Type, err)
+		}
+		if uint16(response.Header.Type) != tc.response || response.Header.Id != msg.Header.Id {
+			t.Errorf("Unexpected response %s to %s", response.Header.Type, msg.Header.Type)
+		}
+		code := 0
+		if e, ok := stun.GetError(response); ok {
+			code = e.Error()
+		}
+		if code != tc.code {
+			t.Errorf("Unexpected error %d in response to %s", code, msg.Header.Type)
+		}
+		if tc.code == 0 {
+			addr, ok := stun.GetXorMappedAddress(response)
+			if !ok || addr.String() != c.LocalAddr().String() {
+				t.Errorf("Unexpected mapped address %s", addr.String())
+			}
+		}
+	}
+}
+
+func TestBindingPadding(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	go s.Serve(conn)
+	defer s.Close()
+
+	c, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second))
+
+	exchange := func(request *goturn.MessageBuilder) *common.Message {
+		msg, err := request.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := msg.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1500)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatalf("No response to %s: %s", msg.Header.Type, err)
+		}
+		response, err := goturn.ParseTurnStrict(buf[0:n], nil)
+		if err != nil {
+			t.Fatalf("Could not parse response to %s: %s", msg.Header.Type, err)
+		}
+		return response
+	}
+
+	response := exchange(goturn.NewMessageBuilder(goturn.BindingRequest).Padding(1000))
+	if size, ok := stun.GetPadding(response); response.Header.Type != goturn.BindingResponse || !ok || size != 1000 {
+		t.Errorf("Expected %s with 1000 bytes of padding, got %s with %d", goturn.BindingResponse, response.Header.Type, size)
+	}
+
+	response = exchange(goturn.NewMessageBuilder(goturn.BindingRequest).Padding(1000).ResponsePort(5000))
+	if code, ok := stun.GetError(response); !ok || code.Error() != 400 {
+		t.Errorf("Expected padded request with a response port to fail, got %s", response.Header.Type)
+	}
+}
+
+func TestListenBehaviorFailure(t *testing.T) {
+	// The alternate address is not local, so only the primary sockets can be
+	// created before listening fails.
+	conns, err := ListenBehavior("udp4",
+		&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)},
+		&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)})
+	if err == nil {
+		t.Fatal("Listened on an address which is not local")
+	}
+	if conns != ([2][2]net.PacketConn{}) {
+		t.Errorf("Closed sockets returned with error: %v", conns)
+	}
+}
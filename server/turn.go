@@ -0,0 +1,538 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/willscott/goturn"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/trace"
+	"github.com/willscott/goturn/turn"
+)
+
+const (
+	// defaultRealm is used for long-term credentials when no Realm is set.
+	defaultRealm = "goturn"
+	// nonceLifetime is how long a nonce is accepted before clients are asked to
+	// use a new one with a 438 response.
+	nonceLifetime = time.Hour
+)
+
+// isTurnRequest indicates whether a message type is a TURN request needing
+// long-term authentication.
+func isTurnRequest(t common.HeaderType) bool {
+	switch t {
+	case goturn.AllocateRequest, goturn.RefreshRequest, goturn.CreatePermissionRequest, goturn.ChannelBindRequest:
+		return true
+	}
+	return false
+}
+
+func (s *Server) realm() string {
+	if s.Realm != "" {
+		return s.Realm
+	}
+	return defaultRealm
+}
+
+// nonceKey provides the secret nonces are signed with, so that they can be
+// validated without keeping state for each client.
+func (s *Server) nonceKey() []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.secret == nil {
+		s.secret = make([]byte, 32)
+		rand.Read(s.secret)
+	}
+	return s.secret
+}
+
+// nonce creates a nonce for a client, holding the time it was issued and a
+// signature binding it to the client's IP.
+func (s *Server) nonce(from net.Addr) []byte {
+	issued := strconv.FormatInt(time.Now().Unix(), 16)
+	return []byte(issued + "-" + s.nonceSignature(issued, from))
+}
+
+func (s *Server) nonceSignature(issued string, from net.Addr) string {
+	mac := hmac.New(sha256.New, s.nonceKey())
+	mac.Write([]byte(issued))
+	client := common.Address{from}
+	mac.Write(client.Host())
+	return hex.EncodeToString(mac.Sum(nil)[0:12])
+}
+
+// validNonce checks that a nonce was issued by the server to a client, and has
+// not expired.
+func (s *Server) validNonce(nonce []byte, from net.Addr) bool {
+	parts := bytes.SplitN(nonce, []byte("-"), 2)
+	if len(parts) != 2 {
+		return false
+	}
+	issued, err := strconv.ParseInt(string(parts[0]), 16, 64)
+	if err != nil || time.Since(time.Unix(issued, 0)) > nonceLifetime {
+		return false
+	}
+	expected := s.nonceSignature(string(parts[0]), from)
+	return hmac.Equal(parts[1], []byte(expected))
+}
+
+// challenge answers a request with an error carrying the realm and a fresh
+// nonce, with which the client can authenticate.
+func (s *Server) challenge(conn net.PacketConn, from net.Addr, header common.Header, code int, phrase string) {
+	s.respond(conn, from, goturn.NewErrorResponseBuilder(header, code, phrase).
+		Credentials(common.Credentials{Realm: s.realm(), Nonce: s.nonce(from)}).
+		Attribute(&stun.RealmAttribute{}).
+		Attribute(&stun.NonceAttribute{}))
+}
+
+// authenticate finds the long-term credentials of a TURN request, with which
+// its MESSAGE-INTEGRITY can be verified. Requests without valid credentials
+// are answered with a challenge, and false is returned.
+func (s *Server) authenticate(conn net.PacketConn, from net.Addr, header common.Header, data []byte) (*common.Credentials, bool) {
+	// The message is first read without verifying its integrity, to learn
+	// which user it claims to be from.
+	attrs := turn.AttributeSet()
+	delete(attrs, stun.MessageIntegrity)
+	msg, err := common.Parse(data, nil, attrs)
+	if err != nil {
+		return nil, false
+	}
+	signed := false
+	for _, raw := range msg.RawAttributes() {
+		if raw.Type == stun.MessageIntegrity {
+			signed = true
+		}
+	}
+	if !signed {
+		s.challenge(conn, from, header, 401, "Unauthorized")
+		return nil, false
+	}
+
+	claimed := msg.Credentials
+	if claimed.Username == "" || claimed.Realm == "" || claimed.Nonce == nil {
+		s.respond(conn, from, goturn.NewErrorResponseBuilder(header, 400, "Bad Request"))
+		return nil, false
+	}
+	if claimed.Realm != s.realm() {
+		s.authFailed()
+		s.challenge(conn, from, header, 401, "Unauthorized")
+		return nil, false
+	}
+	if !s.validNonce(claimed.Nonce, from) {
+		s.challenge(conn, from, header, 438, "Stale Nonce")
+		return nil, false
+	}
+	password, ok := s.Auth(claimed.Username)
+	if !ok {
+		s.authFailed()
+		s.challenge(conn, from, header, 401, "Unauthorized")
+		return nil, false
+	}
+	return &common.Credentials{
+		Username: claimed.Username,
+		Realm:    claimed.Realm,
+		Password: password,
+		Nonce:    claimed.Nonce,
+	}, true
+}
+
+// respondAuthenticated sends a response signed with the credentials of the
+// request it answers.
+func (s *Server) respondAuthenticated(conn net.PacketConn, to net.Addr, response *goturn.MessageBuilder, credentials *common.Credentials) error {
+	return s.respond(conn, to, response.
+		Credentials(*credentials).
+		Attribute(&stun.MessageIntegrityAttribute{}))
+}
+
+// handleTurn processes an authenticated TURN request.
+func (s *Server) handleTurn(conn net.PacketConn, from net.Addr, request *common.Message, credentials *common.Credentials) {
+	key := fiveTuple{conn, from.String()}
+	a := s.allocation(key)
+	if request.Header.Type == goturn.AllocateRequest {
+		if a != nil {
+			a.retransmitted(conn, from, request, credentials)
+			return
+		}
+		s.handleAllocate(conn, from, request, credentials)
+		return
+	}
+
+	if request.Header.Type == goturn.RefreshRequest {
+		if ticket, ok := turn.GetMobilityTicket(request); ok && len(ticket) > 0 {
+			s.handleMobility(conn, from, a, ticket, request, credentials)
+			return
+		}
+	}
+
+	if a == nil {
+		s.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, 437, "Allocation Mismatch"), credentials)
+		return
+	}
+	if a.username != credentials.Username {
+		s.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, 441, "Wrong Credentials"), credentials)
+		return
+	}
+	switch request.Header.Type {
+	case goturn.RefreshRequest:
+		s.handleRefresh(conn, from, a, request, credentials)
+	case goturn.CreatePermissionRequest:
+		s.handleCreatePermission(conn, from, a, request, credentials)
+	case goturn.ChannelBindRequest:
+		s.handleChannelBind(conn, from, a, request, credentials)
+	}
+}
+
+// relayIP provides the address relayed sockets of a family are opened on for
+// clients of conn, or nil if the server cannot relay the family.
+func (s *Server) relayIP(conn net.PacketConn, family uint16) net.IP {
+	if family == turn.FamilyIPv4 && s.RelayIPv4 != nil {
+		return s.RelayIPv4
+	} else if family == turn.FamilyIPv6 && s.RelayIPv6 != nil {
+		return s.RelayIPv6
+	}
+	address := common.Address{conn.LocalAddr()}
+	local := address.Host()
+	if local == nil || local.IsUnspecified() {
+		return nil
+	}
+	if (local.To4() != nil) == (family == turn.FamilyIPv4) {
+		return local
+	}
+	return nil
+}
+
+// lifetime provides the lifetime granted for a request, which is the requested
+// lifetime bounded by MaxLifetime, or the default lifetime if none was asked.
+func (s *Server) lifetime(request *common.Message) time.Duration {
+	lifetime, ok := turn.GetLifetime(request)
+	if !ok {
+		return defaultLifetime
+	}
+	max := s.MaxLifetime
+	if max <= 0 {
+		max = defaultMaxLifetime
+	}
+	if lifetime > max {
+		return max
+	}
+	if lifetime > 0 && lifetime < defaultLifetime {
+		return defaultLifetime
+	}
+	return lifetime
+}
+
+// handleAllocate creates an allocation with a relayed address of each family
+// requested. When one family of a dual-stack request cannot be relayed, the
+// allocation is made with the other, and an ADDRESS-ERROR-CODE explains why.
+// Relayed ports may be asked to be even, with the next port reserved for a
+// later allocation claiming it with a RESERVATION-TOKEN.
+func (s *Server) handleAllocate(conn net.PacketConn, from net.Addr, request *common.Message, credentials *common.Credentials) {
+	fail := func(code int, phrase string) {
+		s.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, code, phrase), credentials)
+	}
+
+	transport, ok := turn.GetRequestedTransport(request)
+	if !ok {
+		fail(400, "Bad Request")
+		return
+	}
+	if transport != 17 {
+		fail(442, "Unsupported Transport Protocol")
+		return
+	}
+
+	families := []uint16{turn.FamilyIPv4}
+	requested, hasRequested := turn.GetRequestedAddressFamily(request)
+	additional, hasAdditional := turn.GetAdditionalAddressFamily(request)
+	switch {
+	case hasRequested && hasAdditional:
+		fail(400, "Bad Request")
+		return
+	case hasRequested:
+		if requested != turn.FamilyIPv4 && requested != turn.FamilyIPv6 {
+			fail(440, "Address Family not Supported")
+			return
+		}
+		families = []uint16{requested}
+	case hasAdditional:
+		if additional != turn.FamilyIPv6 {
+			fail(400, "Bad Request")
+			return
+		}
+		families = append(families, turn.FamilyIPv6)
+	}
+
+	// An even port may only be asked for a single family, and a reservation is
+	// claimed in place of choosing a family and port.
+	reserve, even := turn.GetEvenPort(request)
+	token, reserved := turn.GetReservationToken(request)
+	if (even && hasAdditional) || (reserved && (even || hasRequested || hasAdditional)) {
+		fail(400, "Bad Request")
+		return
+	}
+
+	code, phrase, admitted := s.Quotas.admit(credentials.Username)
+	if !admitted {
+		fail(code, phrase)
+		return
+	}
+	// The allocation is uncounted once it closes, or here if it is not made.
+	var a *allocation
+	defer func() {
+		if a == nil {
+			s.Quotas.release(credentials.Username)
+		}
+	}()
+
+	response := goturn.NewResponseBuilder(request.Header)
+	var relays []net.PacketConn
+	type addressError struct {
+		family uint16
+		code   int
+		phrase string
+	}
+	var failures []addressError
+	if reserved {
+		relay := s.claimReservation(token)
+		if relay == nil {
+			fail(508, "Insufficient Capacity")
+			return
+		}
+		relays = append(relays, relay)
+		response.XorRelayedAddress(advertised(relay))
+		families = nil
+	}
+	for _, family := range families {
+		address := s.relayAddress(conn, family)
+		if address == nil {
+			failures = append(failures, addressError{family, 440, "Address Family not Supported"})
+			continue
+		}
+		relay, next, err := s.listenRelay(*address, even, reserve)
+		if err != nil {
+			failures = append(failures, addressError{family, 508, "Insufficient Capacity"})
+			continue
+		}
+		relays = append(relays, relay)
+		response.XorRelayedAddress(advertised(relay))
+		if next != nil {
+			response.ReservationToken(s.reserve(next))
+		}
+	}
+	if len(relays) == 0 {
+		fail(failures[0].code, failures[0].phrase)
+		return
+	}
+	for _, failure := range failures {
+		response.AddressErrorCode(failure.family, failure.code, failure.phrase)
+	}
+
+	// Servers unable to set the Don't Fragment bit must treat DONT-FRAGMENT as
+	// an unknown attribute.
+	if turn.HasDontFragment(request) {
+		for _, relay := range relays {
+			if err := setDontFragment(relay); err != nil {
+				for _, relay := range relays {
+					relay.Close()
+				}
+				s.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, 420, "Unknown Attribute").
+					UnknownAttributes(turn.DontFragment), credentials)
+				return
+			}
+		}
+	}
+
+	lifetime := s.lifetime(request)
+	if lifetime == 0 {
+		lifetime = defaultLifetime
+	}
+	var ticket []byte
+	if _, ok := turn.GetMobilityTicket(request); ok {
+		ticket = newTicket()
+		response.MobilityTicket(ticket)
+	}
+	response.Lifetime(lifetime).XorMappedAddress(from)
+	data, err := s.serialize(response.
+		Credentials(*credentials).
+		Attribute(&stun.MessageIntegrityAttribute{}))
+	if err != nil {
+		for _, relay := range relays {
+			relay.Close()
+		}
+		fail(500, "Server Error")
+		return
+	}
+
+	a = newAllocation(s, conn, from, credentials.Username, relays)
+	a.dontFragment = turn.HasDontFragment(request)
+	if ticket != nil {
+		a.tickets = []string{string(ticket)}
+	}
+	a.transaction = request.Header.Id
+	a.response = data
+	if !s.addAllocation(a, lifetime) {
+		a.close()
+		return
+	}
+	if _, err := conn.WriteTo(data, from); err == nil {
+		s.trace(trace.Sent, conn, from, data)
+	}
+}
+
+// handleRefresh extends the lifetime of an allocation, or deletes it when the
+// requested lifetime is zero.
+func (s *Server) handleRefresh(conn net.PacketConn, from net.Addr, a *allocation, request *common.Message, credentials *common.Credentials) {
+	lifetime := s.lifetime(request)
+	if lifetime == 0 {
+		s.removeAllocation(a)
+	} else {
+		a.refresh(lifetime)
+	}
+	s.respondAuthenticated(conn, from, goturn.NewResponseBuilder(request.Header).Lifetime(lifetime), credentials)
+}
+
+// handleMobility processes a Refresh request presenting a mobility ticket,
+// moving the allocation the ticket was issued for to the 5-tuple the request
+// came from, and issuing a new ticket, per RFC 8016.
+func (s *Server) handleMobility(conn net.PacketConn, from net.Addr, current *allocation, ticket []byte, request *common.Message, credentials *common.Credentials) {
+	fail := func(code int, phrase string) {
+		s.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, code, phrase), credentials)
+	}
+	a := s.ticketed(ticket)
+	if a == nil || (current != nil && current != a) {
+		fail(405, "Mobility Forbidden")
+		return
+	}
+	if a.username != credentials.Username {
+		fail(441, "Wrong Credentials")
+		return
+	}
+
+	lifetime := s.lifetime(request)
+	if lifetime == 0 {
+		s.removeAllocation(a)
+		s.respondAuthenticated(conn, from, goturn.NewResponseBuilder(request.Header).Lifetime(0), credentials)
+		return
+	}
+	next := newTicket()
+	if !s.moveAllocation(a, conn, from, string(next)) {
+		fail(437, "Allocation Mismatch")
+		return
+	}
+	a.refresh(lifetime)
+	s.respondAuthenticated(conn, from, goturn.NewResponseBuilder(request.Header).
+		Lifetime(lifetime).
+		MobilityTicket(next), credentials)
+}
+
+// handleCreatePermission installs or refreshes permissions for the IP of each
+// peer in the request, if the peer policy of the user permits all of them and
+// the allocation has room for them.
+func (s *Server) handleCreatePermission(conn net.PacketConn, from net.Addr, a *allocation, request *common.Message, credentials *common.Credentials) {
+	peers := turn.GetXorPeerAddresses(request)
+	if len(peers) == 0 {
+		s.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, 400, "Bad Request"), credentials)
+		return
+	}
+	for _, peer := range peers {
+		if a.socket(peer.IP) == nil {
+			s.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, 443, "Peer Address Family Mismatch"), credentials)
+			return
+		}
+	}
+	policy := s.peerPolicy(a.username)
+	for _, peer := range peers {
+		if !s.permitsPeer(policy, peer.IP) {
+			s.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, 403, "Forbidden"), credentials)
+			return
+		}
+	}
+	ips := make([]net.IP, 0, len(peers))
+	for _, peer := range peers {
+		ips = append(ips, peer.IP)
+	}
+	if !a.permit(s.Quotas.permissions(a.username), ips...) {
+		s.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, 508, "Insufficient Capacity"), credentials)
+		return
+	}
+	s.respondAuthenticated(conn, from, goturn.NewResponseBuilder(request.Header), credentials)
+}
+
+// handleChannelBind binds a channel number to a peer, so that data can be
+// exchanged with it in ChannelData messages rather than indications.
+func (s *Server) handleChannelBind(conn net.PacketConn, from net.Addr, a *allocation, request *common.Message, credentials *common.Credentials) {
+	fail := func(code int, phrase string) {
+		s.respondAuthenticated(conn, from, goturn.NewErrorResponseBuilder(request.Header, code, phrase), credentials)
+	}
+	number, ok := turn.GetChannelNumber(request)
+	peer, hasPeer := turn.GetXorPeerAddress(request)
+	if !ok || !hasPeer || number < minChannel || number > maxChannel {
+		fail(400, "Bad Request")
+		return
+	}
+	if a.socket(peer.IP) == nil {
+		fail(443, "Peer Address Family Mismatch")
+		return
+	}
+	if !s.permitsPeer(s.peerPolicy(a.username), peer.IP) {
+		fail(403, "Forbidden")
+		return
+	}
+	if !a.bindable(number, &peer) {
+		fail(400, "Bad Request")
+		return
+	}
+	if !a.permit(s.Quotas.permissions(a.username), peer.IP) {
+		fail(508, "Insufficient Capacity")
+		return
+	}
+	a.bind(number, &peer)
+	s.respondAuthenticated(conn, from, goturn.NewResponseBuilder(request.Header), credentials)
+}
+
+// handleSend relays the data of a Send indication to its peer. Datagrams which
+// should not be fragmented are dropped if the Don't Fragment bit cannot be set.
+func (s *Server) handleSend(conn net.PacketConn, from net.Addr, indication *common.Message) {
+	a := s.allocation(fiveTuple{conn, from.String()})
+	if a == nil {
+		return
+	}
+	peer, ok := turn.GetXorPeerAddress(indication)
+	data, hasData := turn.GetData(indication)
+	if !ok || !hasData {
+		return
+	}
+	if turn.HasDontFragment(indication) && !a.setDontFragment() {
+		return
+	}
+	a.send(data, &peer)
+}
+
+// handleChannelData relays a ChannelData message to the peer bound to its
+// channel.
+func (s *Server) handleChannelData(conn net.PacketConn, from net.Addr, data []byte) {
+	if len(data) < 4 {
+		return
+	}
+	a := s.allocation(fiveTuple{conn, from.String()})
+	if a == nil {
+		return
+	}
+	number := binary.BigEndian.Uint16(data[0:2])
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length > len(data)-4 {
+		return
+	}
+	if peer := a.channelPeer(number); peer != nil {
+		a.send(data[4:4+length], peer)
+	}
+}
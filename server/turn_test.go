@@ -0,0 +1,311 @@
+package server
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/client"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/turn"
+)
+
+// turnServer starts a TURN server on the IPv4 loopback, accepting the user
+// "user" with the password "pass" and permitting loopback peers.
+func turnServer(t *testing.T, relayIPv6 net.IP) (*Server, net.PacketConn) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Realm:     "example.org",
+		RelayIPv6: relayIPv6,
+		Auth: func(username string) (string, bool) {
+			return "pass", username == "user"
+		},
+		Peers: func(string) *PeerPolicy {
+			return &PeerPolicy{Allow: mustParseNetworks("127.0.0.0/8")}
+		},
+	}
+	go s.Serve(conn)
+	return s, conn
+}
+
+// allocate makes an allocation on a server with families.
+func allocate(t *testing.T, server net.PacketConn, username string, families ...uint16) ([]net.Addr, error) {
+	c, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	stunClient := &client.StunClient{Conn: c, Timeout: time.Second}
+	credentials := client.LongtermCredentials(username, "pass")
+	return stunClient.Allocate(&credentials, families...)
+}
+
+func TestAllocateDualStack(t *testing.T) {
+	if conn, err := net.ListenPacket("udp6", "[::1]:0"); err != nil {
+		t.Skip("No IPv6 loopback available")
+	} else {
+		conn.Close()
+	}
+	s, conn := turnServer(t, net.IPv6loopback)
+	defer s.Close()
+
+	addrs, err := allocate(t, conn, "user", turn.FamilyIPv4, turn.FamilyIPv6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("Expected two relayed addresses, got %v", addrs)
+	}
+	v4, v6 := addrs[0].(*net.UDPAddr), addrs[1].(*net.UDPAddr)
+	if !v4.IP.Equal(net.IPv4(127, 0, 0, 1)) || !v6.IP.Equal(net.IPv6loopback) {
+		t.Errorf("Unexpected relayed addresses %v", addrs)
+	}
+
+	addrs, err = allocate(t, conn, "user", turn.FamilyIPv6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || !addrs[0].(*net.UDPAddr).IP.Equal(net.IPv6loopback) {
+		t.Errorf("Unexpected IPv6 relayed addresses %v", addrs)
+	}
+}
+
+func TestAllocateUnsupportedFamily(t *testing.T) {
+	s, conn := turnServer(t, nil)
+	defer s.Close()
+
+	// A dual-stack allocation falls back to the family the server can relay.
+	addrs, err := allocate(t, conn, "user", turn.FamilyIPv4, turn.FamilyIPv6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0].(*net.UDPAddr).IP.To4() == nil {
+		t.Errorf("Unexpected relayed addresses %v", addrs)
+	}
+
+	if _, err := allocate(t, conn, "user", turn.FamilyIPv6); err == nil || !strings.Contains(err.Error(), "440") {
+		t.Errorf("Expected IPv6 allocation to fail with 440, got %v", err)
+	}
+	if _, err := allocate(t, conn, "intruder"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected unknown user to fail with 401, got %v", err)
+	}
+}
+
+func TestAllocateEvenPort(t *testing.T) {
+	s, conn := turnServer(t, nil)
+	defer s.Close()
+
+	dial := func() *client.StunClient {
+		c, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return &client.StunClient{Conn: c, Timeout: time.Second}
+	}
+
+	rtp := client.LongtermCredentials("user", "pass")
+	relayed, token, err := dial().AllocateEvenPort(&rtp, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := relayed.(*net.UDPAddr).Port
+	if port%2 != 0 {
+		t.Errorf("Relayed port %d is not even", port)
+	}
+
+	rtcp := client.LongtermCredentials("user", "pass")
+	reserved, err := dial().AllocateReserved(&rtcp, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserved.(*net.UDPAddr).Port != port+1 {
+		t.Errorf("Reserved port %d does not follow %d", reserved.(*net.UDPAddr).Port, port)
+	}
+
+	again := client.LongtermCredentials("user", "pass")
+	if _, err := dial().AllocateReserved(&again, token); err == nil || !strings.Contains(err.Error(), "508") {
+		t.Errorf("Expected a claimed reservation to fail with 508, got %v", err)
+	}
+}
+
+func TestAllocateDontFragment(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Don't Fragment is only supported on Linux")
+	}
+	s, conn := turnServer(t, nil)
+	defer s.Close()
+
+	c, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	stunClient := &client.StunClient{Conn: c, Timeout: time.Second, DontFragment: true}
+	credentials := client.LongtermCredentials("user", "pass")
+	if _, err := stunClient.Allocate(&credentials); err != nil {
+		t.Fatal(err)
+	}
+
+	a := s.allocation(fiveTuple{conn, c.LocalAddr().String()})
+	if a == nil || !a.dontFragment {
+		t.Error("Allocation does not set Don't Fragment")
+	}
+}
+
+func TestMobility(t *testing.T) {
+	s, conn := turnServer(t, nil)
+	defer s.Close()
+
+	c, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stunClient := &client.StunClient{Conn: c, Timeout: time.Second, Mobility: true}
+	credentials := client.LongtermCredentials("user", "pass")
+	if _, err := stunClient.Allocate(&credentials); err != nil {
+		t.Fatal(err)
+	}
+	ticket := stunClient.MobilityTicket
+	if len(ticket) == 0 {
+		t.Fatal("No mobility ticket issued")
+	}
+	previous := fiveTuple{conn, c.LocalAddr().String()}
+
+	moved, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer moved.Close()
+	if err := stunClient.Migrate(moved); err != nil {
+		t.Fatal(err)
+	}
+	if string(stunClient.MobilityTicket) == string(ticket) {
+		t.Error("Mobility ticket was not replaced")
+	}
+	if s.allocation(previous) != nil {
+		t.Error("Allocation remained on the previous 5-tuple")
+	}
+	if s.allocation(fiveTuple{conn, moved.LocalAddr().String()}) == nil {
+		t.Error("Allocation was not moved to the new 5-tuple")
+	}
+	if err := stunClient.RequestPermission(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}); err != nil {
+		t.Errorf("Allocation not usable after moving: %v", err)
+	}
+
+	// An unknown ticket is rejected.
+	current := stunClient.MobilityTicket
+	stunClient.MobilityTicket = []byte("unknown")
+	other, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := stunClient.Migrate(other); err == nil || !strings.Contains(err.Error(), "405") {
+		t.Errorf("Expected 405 for an unknown mobility ticket, got %v", err)
+	}
+	if stunClient.Conn != moved {
+		t.Error("Connection not restored after a failed migration")
+	}
+
+	// So is the ticket of another allocation, presented from a 5-tuple which
+	// has its own.
+	c2, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	otherClient := &client.StunClient{Conn: c2, Timeout: time.Second, Mobility: true}
+	if _, err := otherClient.Allocate(&credentials); err != nil {
+		t.Fatal(err)
+	}
+	otherClient.MobilityTicket = current
+	if err := otherClient.Migrate(c2); err == nil || !strings.Contains(err.Error(), "405") {
+		t.Errorf("Expected 405 for a foreign mobility ticket, got %v", err)
+	}
+}
+
+func TestPeerPolicy(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default policy applies to users without overrides.
+	s := &Server{
+		Auth: func(username string) (string, bool) {
+			return "pass", true
+		},
+		Peers: func(username string) *PeerPolicy {
+			if username == "admin" {
+				return &PeerPolicy{Allow: mustParseNetworks("127.0.0.0/8")}
+			}
+			return nil
+		},
+	}
+	go s.Serve(conn)
+	defer s.Close()
+
+	dial := func(username string) (net.Conn, *client.StunClient, *common.Credentials) {
+		c, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		stunClient := &client.StunClient{Conn: c, Timeout: time.Second}
+		credentials := client.LongtermCredentials(username, "pass")
+		if _, err := stunClient.Allocate(&credentials); err != nil {
+			t.Fatal(err)
+		}
+		return c, stunClient, &credentials
+	}
+
+	c, stunClient, credentials := dial("user")
+	for _, ip := range []string{"127.0.0.1", "10.0.0.1", "169.254.169.254"} {
+		err := stunClient.RequestPermission(&net.UDPAddr{IP: net.ParseIP(ip), Port: 9})
+		if err == nil || !strings.Contains(err.Error(), "403") {
+			t.Errorf("Expected permission for %s to be forbidden, got %v", ip, err)
+		}
+	}
+	if err := stunClient.RequestPermission(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 9}); err != nil {
+		t.Errorf("Permission for a public peer failed: %v", err)
+	}
+
+	// Channels are checked when they are bound.
+	request, err := goturn.NewMessageBuilder(goturn.ChannelBindRequest).
+		ChannelNumber(0x4000).
+		XorPeerAddress(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}).
+		Credentials(*credentials).
+		Authenticated().
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := request.Serialize()
+	c.Write(data)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 1500)
+	n, err := c.Read(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := goturn.ParseTurn(buffer[0:n], credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, ok := stun.GetError(response); !ok || code.Error() != 403 {
+		t.Errorf("Expected channel to a loopback peer to be forbidden, got %v", code)
+	}
+
+	// Users may be allowed further peers.
+	_, admin, _ := dial("admin")
+	if err := admin.RequestPermission(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}); err != nil {
+		t.Errorf("Permission allowed for user failed: %v", err)
+	}
+}
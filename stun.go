@@ -1,39 +1,67 @@
 package goturn
 
 import (
-	"crypto/rand"
 	common "github.com/willscott/goturn/common"
 	"github.com/willscott/goturn/stun"
 )
 
+// STUN (RFC 5389) defined methods. Shared Secret is defined by RFC 3489.
+const (
+	BindingMethod      common.Method = 0x001
+	SharedSecretMethod common.Method = 0x002
+)
+
 // STUN (RFC 5389) defined message types.
 const (
 	BindingRequest       common.HeaderType = 0x0001
-	SharedSecretRequest                    = 0x0002
-	BindingResponse                        = 0x0101
-	SharedSecretResponse                   = 0x0102
-	BindingError                           = 0x0111
-	SharedSecretError                      = 0x0112
+	SharedSecretRequest  common.HeaderType = 0x0002
+	BindingIndication    common.HeaderType = 0x0011
+	BindingResponse      common.HeaderType = 0x0101
+	SharedSecretResponse common.HeaderType = 0x0102
+	BindingError         common.HeaderType = 0x0111
+	SharedSecretError    common.HeaderType = 0x0112
 )
 
+func init() {
+	common.RegisterMethod(BindingMethod, "Binding")
+	common.RegisterMethod(SharedSecretMethod, "SharedSecret")
+}
+
 // Deprecated: Should live in individual stun attribute implementations.
 const (
 	AlternateServer common.AttributeType = 0x8023
 )
 
+// stunMessageTypes are the message types a strict STUN parser will accept.
+// The RFC 3489 Shared Secret messages are not part of RFC 5389.
+var stunMessageTypes = []common.HeaderType{
+	BindingRequest,
+	BindingIndication,
+	BindingResponse,
+	BindingError,
+}
+
 // ParseStun parses a message in RFC 5389 STUN format. Attributes defined in
 // subsequent standards will not be parsed.
 func ParseStun(data []byte) (*common.Message, error) {
 	return common.Parse(data, nil, stun.StunAttributes)
 }
 
+// ParseStunStrict parses a message in RFC 5389 STUN format, rejecting messages
+// that do not strictly conform to the RFC. It should be preferred when parsing
+// data from untrusted sources. Credentials, when provided, are used to validate
+// message integrity.
+func ParseStunStrict(data []byte, credentials *common.Credentials) (*common.Message, error) {
+	return common.ParseStrict(data, credentials, stun.StunAttributes, stunMessageTypes)
+}
+
 // NewBindingRequest creates a STUN message for a client binding request.
 func NewBindingRequest() (*common.Message, error) {
-	message := common.Message{
-		Header: common.Header{
-			Type: BindingRequest,
-		},
-	}
-	_, err := rand.Read(message.Header.Id[:])
-	return &message, err
+	return NewMessageBuilder(BindingRequest).Build()
+}
+
+// NewBindingIndication creates a STUN message for a binding indication, which
+// refreshes NAT bindings without eliciting a response.
+func NewBindingIndication() (*common.Message, error) {
+	return NewMessageBuilder(BindingIndication).Fingerprint().Build()
 }
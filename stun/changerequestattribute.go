@@ -0,0 +1,94 @@
+package stun
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/willscott/goturn/common"
+)
+
+const (
+	ChangeRequest stun.AttributeType = 0x3
+)
+
+// ChangeRequestAttribute asks a server supporting RFC 5780 to send its response
+// from a different IP address and/or port than the request was received on.
+type ChangeRequestAttribute struct {
+	ChangeIP   bool
+	ChangePort bool
+}
+
+func NewChangeRequestAttribute() stun.Attribute {
+	return stun.Attribute(new(ChangeRequestAttribute))
+}
+
+func (h *ChangeRequestAttribute) Type() stun.AttributeType {
+	return ChangeRequest
+}
+
+// GetChangeRequest provides the flags of the CHANGE-REQUEST attribute of msg,
+// if it has one.
+func GetChangeRequest(msg *stun.Message) (changeIP bool, changePort bool, ok bool) {
+	if attr := msg.GetAttribute(ChangeRequest); attr != nil {
+		if change, ok := (*attr).(*ChangeRequestAttribute); ok {
+			return change.ChangeIP, change.ChangePort, true
+		}
+	}
+	return false, false, false
+}
+
+func (h *ChangeRequestAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *ChangeRequestAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	flags := uint32(0)
+	if h.ChangeIP {
+		flags |= 4
+	}
+	if h.ChangePort {
+		flags |= 2
+	}
+	return binary.BigEndian.AppendUint32(b, flags), nil
+}
+
+func (h *ChangeRequestAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if length != 4 || uint16(len(data)) < length {
+		return errors.New("Truncated Change Request Attribute")
+	}
+	flags := binary.BigEndian.Uint32(data[0:4])
+	h.ChangeIP = flags&4 != 0
+	h.ChangePort = flags&2 != 0
+	return nil
+}
+
+func (h *ChangeRequestAttribute) Length(_ *stun.Message) uint16 {
+	return 4
+}
+
+func (h *ChangeRequestAttribute) String() string {
+	return fmt.Sprintf("ip=%t port=%t", h.ChangeIP, h.ChangePort)
+}
+
+// changeRequestJSON is the JSON representation of a ChangeRequestAttribute.
+type changeRequestJSON struct {
+	ChangeIP   bool `json:"change_ip"`
+	ChangePort bool `json:"change_port"`
+}
+
+// MarshalJSON encodes the flags of the attribute as an object, such as
+// {"change_ip": true, "change_port": false}.
+func (h *ChangeRequestAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(changeRequestJSON{h.ChangeIP, h.ChangePort})
+}
+
+func (h *ChangeRequestAttribute) UnmarshalJSON(data []byte) error {
+	var value changeRequestJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	h.ChangeIP, h.ChangePort = value.ChangeIP, value.ChangePort
+	return nil
+}
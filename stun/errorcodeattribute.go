@@ -1,8 +1,7 @@
 package stun
 
 import (
-	"bytes"
-	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/willscott/goturn/common"
@@ -36,27 +35,33 @@ func (h *ErrorCodeAttribute) Error() int {
 	return int(h.Class)*100 + int(h.Number)
 }
 
-func GetError(msg *stun.Message) *ErrorCodeAttribute {
-	attr := msg.GetAttribute(ErrorCode)
-	if attr != nil {
-		return (*attr).(*ErrorCodeAttribute)
-	} else {
-		return &ErrorCodeAttribute{}
+// GetError provides the ERROR-CODE attribute of msg, if it has one.
+func GetError(msg *stun.Message) (*ErrorCodeAttribute, bool) {
+	if attr := msg.GetAttribute(ErrorCode); attr != nil {
+		if code, ok := (*attr).(*ErrorCodeAttribute); ok {
+			return code, true
+		}
 	}
+	return nil, false
 }
 
-func (h *ErrorCodeAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	err = binary.Write(buf, binary.BigEndian, uint16(0))
-	err = binary.Write(buf, binary.BigEndian, h.Class)
-	err = binary.Write(buf, binary.BigEndian, h.Number)
-	buf.WriteString(h.Phrase)
-
-	if err != nil {
-		return nil, err
+// ErrorReason describes why a request failed from its error response msg, by
+// its ERROR-CODE or else by the type of the response.
+func ErrorReason(msg *stun.Message) string {
+	if code, ok := GetError(msg); ok {
+		return code.String()
 	}
-	return buf.Bytes(), nil
+	return msg.Header.Type.String()
+}
+
+func (h *ErrorCodeAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *ErrorCodeAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	b = append(b, 0, 0, h.Class, h.Number)
+	return append(b, h.Phrase...), nil
 }
 
 func (h *ErrorCodeAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
@@ -81,3 +86,27 @@ func (h *ErrorCodeAttribute) Decode(data []byte, length uint16, _ *stun.Parser)
 func (h *ErrorCodeAttribute) Length(_ *stun.Message) uint16 {
 	return uint16(4 + len(h.Phrase))
 }
+
+// errorCodeJSON is the JSON representation of an ErrorCodeAttribute.
+type errorCodeJSON struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// MarshalJSON encodes the attribute as an object, such as
+// {"code": 401, "reason": "Unauthorized"}.
+func (h *ErrorCodeAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(errorCodeJSON{h.Error(), h.Phrase})
+}
+
+func (h *ErrorCodeAttribute) UnmarshalJSON(data []byte) error {
+	var value errorCodeJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value.Code < 300 || value.Code > 699 {
+		return errors.New("Invalid Error Code")
+	}
+	h.Class, h.Number, h.Phrase = uint8(value.Code/100), uint8(value.Code%100), value.Reason
+	return nil
+}
@@ -1,8 +1,8 @@
 package stun
 
 import (
-	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/willscott/goturn/common"
@@ -29,35 +29,21 @@ func (h *FingerprintAttribute) Type() stun.AttributeType {
 }
 
 func (h *FingerprintAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-
-	// Calculate partial message
-	var partialMsg stun.Message
-	partialMsg.Header = msg.Header
-	partialMsg.Credentials = msg.Credentials
-	partialMsg.Attributes = make([]stun.Attribute, len(msg.Attributes))
-	copy(partialMsg.Attributes, msg.Attributes)
-
-	// Fingerprint must be last attribute.
-	partialMsg.Attributes = partialMsg.Attributes[0 : len(partialMsg.Attributes)-1]
-
-	// Add a new attribute w/ same length as msg integrity
-	dummy := stun.UnknownStunAttribute{Fingerprint, make([]byte, 4)}
-	partialMsg.Attributes = append(partialMsg.Attributes, &dummy)
-	// calcualte the byte string
-	msgBytes, err := partialMsg.Serialize()
+	msgBytes, err := msg.Serialize()
 	if err != nil {
 		return nil, err
 	}
 
-	crc := crc32.ChecksumIEEE(msgBytes[0:len(msgBytes)-8]) ^ crcXOR
-	err = binary.Write(buf, binary.BigEndian, crc)
+	// Fingerprint must be last attribute.
+	return msgBytes[len(msgBytes)-8:], nil
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+// Append calculates the CRC of the message serialized so far, b[start:], and
+// appends it as a fingerprint attribute.
+func (h *FingerprintAttribute) Append(b []byte, start int, msg *stun.Message) ([]byte, error) {
+	crc := crc32.ChecksumIEEE(b[start:]) ^ crcXOR
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return binary.BigEndian.AppendUint32(b, crc), nil
 }
 
 func (h *FingerprintAttribute) Decode(data []byte, length uint16, p *stun.Parser) error {
@@ -83,3 +69,22 @@ func (h *FingerprintAttribute) Decode(data []byte, length uint16, p *stun.Parser
 func (h *FingerprintAttribute) Length(_ *stun.Message) uint16 {
 	return 4
 }
+
+// HasFingerprint indicates whether msg includes a FINGERPRINT attribute.
+func HasFingerprint(msg *stun.Message) bool {
+	return msg.GetAttribute(Fingerprint) != nil
+}
+
+func (h *FingerprintAttribute) String() string {
+	return fmt.Sprintf("0x%08x", h.CRC)
+}
+
+// MarshalJSON encodes the CRC of the attribute as a number. The CRC is
+// recalculated when the message is serialized.
+func (h *FingerprintAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.CRC)
+}
+
+func (h *FingerprintAttribute) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.CRC)
+}
@@ -14,7 +14,7 @@ func TestFingerprintRoundtrip(t *testing.T) {
 		t.Fatalf("Could not serialize message with fingerprint attribute: %s", err)
 	}
 
-	newm, err := common.Parse(msg, common.Credentials{}, common.AttributeSet{
+	newm, err := common.Parse(msg, &common.Credentials{}, common.AttributeSet{
 		Fingerprint: NewFingerprintAttribute})
 	if err != nil {
 		t.Fatal("Could not re-parse encoded message.")
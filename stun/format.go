@@ -0,0 +1,84 @@
+package stun
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/willscott/goturn/common"
+	"net"
+	"strconv"
+)
+
+// The families of address attributes.
+const (
+	familyIPv4 uint16 = 0x01
+	familyIPv6 uint16 = 0x02
+)
+
+func init() {
+	for _, set := range []stun.AttributeSet{StunAttributes, BehaviorAttributes, IceAttributes} {
+		for t, constructor := range set {
+			stun.RegisterAttribute(t, attributeNames[t], constructor)
+		}
+	}
+}
+
+// attributeNames are the names of the attributes of the package, as given by
+// the RFCs defining them.
+var attributeNames = map[stun.AttributeType]string{
+	ChangeRequest:     "CHANGE-REQUEST",
+	ErrorCode:         "ERROR-CODE",
+	Fingerprint:       "FINGERPRINT",
+	IceControlled:     "ICE-CONTROLLED",
+	IceControlling:    "ICE-CONTROLLING",
+	MappedAddress:     "MAPPED-ADDRESS",
+	MessageIntegrity:  "MESSAGE-INTEGRITY",
+	Nonce:             "NONCE",
+	OtherAddress:      "OTHER-ADDRESS",
+	Padding:           "PADDING",
+	Priority:          "PRIORITY",
+	Realm:             "REALM",
+	ResponseOrigin:    "RESPONSE-ORIGIN",
+	ResponsePort:      "RESPONSE-PORT",
+	Software:          "SOFTWARE",
+	UnknownAttributes: "UNKNOWN-ATTRIBUTES",
+	UseCandidate:      "USE-CANDIDATE",
+	Username:          "USERNAME",
+	XorMappedAddress:  "XOR-MAPPED-ADDRESS",
+}
+
+// formatAddress renders the address of an address attribute, such as
+// "192.0.2.1:3478".
+func formatAddress(address net.IP, port uint16) string {
+	return net.JoinHostPort(address.String(), strconv.Itoa(int(port)))
+}
+
+// marshalAddress encodes the address of an address attribute as a JSON string.
+func marshalAddress(address net.IP, port uint16) ([]byte, error) {
+	return json.Marshal(formatAddress(address, port))
+}
+
+// unmarshalAddress decodes an address encoded by marshalAddress, along with
+// its family.
+func unmarshalAddress(data []byte) (family uint16, port uint16, address net.IP, err error) {
+	var value string
+	if err = json.Unmarshal(data, &value); err != nil {
+		return
+	}
+	host, portString, err := net.SplitHostPort(value)
+	if err != nil {
+		return
+	}
+	p, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return
+	}
+	if address = net.ParseIP(host); address == nil {
+		err = errors.New("Invalid address " + value)
+		return
+	}
+	family = familyIPv6
+	if ip4 := address.To4(); ip4 != nil {
+		family, address = familyIPv4, ip4
+	}
+	return family, uint16(p), address, nil
+}
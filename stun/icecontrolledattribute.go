@@ -0,0 +1,73 @@
+package stun
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/willscott/goturn/common"
+	"strconv"
+)
+
+const (
+	IceControlled stun.AttributeType = 0x8029
+)
+
+// IceControlledAttribute indicates that the sender of a connectivity check
+// believes it is the controlled ICE agent, carrying the tie-breaker used to
+// resolve role conflicts per RFC 8445.
+type IceControlledAttribute struct {
+	TieBreaker uint64
+}
+
+func NewIceControlledAttribute() stun.Attribute {
+	return stun.Attribute(new(IceControlledAttribute))
+}
+
+func (h *IceControlledAttribute) Type() stun.AttributeType {
+	return IceControlled
+}
+
+// GetIceControlled provides the tie-breaker of the ICE-CONTROLLED attribute of
+// msg, if it has one.
+func GetIceControlled(msg *stun.Message) (uint64, bool) {
+	if attr := msg.GetAttribute(IceControlled); attr != nil {
+		if role, ok := (*attr).(*IceControlledAttribute); ok {
+			return role.TieBreaker, true
+		}
+	}
+	return 0, false
+}
+
+func (h *IceControlledAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *IceControlledAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return binary.BigEndian.AppendUint64(b, h.TieBreaker), nil
+}
+
+func (h *IceControlledAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if length != 8 || uint16(len(data)) < length {
+		return errors.New("Truncated ICE-Controlled Attribute")
+	}
+	h.TieBreaker = binary.BigEndian.Uint64(data[0:8])
+	return nil
+}
+
+func (h *IceControlledAttribute) Length(_ *stun.Message) uint16 {
+	return 8
+}
+
+func (h *IceControlledAttribute) String() string {
+	return strconv.FormatUint(h.TieBreaker, 10)
+}
+
+// MarshalJSON encodes the tie-breaker of the attribute as a number.
+func (h *IceControlledAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.TieBreaker)
+}
+
+func (h *IceControlledAttribute) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.TieBreaker)
+}
@@ -0,0 +1,73 @@
+package stun
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/willscott/goturn/common"
+	"strconv"
+)
+
+const (
+	IceControlling stun.AttributeType = 0x802A
+)
+
+// IceControllingAttribute indicates that the sender of a connectivity check
+// believes it is the controlling ICE agent, carrying the tie-breaker used to
+// resolve role conflicts per RFC 8445.
+type IceControllingAttribute struct {
+	TieBreaker uint64
+}
+
+func NewIceControllingAttribute() stun.Attribute {
+	return stun.Attribute(new(IceControllingAttribute))
+}
+
+func (h *IceControllingAttribute) Type() stun.AttributeType {
+	return IceControlling
+}
+
+// GetIceControlling provides the tie-breaker of the ICE-CONTROLLING attribute of
+// msg, if it has one.
+func GetIceControlling(msg *stun.Message) (uint64, bool) {
+	if attr := msg.GetAttribute(IceControlling); attr != nil {
+		if role, ok := (*attr).(*IceControllingAttribute); ok {
+			return role.TieBreaker, true
+		}
+	}
+	return 0, false
+}
+
+func (h *IceControllingAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *IceControllingAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return binary.BigEndian.AppendUint64(b, h.TieBreaker), nil
+}
+
+func (h *IceControllingAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if length != 8 || uint16(len(data)) < length {
+		return errors.New("Truncated ICE-Controlling Attribute")
+	}
+	h.TieBreaker = binary.BigEndian.Uint64(data[0:8])
+	return nil
+}
+
+func (h *IceControllingAttribute) Length(_ *stun.Message) uint16 {
+	return 8
+}
+
+func (h *IceControllingAttribute) String() string {
+	return strconv.FormatUint(h.TieBreaker, 10)
+}
+
+// MarshalJSON encodes the tie-breaker of the attribute as a number.
+func (h *IceControllingAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.TieBreaker)
+}
+
+func (h *IceControllingAttribute) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.TieBreaker)
+}
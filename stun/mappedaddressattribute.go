@@ -1,7 +1,6 @@
 package stun
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"github.com/willscott/goturn/common"
@@ -27,20 +26,33 @@ func (h *MappedAddressAttribute) Type() stun.AttributeType {
 }
 
 func (h *MappedAddressAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	err = binary.Write(buf, binary.BigEndian, h.Family)
-	err = binary.Write(buf, binary.BigEndian, h.Port)
-	err = binary.Write(buf, binary.BigEndian, h.Address)
+	return h.Append(nil, 0, msg)
+}
+
+func (h *MappedAddressAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return AppendAddress(b, h)
+}
 
-	if err != nil {
-		return nil, err
+// AppendAddress appends the body of an address attribute onto b.
+func AppendAddress(b []byte, h *MappedAddressAttribute) ([]byte, error) {
+	address := h.Address.To16()
+	if h.Family == 1 {
+		address = h.Address.To4()
 	}
-	return buf.Bytes(), nil
+	if address == nil {
+		return nil, errors.New("Address does not match Address Family.")
+	}
+	b = binary.BigEndian.AppendUint16(b, h.Family)
+	b = binary.BigEndian.AppendUint16(b, h.Port)
+	return append(b, address...), nil
 }
 
 func (h *MappedAddressAttribute) Decode(data []byte, _ uint16, _ *stun.Parser) error {
-	if data[0] != 0 && data[1] != 1 && data[0] != 2 {
+	if len(data) < 4 {
+		return errors.New("Mapped Address Attribute unexpectedly Truncated.")
+	}
+	if data[0] != 0 || (data[1] != 1 && data[1] != 2) {
 		return errors.New("Incorrect Mapped Address Family.")
 	}
 	h.Family = uint16(data[1])
@@ -63,3 +75,29 @@ func (h *MappedAddressAttribute) Length(_ *stun.Message) uint16 {
 		return 20
 	}
 }
+
+// GetMappedAddress provides the address in the MAPPED-ADDRESS attribute of msg,
+// if it has one.
+func GetMappedAddress(msg *stun.Message) (net.UDPAddr, bool) {
+	if attr := msg.GetAttribute(MappedAddress); attr != nil {
+		if addr, ok := (*attr).(*MappedAddressAttribute); ok {
+			return net.UDPAddr{IP: addr.Address, Port: int(addr.Port)}, true
+		}
+	}
+	return net.UDPAddr{}, false
+}
+
+func (h *MappedAddressAttribute) String() string {
+	return formatAddress(h.Address, h.Port)
+}
+
+// MarshalJSON encodes the address of the attribute as a string, such as
+// "192.0.2.1:3478".
+func (h *MappedAddressAttribute) MarshalJSON() ([]byte, error) {
+	return marshalAddress(h.Address, h.Port)
+}
+
+func (h *MappedAddressAttribute) UnmarshalJSON(data []byte) (err error) {
+	h.Family, h.Port, h.Address, err = unmarshalAddress(data)
+	return err
+}
@@ -1,13 +1,11 @@
 package stun
 
 import (
-	"bytes"
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/sha1"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"github.com/willscott/goturn/common"
 )
 
@@ -26,8 +24,13 @@ func (h *MessageIntegrityAttribute) Type() stun.AttributeType {
 	return MessageIntegrity
 }
 
+// makeKey derives the HMAC key for credentials. Long-term credentials, which
+// always have a realm, use a hash of the username, realm and password.
+// Short-term credentials, such as those of ICE, use the password directly.
 func makeKey(cred *stun.Credentials) []byte {
-	if len(cred.Username) > 0 {
+	if cred == nil {
+		return nil
+	} else if len(cred.Realm) > 0 {
 		key := make([]byte, 16)
 		sum := md5.Sum([]byte(cred.Username + ":" + cred.Realm + ":" + cred.Password))
 		copy(key[:], sum[0:16])
@@ -40,50 +43,35 @@ func makeKey(cred *stun.Credentials) []byte {
 }
 
 func (h *MessageIntegrityAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	if err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg); err != nil {
+	msgBytes, err := msg.Serialize()
+	if err != nil {
 		return nil, err
 	}
 
+	// Message integrity is either the last attribute, or followed only by a
+	// fingerprint.
+	end := len(msgBytes)
+	if len(msg.Attributes) > 0 && msg.Attributes[len(msg.Attributes)-1].Type() != MessageIntegrity {
+		end -= 8
+	}
+	if end < 44 {
+		return nil, errors.New("Message Integrity must be the last attribute.")
+	}
+	return msgBytes[end-24 : end], nil
+}
+
+// Append calculates the HMAC of the message serialized so far, b[start:], and
+// appends it as a message integrity attribute.
+func (h *MessageIntegrityAttribute) Append(b []byte, start int, msg *stun.Message) ([]byte, error) {
 	key := makeKey(&msg.Credentials)
 	if key == nil {
 		return nil, errors.New("Cannot sign request without credentials.")
 	}
 
-	// Calculate partial message
-	var partialMsg stun.Message
-	partialMsg.Header = msg.Header
-	partialMsg.Credentials = msg.Credentials
-
-	partialMsg.Attributes = make([]stun.Attribute, len(msg.Attributes))
-	copy(partialMsg.Attributes, msg.Attributes)
-
-	// Remove either 1 (msg integrity) or 2 (fingerprint and msg integrity) attributes
-	partialMsg.Attributes = partialMsg.Attributes[0 : len(partialMsg.Attributes)-1]
-	if len(partialMsg.Attributes) > 0 &&
-		partialMsg.Attributes[len(partialMsg.Attributes)-1].Type() == MessageIntegrity {
-		partialMsg.Attributes = partialMsg.Attributes[0 : len(partialMsg.Attributes)-1]
-	}
-	// Add a new attribute w/ same length as msg integrity
-	dummy := stun.UnknownStunAttribute{MessageIntegrity, make([]byte, 20)}
-	partialMsg.Attributes = append(partialMsg.Attributes, &dummy)
-	// calcualte the byte string
-	msgBytes, err := partialMsg.Serialize()
-	if err != nil {
-		return nil, err
-	}
-
-	//hmac all but the dummy attribute
 	mac := hmac.New(sha1.New, key)
-	mac.Write(msgBytes[0 : len(msgBytes)-24])
-	hash := mac.Sum(nil)
-
-	err = binary.Write(buf, binary.BigEndian, hash)
-
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+	mac.Write(b[start:])
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return mac.Sum(b), nil
 }
 
 func (h *MessageIntegrityAttribute) Decode(data []byte, length uint16, p *stun.Parser) error {
@@ -98,29 +86,18 @@ func (h *MessageIntegrityAttribute) Decode(data []byte, length uint16, p *stun.P
 
 	msgBytes := p.Data[0:p.Offset]
 	// Twiddle length to where it would be at the point of this attribute
-	var header stun.Header
-	if err := header.Decode(msgBytes); err != nil {
-		return err
-	}
-	oldLength := header.Length
-	header.Length = uint16(p.Offset - 20 + 24)
-	newhead, err := header.Encode()
-	if err != nil {
-		return err
-	}
-	copy(msgBytes[0:20], newhead[0:20])
+	oldLength := binary.BigEndian.Uint16(msgBytes[2:4])
+	binary.BigEndian.PutUint16(msgBytes[2:4], p.Offset-20+24)
 
 	mac := hmac.New(sha1.New, key)
 	mac.Write(msgBytes[0:len(msgBytes)])
 	hash := mac.Sum(nil)
 
 	//unfiddle w/ header length
-	header.Length = oldLength
-	newhead, _ = header.Encode()
-	copy(msgBytes[0:20], newhead[0:20])
+	binary.BigEndian.PutUint16(msgBytes[2:4], oldLength)
 
-	if !bytes.Equal(hash, data[0:20]) {
-		return errors.New(fmt.Sprintf("Invalid Message Integrity value. Calculated %x, but was %x", hash, data[0:20]))
+	if !hmac.Equal(hash, data[0:20]) {
+		return errors.New("Invalid Message Integrity value")
 	}
 
 	return nil
@@ -129,3 +106,20 @@ func (h *MessageIntegrityAttribute) Decode(data []byte, length uint16, p *stun.P
 func (h *MessageIntegrityAttribute) Length(_ *stun.Message) uint16 {
 	return 20
 }
+
+// HasMessageIntegrity indicates whether msg is authenticated with a
+// MESSAGE-INTEGRITY attribute.
+func HasMessageIntegrity(msg *stun.Message) bool {
+	return msg.GetAttribute(MessageIntegrity) != nil
+}
+
+// String is empty, as the HMAC of the attribute is calculated as the message
+// is serialized, and not kept when it is parsed.
+func (h *MessageIntegrityAttribute) String() string {
+	return ""
+}
+
+// MarshalJSON encodes the attribute as null, as it has no value of its own.
+func (h *MessageIntegrityAttribute) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
@@ -1,6 +1,7 @@
 package stun
 
 import (
+	"bytes"
 	common "github.com/willscott/goturn/common"
 	"testing"
 )
@@ -16,7 +17,7 @@ func TestShortTermIntegrityRoundtrip(t *testing.T) {
 		t.Fatalf("Could not serialize message with integrity attribute: %s", err)
 	}
 
-	newm, err := common.Parse(msg, credentials, common.AttributeSet{
+	newm, err := common.Parse(msg, &credentials, common.AttributeSet{
 		MessageIntegrity: NewMessageIntegrityAttribute})
 	if err != nil {
 		t.Fatal("Could not re-parse encoded message.")
@@ -38,7 +39,7 @@ func TestLongTermIntegrityRoundtrip(t *testing.T) {
 		t.Fatalf("Could not serialize message with integrity attribute: %s", err)
 	}
 
-	newm, err := common.Parse(msg, credentials, common.AttributeSet{
+	newm, err := common.Parse(msg, &credentials, common.AttributeSet{
 		MessageIntegrity: NewMessageIntegrityAttribute})
 	if err != nil {
 		t.Fatal("Could not re-parse encoded message.")
@@ -61,7 +62,7 @@ func TestIntegrityWithFingerprintRoundtrip(t *testing.T) {
 		t.Fatalf("Could not serialize message with integrity attribute: %s", err)
 	}
 
-	newm, err := common.Parse(msg, credentials, common.AttributeSet{
+	newm, err := common.Parse(msg, &credentials, common.AttributeSet{
 		MessageIntegrity: NewMessageIntegrityAttribute,
 		Fingerprint:      NewFingerprintAttribute})
 	if err != nil {
@@ -72,3 +73,38 @@ func TestIntegrityWithFingerprintRoundtrip(t *testing.T) {
 		t.Error("Re-instantiated message didn't check integrity")
 	}
 }
+
+func TestIntegrityAppendToPrefix(t *testing.T) {
+	credentials := common.Credentials{Username: "me:time", Realm: "example.com", Password: "1234567890"}
+	m := common.Message{}
+	m.Credentials = credentials
+	m.Attributes = []common.Attribute{&SoftwareAttribute{"goturn"},
+		&MessageIntegrityAttribute{},
+		&FingerprintAttribute{}}
+
+	msg, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Could not serialize message with integrity attribute: %s", err)
+	}
+
+	prefix := []byte("prefix")
+	appended, err := m.AppendTo(prefix)
+	if err != nil {
+		t.Fatalf("Could not append message with integrity attribute: %s", err)
+	}
+	if !bytes.Equal(appended[len(prefix):], msg) {
+		t.Error("Appended message differs from serialized message")
+	}
+
+	integrity, err := m.Attributes[1].Encode(&m)
+	if err != nil {
+		t.Fatalf("Could not encode integrity attribute: %s", err)
+	}
+	if !bytes.Equal(integrity, msg[len(msg)-32:len(msg)-8]) {
+		t.Error("Encoded integrity attribute differs from serialized message")
+	}
+
+	if _, err := common.ParseStrict(msg, &credentials, StunAttributes, nil); err != nil {
+		t.Errorf("Could not re-parse encoded message: %s", err)
+	}
+}
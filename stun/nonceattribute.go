@@ -1,7 +1,6 @@
 package stun
 
 import (
-	"bytes"
 	"errors"
 	"github.com/willscott/goturn/common"
 )
@@ -22,14 +21,12 @@ func (h *NonceAttribute) Type() stun.AttributeType {
 }
 
 func (h *NonceAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	buf.Write(msg.Credentials.Nonce)
+	return h.Append(nil, 0, msg)
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+func (h *NonceAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return append(b, msg.Credentials.Nonce...), nil
 }
 
 func (h *NonceAttribute) Decode(data []byte, length uint16, p *stun.Parser) error {
@@ -47,3 +44,22 @@ func (h *NonceAttribute) Decode(data []byte, length uint16, p *stun.Parser) erro
 func (h *NonceAttribute) Length(msg *stun.Message) uint16 {
 	return uint16(len(msg.Credentials.Nonce))
 }
+
+// GetNonce provides the nonce of msg, if it has a NONCE attribute.
+func GetNonce(msg *stun.Message) ([]byte, bool) {
+	if msg.GetAttribute(Nonce) == nil {
+		return nil, false
+	}
+	return msg.Credentials.Nonce, true
+}
+
+// String is empty, as the nonce is held in the Credentials of the message.
+func (h *NonceAttribute) String() string {
+	return ""
+}
+
+// MarshalJSON encodes the attribute as null, as the nonce is held in the
+// Credentials of the message, and encoded with it.
+func (h *NonceAttribute) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
@@ -0,0 +1,79 @@
+package stun
+
+import (
+	"github.com/willscott/goturn/common"
+	"net"
+)
+
+const (
+	OtherAddress stun.AttributeType = 0x802c
+)
+
+// OtherAddressAttribute holds the alternate address and port a server supporting RFC 5780 can respond from.
+type OtherAddressAttribute struct {
+	Family  uint16
+	Port    uint16
+	Address net.IP
+}
+
+func NewOtherAddressAttribute() stun.Attribute {
+	return stun.Attribute(new(OtherAddressAttribute))
+}
+
+func (h *OtherAddressAttribute) Type() stun.AttributeType {
+	return OtherAddress
+}
+
+// GetOtherAddress provides the address in the OTHER-ADDRESS attribute of msg, if it has one.
+func GetOtherAddress(msg *stun.Message) (net.UDPAddr, bool) {
+	if attr := msg.GetAttribute(OtherAddress); attr != nil {
+		if addr, ok := (*attr).(*OtherAddressAttribute); ok {
+			return net.UDPAddr{IP: addr.Address, Port: int(addr.Port)}, true
+		}
+	}
+	return net.UDPAddr{}, false
+}
+
+func (h *OtherAddressAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *OtherAddressAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	mapped := MappedAddressAttribute(*h)
+	return AppendAddress(b, &mapped)
+}
+
+func (h *OtherAddressAttribute) Decode(data []byte, length uint16, p *stun.Parser) error {
+	mapped := MappedAddressAttribute(*h)
+	if err := mapped.Decode(data, length, p); err != nil {
+		return err
+	}
+	h.Family = mapped.Family
+	h.Port = mapped.Port
+	h.Address = mapped.Address
+	return nil
+}
+
+func (h *OtherAddressAttribute) Length(_ *stun.Message) uint16 {
+	if h.Family == 1 {
+		return 8
+	} else {
+		return 20
+	}
+}
+
+func (h *OtherAddressAttribute) String() string {
+	return formatAddress(h.Address, h.Port)
+}
+
+// MarshalJSON encodes the address of the attribute as a string, such as
+// "192.0.2.1:3479".
+func (h *OtherAddressAttribute) MarshalJSON() ([]byte, error) {
+	return marshalAddress(h.Address, h.Port)
+}
+
+func (h *OtherAddressAttribute) UnmarshalJSON(data []byte) (err error) {
+	h.Family, h.Port, h.Address, err = unmarshalAddress(data)
+	return err
+}
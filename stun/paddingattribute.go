@@ -0,0 +1,71 @@
+package stun
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/willscott/goturn/common"
+)
+
+const (
+	Padding stun.AttributeType = 0x26
+)
+
+// PaddingAttribute pads a message to a given size, as used by RFC 5780 to
+// test how a path handles large or fragmented messages. Its contents are
+// ignored.
+type PaddingAttribute struct {
+	Size uint16
+}
+
+func NewPaddingAttribute() stun.Attribute {
+	return stun.Attribute(new(PaddingAttribute))
+}
+
+func (h *PaddingAttribute) Type() stun.AttributeType {
+	return Padding
+}
+
+// GetPadding provides the size of the PADDING attribute of msg, if it has one.
+func GetPadding(msg *stun.Message) (uint16, bool) {
+	if attr := msg.GetAttribute(Padding); attr != nil {
+		if padding, ok := (*attr).(*PaddingAttribute); ok {
+			return padding.Size, true
+		}
+	}
+	return 0, false
+}
+
+func (h *PaddingAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *PaddingAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return append(b, make([]byte, h.Size)...), nil
+}
+
+func (h *PaddingAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if uint16(len(data)) < length {
+		return errors.New("Truncated Padding Attribute")
+	}
+	h.Size = length
+	return nil
+}
+
+func (h *PaddingAttribute) Length(_ *stun.Message) uint16 {
+	return h.Size
+}
+
+func (h *PaddingAttribute) String() string {
+	return fmt.Sprintf("%d bytes", h.Size)
+}
+
+// MarshalJSON encodes the size of the attribute as a number.
+func (h *PaddingAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.Size)
+}
+
+func (h *PaddingAttribute) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.Size)
+}
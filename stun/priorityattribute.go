@@ -0,0 +1,72 @@
+package stun
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/willscott/goturn/common"
+	"strconv"
+)
+
+const (
+	Priority stun.AttributeType = 0x24
+)
+
+// PriorityAttribute carries the priority an ICE agent would assign to a peer
+// reflexive candidate learned from a connectivity check, per RFC 8445.
+type PriorityAttribute struct {
+	Priority uint32
+}
+
+func NewPriorityAttribute() stun.Attribute {
+	return stun.Attribute(new(PriorityAttribute))
+}
+
+func (h *PriorityAttribute) Type() stun.AttributeType {
+	return Priority
+}
+
+// GetPriority provides the value of the PRIORITY attribute of msg, if it has
+// one.
+func GetPriority(msg *stun.Message) (uint32, bool) {
+	if attr := msg.GetAttribute(Priority); attr != nil {
+		if priority, ok := (*attr).(*PriorityAttribute); ok {
+			return priority.Priority, true
+		}
+	}
+	return 0, false
+}
+
+func (h *PriorityAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *PriorityAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return binary.BigEndian.AppendUint32(b, h.Priority), nil
+}
+
+func (h *PriorityAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if length != 4 || uint16(len(data)) < length {
+		return errors.New("Truncated Priority Attribute")
+	}
+	h.Priority = binary.BigEndian.Uint32(data[0:4])
+	return nil
+}
+
+func (h *PriorityAttribute) Length(_ *stun.Message) uint16 {
+	return 4
+}
+
+func (h *PriorityAttribute) String() string {
+	return strconv.FormatUint(uint64(h.Priority), 10)
+}
+
+// MarshalJSON encodes the priority as a number.
+func (h *PriorityAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.Priority)
+}
+
+func (h *PriorityAttribute) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.Priority)
+}
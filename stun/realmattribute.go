@@ -1,7 +1,6 @@
 package stun
 
 import (
-	"bytes"
 	"errors"
 	"github.com/willscott/goturn/common"
 )
@@ -22,14 +21,12 @@ func (h *RealmAttribute) Type() stun.AttributeType {
 }
 
 func (h *RealmAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	buf.WriteString(msg.Credentials.Realm)
+	return h.Append(nil, 0, msg)
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+func (h *RealmAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return append(b, msg.Credentials.Realm...), nil
 }
 
 func (h *RealmAttribute) Decode(data []byte, length uint16, p *stun.Parser) error {
@@ -46,3 +43,22 @@ func (h *RealmAttribute) Decode(data []byte, length uint16, p *stun.Parser) erro
 func (h *RealmAttribute) Length(msg *stun.Message) uint16 {
 	return uint16(len(msg.Credentials.Realm))
 }
+
+// GetRealm provides the realm of msg, if it has a REALM attribute.
+func GetRealm(msg *stun.Message) (string, bool) {
+	if msg.GetAttribute(Realm) == nil {
+		return "", false
+	}
+	return msg.Credentials.Realm, true
+}
+
+// String is empty, as the realm is held in the Credentials of the message.
+func (h *RealmAttribute) String() string {
+	return ""
+}
+
+// MarshalJSON encodes the attribute as null, as the realm is held in the
+// Credentials of the message, and encoded with it.
+func (h *RealmAttribute) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
@@ -0,0 +1,79 @@
+package stun
+
+import (
+	"github.com/willscott/goturn/common"
+	"net"
+)
+
+const (
+	ResponseOrigin stun.AttributeType = 0x802b
+)
+
+// ResponseOriginAttribute holds the address a response was sent from.
+type ResponseOriginAttribute struct {
+	Family  uint16
+	Port    uint16
+	Address net.IP
+}
+
+func NewResponseOriginAttribute() stun.Attribute {
+	return stun.Attribute(new(ResponseOriginAttribute))
+}
+
+func (h *ResponseOriginAttribute) Type() stun.AttributeType {
+	return ResponseOrigin
+}
+
+// GetResponseOrigin provides the address in the RESPONSE-ORIGIN attribute of msg, if it has one.
+func GetResponseOrigin(msg *stun.Message) (net.UDPAddr, bool) {
+	if attr := msg.GetAttribute(ResponseOrigin); attr != nil {
+		if addr, ok := (*attr).(*ResponseOriginAttribute); ok {
+			return net.UDPAddr{IP: addr.Address, Port: int(addr.Port)}, true
+		}
+	}
+	return net.UDPAddr{}, false
+}
+
+func (h *ResponseOriginAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *ResponseOriginAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	mapped := MappedAddressAttribute(*h)
+	return AppendAddress(b, &mapped)
+}
+
+func (h *ResponseOriginAttribute) Decode(data []byte, length uint16, p *stun.Parser) error {
+	mapped := MappedAddressAttribute(*h)
+	if err := mapped.Decode(data, length, p); err != nil {
+		return err
+	}
+	h.Family = mapped.Family
+	h.Port = mapped.Port
+	h.Address = mapped.Address
+	return nil
+}
+
+func (h *ResponseOriginAttribute) Length(_ *stun.Message) uint16 {
+	if h.Family == 1 {
+		return 8
+	} else {
+		return 20
+	}
+}
+
+func (h *ResponseOriginAttribute) String() string {
+	return formatAddress(h.Address, h.Port)
+}
+
+// MarshalJSON encodes the address of the attribute as a string, such as
+// "192.0.2.1:3478".
+func (h *ResponseOriginAttribute) MarshalJSON() ([]byte, error) {
+	return marshalAddress(h.Address, h.Port)
+}
+
+func (h *ResponseOriginAttribute) UnmarshalJSON(data []byte) (err error) {
+	h.Family, h.Port, h.Address, err = unmarshalAddress(data)
+	return err
+}
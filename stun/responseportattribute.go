@@ -0,0 +1,73 @@
+package stun
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/willscott/goturn/common"
+	"strconv"
+)
+
+const (
+	ResponsePort stun.AttributeType = 0x27
+)
+
+// ResponsePortAttribute asks a server supporting RFC 5780 to send its response
+// to a different port than the request was sent from.
+type ResponsePortAttribute struct {
+	Port uint16
+}
+
+func NewResponsePortAttribute() stun.Attribute {
+	return stun.Attribute(new(ResponsePortAttribute))
+}
+
+func (h *ResponsePortAttribute) Type() stun.AttributeType {
+	return ResponsePort
+}
+
+// GetResponsePort provides the port in the RESPONSE-PORT attribute of msg, if
+// it has one.
+func GetResponsePort(msg *stun.Message) (uint16, bool) {
+	if attr := msg.GetAttribute(ResponsePort); attr != nil {
+		if port, ok := (*attr).(*ResponsePortAttribute); ok {
+			return port.Port, true
+		}
+	}
+	return 0, false
+}
+
+func (h *ResponsePortAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *ResponsePortAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	b = binary.BigEndian.AppendUint16(b, h.Port)
+	return binary.BigEndian.AppendUint16(b, 0), nil
+}
+
+func (h *ResponsePortAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if length != 4 || uint16(len(data)) < length {
+		return errors.New("Truncated Response Port Attribute")
+	}
+	h.Port = binary.BigEndian.Uint16(data[0:2])
+	return nil
+}
+
+func (h *ResponsePortAttribute) Length(_ *stun.Message) uint16 {
+	return 4
+}
+
+func (h *ResponsePortAttribute) String() string {
+	return strconv.Itoa(int(h.Port))
+}
+
+// MarshalJSON encodes the port as a number.
+func (h *ResponsePortAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.Port)
+}
+
+func (h *ResponsePortAttribute) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.Port)
+}
@@ -1,7 +1,7 @@
 package stun
 
 import (
-	"bytes"
+	"encoding/json"
 	"errors"
 	"github.com/willscott/goturn/common"
 )
@@ -23,14 +23,12 @@ func (h *SoftwareAttribute) Type() stun.AttributeType {
 }
 
 func (h *SoftwareAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	buf.WriteString(h.Software)
+	return h.Append(nil, 0, msg)
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+func (h *SoftwareAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return append(b, h.Software...), nil
 }
 
 func (h *SoftwareAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
@@ -47,3 +45,27 @@ func (h *SoftwareAttribute) Decode(data []byte, length uint16, _ *stun.Parser) e
 func (h *SoftwareAttribute) Length(_ *stun.Message) uint16 {
 	return uint16(len(h.Software))
 }
+
+// GetSoftware provides the value of the SOFTWARE attribute of msg, if it has
+// one.
+func GetSoftware(msg *stun.Message) (string, bool) {
+	if attr := msg.GetAttribute(Software); attr != nil {
+		if software, ok := (*attr).(*SoftwareAttribute); ok {
+			return software.Software, true
+		}
+	}
+	return "", false
+}
+
+func (h *SoftwareAttribute) String() string {
+	return h.Software
+}
+
+// MarshalJSON encodes the software as a string.
+func (h *SoftwareAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.Software)
+}
+
+func (h *SoftwareAttribute) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.Software)
+}
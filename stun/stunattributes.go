@@ -20,4 +20,23 @@ var (
 		Username:          NewUsernameAttribute,
 		XorMappedAddress:  NewXorMappedAddressAttribute,
 	}
+
+	// BehaviorAttributes represents the AttributeSet of attributes defined by
+	// RFC 5780 for discovering the behavior of NATs.
+	BehaviorAttributes = stun.AttributeSet{
+		ChangeRequest:  NewChangeRequestAttribute,
+		OtherAddress:   NewOtherAddressAttribute,
+		Padding:        NewPaddingAttribute,
+		ResponseOrigin: NewResponseOriginAttribute,
+		ResponsePort:   NewResponsePortAttribute,
+	}
+
+	// IceAttributes represents the AttributeSet of attributes defined by
+	// RFC 8445 for ICE connectivity checks.
+	IceAttributes = stun.AttributeSet{
+		IceControlled:  NewIceControlledAttribute,
+		IceControlling: NewIceControllingAttribute,
+		Priority:       NewPriorityAttribute,
+		UseCandidate:   NewUseCandidateAttribute,
+	}
 )
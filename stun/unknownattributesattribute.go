@@ -1,10 +1,11 @@
 package stun
 
 import (
-	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"github.com/willscott/goturn/common"
+	"strings"
 )
 
 const (
@@ -24,24 +25,24 @@ func (h *UnknownAttributesAttribute) Type() stun.AttributeType {
 }
 
 func (h *UnknownAttributesAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	for _, att := range h.Attributes {
-		if err := binary.Write(buf, binary.BigEndian, att); err != nil {
-			return nil, err
-		}
-	}
+	return h.Append(nil, 0, msg)
+}
 
-	if err != nil {
-		return nil, err
+func (h *UnknownAttributesAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	for _, att := range h.Attributes {
+		b = binary.BigEndian.AppendUint16(b, att)
 	}
-	return buf.Bytes(), nil
+	return b, nil
 }
 
 func (h *UnknownAttributesAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
 	if uint16(len(data)) < length {
 		return errors.New("Truncated Unknown Attributes Attribute")
 	}
+	if length%2 != 0 {
+		return errors.New("Unknown Attributes Attribute has odd Length")
+	}
 
 	for i := 0; uint16(i) < length; i += 2 {
 		h.Attributes = append(h.Attributes, uint16(data[i])<<8+uint16(data[i+1]))
@@ -53,3 +54,54 @@ func (h *UnknownAttributesAttribute) Decode(data []byte, length uint16, _ *stun.
 func (h *UnknownAttributesAttribute) Length(_ *stun.Message) uint16 {
 	return uint16(2 * len(h.Attributes))
 }
+
+// GetUnknownAttributes provides the attribute types listed in the
+// UNKNOWN-ATTRIBUTES attribute of msg, if it has one.
+func GetUnknownAttributes(msg *stun.Message) ([]stun.AttributeType, bool) {
+	if attr := msg.GetAttribute(UnknownAttributes); attr != nil {
+		if unknown, ok := (*attr).(*UnknownAttributesAttribute); ok {
+			types := make([]stun.AttributeType, len(unknown.Attributes))
+			for i, t := range unknown.Attributes {
+				types[i] = stun.AttributeType(t)
+			}
+			return types, true
+		}
+	}
+	return nil, false
+}
+
+// String lists the types of the unknown attributes, such as
+// "0x8055, LIFETIME".
+func (h *UnknownAttributesAttribute) String() string {
+	names := make([]string, 0, len(h.Attributes))
+	for _, t := range h.Attributes {
+		names = append(names, stun.AttributeType(t).String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// MarshalJSON encodes the types of the unknown attributes as an array of
+// names, such as ["0x8055", "LIFETIME"].
+func (h *UnknownAttributesAttribute) MarshalJSON() ([]byte, error) {
+	names := make([]string, 0, len(h.Attributes))
+	for _, t := range h.Attributes {
+		names = append(names, stun.AttributeType(t).String())
+	}
+	return json.Marshal(names)
+}
+
+func (h *UnknownAttributesAttribute) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	h.Attributes = make([]uint16, 0, len(names))
+	for _, name := range names {
+		t, err := stun.ParseAttributeType(name)
+		if err != nil {
+			return err
+		}
+		h.Attributes = append(h.Attributes, uint16(t))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package stun
+
+import (
+	"github.com/willscott/goturn/common"
+)
+
+const (
+	UseCandidate stun.AttributeType = 0x25
+)
+
+// UseCandidateAttribute is sent by a controlling ICE agent to nominate the
+// candidate pair a connectivity check is sent on, per RFC 8445. It has no
+// value.
+type UseCandidateAttribute struct {
+}
+
+func NewUseCandidateAttribute() stun.Attribute {
+	return stun.Attribute(new(UseCandidateAttribute))
+}
+
+func (h *UseCandidateAttribute) Type() stun.AttributeType {
+	return UseCandidate
+}
+
+// HasUseCandidate indicates whether msg nominates a candidate pair with a
+// USE-CANDIDATE attribute.
+func HasUseCandidate(msg *stun.Message) bool {
+	return msg.GetAttribute(UseCandidate) != nil
+}
+
+func (h *UseCandidateAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *UseCandidateAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	return stun.AppendAttributeHeader(b, h, msg), nil
+}
+
+func (h *UseCandidateAttribute) Decode(_ []byte, _ uint16, _ *stun.Parser) error {
+	return nil
+}
+
+func (h *UseCandidateAttribute) Length(_ *stun.Message) uint16 {
+	return 0
+}
+
+// String is empty, as the attribute is a flag without a value.
+func (h *UseCandidateAttribute) String() string {
+	return ""
+}
+
+// MarshalJSON encodes the attribute as null, as it has no value.
+func (h *UseCandidateAttribute) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
@@ -1,7 +1,6 @@
 package stun
 
 import (
-	"bytes"
 	"errors"
 	"github.com/willscott/goturn/common"
 )
@@ -22,14 +21,12 @@ func (h *UsernameAttribute) Type() stun.AttributeType {
 }
 
 func (h *UsernameAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	buf.WriteString(msg.Credentials.Username)
+	return h.Append(nil, 0, msg)
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+func (h *UsernameAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return append(b, msg.Credentials.Username...), nil
 }
 
 func (h *UsernameAttribute) Decode(data []byte, length uint16, p *stun.Parser) error {
@@ -43,3 +40,22 @@ func (h *UsernameAttribute) Decode(data []byte, length uint16, p *stun.Parser) e
 func (h *UsernameAttribute) Length(msg *stun.Message) uint16 {
 	return uint16(len(msg.Credentials.Username))
 }
+
+// GetUsername provides the username of msg, if it has a USERNAME attribute.
+func GetUsername(msg *stun.Message) (string, bool) {
+	if msg.GetAttribute(Username) == nil {
+		return "", false
+	}
+	return msg.Credentials.Username, true
+}
+
+// String is empty, as the username is held in the Credentials of the message.
+func (h *UsernameAttribute) String() string {
+	return ""
+}
+
+// MarshalJSON encodes the attribute as null, as the username is held in the
+// Credentials of the message, and encoded with it.
+func (h *UsernameAttribute) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
@@ -1,7 +1,6 @@
 package stun
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -31,49 +30,51 @@ func (h *XorMappedAddressAttribute) Type() stun.AttributeType {
 	return XorMappedAddress
 }
 
+// XorAddressData provides the body of an address attribute, with the address
+// XOR'ed against the magic cookie and transaction ID of msg.
 func XorAddressData(h *XorMappedAddressAttribute, msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.BigEndian, h.Family)
-	xport := h.Port ^ uint16(stun.MagicCookie>>16)
-	err = binary.Write(buf, binary.BigEndian, xport)
+	return AppendXorAddress(nil, h, msg)
+}
 
-	var xoraddress []byte
+// AppendXorAddress appends the body of an address attribute onto b, with the
+// address XOR'ed against the magic cookie and transaction ID of msg.
+func AppendXorAddress(b []byte, h *XorMappedAddressAttribute, msg *stun.Message) ([]byte, error) {
+	var address net.IP
 	if h.Family == 1 {
-		xoraddress = make([]byte, 4)
-		binary.BigEndian.PutUint32(xoraddress, stun.MagicCookie)
-		h.Address = h.Address.To4()
+		address = h.Address.To4()
 	} else {
-		xoraddress = make([]byte, 16)
-		binary.BigEndian.PutUint32(xoraddress, stun.MagicCookie)
-		copy(xoraddress[4:16], msg.Header.Id[:])
-		h.Address = h.Address.To16()
+		address = h.Address.To16()
 	}
-	for i, _ := range xoraddress {
-		xoraddress[i] ^= h.Address[i]
+	if address == nil {
+		return nil, errors.New("Address does not match Address Family.")
 	}
-	err = binary.Write(buf, binary.BigEndian, xoraddress)
 
-	if err != nil {
-		return nil, err
+	var xoraddress [16]byte
+	binary.BigEndian.PutUint32(xoraddress[:], stun.MagicCookie)
+	copy(xoraddress[4:16], msg.Header.Id[:])
+
+	b = binary.BigEndian.AppendUint16(b, h.Family)
+	b = binary.BigEndian.AppendUint16(b, h.Port^uint16(stun.MagicCookie>>16))
+	for i := range address {
+		b = append(b, address[i]^xoraddress[i])
 	}
-	return buf.Bytes(), nil
+	return b, nil
 }
 
 func (h *XorMappedAddressAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	if err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg); err != nil {
-		return nil, err
-	}
-	bytes, err := XorAddressData(h, msg)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(bytes)
-	return buf.Bytes(), nil
+	return h.Append(nil, 0, msg)
+}
+
+func (h *XorMappedAddressAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return AppendXorAddress(b, h, msg)
 }
 
 func (h *XorMappedAddressAttribute) Decode(data []byte, _ uint16, p *stun.Parser) error {
-	if data[0] != 0 && data[1] != 1 && data[0] != 2 {
+	if len(data) < 4 {
+		return errors.New("Mapped Address Attribute unexpectedly Truncated.")
+	}
+	if data[0] != 0 || (data[1] != 1 && data[1] != 2) {
 		return errors.New("Incorrect Mapped Address Family.")
 	}
 	h.Family = uint16(data[1])
@@ -110,3 +111,25 @@ func (h *XorMappedAddressAttribute) Length(_ *stun.Message) uint16 {
 		return 20
 	}
 }
+
+// GetXorMappedAddress provides the address in the XOR-MAPPED-ADDRESS attribute
+// of msg, if it has one.
+func GetXorMappedAddress(msg *stun.Message) (net.UDPAddr, bool) {
+	if attr := msg.GetAttribute(XorMappedAddress); attr != nil {
+		if addr, ok := (*attr).(*XorMappedAddressAttribute); ok {
+			return net.UDPAddr{IP: addr.Address, Port: int(addr.Port)}, true
+		}
+	}
+	return net.UDPAddr{}, false
+}
+
+// MarshalJSON encodes the address of the attribute as a string, such as
+// "192.0.2.1:3478", without XOR'ing it.
+func (h *XorMappedAddressAttribute) MarshalJSON() ([]byte, error) {
+	return marshalAddress(h.Address, h.Port)
+}
+
+func (h *XorMappedAddressAttribute) UnmarshalJSON(data []byte) (err error) {
+	h.Family, h.Port, h.Address, err = unmarshalAddress(data)
+	return err
+}
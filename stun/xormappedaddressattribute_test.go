@@ -0,0 +1,51 @@
+package stun
+
+import (
+	common "github.com/willscott/goturn/common"
+	"net"
+	"testing"
+)
+
+func TestXorMappedAddressRoundtrip(t *testing.T) {
+	for _, ip := range []net.IP{net.ParseIP("192.0.2.1").To4(), net.ParseIP("2001:db8::1")} {
+		family := uint16(1)
+		if ip.To4() == nil {
+			family = 2
+		}
+		m := common.Message{}
+		m.Attributes = []common.Attribute{&XorMappedAddressAttribute{family, 3478, ip}}
+
+		msg, err := m.Serialize()
+		if err != nil {
+			t.Fatalf("Could not serialize message with xor mapped address: %s", err)
+		}
+
+		newm, err := common.ParseStrict(msg, nil, StunAttributes, nil)
+		if err != nil {
+			t.Fatalf("Could not re-parse encoded message: %s", err)
+		}
+		addr := newm.Attributes[0].(*XorMappedAddressAttribute)
+		if !addr.Address.Equal(ip) || addr.Port != 3478 {
+			t.Errorf("Re-instantiated address was %s, expected %s", addr, ip)
+		}
+	}
+}
+
+func TestXorMappedAddressBadFamily(t *testing.T) {
+	m := common.Message{}
+	m.Attributes = []common.Attribute{&XorMappedAddressAttribute{1, 3478, net.IPv4(192, 0, 2, 1)}}
+	msg, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Could not serialize message with xor mapped address: %s", err)
+	}
+
+	for _, family := range [][2]byte{{0, 3}, {1, 1}, {0, 0}} {
+		msg[24], msg[25] = family[0], family[1]
+		if _, err := common.Parse(msg, nil, StunAttributes); err == nil {
+			t.Errorf("Parsed address with invalid family %x", family)
+		}
+		if _, err := common.ParseStrict(msg, nil, StunAttributes, nil); err == nil {
+			t.Errorf("Strictly parsed address with invalid family %x", family)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Package otel traces STUN transactions as OpenTelemetry spans. It is
+// separate from package trace so that only programs importing it depend on
+// OpenTelemetry.
+//
+//	stunClient.Tracer = otel.NewSpans(provider.Tracer("goturn"))
+package otel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/trace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// defaultTimeout is how long a span waits for the response to its request,
+// a little longer than clients retransmit requests for.
+const defaultTimeout = 40 * time.Second
+
+// Spans is a trace.Tracer recording a span for each transaction, from its
+// request to its response. Requests sent start client spans, and requests
+// received start server spans. Retransmissions are recorded as events of the
+// span, and error responses set its status. Indications are not traced.
+type Spans struct {
+	tracer oteltrace.Tracer
+	// How long a span waits for its response before it is ended with an
+	// error. Defaults to 40 seconds.
+	Timeout time.Duration
+
+	lock sync.Mutex
+	open map[transaction]*span
+}
+
+// transaction identifies a request by its ID and the address of the other end.
+type transaction struct {
+	id     [12]byte
+	remote string
+}
+
+type span struct {
+	oteltrace.Span
+	started time.Time
+}
+
+// NewSpans creates a tracer recording spans with tracer.
+func NewSpans(tracer oteltrace.Tracer) *Spans {
+	return &Spans{tracer: tracer, open: make(map[transaction]*span)}
+}
+
+func (s *Spans) Trace(event *trace.Event) {
+	msg := event.Message
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expire(event.Time)
+	if msg == nil {
+		return
+	}
+	key := transaction{msg.Header.Id, fmt.Sprint(event.Remote)}
+	switch msg.Header.Type.Class() {
+	case common.ClassRequest:
+		if open, ok := s.open[key]; ok {
+			open.AddEvent("retransmission", oteltrace.WithTimestamp(event.Time))
+			return
+		}
+		kind := oteltrace.SpanKindClient
+		if event.Direction == trace.Received {
+			kind = oteltrace.SpanKindServer
+		}
+		attrs := []attribute.KeyValue{
+			attribute.String("stun.method", msg.Header.Type.Method().String()),
+			attribute.String("stun.transaction_id", fmt.Sprintf("%x", msg.Header.Id)),
+			attribute.String("network.transport", event.Network),
+		}
+		if event.Local != nil {
+			attrs = append(attrs, attribute.String("network.local.address", event.Local.String()))
+		}
+		if event.Remote != nil {
+			attrs = append(attrs, attribute.String("network.peer.address", event.Remote.String()))
+		}
+		if msg.Credentials.Username != "" {
+			attrs = append(attrs, attribute.String("stun.username", msg.Credentials.Username))
+		}
+		_, started := s.tracer.Start(context.Background(), "STUN "+msg.Header.Type.Method().String(),
+			oteltrace.WithSpanKind(kind),
+			oteltrace.WithTimestamp(event.Time),
+			oteltrace.WithAttributes(attrs...))
+		s.open[key] = &span{started, event.Time}
+	case common.ClassSuccess, common.ClassError:
+		open, ok := s.open[key]
+		if !ok {
+			return
+		}
+		delete(s.open, key)
+		if msg.Header.Type.Class() == common.ClassError {
+			if code, ok := stun.GetError(msg); ok {
+				open.SetAttributes(attribute.Int("stun.error_code", code.Error()))
+				open.SetStatus(codes.Error, code.String())
+			} else {
+				open.SetStatus(codes.Error, "Error response")
+			}
+		}
+		open.End(oteltrace.WithTimestamp(event.Time))
+	}
+}
+
+// expire ends the spans of requests which have not been answered in time.
+func (s *Spans) expire(now time.Time) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	for key, open := range s.open {
+		if now.Sub(open.started) > timeout {
+			open.SetStatus(codes.Error, "No response received")
+			open.End(oteltrace.WithTimestamp(open.started.Add(timeout)))
+			delete(s.open, key)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package otel
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/trace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// event describes a message built by build, as it would be traced.
+func event(t *testing.T, direction trace.Direction, build *goturn.MessageBuilder, at time.Time) *trace.Event {
+	msg, err := build.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := trace.NewEvent(direction, data, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 5000},
+		&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 3478})
+	e.Time = at
+	return e
+}
+
+func TestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	spans := NewSpans(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test"))
+
+	start := time.Now()
+	request := goturn.NewMessageBuilder(goturn.AllocateRequest).RequestedTransport("udp")
+	first := event(t, trace.Sent, request, start)
+	spans.Trace(first)
+	spans.Trace(event(t, trace.Sent, request, start.Add(100*time.Millisecond)))
+	header := first.Message.Header
+	spans.Trace(event(t, trace.Received, goturn.NewErrorResponseBuilder(header, 401, "Unauthorized"), start.Add(150*time.Millisecond)))
+
+	// A request received is never answered.
+	unanswered := goturn.NewMessageBuilder(goturn.RefreshRequest)
+	spans.Trace(event(t, trace.Received, unanswered, start.Add(time.Second)))
+	spans.Trace(event(t, trace.Received, goturn.NewMessageBuilder(goturn.BindingIndication), start.Add(time.Minute)))
+
+	ended := recorder.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("Expected 2 spans, recorded %d", len(ended))
+	}
+	allocate := ended[0]
+	if allocate.Name() != "STUN Allocate" || allocate.SpanKind() != oteltrace.SpanKindClient {
+		t.Errorf("Unexpected span %s of kind %v", allocate.Name(), allocate.SpanKind())
+	}
+	if d := allocate.EndTime().Sub(allocate.StartTime()); d != 150*time.Millisecond {
+		t.Errorf("Unexpected duration %v", d)
+	}
+	if len(allocate.Events()) != 1 || allocate.Events()[0].Name != "retransmission" {
+		t.Errorf("Unexpected events %v", allocate.Events())
+	}
+	if allocate.Status().Code != codes.Error {
+		t.Errorf("Unexpected status %v", allocate.Status())
+	}
+	found := false
+	for _, attr := range allocate.Attributes() {
+		if attr == attribute.Int("stun.error_code", 401) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("No error code in attributes %v", allocate.Attributes())
+	}
+
+	refresh := ended[1]
+	if refresh.Name() != "STUN Refresh" || refresh.SpanKind() != oteltrace.SpanKindServer || refresh.Status().Code != codes.Error {
+		t.Errorf("Unexpected unanswered span %s of kind %v with status %v", refresh.Name(), refresh.SpanKind(), refresh.Status())
+	}
+}
+
+func TestSpansErrorWithoutCode(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	spans := NewSpans(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test"))
+
+	start := time.Now()
+	request := event(t, trace.Sent, goturn.NewMessageBuilder(goturn.AllocateRequest), start)
+	spans.Trace(request)
+	response, err := goturn.NewResponseBuilder(request.Message.Header).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Header.Type = goturn.AllocateError
+	data, err := response.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans.Trace(trace.NewEvent(trace.Received, data, request.Local, request.Remote))
+
+	ended := recorder.Ended()
+	if len(ended) != 1 || ended[0].Status().Code != codes.Error {
+		t.Fatalf("Expected a failed span, recorded %v", ended)
+	}
+	for _, attr := range ended[0].Attributes() {
+		if attr.Key == "stun.error_code" {
+			t.Errorf("Error code %v recorded for response without ERROR-CODE", attr.Value.AsInt64())
+		}
+	}
+}
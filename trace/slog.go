@@ -0,0 +1,96 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/willscott/goturn/common"
+)
+
+// requestExpiry is how long a Logger remembers a request awaiting its
+// response, which is longer than a client retransmits for.
+const requestExpiry = time.Minute
+
+// Logger traces messages to a structured logger, at Level, which defaults to
+// debug. Responses are logged with the round trip time since their request.
+type Logger struct {
+	Logger *slog.Logger
+	Level  slog.Level
+
+	lock sync.Mutex
+	// When requests awaiting responses were first sent or received, by
+	// transaction.
+	requests map[transaction]time.Time
+}
+
+// transaction identifies a request by its ID and the address of the other end.
+type transaction struct {
+	id     [12]byte
+	remote string
+}
+
+// NewLogger creates a tracer logging to logger at debug level.
+func NewLogger(logger *slog.Logger) *Logger {
+	return &Logger{Logger: logger, Level: slog.LevelDebug}
+}
+
+func (l *Logger) Trace(event *Event) {
+	ctx := context.Background()
+	if !l.Logger.Enabled(ctx, l.Level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("direction", event.Direction.String()),
+		slog.String("network", event.Network),
+		slog.Any("local", event.Local),
+		slog.Any("remote", event.Remote),
+	}
+	msg := event.Message
+	if msg == nil {
+		attrs = append(attrs, slog.Int("length", len(event.Data)))
+		l.Logger.LogAttrs(ctx, l.Level, "STUN data", attrs...)
+		return
+	}
+	attrs = append(attrs,
+		slog.String("type", msg.Header.Type.String()),
+		slog.String("transaction", fmt.Sprintf("%x", msg.Header.Id)))
+	if rtt, ok := l.roundTrip(event); ok {
+		attrs = append(attrs, slog.Duration("rtt", rtt))
+	}
+	// The attributes follow the header of the message, one to a line.
+	described := strings.Split(msg.String(), "\n  ")[1:]
+	attrs = append(attrs, slog.Any("attributes", described))
+	l.Logger.LogAttrs(ctx, l.Level, "STUN message", attrs...)
+}
+
+// roundTrip remembers when requests are first seen, and provides the time
+// since its request for a response.
+func (l *Logger) roundTrip(event *Event) (time.Duration, bool) {
+	key := transaction{event.Message.Header.Id, fmt.Sprint(event.Remote)}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.requests == nil {
+		l.requests = make(map[transaction]time.Time)
+	}
+	for t, seen := range l.requests {
+		if event.Time.Sub(seen) > requestExpiry {
+			delete(l.requests, t)
+		}
+	}
+	switch event.Message.Header.Type.Class() {
+	case common.ClassRequest:
+		if _, retransmitted := l.requests[key]; !retransmitted {
+			l.requests[key] = event.Time
+		}
+	case common.ClassSuccess, common.ClassError:
+		if seen, ok := l.requests[key]; ok {
+			delete(l.requests, key)
+			return event.Time.Sub(seen), true
+		}
+	}
+	return 0, false
+}
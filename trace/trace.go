@@ -0,0 +1,118 @@
+// Package trace describes the STUN messages sent and received by clients and
+// servers, for debugging. A Tracer set on a client.StunClient or server.Server
+// is given an Event for each message, with the message decoded, its bytes, the
+// addresses it was exchanged between, and when.
+//
+// The values of MESSAGE-INTEGRITY attributes are zeroed in traced messages, so
+// that they cannot be used to guess passwords offline, and messages are decoded
+// without credentials, so that passwords never reach tracers. Usernames, realms
+// and nonces are kept, as they are sent in the clear.
+//
+//	stunClient.Tracer = trace.NewLogger(slog.Default())
+package trace
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/turn"
+)
+
+// Direction is whether a message was sent or received.
+type Direction int
+
+const (
+	Sent Direction = iota
+	Received
+)
+
+func (d Direction) String() string {
+	if d == Sent {
+		return "sent"
+	}
+	return "received"
+}
+
+// Event describes a message sent or received.
+type Event struct {
+	Direction Direction
+	// The message, decoded for inspection, or nil for data which is not a STUN
+	// message, such as ChannelData, or which cannot be decoded. Its integrity
+	// is not verified.
+	Message *common.Message
+	// The message as it was sent or received, with its MESSAGE-INTEGRITY
+	// zeroed. Message refers to it.
+	Data []byte
+	// The 5-tuple the message was exchanged on: the transport protocol, such
+	// as "udp", and the local and remote addresses.
+	Network       string
+	Local, Remote net.Addr
+	// When the message was sent or received.
+	Time time.Time
+}
+
+// Tracer is given an Event for each message sent or received. Its Trace method
+// may be called concurrently.
+type Tracer interface {
+	Trace(event *Event)
+}
+
+// TracerFunc adapts a function to a Tracer.
+type TracerFunc func(event *Event)
+
+func (f TracerFunc) Trace(event *Event) {
+	f(event)
+}
+
+// redacted are the attributes whose values are zeroed in traced messages.
+var redacted = map[common.AttributeType]bool{
+	stun.MessageIntegrity: true,
+	// MESSAGE-INTEGRITY-SHA256, from RFC 8489.
+	0x001C: true,
+}
+
+// NewEvent describes a message exchanged between local and remote, which is
+// copied and redacted so that the event may be kept.
+func NewEvent(direction Direction, data []byte, local, remote net.Addr) *Event {
+	event := &Event{
+		Direction: direction,
+		Data:      append([]byte(nil), data...),
+		Local:     local,
+		Remote:    remote,
+		Time:      time.Now(),
+	}
+	if remote != nil {
+		event.Network = remote.Network()
+	}
+	// Integrity cannot be verified without the password, so it is decoded as
+	// an unknown attribute, whose value is then zeroed along with the data it
+	// refers to.
+	if len(event.Data) >= 20 && event.Data[0]&0xC0 == 0 {
+		attrs := turn.AttributeSet()
+		delete(attrs, stun.MessageIntegrity)
+		if msg, err := common.Parse(event.Data, nil, attrs); err == nil {
+			event.Message = msg
+		}
+		redact(event.Data)
+	}
+	return event
+}
+
+// redact zeroes the values of redacted attributes of a STUN message.
+func redact(data []byte) {
+	for offset := 20; offset+4 <= len(data); {
+		attrType := common.AttributeType(binary.BigEndian.Uint16(data[offset:]))
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 4 + length
+		if end > len(data) {
+			end = len(data)
+		}
+		if redacted[attrType] {
+			clear(data[offset+4 : end])
+		}
+		offset += 4 + (length+3)&^3
+	}
+}
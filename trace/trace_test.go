@@ -0,0 +1,107 @@
+package trace
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/stun"
+)
+
+func TestNewEventRedacts(t *testing.T) {
+	credentials := common.Credentials{Username: "alice", Realm: "example.org", Password: "secret", Nonce: []byte("nonce")}
+	msg, err := goturn.NewMessageBuilder(goturn.AllocateRequest).
+		RequestedTransport("udp").
+		Credentials(credentials).
+		Authenticated().
+		Fingerprint().
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := append([]byte(nil), data...)
+
+	remote := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 3478}
+	event := NewEvent(Sent, data, nil, remote)
+	if !bytes.Equal(data, original) {
+		t.Error("The traced data was modified")
+	}
+	if event.Network != "udp" || event.Message == nil || event.Message.Header.Type != goturn.AllocateRequest {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	if c := event.Message.Credentials; c.Username != "alice" || c.Realm != "example.org" || c.Password != "" {
+		t.Errorf("Unexpected credentials %+v", c)
+	}
+	integrity := original[len(original)-8-20 : len(original)-8]
+	if bytes.Contains(event.Data, integrity) {
+		t.Error("The message integrity was not redacted from the data")
+	}
+	for _, raw := range event.Message.RawAttributes() {
+		if raw.Type == stun.MessageIntegrity && !bytes.Equal(raw.Value, make([]byte, 20)) {
+			t.Errorf("The message integrity was not redacted from the message: %x", raw.Value)
+		}
+	}
+
+	if event := NewEvent(Received, []byte{0x40, 0x00, 0x00, 0x01, 0xff}, nil, remote); event.Message != nil {
+		t.Error("ChannelData was decoded as a message")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var log bytes.Buffer
+	logger := NewLogger(slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	local := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 5000}
+	remote := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 3478}
+
+	credentials := common.Credentials{Username: "alice", Realm: "example.org", Password: "secret", Nonce: []byte("nonce")}
+	request, err := goturn.NewMessageBuilder(goturn.AllocateRequest).
+		RequestedTransport("udp").
+		Credentials(credentials).
+		Authenticated().
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := goturn.NewErrorResponseBuilder(request.Header, 437, "Allocation Mismatch").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i, msg := range []*common.Message{request, response} {
+		data, err := msg.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		event := NewEvent(Direction(i), data, local, remote)
+		event.Time = start.Add(time.Duration(i) * 20 * time.Millisecond)
+		logger.Trace(event)
+	}
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 messages logged, got %q", log.String())
+	}
+	for i, expected := range [][]string{
+		{"direction=sent", "local=192.0.2.2:5000", "remote=192.0.2.1:3478", `type="Allocate Request"`,
+			`USERNAME \"alice\"`, "MESSAGE-INTEGRITY " + strings.Repeat("0", 40)},
+		{"direction=received", `type="Allocate Error Response"`, "ERROR-CODE 437: Allocation Mismatch", "rtt=20ms"},
+	} {
+		for _, e := range expected {
+			if !strings.Contains(lines[i], e) {
+				t.Errorf("Message %d lacks %s: %s", i, e, lines[i])
+			}
+		}
+	}
+	if strings.Contains(log.String(), "secret") {
+		t.Errorf("The password was logged: %s", log.String())
+	}
+}
@@ -2,47 +2,63 @@
 package goturn
 
 import (
-	"crypto/rand"
-
 	common "github.com/willscott/goturn/common"
-	"github.com/willscott/goturn/stun"
 	"github.com/willscott/goturn/turn"
 
 	"net"
+	"time"
 )
 
-// TURN (RFC 5766) defined message types.
+// TURN (RFC 5766) defined methods, and the TCP allocation methods of RFC 6062.
 const (
-	AllocateRequest             common.HeaderType = 0x0003
-	RefreshRequest                                = 0x0004
-	CreatePermissionRequest                       = 0x0008
-	ChannelBindRequest                            = 0x0009
-	ConnectRequest                                = 0x000a
-	ConnectionBindRequest                         = 0x000b
-	SendIndication                                = 0x0016
-	DataIndication                                = 0x0017
-	ConnectionAttemptIndication                   = 0x001c
-	AllocateResponse                              = 0x0103
-	RefreshResponse                               = 0x0104
-	CreatePermissionResponse                      = 0x0108
-	ChannelBindResponse                           = 0x0109
-	ConnectResponse                               = 0x010a
-	ConnectionBindResponse                        = 0x010b
-	AllocateError                                 = 0x0113
-	RefreshError                                  = 0x0114
-	CreatePermissionError                         = 0x0118
-	ChannelBindError                              = 0x0119
-	ConnectError                                  = 0x011a
-	ConnectionBindError                           = 0x011b
+	AllocateMethod          common.Method = 0x003
+	RefreshMethod           common.Method = 0x004
+	SendMethod              common.Method = 0x006
+	DataMethod              common.Method = 0x007
+	CreatePermissionMethod  common.Method = 0x008
+	ChannelBindMethod       common.Method = 0x009
+	ConnectMethod           common.Method = 0x00a
+	ConnectionBindMethod    common.Method = 0x00b
+	ConnectionAttemptMethod common.Method = 0x00c
 )
 
-// Deprecated: Should live in individual turn attribute implementations.
+// TURN (RFC 5766) defined message types.
 const (
-	EvenPort         common.AttributeType = 0x18
-	DontFragment                          = 0x1A
-	ReservationToken                      = 0x22
+	AllocateRequest             common.HeaderType = 0x0003
+	RefreshRequest              common.HeaderType = 0x0004
+	CreatePermissionRequest     common.HeaderType = 0x0008
+	ChannelBindRequest          common.HeaderType = 0x0009
+	ConnectRequest              common.HeaderType = 0x000a
+	ConnectionBindRequest       common.HeaderType = 0x000b
+	SendIndication              common.HeaderType = 0x0016
+	DataIndication              common.HeaderType = 0x0017
+	ConnectionAttemptIndication common.HeaderType = 0x001c
+	AllocateResponse            common.HeaderType = 0x0103
+	RefreshResponse             common.HeaderType = 0x0104
+	CreatePermissionResponse    common.HeaderType = 0x0108
+	ChannelBindResponse         common.HeaderType = 0x0109
+	ConnectResponse             common.HeaderType = 0x010a
+	ConnectionBindResponse      common.HeaderType = 0x010b
+	AllocateError               common.HeaderType = 0x0113
+	RefreshError                common.HeaderType = 0x0114
+	CreatePermissionError       common.HeaderType = 0x0118
+	ChannelBindError            common.HeaderType = 0x0119
+	ConnectError                common.HeaderType = 0x011a
+	ConnectionBindError         common.HeaderType = 0x011b
 )
 
+func init() {
+	common.RegisterMethod(AllocateMethod, "Allocate")
+	common.RegisterMethod(RefreshMethod, "Refresh")
+	common.RegisterMethod(SendMethod, "Send")
+	common.RegisterMethod(DataMethod, "Data")
+	common.RegisterMethod(CreatePermissionMethod, "CreatePermission")
+	common.RegisterMethod(ChannelBindMethod, "ChannelBind")
+	common.RegisterMethod(ConnectMethod, "Connect")
+	common.RegisterMethod(ConnectionBindMethod, "ConnectionBind")
+	common.RegisterMethod(ConnectionAttemptMethod, "ConnectionAttempt")
+}
+
 // ParseTurn Parses data per the RFC 5766 TURN specification. Undefined attribute
 // types will be left un-parsed. Credentials, when provided, are used to validate
 // provided message integrity (used for authenticity) of the parsed message.
@@ -50,103 +66,139 @@ func ParseTurn(data []byte, credentials *common.Credentials) (*common.Message, e
 	return common.Parse(data, credentials, turn.AttributeSet())
 }
 
-// newMsg creates a new STUN message with a new message ID.
-func newMsg(htype common.HeaderType) (*common.Message, error) {
-	message := common.Message{
-		Header: common.Header{
-			Type: htype,
-		},
-	}
-	_, err := rand.Read(message.Header.Id[:])
-	return &message, err
+// turnMessageTypes are the message types a strict TURN parser will accept. Each
+// TURN method may only be used with the classes defined for it by RFC 5766 and
+// RFC 6062.
+var turnMessageTypes = append([]common.HeaderType{
+	AllocateRequest,
+	AllocateResponse,
+	AllocateError,
+	RefreshRequest,
+	RefreshResponse,
+	RefreshError,
+	CreatePermissionRequest,
+	CreatePermissionResponse,
+	CreatePermissionError,
+	ChannelBindRequest,
+	ChannelBindResponse,
+	ChannelBindError,
+	ConnectRequest,
+	ConnectResponse,
+	ConnectError,
+	ConnectionBindRequest,
+	ConnectionBindResponse,
+	ConnectionBindError,
+	SendIndication,
+	DataIndication,
+	ConnectionAttemptIndication,
+}, stunMessageTypes...)
+
+// ParseTurnStrict parses data per the RFC 5766 TURN specification like
+// ParseTurn, but rejects messages that do not strictly conform to the RFCs. It
+// should be preferred when parsing data from untrusted sources.
+func ParseTurnStrict(data []byte, credentials *common.Credentials) (*common.Message, error) {
+	return common.ParseStrict(data, credentials, turn.AttributeSet(), turnMessageTypes)
 }
 
 // NewAllocateRequest creates a new message requesting authorization with a
 // remote server. The allocation request specifies the type of remote network
-// that the client wishes interact with.
-func NewAllocateRequest(network string, authenticated bool) (*common.Message, error) {
-	message, err := newMsg(AllocateRequest)
+// that the client wishes interact with, and optionally the address families of
+// the relayed addresses. Without families, servers relay IPv4. A single family
+// is requested with REQUESTED-ADDRESS-FAMILY, while IPv4 and IPv6 together
+// request a dual-stack allocation with ADDITIONAL-ADDRESS-FAMILY, per RFC 8656.
+func NewAllocateRequest(network string, authenticated bool, families ...uint16) (*common.Message, error) {
+	builder := NewMessageBuilder(AllocateRequest).
+		RequestedTransport(network).
+		AddressFamilies(families...)
+	if authenticated {
+		builder.Authenticated().Fingerprint()
+	}
+	return builder.Build()
+}
 
-	net := uint8(17)
-	if network == "tcp" || network == "tcp4" || network == "tcp6" {
-		net = 6
+// NewEvenPortAllocateRequest creates an Allocate request for a relayed address
+// with an even port, such as for RTP. When reserve is set, the server is asked
+// to also reserve the next port, which can be claimed by another allocation
+// with NewReservedAllocateRequest.
+func NewEvenPortAllocateRequest(network string, reserve bool, authenticated bool) (*common.Message, error) {
+	builder := NewMessageBuilder(AllocateRequest).
+		RequestedTransport(network).
+		EvenPort(reserve)
+	if authenticated {
+		builder.Authenticated().Fingerprint()
 	}
+	return builder.Build()
+}
 
-	if authenticated == true {
-		message.Attributes = []common.Attribute{&turn.RequestedTransportAttribute{net},
-			&stun.NonceAttribute{},
-			&stun.UsernameAttribute{},
-			&stun.RealmAttribute{},
-			&stun.MessageIntegrityAttribute{},
-			&stun.FingerprintAttribute{}}
-	} else {
-		message.Attributes = []common.Attribute{&turn.RequestedTransportAttribute{net}}
+// NewReservedAllocateRequest creates an Allocate request claiming the relayed
+// port a server reserved with a reservation token.
+func NewReservedAllocateRequest(network string, token uint64, authenticated bool) (*common.Message, error) {
+	builder := NewMessageBuilder(AllocateRequest).
+		RequestedTransport(network).
+		ReservationToken(token)
+	if authenticated {
+		builder.Authenticated().Fingerprint()
 	}
+	return builder.Build()
+}
 
-	return message, err
+// NewRefreshRequest creates a message requesting that an allocation be kept for
+// lifetime, or deleted if lifetime is 0.
+func NewRefreshRequest(lifetime time.Duration) (*common.Message, error) {
+	return NewMessageBuilder(RefreshRequest).
+		Lifetime(lifetime).
+		Authenticated().
+		Fingerprint().
+		Build()
+}
+
+// NewMobilityRefreshRequest creates a Refresh request presenting a mobility
+// ticket, which moves the allocation it was issued for to the 5-tuple the
+// request is sent from, per RFC 8016.
+func NewMobilityRefreshRequest(ticket []byte) (*common.Message, error) {
+	return NewMessageBuilder(RefreshRequest).
+		MobilityTicket(ticket).
+		Authenticated().
+		Fingerprint().
+		Build()
 }
 
 // NewPermissionRequest creates a message requesting permission from the server
 // to allow sending and receiving data with a remote Address.
 func NewPermissionRequest(to net.Addr) (*common.Message, error) {
-	message, err := newMsg(CreatePermissionRequest)
-
-	message.Attributes = []common.Attribute{&stun.NonceAttribute{},
-		&stun.UsernameAttribute{},
-		&stun.RealmAttribute{},
-		&stun.MessageIntegrityAttribute{},
-		&stun.FingerprintAttribute{}}
-
-	turn.AddXorPeerAddressAttribute(message, to)
-
-	return message, err
+	return NewMessageBuilder(CreatePermissionRequest).
+		XorPeerAddress(to).
+		Authenticated().
+		Fingerprint().
+		Build()
 }
 
 // NewConnectRequest creates a message representing a request to create a new
 // TCP connection for exchanging data with a remote address, Per RFC 6062.
 func NewConnectRequest(to net.Addr) (*common.Message, error) {
-	message, err := newMsg(ConnectRequest)
-
-	message.Attributes = []common.Attribute{
-		&stun.NonceAttribute{},
-		&stun.UsernameAttribute{},
-		&stun.RealmAttribute{},
-		&stun.MessageIntegrityAttribute{},
-		&stun.FingerprintAttribute{}}
-
-	turn.AddXorPeerAddressAttribute(message, to)
-
-	return message, err
+	return NewMessageBuilder(ConnectRequest).
+		XorPeerAddress(to).
+		Authenticated().
+		Fingerprint().
+		Build()
 }
 
 // NewConnectionBindRequest creates a message representing a request to turn
 // the current connection with the server into a TCP connection relayed to a
 // remote peer specified by a previously generated ConnectionID. Per RFC 6062.
 func NewConnectionBindRequest(connectionID uint32) (*common.Message, error) {
-	message, err := newMsg(ConnectionBindRequest)
-
-	message.Attributes = []common.Attribute{
-		&turn.ConnectionIdAttribute{connectionID},
-		&stun.NonceAttribute{},
-		&stun.UsernameAttribute{},
-		&stun.RealmAttribute{},
-		&stun.MessageIntegrityAttribute{},
-		&stun.FingerprintAttribute{},
-	}
-	return message, err
+	return NewMessageBuilder(ConnectionBindRequest).
+		ConnectionId(connectionID).
+		Authenticated().
+		Fingerprint().
+		Build()
 }
 
 // NewSendIndication creates a message representing a request to send a message
 // of data over an existing allocation.
 func NewSendIndication(host net.IP, port uint16, data []byte) (*common.Message, error) {
-	message, err := newMsg(SendIndication)
-
-	family := uint16(1)
-	if host.To4() == nil {
-		family = 2
-	}
-	message.Attributes = []common.Attribute{&turn.XorPeerAddressAttribute{family, port, host},
-		&turn.DataAttribute{data}}
-
-	return message, err
+	return NewMessageBuilder(SendIndication).
+		XorPeerAddress(&net.UDPAddr{IP: host, Port: int(port)}).
+		Data(data).
+		Build()
 }
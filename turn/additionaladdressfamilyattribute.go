@@ -0,0 +1,71 @@
+package turn
+
+import (
+	"errors"
+	"github.com/willscott/goturn/common"
+)
+
+const (
+	AdditionalAddressFamily stun.AttributeType = 0x8000
+)
+
+// AdditionalAddressFamilyAttribute asks for an IPv6 relayed address in addition
+// to the default IPv4 one, making a dual-stack allocation per RFC 8656. IPv6 is
+// the only family it may carry.
+type AdditionalAddressFamilyAttribute struct {
+	Family uint16
+}
+
+func NewAdditionalAddressFamilyAttribute() stun.Attribute {
+	return stun.Attribute(new(AdditionalAddressFamilyAttribute))
+}
+
+func (h *AdditionalAddressFamilyAttribute) Type() stun.AttributeType {
+	return AdditionalAddressFamily
+}
+
+func (h *AdditionalAddressFamilyAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *AdditionalAddressFamilyAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return append(b, uint8(h.Family), 0, 0, 0), nil
+}
+
+func (h *AdditionalAddressFamilyAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if length != 4 || uint16(len(data)) < length {
+		return errors.New("Truncated AdditionalAddressFamily Attribute")
+	}
+	h.Family = uint16(data[0])
+	return nil
+}
+
+func (h *AdditionalAddressFamilyAttribute) Length(_ *stun.Message) uint16 {
+	return 4
+}
+
+// GetAdditionalAddressFamily provides the family in the
+// ADDITIONAL-ADDRESS-FAMILY attribute of msg, if it has one.
+func GetAdditionalAddressFamily(msg *stun.Message) (uint16, bool) {
+	if attr := msg.GetAttribute(AdditionalAddressFamily); attr != nil {
+		if family, ok := (*attr).(*AdditionalAddressFamilyAttribute); ok {
+			return family.Family, true
+		}
+	}
+	return 0, false
+}
+
+func (h *AdditionalAddressFamilyAttribute) String() string {
+	return formatFamily(h.Family)
+}
+
+// MarshalJSON encodes the family of the attribute as "IPv4" or "IPv6".
+func (h *AdditionalAddressFamilyAttribute) MarshalJSON() ([]byte, error) {
+	return marshalFamily(h.Family)
+}
+
+func (h *AdditionalAddressFamilyAttribute) UnmarshalJSON(data []byte) (err error) {
+	h.Family, err = unmarshalFamily(data)
+	return err
+}
@@ -0,0 +1,115 @@
+package turn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/willscott/goturn/common"
+)
+
+const (
+	AddressErrorCode stun.AttributeType = 0x8001
+)
+
+// AddressErrorCodeAttribute is present in the success response to a dual-stack
+// Allocate request when the relayed address of one family could not be
+// allocated, per RFC 8656. It explains the failure like an ERROR-CODE.
+type AddressErrorCodeAttribute struct {
+	Family uint16
+	Class  uint8
+	Number uint8
+	Phrase string
+}
+
+func (h AddressErrorCodeAttribute) String() string {
+	return fmt.Sprintf("%d: %s", int(h.Class)*100+int(h.Number), h.Phrase)
+}
+
+func NewAddressErrorCodeAttribute() stun.Attribute {
+	return stun.Attribute(new(AddressErrorCodeAttribute))
+}
+
+func (h *AddressErrorCodeAttribute) Type() stun.AttributeType {
+	return AddressErrorCode
+}
+
+func (h *AddressErrorCodeAttribute) Error() int {
+	return int(h.Class)*100 + int(h.Number)
+}
+
+func (h *AddressErrorCodeAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *AddressErrorCodeAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	b = append(b, uint8(h.Family), 0, h.Class, h.Number)
+	return append(b, h.Phrase...), nil
+}
+
+func (h *AddressErrorCodeAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if len(data) < 4 || uint16(len(data)) < length || length < 4 {
+		return errors.New("Truncated AddressErrorCode Attribute")
+	}
+	h.Family = uint16(data[0])
+	h.Class = uint8(data[2]) & 0x7
+	if h.Class < 3 || h.Class > 6 {
+		return errors.New("Invalid Address Error Code Class")
+	}
+	h.Number = uint8(data[3])
+	if h.Number > 99 {
+		return errors.New("Invalid Address Error Code Number")
+	}
+	h.Phrase = string(data[4:length])
+	return nil
+}
+
+func (h *AddressErrorCodeAttribute) Length(_ *stun.Message) uint16 {
+	return uint16(4 + len(h.Phrase))
+}
+
+// GetAddressErrorCodes provides every ADDRESS-ERROR-CODE attribute of msg, one
+// for each address family which could not be allocated.
+func GetAddressErrorCodes(msg *stun.Message) []*AddressErrorCodeAttribute {
+	var codes []*AddressErrorCodeAttribute
+	for _, attr := range msg.GetAttributes(AddressErrorCode) {
+		if code, ok := attr.(*AddressErrorCodeAttribute); ok {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// addressErrorCodeJSON is the JSON representation of an
+// AddressErrorCodeAttribute.
+type addressErrorCodeJSON struct {
+	Family json.RawMessage `json:"family"`
+	Code   int             `json:"code"`
+	Reason string          `json:"reason"`
+}
+
+// MarshalJSON encodes the attribute as an object, such as
+// {"family": "IPv6", "code": 440, "reason": "Address Family not Supported"}.
+func (h *AddressErrorCodeAttribute) MarshalJSON() ([]byte, error) {
+	family, err := marshalFamily(h.Family)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(addressErrorCodeJSON{family, h.Error(), h.Phrase})
+}
+
+func (h *AddressErrorCodeAttribute) UnmarshalJSON(data []byte) error {
+	var value addressErrorCodeJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value.Code < 300 || value.Code > 699 {
+		return errors.New("Invalid Error Code")
+	}
+	family, err := unmarshalFamily(value.Family)
+	if err != nil {
+		return err
+	}
+	h.Family, h.Class, h.Number, h.Phrase = family, uint8(value.Code/100), uint8(value.Code%100), value.Reason
+	return nil
+}
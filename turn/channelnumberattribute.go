@@ -1,9 +1,10 @@
 package turn
 
 import (
-	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/willscott/goturn/common"
 )
 
@@ -24,15 +25,13 @@ func (h *ChannelNumberAttribute) Type() stun.AttributeType {
 }
 
 func (h *ChannelNumberAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	err = binary.Write(buf, binary.BigEndian, h.ChannelNumber)
-	err = binary.Write(buf, binary.BigEndian, uint16(0))
+	return h.Append(nil, 0, msg)
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+func (h *ChannelNumberAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	b = binary.BigEndian.AppendUint16(b, h.ChannelNumber)
+	return binary.BigEndian.AppendUint16(b, 0), nil
 }
 
 func (h *ChannelNumberAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
@@ -46,3 +45,27 @@ func (h *ChannelNumberAttribute) Decode(data []byte, length uint16, _ *stun.Pars
 func (h *ChannelNumberAttribute) Length(_ *stun.Message) uint16 {
 	return 4
 }
+
+// GetChannelNumber provides the channel in the CHANNEL-NUMBER attribute of msg,
+// if it has one.
+func GetChannelNumber(msg *stun.Message) (uint16, bool) {
+	if attr := msg.GetAttribute(ChannelNumber); attr != nil {
+		if channel, ok := (*attr).(*ChannelNumberAttribute); ok {
+			return channel.ChannelNumber, true
+		}
+	}
+	return 0, false
+}
+
+func (h *ChannelNumberAttribute) String() string {
+	return fmt.Sprintf("0x%04x", h.ChannelNumber)
+}
+
+// MarshalJSON encodes the channel number as a number.
+func (h *ChannelNumberAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.ChannelNumber)
+}
+
+func (h *ChannelNumberAttribute) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.ChannelNumber)
+}
@@ -1,10 +1,11 @@
 package turn
 
 import (
-	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"github.com/willscott/goturn/common"
+	"strconv"
 )
 
 const (
@@ -24,18 +25,16 @@ func (h *ConnectionIdAttribute) Type() stun.AttributeType {
 }
 
 func (h *ConnectionIdAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	err = binary.Write(buf, binary.BigEndian, h.ConnectionId)
+	return h.Append(nil, 0, msg)
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+func (h *ConnectionIdAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return binary.BigEndian.AppendUint32(b, h.ConnectionId), nil
 }
 
 func (h *ConnectionIdAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
-	if uint16(len(data)) < length {
+	if length != 4 || uint16(len(data)) < length {
 		return errors.New("Truncated ConnectionID Attribute")
 	}
 	h.ConnectionId = binary.BigEndian.Uint32(data[0:4])
@@ -45,3 +44,27 @@ func (h *ConnectionIdAttribute) Decode(data []byte, length uint16, _ *stun.Parse
 func (h *ConnectionIdAttribute) Length(_ *stun.Message) uint16 {
 	return 4
 }
+
+// GetConnectionId provides the identifier in the CONNECTION-ID attribute of
+// msg, if it has one.
+func GetConnectionId(msg *stun.Message) (uint32, bool) {
+	if attr := msg.GetAttribute(ConnectionId); attr != nil {
+		if id, ok := (*attr).(*ConnectionIdAttribute); ok {
+			return id.ConnectionId, true
+		}
+	}
+	return 0, false
+}
+
+func (h *ConnectionIdAttribute) String() string {
+	return strconv.FormatUint(uint64(h.ConnectionId), 10)
+}
+
+// MarshalJSON encodes the connection id as a number.
+func (h *ConnectionIdAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.ConnectionId)
+}
+
+func (h *ConnectionIdAttribute) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.ConnectionId)
+}
@@ -1,8 +1,10 @@
 package turn
 
 import (
-	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/willscott/goturn/common"
 )
 
@@ -23,14 +25,12 @@ func (h *DataAttribute) Type() stun.AttributeType {
 }
 
 func (h *DataAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	buf.Write(h.Data)
+	return h.Append(nil, 0, msg)
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+func (h *DataAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return append(b, h.Data...), nil
 }
 
 func (h *DataAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
@@ -45,3 +45,37 @@ func (h *DataAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error
 func (h *DataAttribute) Length(_ *stun.Message) uint16 {
 	return uint16(len(h.Data))
 }
+
+// GetData provides the contents of the DATA attribute of msg, if it has one.
+func GetData(msg *stun.Message) ([]byte, bool) {
+	if attr := msg.GetAttribute(Data); attr != nil {
+		if data, ok := (*attr).(*DataAttribute); ok {
+			return data.Data, true
+		}
+	}
+	return nil, false
+}
+
+// String gives the size of the data rather than the data itself, which is
+// usually binary.
+func (h *DataAttribute) String() string {
+	return fmt.Sprintf("%d bytes", len(h.Data))
+}
+
+// MarshalJSON encodes the data as a hex string.
+func (h *DataAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h.Data))
+}
+
+func (h *DataAttribute) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	h.Data = decoded
+	return nil
+}
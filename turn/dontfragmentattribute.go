@@ -0,0 +1,55 @@
+package turn
+
+import (
+	"github.com/willscott/goturn/common"
+)
+
+const (
+	DontFragment stun.AttributeType = 0x1A
+)
+
+// DontFragmentAttribute asks a server to set the Don't Fragment bit on the
+// datagrams it relays to peers. In an Allocate request it applies to the whole
+// allocation, and in a Send indication to the datagram being sent. It has no
+// value.
+type DontFragmentAttribute struct {
+}
+
+func NewDontFragmentAttribute() stun.Attribute {
+	return stun.Attribute(new(DontFragmentAttribute))
+}
+
+func (h *DontFragmentAttribute) Type() stun.AttributeType {
+	return DontFragment
+}
+
+func (h *DontFragmentAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *DontFragmentAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	return stun.AppendAttributeHeader(b, h, msg), nil
+}
+
+func (h *DontFragmentAttribute) Decode(_ []byte, _ uint16, _ *stun.Parser) error {
+	return nil
+}
+
+func (h *DontFragmentAttribute) Length(_ *stun.Message) uint16 {
+	return 0
+}
+
+// HasDontFragment indicates whether msg has a DONT-FRAGMENT attribute.
+func HasDontFragment(msg *stun.Message) bool {
+	return msg.GetAttribute(DontFragment) != nil
+}
+
+// String is empty, as the attribute is a flag without a value.
+func (h *DontFragmentAttribute) String() string {
+	return ""
+}
+
+// MarshalJSON encodes the attribute as null, as it has no value.
+func (h *DontFragmentAttribute) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
@@ -0,0 +1,86 @@
+package turn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/willscott/goturn/common"
+)
+
+const (
+	EvenPort stun.AttributeType = 0x18
+)
+
+// EvenPortAttribute asks for a relayed address with an even port in an
+// Allocate request, as needed by RTP. When Reserve is set, the server also
+// reserves the next port for a later allocation, such as one for RTCP, and
+// identifies the reservation with a RESERVATION-TOKEN.
+type EvenPortAttribute struct {
+	Reserve bool
+}
+
+func NewEvenPortAttribute() stun.Attribute {
+	return stun.Attribute(new(EvenPortAttribute))
+}
+
+func (h *EvenPortAttribute) Type() stun.AttributeType {
+	return EvenPort
+}
+
+func (h *EvenPortAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *EvenPortAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	if h.Reserve {
+		return append(b, 0x80), nil
+	}
+	return append(b, 0), nil
+}
+
+func (h *EvenPortAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if length < 1 || uint16(len(data)) < length {
+		return errors.New("Truncated EvenPort Attribute")
+	}
+	h.Reserve = data[0]&0x80 != 0
+	return nil
+}
+
+func (h *EvenPortAttribute) Length(_ *stun.Message) uint16 {
+	return 1
+}
+
+// GetEvenPort provides whether msg has an EVEN-PORT attribute, and whether it
+// asks for the next port to be reserved.
+func GetEvenPort(msg *stun.Message) (reserve bool, ok bool) {
+	if attr := msg.GetAttribute(EvenPort); attr != nil {
+		if even, ok := (*attr).(*EvenPortAttribute); ok {
+			return even.Reserve, true
+		}
+	}
+	return false, false
+}
+
+func (h *EvenPortAttribute) String() string {
+	return fmt.Sprintf("reserve=%t", h.Reserve)
+}
+
+// evenPortJSON is the JSON representation of an EvenPortAttribute.
+type evenPortJSON struct {
+	Reserve bool `json:"reserve"`
+}
+
+// MarshalJSON encodes the attribute as an object, such as {"reserve": true}.
+func (h *EvenPortAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(evenPortJSON{h.Reserve})
+}
+
+func (h *EvenPortAttribute) UnmarshalJSON(data []byte) error {
+	var value evenPortJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	h.Reserve = value.Reserve
+	return nil
+}
@@ -0,0 +1,69 @@
+package turn
+
+import (
+	"encoding/json"
+	"fmt"
+	common "github.com/willscott/goturn/common"
+)
+
+func init() {
+	for t, constructor := range TurnAttributes {
+		common.RegisterAttribute(t, attributeNames[t], constructor)
+	}
+}
+
+// attributeNames are the names of the attributes of the package, as given by
+// the RFCs defining them.
+var attributeNames = map[common.AttributeType]string{
+	AdditionalAddressFamily: "ADDITIONAL-ADDRESS-FAMILY",
+	AddressErrorCode:        "ADDRESS-ERROR-CODE",
+	ChannelNumber:           "CHANNEL-NUMBER",
+	ConnectionId:            "CONNECTION-ID",
+	Data:                    "DATA",
+	DontFragment:            "DONT-FRAGMENT",
+	EvenPort:                "EVEN-PORT",
+	Lifetime:                "LIFETIME",
+	MobilityTicket:          "MOBILITY-TICKET",
+	RequestedAddressFamily:  "REQUESTED-ADDRESS-FAMILY",
+	RequestedTransport:      "REQUESTED-TRANSPORT",
+	ReservationToken:        "RESERVATION-TOKEN",
+	XorPeerAddress:          "XOR-PEER-ADDRESS",
+	XorRelayedAddress:       "XOR-RELAYED-ADDRESS",
+}
+
+// formatFamily names an address family, such as "IPv4".
+func formatFamily(family uint16) string {
+	switch family {
+	case FamilyIPv4:
+		return "IPv4"
+	case FamilyIPv6:
+		return "IPv6"
+	}
+	return fmt.Sprintf("0x%02x", family)
+}
+
+// marshalFamily encodes an address family as its name if it has one, and as
+// a number otherwise.
+func marshalFamily(family uint16) ([]byte, error) {
+	if family == FamilyIPv4 || family == FamilyIPv6 {
+		return json.Marshal(formatFamily(family))
+	}
+	return json.Marshal(family)
+}
+
+// unmarshalFamily decodes an address family encoded by marshalFamily.
+func unmarshalFamily(data []byte) (uint16, error) {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var family uint16
+		err = json.Unmarshal(data, &family)
+		return family, err
+	}
+	switch name {
+	case "IPv4":
+		return FamilyIPv4, nil
+	case "IPv6":
+		return FamilyIPv6, nil
+	}
+	return 0, fmt.Errorf("Unknown address family %q", name)
+}
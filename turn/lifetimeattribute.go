@@ -1,10 +1,11 @@
 package turn
 
 import (
-	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"github.com/willscott/goturn/common"
+	"time"
 )
 
 const (
@@ -24,18 +25,16 @@ func (h *LifetimeAttribute) Type() stun.AttributeType {
 }
 
 func (h *LifetimeAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	err = binary.Write(buf, binary.BigEndian, h.Lifetime)
+	return h.Append(nil, 0, msg)
+}
 
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+func (h *LifetimeAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return binary.BigEndian.AppendUint32(b, h.Lifetime), nil
 }
 
 func (h *LifetimeAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
-	if uint16(len(data)) < length {
+	if length != 4 || uint16(len(data)) < length {
 		return errors.New("Truncated Lifetime Attribute")
 	}
 	h.Lifetime = binary.BigEndian.Uint32(data[0:4])
@@ -45,3 +44,27 @@ func (h *LifetimeAttribute) Decode(data []byte, length uint16, _ *stun.Parser) e
 func (h *LifetimeAttribute) Length(_ *stun.Message) uint16 {
 	return 4
 }
+
+// GetLifetime provides the duration in the LIFETIME attribute of msg, if it has
+// one.
+func GetLifetime(msg *stun.Message) (time.Duration, bool) {
+	if attr := msg.GetAttribute(Lifetime); attr != nil {
+		if lifetime, ok := (*attr).(*LifetimeAttribute); ok {
+			return time.Duration(lifetime.Lifetime) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+func (h *LifetimeAttribute) String() string {
+	return (time.Duration(h.Lifetime) * time.Second).String()
+}
+
+// MarshalJSON encodes the lifetime as a number of seconds.
+func (h *LifetimeAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.Lifetime)
+}
+
+func (h *LifetimeAttribute) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &h.Lifetime)
+}
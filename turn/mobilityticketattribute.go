@@ -0,0 +1,83 @@
+package turn
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/willscott/goturn/common"
+)
+
+const (
+	MobilityTicket stun.AttributeType = 0x8030
+)
+
+// MobilityTicketAttribute carries the opaque ticket with which an allocation
+// can be moved to a new 5-tuple when the address of a client changes, per
+// RFC 8016. An empty ticket in an Allocate request asks the server for one, and
+// a ticket in a Refresh request from a new 5-tuple moves the allocation there.
+type MobilityTicketAttribute struct {
+	Ticket []byte
+}
+
+func NewMobilityTicketAttribute() stun.Attribute {
+	return stun.Attribute(new(MobilityTicketAttribute))
+}
+
+func (h *MobilityTicketAttribute) Type() stun.AttributeType {
+	return MobilityTicket
+}
+
+func (h *MobilityTicketAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *MobilityTicketAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return append(b, h.Ticket...), nil
+}
+
+func (h *MobilityTicketAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if uint16(len(data)) < length {
+		return errors.New("Truncated MobilityTicket Attribute")
+	}
+	h.Ticket = make([]byte, length)
+	copy(h.Ticket, data[0:length])
+	return nil
+}
+
+func (h *MobilityTicketAttribute) Length(_ *stun.Message) uint16 {
+	return uint16(len(h.Ticket))
+}
+
+// GetMobilityTicket provides the ticket in the MOBILITY-TICKET attribute of
+// msg, if it has one. The ticket is empty when a client is asking for one.
+func GetMobilityTicket(msg *stun.Message) ([]byte, bool) {
+	if attr := msg.GetAttribute(MobilityTicket); attr != nil {
+		if ticket, ok := (*attr).(*MobilityTicketAttribute); ok {
+			return ticket.Ticket, true
+		}
+	}
+	return nil, false
+}
+
+func (h *MobilityTicketAttribute) String() string {
+	return hex.EncodeToString(h.Ticket)
+}
+
+// MarshalJSON encodes the ticket as a hex string.
+func (h *MobilityTicketAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h.Ticket))
+}
+
+func (h *MobilityTicketAttribute) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	ticket, err := hex.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	h.Ticket = ticket
+	return nil
+}
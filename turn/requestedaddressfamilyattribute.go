@@ -0,0 +1,78 @@
+package turn
+
+import (
+	"errors"
+	"github.com/willscott/goturn/common"
+)
+
+const (
+	RequestedAddressFamily stun.AttributeType = 0x17
+)
+
+// Address families of the REQUESTED-ADDRESS-FAMILY, ADDITIONAL-ADDRESS-FAMILY
+// and ADDRESS-ERROR-CODE attributes, matching the families of STUN addresses.
+const (
+	FamilyIPv4 uint16 = 0x01
+	FamilyIPv6 uint16 = 0x02
+)
+
+// RequestedAddressFamilyAttribute asks for a relayed address of a specific
+// address family in an Allocate request, per RFC 6156. Servers relay IPv4 by
+// default.
+type RequestedAddressFamilyAttribute struct {
+	Family uint16
+}
+
+func NewRequestedAddressFamilyAttribute() stun.Attribute {
+	return stun.Attribute(new(RequestedAddressFamilyAttribute))
+}
+
+func (h *RequestedAddressFamilyAttribute) Type() stun.AttributeType {
+	return RequestedAddressFamily
+}
+
+func (h *RequestedAddressFamilyAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *RequestedAddressFamilyAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return append(b, uint8(h.Family), 0, 0, 0), nil
+}
+
+func (h *RequestedAddressFamilyAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if length != 4 || uint16(len(data)) < length {
+		return errors.New("Truncated RequestedAddressFamily Attribute")
+	}
+	h.Family = uint16(data[0])
+	return nil
+}
+
+func (h *RequestedAddressFamilyAttribute) Length(_ *stun.Message) uint16 {
+	return 4
+}
+
+// GetRequestedAddressFamily provides the family in the REQUESTED-ADDRESS-FAMILY
+// attribute of msg, if it has one.
+func GetRequestedAddressFamily(msg *stun.Message) (uint16, bool) {
+	if attr := msg.GetAttribute(RequestedAddressFamily); attr != nil {
+		if family, ok := (*attr).(*RequestedAddressFamilyAttribute); ok {
+			return family.Family, true
+		}
+	}
+	return 0, false
+}
+
+func (h *RequestedAddressFamilyAttribute) String() string {
+	return formatFamily(h.Family)
+}
+
+// MarshalJSON encodes the family of the attribute as "IPv4" or "IPv6".
+func (h *RequestedAddressFamilyAttribute) MarshalJSON() ([]byte, error) {
+	return marshalFamily(h.Family)
+}
+
+func (h *RequestedAddressFamilyAttribute) UnmarshalJSON(data []byte) (err error) {
+	h.Family, err = unmarshalFamily(data)
+	return err
+}
@@ -1,10 +1,10 @@
 package turn
 
 import (
-	"bytes"
-	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"github.com/willscott/goturn/common"
+	"strconv"
 )
 
 const (
@@ -24,29 +24,72 @@ func (h *RequestedTransportAttribute) Type() stun.AttributeType {
 }
 
 func (h *RequestedTransportAttribute) Encode(msg *stun.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	err := stun.WriteAttributeHeader(buf, stun.Attribute(h), msg)
-	err = binary.Write(buf, binary.BigEndian, h.Transport)
-	err = binary.Write(buf, binary.BigEndian, uint16(0))
-	err = binary.Write(buf, binary.BigEndian, uint8(0))
-
-	if err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
+	return h.Append(nil, 0, msg)
+}
+
+func (h *RequestedTransportAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return append(b, h.Transport, 0, 0, 0), nil
 }
 
 func (h *RequestedTransportAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
 	if length != 4 || uint16(len(data)) < length {
 		return errors.New("Truncated RequestedTransport Attribute")
 	}
+	// Unsupported transports are left for servers to reject with a 442.
 	h.Transport = uint8(data[0])
-	if h.Transport != 17 {
-		return errors.New("RequestedTransport Attribute was not UDP as expected.")
-	}
 	return nil
 }
 
 func (h *RequestedTransportAttribute) Length(_ *stun.Message) uint16 {
 	return 4
 }
+
+// GetRequestedTransport provides the IANA protocol number in the
+// REQUESTED-TRANSPORT attribute of msg, if it has one.
+func GetRequestedTransport(msg *stun.Message) (uint8, bool) {
+	if attr := msg.GetAttribute(RequestedTransport); attr != nil {
+		if transport, ok := (*attr).(*RequestedTransportAttribute); ok {
+			return transport.Transport, true
+		}
+	}
+	return 0, false
+}
+
+// Names of the transports of the REQUESTED-TRANSPORT attribute.
+var transportNames = map[uint8]string{
+	6:  "tcp",
+	17: "udp",
+}
+
+// String names the transport, such as "udp", or gives its protocol number
+// if it has no name.
+func (h *RequestedTransportAttribute) String() string {
+	if name, ok := transportNames[h.Transport]; ok {
+		return name
+	}
+	return strconv.Itoa(int(h.Transport))
+}
+
+// MarshalJSON encodes the transport as its name, such as "udp", or as its
+// protocol number if it has no name.
+func (h *RequestedTransportAttribute) MarshalJSON() ([]byte, error) {
+	if name, ok := transportNames[h.Transport]; ok {
+		return json.Marshal(name)
+	}
+	return json.Marshal(h.Transport)
+}
+
+func (h *RequestedTransportAttribute) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return json.Unmarshal(data, &h.Transport)
+	}
+	for transport, transportName := range transportNames {
+		if name == transportName {
+			h.Transport = transport
+			return nil
+		}
+	}
+	return errors.New("Unknown transport " + name)
+}
@@ -0,0 +1,87 @@
+package turn
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/willscott/goturn/common"
+)
+
+const (
+	ReservationToken stun.AttributeType = 0x22
+)
+
+// ReservationTokenAttribute identifies a relayed port reserved by a server. It
+// is returned in the response to an Allocate request with EVEN-PORT, and
+// presented in a later Allocate request to claim the reserved port.
+type ReservationTokenAttribute struct {
+	Token uint64
+}
+
+func NewReservationTokenAttribute() stun.Attribute {
+	return stun.Attribute(new(ReservationTokenAttribute))
+}
+
+func (h *ReservationTokenAttribute) Type() stun.AttributeType {
+	return ReservationToken
+}
+
+func (h *ReservationTokenAttribute) Encode(msg *stun.Message) ([]byte, error) {
+	return h.Append(nil, 0, msg)
+}
+
+func (h *ReservationTokenAttribute) Append(b []byte, _ int, msg *stun.Message) ([]byte, error) {
+	b = stun.AppendAttributeHeader(b, h, msg)
+	return binary.BigEndian.AppendUint64(b, h.Token), nil
+}
+
+func (h *ReservationTokenAttribute) Decode(data []byte, length uint16, _ *stun.Parser) error {
+	if length != 8 || uint16(len(data)) < length {
+		return errors.New("Truncated ReservationToken Attribute")
+	}
+	h.Token = binary.BigEndian.Uint64(data[0:8])
+	return nil
+}
+
+func (h *ReservationTokenAttribute) Length(_ *stun.Message) uint16 {
+	return 8
+}
+
+// GetReservationToken provides the token in the RESERVATION-TOKEN attribute of
+// msg, if it has one.
+func GetReservationToken(msg *stun.Message) (uint64, bool) {
+	if attr := msg.GetAttribute(ReservationToken); attr != nil {
+		if token, ok := (*attr).(*ReservationTokenAttribute); ok {
+			return token.Token, true
+		}
+	}
+	return 0, false
+}
+
+func (h *ReservationTokenAttribute) String() string {
+	return fmt.Sprintf("%016x", h.Token)
+}
+
+// MarshalJSON encodes the token as a hex string, as it is opaque and
+// exceeds the precision of JSON numbers.
+func (h *ReservationTokenAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.String())
+}
+
+func (h *ReservationTokenAttribute) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	token, err := hex.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	if len(token) != 8 {
+		return errors.New("Invalid Reservation Token")
+	}
+	h.Token = binary.BigEndian.Uint64(token)
+	return nil
+}
@@ -8,16 +8,31 @@ import (
 
 var (
 	TurnAttributes = common.AttributeSet{
-		ChannelNumber:      NewChannelNumberAttribute,
-		ConnectionId:       NewConnectionIdAttribute,
-		Data:               NewDataAttribute,
-		Lifetime:           NewLifetimeAttribute,
-		RequestedTransport: NewRequestedTransportAttribute,
-		XorPeerAddress:     NewXorPeerAddressAttribute,
-		XorRelayedAddress:  NewXorRelayedAddressAttribute,
+		AdditionalAddressFamily: NewAdditionalAddressFamilyAttribute,
+		AddressErrorCode:        NewAddressErrorCodeAttribute,
+		ChannelNumber:           NewChannelNumberAttribute,
+		ConnectionId:            NewConnectionIdAttribute,
+		Data:                    NewDataAttribute,
+		DontFragment:            NewDontFragmentAttribute,
+		EvenPort:                NewEvenPortAttribute,
+		Lifetime:                NewLifetimeAttribute,
+		MobilityTicket:          NewMobilityTicketAttribute,
+		RequestedAddressFamily:  NewRequestedAddressFamilyAttribute,
+		RequestedTransport:      NewRequestedTransportAttribute,
+		ReservationToken:        NewReservationTokenAttribute,
+		XorPeerAddress:          NewXorPeerAddressAttribute,
+		XorRelayedAddress:       NewXorRelayedAddressAttribute,
 	}
 )
 
+func init() {
+	// Dual-stack allocations have a relayed address and an address error for
+	// each family, and permissions may be created for several peers at once.
+	common.RegisterRepeatable(XorRelayedAddress)
+	common.RegisterRepeatable(XorPeerAddress)
+	common.RegisterRepeatable(AddressErrorCode)
+}
+
 // Registry of stun and turn attributes as the default set to work with when
 // decoding messages.
 func AttributeSet() common.AttributeSet {
@@ -25,6 +40,12 @@ func AttributeSet() common.AttributeSet {
 	for key, value := range stun.StunAttributes {
 		set[key] = value
 	}
+	for key, value := range stun.BehaviorAttributes {
+		set[key] = value
+	}
+	for key, value := range stun.IceAttributes {
+		set[key] = value
+	}
 	for key, value := range TurnAttributes {
 		set[key] = value
 	}
@@ -1,7 +1,6 @@
 package turn
 
 import (
-	"bytes"
 	"fmt"
 	common "github.com/willscott/goturn/common"
 	"github.com/willscott/goturn/stun"
@@ -40,17 +39,13 @@ func (h *XorPeerAddressAttribute) Type() common.AttributeType {
 }
 
 func (h *XorPeerAddressAttribute) Encode(msg *common.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	if err := common.WriteAttributeHeader(buf, common.Attribute(h), msg); err != nil {
-		return nil, err
-	}
+	return h.Append(nil, 0, msg)
+}
+
+func (h *XorPeerAddressAttribute) Append(b []byte, _ int, msg *common.Message) ([]byte, error) {
+	b = common.AppendAttributeHeader(b, h, msg)
 	mapped := stun.XorMappedAddressAttribute(*h)
-	bytes, err := stun.XorAddressData(&mapped, msg)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(bytes)
-	return buf.Bytes(), nil
+	return stun.AppendXorAddress(b, &mapped, msg)
 }
 
 func (h *XorPeerAddressAttribute) Decode(data []byte, length uint16, p *common.Parser) error {
@@ -71,3 +66,36 @@ func (h *XorPeerAddressAttribute) Length(_ *common.Message) uint16 {
 		return 20
 	}
 }
+
+// GetXorPeerAddress provides the address in the XOR-PEER-ADDRESS attribute of
+// msg, if it has one.
+func GetXorPeerAddress(msg *common.Message) (net.UDPAddr, bool) {
+	if attr := msg.GetAttribute(XorPeerAddress); attr != nil {
+		if addr, ok := (*attr).(*XorPeerAddressAttribute); ok {
+			return net.UDPAddr{IP: addr.Address, Port: int(addr.Port)}, true
+		}
+	}
+	return net.UDPAddr{}, false
+}
+
+// GetXorPeerAddresses provides the addresses in every XOR-PEER-ADDRESS
+// attribute of msg. A CreatePermission request may include several.
+func GetXorPeerAddresses(msg *common.Message) []net.UDPAddr {
+	var addrs []net.UDPAddr
+	for _, attr := range msg.GetAttributes(XorPeerAddress) {
+		if addr, ok := attr.(*XorPeerAddressAttribute); ok {
+			addrs = append(addrs, net.UDPAddr{IP: addr.Address, Port: int(addr.Port)})
+		}
+	}
+	return addrs
+}
+
+// MarshalJSON encodes the address of the attribute as a string, such as
+// "192.0.2.1:49152", without XOR'ing it.
+func (h *XorPeerAddressAttribute) MarshalJSON() ([]byte, error) {
+	return (*stun.XorMappedAddressAttribute)(h).MarshalJSON()
+}
+
+func (h *XorPeerAddressAttribute) UnmarshalJSON(data []byte) error {
+	return (*stun.XorMappedAddressAttribute)(h).UnmarshalJSON(data)
+}
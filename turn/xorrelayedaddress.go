@@ -1,7 +1,6 @@
 package turn
 
 import (
-	"bytes"
 	"fmt"
 	common "github.com/willscott/goturn/common"
 	"github.com/willscott/goturn/stun"
@@ -31,17 +30,13 @@ func (x *XorRelayedAddressAttribute) Type() common.AttributeType {
 }
 
 func (h *XorRelayedAddressAttribute) Encode(msg *common.Message) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	if err := common.WriteAttributeHeader(buf, common.Attribute(h), msg); err != nil {
-		return nil, err
-	}
+	return h.Append(nil, 0, msg)
+}
+
+func (h *XorRelayedAddressAttribute) Append(b []byte, _ int, msg *common.Message) ([]byte, error) {
+	b = common.AppendAttributeHeader(b, h, msg)
 	mapped := stun.XorMappedAddressAttribute(*h)
-	bytes, err := stun.XorAddressData(&mapped, msg)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(bytes)
-	return buf.Bytes(), nil
+	return stun.AppendXorAddress(b, &mapped, msg)
 }
 
 func (h *XorRelayedAddressAttribute) Decode(data []byte, length uint16, p *common.Parser) error {
@@ -62,3 +57,36 @@ func (h *XorRelayedAddressAttribute) Length(_ *common.Message) uint16 {
 		return 20
 	}
 }
+
+// GetXorRelayedAddress provides the address in the XOR-RELAYED-ADDRESS
+// attribute of msg, if it has one.
+func GetXorRelayedAddress(msg *common.Message) (net.UDPAddr, bool) {
+	if attr := msg.GetAttribute(XorRelayedAddress); attr != nil {
+		if addr, ok := (*attr).(*XorRelayedAddressAttribute); ok {
+			return net.UDPAddr{IP: addr.Address, Port: int(addr.Port)}, true
+		}
+	}
+	return net.UDPAddr{}, false
+}
+
+// GetXorRelayedAddresses provides the addresses in every XOR-RELAYED-ADDRESS
+// attribute of msg. A dual-stack allocation has one for each address family.
+func GetXorRelayedAddresses(msg *common.Message) []net.UDPAddr {
+	var addrs []net.UDPAddr
+	for _, attr := range msg.GetAttributes(XorRelayedAddress) {
+		if addr, ok := attr.(*XorRelayedAddressAttribute); ok {
+			addrs = append(addrs, net.UDPAddr{IP: addr.Address, Port: int(addr.Port)})
+		}
+	}
+	return addrs
+}
+
+// MarshalJSON encodes the address of the attribute as a string, such as
+// "192.0.2.15:50000", without XOR'ing it.
+func (h *XorRelayedAddressAttribute) MarshalJSON() ([]byte, error) {
+	return (*stun.XorMappedAddressAttribute)(h).MarshalJSON()
+}
+
+func (h *XorRelayedAddressAttribute) UnmarshalJSON(data []byte) error {
+	return (*stun.XorMappedAddressAttribute)(h).UnmarshalJSON(data)
+}
@@ -0,0 +1,238 @@
+package goturn
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/turn"
+)
+
+var benchCredentials = common.Credentials{
+	Nonce:    []byte("f8a0a3e1c6d2b5e4"),
+	Username: "user",
+	Realm:    "example.com",
+	Password: "password",
+}
+
+func benchAllocateRequest(b *testing.B) []byte {
+	msg, err := NewAllocateRequest("udp", true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	msg.Credentials = benchCredentials
+	data, err := msg.Serialize()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func benchSendIndication(b *testing.B) []byte {
+	msg, err := NewSendIndication(net.IPv4(192, 0, 2, 1), 5000, make([]byte, 1000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func BenchmarkParseTurn(b *testing.B) {
+	data := benchAllocateRequest(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseTurn(data, &benchCredentials); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParseTurnStrictMaxSize parses the largest message which fits in a
+// UDP datagram, filled with as many distinct comprehension-optional attributes
+// as strict parsing accepts, to bound the cost of strict parsing of
+// unauthenticated requests.
+func BenchmarkParseTurnStrictMaxSize(b *testing.B) {
+	const length = (65507 - 20) &^ 3
+	const count = 128
+	const size = (length/count - 4) &^ 3
+	data := make([]byte, 20+length)
+	binary.BigEndian.PutUint16(data[0:], uint16(AllocateRequest))
+	binary.BigEndian.PutUint16(data[2:], length)
+	binary.BigEndian.PutUint32(data[4:], 0x2112A442)
+	for i := 0; i < count; i++ {
+		attr := data[20+i*(size+4):]
+		binary.BigEndian.PutUint16(attr, uint16(0xC000+i))
+		binary.BigEndian.PutUint16(attr[2:], size)
+	}
+	// The last attribute takes up the remainder of the message.
+	last := data[20+(count-1)*(size+4):]
+	binary.BigEndian.PutUint16(last[2:], uint16(len(last)-4))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseTurnStrict(data, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseInto(b *testing.B) {
+	data := benchAllocateRequest(b)
+	attrs := turn.AttributeSet()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := common.AcquireMessage()
+		if err := common.ParseInto(m, data, &benchCredentials, attrs); err != nil {
+			b.Fatal(err)
+		}
+		common.ReleaseMessage(m)
+	}
+}
+
+func BenchmarkParseIntoSendIndication(b *testing.B) {
+	data := benchSendIndication(b)
+	attrs := turn.AttributeSet()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := common.AcquireMessage()
+		if err := common.ParseInto(m, data, nil, attrs); err != nil {
+			b.Fatal(err)
+		}
+		for _, raw := range m.RawAttributes() {
+			if raw.Type == turn.Data && len(raw.Value) != 1000 {
+				b.Fatal("Unexpected data length")
+			}
+		}
+		common.ReleaseMessage(m)
+	}
+}
+
+func BenchmarkSerialize(b *testing.B) {
+	msg, err := NewAllocateRequest("udp", true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	msg.Credentials = benchCredentials
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := msg.Serialize(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSerializeSendIndication(b *testing.B) {
+	msg, err := NewSendIndication(net.IPv4(192, 0, 2, 1), 5000, make([]byte, 1000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := msg.Serialize(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendTo(b *testing.B) {
+	msg, err := NewAllocateRequest("udp", true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	msg.Credentials = benchCredentials
+	buf := make([]byte, 0, 1500)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := msg.AppendTo(buf[:0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMessageTypes(t *testing.T) {
+	cases := []struct {
+		htype  common.HeaderType
+		method common.Method
+		class  common.Class
+	}{
+		{BindingIndication, BindingMethod, common.ClassIndication},
+		{BindingError, BindingMethod, common.ClassError},
+		{AllocateResponse, AllocateMethod, common.ClassSuccess},
+		{ChannelBindRequest, ChannelBindMethod, common.ClassRequest},
+		{SendIndication, SendMethod, common.ClassIndication},
+		{DataIndication, DataMethod, common.ClassIndication},
+		{ConnectionAttemptIndication, ConnectionAttemptMethod, common.ClassIndication},
+		{ConnectionBindError, ConnectionBindMethod, common.ClassError},
+	}
+	for _, c := range cases {
+		if c.htype.Method() != c.method || c.htype.Class() != c.class {
+			t.Errorf("%s split as %s %s", c.htype, c.htype.Method(), c.htype.Class())
+		}
+	}
+	if RefreshRequest.ErrorResponse() != RefreshError {
+		t.Errorf("Unexpected error type %s for refresh", RefreshRequest.ErrorResponse())
+	}
+}
+
+func TestAllocateRequestFamilies(t *testing.T) {
+	msg, err := NewAllocateRequest("udp", false, turn.FamilyIPv6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if family, ok := turn.GetRequestedAddressFamily(msg); !ok || family != turn.FamilyIPv6 {
+		t.Errorf("Expected IPv6 to be requested, got %d", family)
+	}
+
+	msg, err = NewAllocateRequest("udp", false, turn.FamilyIPv4, turn.FamilyIPv6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := turn.GetRequestedAddressFamily(msg); ok {
+		t.Error("Dual-stack request should not have a requested family")
+	}
+	if family, ok := turn.GetAdditionalAddressFamily(msg); !ok || family != turn.FamilyIPv6 {
+		t.Errorf("Expected IPv6 as an additional family, got %d", family)
+	}
+
+	if _, err := NewAllocateRequest("udp", false, turn.FamilyIPv4, turn.FamilyIPv4); err == nil {
+		t.Error("Expected repeated families to be rejected")
+	}
+}
+
+func TestDualStackResponseRoundtrip(t *testing.T) {
+	request, _ := NewAllocateRequest("udp", false)
+	msg, err := NewResponseBuilder(request.Header).
+		XorRelayedAddress(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}).
+		XorRelayedAddress(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5001}).
+		AddressErrorCode(turn.FamilyIPv6, 440, "Address Family not Supported").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseTurnStrict(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := turn.GetXorRelayedAddresses(parsed)
+	if len(addrs) != 2 || addrs[0].Port != 5000 || !addrs[1].IP.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("Unexpected relayed addresses %v", addrs)
+	}
+	codes := turn.GetAddressErrorCodes(parsed)
+	if len(codes) != 1 || codes[0].Family != turn.FamilyIPv6 || codes[0].Error() != 440 {
+		t.Errorf("Unexpected address error codes %v", codes)
+	}
+}
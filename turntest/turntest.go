@@ -0,0 +1,285 @@
+// Package turntest provides a TURN server for tests of applications using
+// TURN, in the manner of net/http/httptest. The server runs in-process on the
+// loopback interface, relaying UDP, and records the messages it receives.
+// Failures can be injected into its responses to exercise the error handling
+// of clients.
+//
+//	ts := turntest.NewServer()
+//	defer ts.Close()
+//	ts.Inject(goturn.AllocateRequest, turntest.AllocationQuotaReached)
+//
+//	conn, _ := net.Dial("udp", ts.Addr.String())
+//	stunClient := &client.StunClient{Conn: conn}
+//	credentials := client.LongtermCredentials(turntest.Username, turntest.Password)
+//	if _, err := stunClient.Allocate(&credentials); err == nil {
+//	  t.Error("Allocation succeeded beyond the quota")
+//	}
+package turntest
+
+import (
+	"net"
+	"sync"
+
+	"github.com/willscott/goturn"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/server"
+	"github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/turn"
+)
+
+// The credentials of the user a server accepts unless it is given Users.
+const (
+	Username = "user"
+	Password = "pass"
+)
+
+// Fault is a failure injected into the handling of a request.
+type Fault int
+
+const (
+	// StaleNonce answers the request with a 438 error, carrying the nonce of
+	// the request as the new nonce, so that the retried request succeeds.
+	StaleNonce Fault = iota
+	// AllocationQuotaReached answers the request with a 486 error.
+	AllocationQuotaReached
+	// InsufficientCapacity answers the request with a 508 error.
+	InsufficientCapacity
+	// DropResponse handles the request, but drops the response to it.
+	DropResponse
+	// DropRequest drops the request without handling it.
+	DropRequest
+)
+
+// Received is a message received by a server.
+type Received struct {
+	// The address the message was sent from.
+	From net.Addr
+	// The message, or nil for ChannelData and data which could not be parsed.
+	Message *common.Message
+	// The message as it was received.
+	Data []byte
+}
+
+// Server is a TURN server listening on the loopback interface.
+type Server struct {
+	// The address of the server, for clients to dial over UDP.
+	Addr *net.UDPAddr
+	// The TURN server, which may be configured, such as with a Realm or
+	// MaxLifetime, before an unstarted server is started. Its Auth is provided
+	// from Users when unset, and its Peers permit every address, including
+	// the loopback, unless they are set.
+	Config *server.Server
+	// The passwords of the users accepted by the server, by username.
+	Users map[string]string
+
+	conn *recorder
+
+	lock     sync.Mutex
+	faults   map[common.HeaderType][]Fault
+	dropped  map[[12]byte]bool
+	received []Received
+}
+
+// NewServer starts a server which accepts the user Username with Password.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer creates a server listening on the loopback interface,
+// which must be configured and then started with Start. Close should be called
+// once it is no longer needed.
+func NewUnstartedServer() *Server {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		if conn, err = net.ListenPacket("udp6", "[::1]:0"); err != nil {
+			panic("turntest: failed to listen on a port: " + err.Error())
+		}
+	}
+	s := &Server{
+		Addr:    conn.LocalAddr().(*net.UDPAddr),
+		Config:  server.NewServer(),
+		Users:   map[string]string{Username: Password},
+		faults:  make(map[common.HeaderType][]Fault),
+		dropped: make(map[[12]byte]bool),
+	}
+	s.conn = &recorder{PacketConn: conn, server: s}
+	return s
+}
+
+// Start begins serving requests.
+func (s *Server) Start() {
+	if s.Config.Auth == nil {
+		s.Config.Auth = func(username string) (string, bool) {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			password, ok := s.Users[username]
+			return password, ok
+		}
+	}
+	if s.Config.Peers == nil {
+		everything, _ := server.ParseNetworks("0.0.0.0/0", "::/0")
+		s.Config.Peers = func(string) *server.PeerPolicy {
+			return &server.PeerPolicy{Allow: everything}
+		}
+	}
+	go s.Config.Serve(s.conn)
+}
+
+// Close stops the server, deleting its allocations.
+func (s *Server) Close() {
+	s.Config.Close()
+	s.conn.Close()
+}
+
+// Credentials provides the long-term credentials of a user of the server.
+func (s *Server) Credentials(username string) common.Credentials {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return common.Credentials{Username: username, Password: s.Users[username]}
+}
+
+// Inject makes the next request of a type fail with a fault. Faults injected
+// for the same type of request are applied to successive requests in order.
+func (s *Server) Inject(request common.HeaderType, fault Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults[request] = append(s.faults[request], fault)
+}
+
+// ClearFaults removes the faults which have not yet been applied.
+func (s *Server) ClearFaults() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = make(map[common.HeaderType][]Fault)
+}
+
+// Received provides the messages received by the server, in the order they
+// arrived, including those failed by injected faults.
+func (s *Server) Received() []Received {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Received{}, s.received...)
+}
+
+// ReceivedOf provides the messages of a type received by the server.
+func (s *Server) ReceivedOf(t common.HeaderType) []*common.Message {
+	var messages []*common.Message
+	for _, r := range s.Received() {
+		if r.Message != nil && r.Message.Header.Type == t {
+			messages = append(messages, r.Message)
+		}
+	}
+	return messages
+}
+
+// record notes a received message, and provides the fault to apply to it.
+func (s *Server) record(from net.Addr, data []byte) (*common.Message, Fault, bool) {
+	r := Received{From: from, Data: append([]byte{}, data...)}
+	if len(data) > 0 && data[0]&0xC0 == 0 {
+		// Integrity is not verified, since the password is not yet known.
+		attrs := turn.AttributeSet()
+		delete(attrs, stun.MessageIntegrity)
+		if msg, err := common.Parse(r.Data, nil, attrs); err == nil {
+			msg.DecodeAttributes()
+			r.Message = msg
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.received = append(s.received, r)
+	if r.Message == nil || r.Message.Header.Type.Class() != common.ClassRequest {
+		return r.Message, 0, false
+	}
+	faults := s.faults[r.Message.Header.Type]
+	if len(faults) == 0 {
+		return r.Message, 0, false
+	}
+	s.faults[r.Message.Header.Type] = faults[1:]
+	if faults[0] == DropResponse {
+		s.dropped[r.Message.Header.Id] = true
+	}
+	return r.Message, faults[0], true
+}
+
+// fail answers a request with the error of a fault. Errors other than 438
+// are signed when the user of the request is known, as the server would.
+func (s *Server) fail(from net.Addr, request *common.Message, fault Fault) {
+	var response *goturn.MessageBuilder
+	claimed := request.Credentials
+	switch fault {
+	case StaleNonce:
+		response = goturn.NewErrorResponseBuilder(request.Header, 438, "Stale Nonce").
+			Credentials(common.Credentials{Realm: claimed.Realm, Nonce: claimed.Nonce}).
+			Attribute(&stun.RealmAttribute{}).
+			Attribute(&stun.NonceAttribute{})
+	case AllocationQuotaReached:
+		response = goturn.NewErrorResponseBuilder(request.Header, 486, "Allocation Quota Reached")
+	case InsufficientCapacity:
+		response = goturn.NewErrorResponseBuilder(request.Header, 508, "Insufficient Capacity")
+	default:
+		return
+	}
+	if fault != StaleNonce && claimed.Username != "" {
+		s.lock.Lock()
+		password, ok := s.Users[claimed.Username]
+		s.lock.Unlock()
+		if ok {
+			claimed.Password = password
+			response.Credentials(claimed).Attribute(&stun.MessageIntegrityAttribute{})
+		}
+	}
+	msg, err := response.Fingerprint().Build()
+	if err != nil {
+		return
+	}
+	if data, err := msg.Serialize(); err == nil {
+		s.conn.PacketConn.WriteTo(data, from)
+	}
+}
+
+// suppressed indicates whether a response is dropped by a DropResponse fault.
+func (s *Server) suppressed(data []byte) bool {
+	if len(data) < 20 {
+		return false
+	}
+	var id [12]byte
+	copy(id[:], data[8:20])
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.dropped[id] {
+		delete(s.dropped, id)
+		return true
+	}
+	return false
+}
+
+// recorder is the socket of the server, which records the messages it
+// receives and applies faults to them.
+type recorder struct {
+	net.PacketConn
+	server *Server
+}
+
+func (r *recorder) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, from, err := r.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, from, err
+		}
+		request, fault, ok := r.server.record(from, b[0:n])
+		if !ok || fault == DropResponse {
+			return n, from, nil
+		}
+		r.server.fail(from, request, fault)
+	}
+}
+
+func (r *recorder) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if r.server.suppressed(b) {
+		return len(b), nil
+	}
+	return r.PacketConn.WriteTo(b, addr)
+}
@@ -0,0 +1,98 @@
+package turntest
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/client"
+	"github.com/willscott/goturn/stun"
+)
+
+// dial connects a client to a server.
+func dial(t *testing.T, ts *Server) *client.StunClient {
+	conn, err := net.Dial("udp", ts.Addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client.StunClient{Conn: conn, Timeout: time.Second}
+}
+
+func TestRecordsMessages(t *testing.T) {
+	ts := NewUnstartedServer()
+	ts.Config.Realm = "example.org"
+	ts.Users = map[string]string{"alice": "secret"}
+	ts.Start()
+	defer ts.Close()
+
+	credentials := ts.Credentials("alice")
+	if _, err := dial(t, ts).Allocate(&credentials); err != nil {
+		t.Fatal(err)
+	}
+	if string(credentials.Realm) != "example.org" {
+		t.Errorf("Unexpected realm %q", credentials.Realm)
+	}
+
+	// The first request is challenged for credentials.
+	requests := ts.ReceivedOf(goturn.AllocateRequest)
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 Allocate requests, received %d", len(requests))
+	}
+	if username, ok := stun.GetUsername(requests[1]); !ok || username != "alice" {
+		t.Errorf("Unexpected username %q", username)
+	}
+	if received := ts.Received(); len(received) != 2 || received[0].From == nil || len(received[0].Data) == 0 {
+		t.Errorf("Unexpected received messages %v", received)
+	}
+}
+
+func TestInjectErrors(t *testing.T) {
+	ts := NewServer()
+	defer ts.Close()
+	ts.Inject(goturn.AllocateRequest, AllocationQuotaReached)
+	ts.Inject(goturn.AllocateRequest, InsufficientCapacity)
+
+	// The challenge of the first client is answered with the first fault.
+	credentials := ts.Credentials(Username)
+	if _, err := dial(t, ts).Allocate(&credentials); err == nil || !strings.Contains(err.Error(), "Quota") {
+		t.Errorf("Expected allocation quota error, got %v", err)
+	}
+	credentials = ts.Credentials(Username)
+	if _, err := dial(t, ts).Allocate(&credentials); err == nil || !strings.Contains(err.Error(), "Capacity") {
+		t.Errorf("Expected insufficient capacity error, got %v", err)
+	}
+	credentials = ts.Credentials(Username)
+	if _, err := dial(t, ts).Allocate(&credentials); err != nil {
+		t.Errorf("Allocation failed once faults were applied: %v", err)
+	}
+}
+
+func TestInjectRelayFaults(t *testing.T) {
+	ts := NewServer()
+	defer ts.Close()
+
+	stunClient := dial(t, ts)
+	credentials := ts.Credentials(Username)
+	relayed, err := stunClient.Allocate(&credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := client.NewRelayConn(stunClient, relayed[0])
+	defer relay.Close()
+
+	// Each fault is recovered from by retrying the request.
+	faults := []Fault{StaleNonce, DropResponse, DropRequest}
+	for i, fault := range faults {
+		ts.Inject(goturn.CreatePermissionRequest, fault)
+		peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, byte(2+i)), Port: 9}
+		if _, err := relay.WriteTo([]byte("data"), peer); err != nil {
+			t.Errorf("Fault %d was not recovered from: %v", fault, err)
+		}
+	}
+	if requests := ts.ReceivedOf(goturn.CreatePermissionRequest); len(requests) != 2*len(faults) {
+		t.Errorf("Expected %d CreatePermission requests, received %d", 2*len(faults), len(requests))
+	}
+}
@@ -0,0 +1,181 @@
+package vnet
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// receiveBuffer is how many datagrams a socket holds before dropping more.
+const receiveBuffer = 1024
+
+// datagram is a packet received by a socket.
+type datagram struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// deadline is a read deadline which wakes readers waiting on it when changed.
+type deadline struct {
+	lock    sync.Mutex
+	t       time.Time
+	changed chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{changed: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.t = t
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// wait provides channels which fire when the deadline passes or changes. The
+// returned function releases the timer, and is nil if the deadline has
+// already passed.
+func (d *deadline) wait() (<-chan time.Time, <-chan struct{}, func()) {
+	d.lock.Lock()
+	t, changed := d.t, d.changed
+	d.lock.Unlock()
+	if t.IsZero() {
+		return nil, changed, func() {}
+	}
+	remaining := time.Until(t)
+	if remaining <= 0 {
+		return nil, changed, nil
+	}
+	timer := time.NewTimer(remaining)
+	return timer.C, changed, func() { timer.Stop() }
+}
+
+// packetConn is a UDP socket on a simulated host. A socket created by Dial is
+// also a net.Conn, which only exchanges datagrams with its remote address.
+type packetConn struct {
+	host   *Host
+	local  *net.UDPAddr
+	remote *net.UDPAddr
+	// The key the socket is registered under by the host.
+	key string
+
+	incoming  chan datagram
+	deadline  *deadline
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPacketConn(h *Host, local, remote *net.UDPAddr) *packetConn {
+	return &packetConn{
+		host:     h,
+		local:    local,
+		remote:   remote,
+		incoming: make(chan datagram, receiveBuffer),
+		deadline: newDeadline(),
+		closed:   make(chan struct{}),
+	}
+}
+
+// receive queues a datagram arriving at the socket, dropping it if the buffer
+// of the socket is full.
+func (c *packetConn) receive(data []byte, from *net.UDPAddr) {
+	if c.remote != nil && !(c.remote.IP.Equal(from.IP) && c.remote.Port == from.Port) {
+		return
+	}
+	select {
+	case c.incoming <- datagram{data, from}:
+	default:
+	}
+}
+
+// ReadFrom reads the next datagram received by the socket.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		expired, changed, stop := c.deadline.wait()
+		if stop == nil {
+			return 0, nil, os.ErrDeadlineExceeded
+		}
+		select {
+		case d := <-c.incoming:
+			stop()
+			return copy(b, d.data), d.from, nil
+		case <-expired:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-changed:
+			stop()
+		case <-c.closed:
+			stop()
+			return 0, nil, net.ErrClosed
+		}
+	}
+}
+
+// WriteTo sends a datagram from the socket.
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	dst, err := udpAddress(addr)
+	if err != nil {
+		return 0, err
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	c.host.send(c.local, dst, data)
+	return len(b), nil
+}
+
+// Read reads the next datagram from the remote address of a dialed socket.
+func (c *packetConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+// Write sends a datagram to the remote address of a dialed socket.
+func (c *packetConn) Write(b []byte) (int, error) {
+	if c.remote == nil {
+		return 0, errors.New("Socket is not connected.")
+	}
+	return c.WriteTo(b, c.remote)
+}
+
+// Close closes the socket, releasing its address.
+func (c *packetConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.host.unbind(c)
+	})
+	return nil
+}
+
+func (c *packetConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr provides the remote address of a dialed socket.
+func (c *packetConn) RemoteAddr() net.Addr {
+	if c.remote == nil {
+		return nil
+	}
+	return c.remote
+}
+
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.deadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline has no effect, since writes never block.
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
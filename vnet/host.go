@@ -0,0 +1,204 @@
+package vnet
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// firstEphemeralPort is where ports chosen for sockets bound to port 0 begin.
+const firstEphemeralPort = 49152
+
+// Host is a machine on a Network, with one or more IP addresses, which opens
+// sockets with the same methods as the net package. A host either has public
+// addresses, or private addresses behind a NAT.
+type Host struct {
+	network *Network
+	ips     []net.IP
+	// The NAT the host is behind, if its addresses are private.
+	nat *NAT
+
+	lock      sync.Mutex
+	sockets   map[string]*packetConn
+	listeners map[string]*listener
+	next      int
+}
+
+func newHost(n *Network, nat *NAT, ips []net.IP) (*Host, error) {
+	if len(ips) == 0 {
+		return nil, errors.New("Host has no address.")
+	}
+	return &Host{
+		network:   n,
+		ips:       ips,
+		nat:       nat,
+		sockets:   make(map[string]*packetConn),
+		listeners: make(map[string]*listener),
+		next:      firstEphemeralPort,
+	}, nil
+}
+
+// IPs provides the addresses of the host.
+func (h *Host) IPs() []net.IP {
+	return h.ips
+}
+
+// inbound accepts packets addressed to any IP of the host.
+func (h *Host) inbound(_ string, _, dst *net.UDPAddr) (*Host, *net.UDPAddr, bool) {
+	return h, dst, true
+}
+
+// ListenPacket opens a UDP socket on the host, like net.ListenPacket. The
+// address must be an IP of the host, or unspecified to receive datagrams sent
+// to any of them. Sockets bound to an unspecified address report the first IP
+// of the host as their local address.
+func (h *Host) ListenPacket(network, address string) (net.PacketConn, error) {
+	if !strings.HasPrefix(network, "udp") {
+		return nil, net.UnknownNetworkError(network)
+	}
+	local, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return h.listenPacket(local, nil)
+}
+
+// Dial connects to an address from the host, like net.Dial. UDP connections
+// exchange datagrams with the address alone, and TCP connections are streams
+// accepted by a listener at the address.
+func (h *Host) Dial(network, address string) (net.Conn, error) {
+	remote, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasPrefix(network, "udp"):
+		return h.listenPacket(&net.UDPAddr{IP: h.source(remote.IP)}, remote)
+	case strings.HasPrefix(network, "tcp"):
+		return h.dialStream(remote)
+	}
+	return nil, net.UnknownNetworkError(network)
+}
+
+// Listen opens a TCP listener on the host, like net.Listen.
+func (h *Host) Listen(network, address string) (net.Listener, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		return nil, net.UnknownNetworkError(network)
+	}
+	local, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return h.listen(local)
+}
+
+// source chooses the IP of the host which sends to a destination: the first of
+// the same family.
+func (h *Host) source(dst net.IP) net.IP {
+	for _, ip := range h.ips {
+		if (ip.To4() != nil) == (dst.To4() != nil) {
+			return ip
+		}
+	}
+	return h.ips[0]
+}
+
+// bind validates a local address for a new socket, choosing a port if it has
+// none, and provides the key it is registered under. h.lock must be held.
+func (h *Host) bind(local *net.UDPAddr, used func(key string) bool) (*net.UDPAddr, string, error) {
+	if !local.IP.IsUnspecified() && !h.owns(local.IP) {
+		return nil, "", errors.New("Address not available: " + local.IP.String())
+	}
+	bound := &net.UDPAddr{IP: local.IP, Port: local.Port}
+	if bound.Port == 0 {
+		ephemeral := 65536 - firstEphemeralPort
+		for i := 0; i < ephemeral && bound.Port == 0; i++ {
+			port := firstEphemeralPort + (h.next-firstEphemeralPort+i)%ephemeral
+			if !used(key(bound.IP, port)) && !used(key(net.IPv4zero, port)) {
+				bound.Port = port
+				h.next = port + 1
+			}
+		}
+		if bound.Port == 0 {
+			return nil, "", errors.New("No ports available.")
+		}
+	} else if used(key(bound.IP, bound.Port)) || used(key(net.IPv4zero, bound.Port)) {
+		return nil, "", errors.New("Address already in use: " + bound.String())
+	}
+	k := key(bound.IP, bound.Port)
+	if bound.IP.IsUnspecified() {
+		bound.IP = h.ips[0]
+	}
+	return bound, k, nil
+}
+
+// owns indicates whether an IP is one of the addresses of the host.
+func (h *Host) owns(ip net.IP) bool {
+	for _, own := range h.ips {
+		if own.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// key identifies the socket bound to an address. Unspecified addresses of
+// either family share a key.
+func key(ip net.IP, port int) string {
+	if ip.IsUnspecified() {
+		ip = net.IPv4zero
+	}
+	return (&net.UDPAddr{IP: ip, Port: port}).String()
+}
+
+// send transmits a datagram from a socket of the host, translating its source
+// address if the host is behind a NAT and the destination is not.
+func (h *Host) send(src, dst *net.UDPAddr, data []byte) {
+	var lan *NAT
+	if h.nat != nil {
+		if h.nat.lanHost(dst.IP) != nil {
+			lan = h.nat
+		} else {
+			src = h.nat.outbound("udp", h, src, dst)
+		}
+	}
+	h.network.transmit(packet{"udp", src, dst, data}, lan)
+}
+
+// packetConn finds the socket receiving datagrams sent to an address.
+func (h *Host) packetConn(dst *net.UDPAddr) *packetConn {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if c, ok := h.sockets[key(dst.IP, dst.Port)]; ok {
+		return c
+	}
+	return h.sockets[key(net.IPv4zero, dst.Port)]
+}
+
+// listenPacket opens a UDP socket, which only exchanges datagrams with remote
+// if it is set.
+func (h *Host) listenPacket(local, remote *net.UDPAddr) (*packetConn, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	bound, k, err := h.bind(local, func(k string) bool {
+		_, ok := h.sockets[k]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := newPacketConn(h, bound, remote)
+	c.key = k
+	h.sockets[k] = c
+	return c, nil
+}
+
+// unbind releases the address of a closed socket.
+func (h *Host) unbind(c *packetConn) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.sockets[c.key] == c {
+		delete(h.sockets, c.key)
+	}
+}
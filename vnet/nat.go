@@ -0,0 +1,204 @@
+package vnet
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// firstMappedPort is where the public ports of NAT mappings begin.
+const firstMappedPort = 20000
+
+// Behavior is the way a NAT maps and filters traffic, per RFC 4787.
+type Behavior int
+
+const (
+	// FullCone NATs map each private address to one public port, and forward
+	// anything sent to it.
+	FullCone Behavior = iota
+	// RestrictedCone NATs map each private address to one public port, and
+	// forward traffic from IPs the private address has sent to.
+	RestrictedCone
+	// PortRestrictedCone NATs map each private address to one public port, and
+	// forward traffic from addresses the private address has sent to.
+	PortRestrictedCone
+	// Symmetric NATs map each pair of private and remote addresses to its own
+	// public port, and forward traffic from the remote address alone.
+	Symmetric
+)
+
+func (b Behavior) String() string {
+	switch b {
+	case FullCone:
+		return "Full Cone"
+	case RestrictedCone:
+		return "Restricted Cone"
+	case PortRestrictedCone:
+		return "Port Restricted Cone"
+	case Symmetric:
+		return "Symmetric"
+	}
+	return "Behavior(" + strconv.Itoa(int(b)) + ")"
+}
+
+// NAT translates the private addresses of the hosts behind it to ports on its
+// public IP. Hosts behind the same NAT reach each other directly, and reach
+// each other through the public IP of the NAT by hairpinning.
+type NAT struct {
+	network  *Network
+	ip       net.IP
+	behavior Behavior
+
+	lock    sync.Mutex
+	timeout time.Duration
+	lan     map[string]*Host
+	// Mappings by network, private address and, for symmetric NATs, the remote
+	// address; and by network and public port.
+	private map[string]*mapping
+	public  map[string]*mapping
+	next    int
+}
+
+// mapping is a public port of a NAT, and the private address it forwards to.
+type mapping struct {
+	host     *Host
+	address  *net.UDPAddr
+	port     int
+	keys     [2]string
+	peers    map[string]bool
+	lastUsed time.Time
+}
+
+func newNAT(n *Network, ip net.IP, behavior Behavior) *NAT {
+	return &NAT{
+		network:  n,
+		ip:       ip,
+		behavior: behavior,
+		lan:      make(map[string]*Host),
+		private:  make(map[string]*mapping),
+		public:   make(map[string]*mapping),
+		next:     firstMappedPort,
+	}
+}
+
+// IP provides the public address of the NAT.
+func (g *NAT) IP() net.IP {
+	return g.ip
+}
+
+// Behavior provides how the NAT maps and filters traffic.
+func (g *NAT) Behavior() Behavior {
+	return g.behavior
+}
+
+// SetMappingTimeout sets how long mappings are kept without outbound traffic.
+// Mappings are kept indefinitely by default.
+func (g *NAT) SetMappingTimeout(timeout time.Duration) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.timeout = timeout
+}
+
+// Host adds a host with private IP addresses behind the NAT.
+func (g *NAT) Host(ips ...net.IP) (*Host, error) {
+	h, err := newHost(g.network, g, ips)
+	if err != nil {
+		return nil, err
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, ip := range ips {
+		if _, ok := g.lan[ip.String()]; ok || ip.Equal(g.ip) {
+			return nil, errors.New("Address already in use: " + ip.String())
+		}
+	}
+	for _, ip := range ips {
+		g.lan[ip.String()] = h
+	}
+	return h, nil
+}
+
+// lanHost finds the host behind the NAT with a private IP.
+func (g *NAT) lanHost(ip net.IP) *Host {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.lan[ip.String()]
+}
+
+// outbound translates the source of traffic from a host behind the NAT,
+// creating or refreshing its mapping.
+func (g *NAT) outbound(network string, h *Host, src, dst *net.UDPAddr) *net.UDPAddr {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	privateKey := network + " " + src.String()
+	if g.behavior == Symmetric {
+		privateKey += " " + dst.String()
+	}
+	m := g.private[privateKey]
+	if m != nil && g.expired(m) {
+		g.remove(m)
+		m = nil
+	}
+	if m == nil {
+		m = &mapping{host: h, address: src, peers: make(map[string]bool)}
+		for g.public[network+" "+strconv.Itoa(g.next)] != nil {
+			g.advance()
+		}
+		m.port = g.next
+		g.advance()
+		m.keys = [2]string{privateKey, network + " " + strconv.Itoa(m.port)}
+		g.private[m.keys[0]] = m
+		g.public[m.keys[1]] = m
+	}
+	m.peers[dst.IP.String()] = true
+	m.peers[dst.String()] = true
+	m.lastUsed = time.Now()
+	return &net.UDPAddr{IP: g.ip, Port: m.port}
+}
+
+// inbound translates the destination of traffic to the public IP of the NAT,
+// if it has a mapping for the port which accepts traffic from the source.
+func (g *NAT) inbound(network string, src, dst *net.UDPAddr) (*Host, *net.UDPAddr, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	m := g.public[network+" "+strconv.Itoa(dst.Port)]
+	if m == nil {
+		return nil, nil, false
+	}
+	if g.expired(m) {
+		g.remove(m)
+		return nil, nil, false
+	}
+	switch g.behavior {
+	case RestrictedCone:
+		if !m.peers[src.IP.String()] {
+			return nil, nil, false
+		}
+	case PortRestrictedCone, Symmetric:
+		if !m.peers[src.String()] {
+			return nil, nil, false
+		}
+	}
+	return m.host, m.address, true
+}
+
+// expired indicates whether a mapping has timed out. g.lock must be held.
+func (g *NAT) expired(m *mapping) bool {
+	return g.timeout > 0 && time.Since(m.lastUsed) > g.timeout
+}
+
+// remove deletes a mapping. g.lock must be held.
+func (g *NAT) remove(m *mapping) {
+	delete(g.private, m.keys[0])
+	delete(g.public, m.keys[1])
+}
+
+// advance moves to the next public port. g.lock must be held.
+func (g *NAT) advance() {
+	g.next++
+	if g.next > 65535 {
+		g.next = firstMappedPort
+	}
+}
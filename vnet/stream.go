@@ -0,0 +1,265 @@
+package vnet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// listenBacklog is how many streams a listener holds before refusing more.
+const listenBacklog = 16
+
+// listener accepts TCP streams on a simulated host.
+type listener struct {
+	host    *Host
+	local   *net.UDPAddr
+	key     string
+	accepts chan *streamConn
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// listen opens a TCP listener.
+func (h *Host) listen(local *net.UDPAddr) (*listener, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	bound, k, err := h.bind(local, func(k string) bool {
+		_, ok := h.listeners[k]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{
+		host:    h,
+		local:   bound,
+		key:     k,
+		accepts: make(chan *streamConn, listenBacklog),
+		closed:  make(chan struct{}),
+	}
+	h.listeners[k] = l
+	return l, nil
+}
+
+// listener finds the listener accepting streams at an address.
+func (h *Host) listener(dst *net.UDPAddr) *listener {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if l, ok := h.listeners[key(dst.IP, dst.Port)]; ok {
+		return l
+	}
+	return h.listeners[key(net.IPv4zero, dst.Port)]
+}
+
+// dialStream opens a TCP stream to a listener. Streams are translated by NATs
+// like datagrams, and are refused if there is no listener at the address or a
+// NAT filters them.
+func (h *Host) dialStream(remote *net.UDPAddr) (net.Conn, error) {
+	h.lock.Lock()
+	local, _, err := h.bind(&net.UDPAddr{IP: h.source(remote.IP)}, func(k string) bool {
+		_, ok := h.listeners[k]
+		return ok
+	})
+	h.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	src, lan := local, (*NAT)(nil)
+	if h.nat != nil {
+		if h.nat.lanHost(remote.IP) != nil {
+			lan = h.nat
+		} else {
+			src = h.nat.outbound("tcp", h, local, remote)
+		}
+	}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Addr: tcpAddress(remote), Err: errors.New("Connection refused")}
+	peer, dst, ok := h.network.resolve(packet{"tcp", src, remote, nil}, lan)
+	if !ok {
+		return nil, refused
+	}
+	l := peer.listener(dst)
+	if l == nil {
+		return nil, refused
+	}
+
+	outgoing, incoming := newPipe(), newPipe()
+	client := &streamConn{local: tcpAddress(local), remote: tcpAddress(remote), in: incoming, out: outgoing, deadline: newDeadline()}
+	server := &streamConn{local: tcpAddress(dst), remote: tcpAddress(src), in: outgoing, out: incoming, deadline: newDeadline()}
+	select {
+	case <-l.closed:
+		return nil, refused
+	default:
+	}
+	select {
+	case l.accepts <- server:
+		return client, nil
+	default:
+		return nil, refused
+	}
+}
+
+// Accept waits for the next stream opened to the listener.
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accepts:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener, releasing its address.
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.host.lock.Lock()
+		if l.host.listeners[l.key] == l {
+			delete(l.host.listeners, l.key)
+		}
+		l.host.lock.Unlock()
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return tcpAddress(l.local)
+}
+
+// pipe carries the bytes of one direction of a stream.
+type pipe struct {
+	lock    sync.Mutex
+	buffer  bytes.Buffer
+	closed  bool
+	changed chan struct{}
+}
+
+func newPipe() *pipe {
+	return &pipe{changed: make(chan struct{})}
+}
+
+// notify wakes readers waiting on the pipe. p.lock must be held.
+func (p *pipe) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *pipe) close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.closed {
+		p.closed = true
+		p.notify()
+	}
+}
+
+// streamConn is one end of a TCP stream between simulated hosts.
+type streamConn struct {
+	local, remote net.Addr
+	in, out       *pipe
+	deadline      *deadline
+	closeOnce     sync.Once
+	closed        bool
+	lock          sync.Mutex
+}
+
+// Read reads bytes written by the other end of the stream, returning io.EOF
+// once it has been closed.
+func (c *streamConn) Read(b []byte) (int, error) {
+	for {
+		c.lock.Lock()
+		closed := c.closed
+		c.lock.Unlock()
+		if closed {
+			return 0, net.ErrClosed
+		}
+
+		c.in.lock.Lock()
+		if c.in.buffer.Len() > 0 {
+			n, _ := c.in.buffer.Read(b)
+			c.in.lock.Unlock()
+			return n, nil
+		}
+		if c.in.closed {
+			c.in.lock.Unlock()
+			return 0, io.EOF
+		}
+		ready := c.in.changed
+		c.in.lock.Unlock()
+
+		expired, changed, stop := c.deadline.wait()
+		if stop == nil {
+			return 0, os.ErrDeadlineExceeded
+		}
+		select {
+		case <-ready:
+		case <-changed:
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		}
+		stop()
+	}
+}
+
+// Write sends bytes to the other end of the stream. Writes never block.
+func (c *streamConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	closed := c.closed
+	c.lock.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	c.out.lock.Lock()
+	defer c.out.lock.Unlock()
+	if c.out.closed {
+		return 0, io.ErrClosedPipe
+	}
+	c.out.buffer.Write(b)
+	c.out.notify()
+	return len(b), nil
+}
+
+// Close closes both directions of the stream.
+func (c *streamConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		c.closed = true
+		c.lock.Unlock()
+		c.out.close()
+		c.in.close()
+		c.deadline.set(time.Time{})
+	})
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	c.deadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline has no effect, since writes never block.
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// tcpAddress converts an address to a TCP address.
+func tcpAddress(addr *net.UDPAddr) *net.TCPAddr {
+	return &net.TCPAddr{IP: addr.IP, Port: addr.Port}
+}
@@ -0,0 +1,281 @@
+// Package vnet simulates networks in memory, so that STUN and TURN clients and
+// servers can be tested without a real network. Hosts on a Network exchange
+// datagrams and streams through net.PacketConn, net.Conn and net.Listener
+// endpoints, either directly or through NATs with the mapping and filtering
+// behaviors described by RFC 4787, over links which may lose, delay and reorder
+// datagrams.
+//
+//	network := vnet.New()
+//	public, _ := network.Host(net.IPv4(198, 51, 100, 1))
+//	conn, _ := public.ListenPacket("udp", "198.51.100.1:3478")
+//	go server.NewServer().Serve(conn)
+//
+//	nat, _ := network.NAT(net.IPv4(203, 0, 113, 1), vnet.Symmetric)
+//	private, _ := nat.Host(net.IPv4(10, 0, 0, 2))
+//	c, _ := private.Dial("udp", "198.51.100.1:3478")
+//	address, _ := (&client.StunClient{Conn: c}).Bind()
+package vnet
+
+import (
+	"container/heap"
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Conditions describe the impairments of the links of a Network, which apply
+// to datagrams. Streams are reliable, and are not delayed.
+type Conditions struct {
+	// The fraction of datagrams lost, from 0 to 1.
+	Loss float64
+	// The delay of every datagram.
+	Latency time.Duration
+	// The most extra delay of each datagram, chosen at random. Datagrams which
+	// are delayed by different amounts arrive out of order.
+	Jitter time.Duration
+}
+
+// Network is a simulated network, joining hosts with public addresses and the
+// NATs which hide hosts with private addresses. A Network is created with New.
+type Network struct {
+	lock       sync.Mutex
+	conditions Conditions
+	random     *rand.Rand
+	// Public hosts and NATs, by each of their IPs.
+	nodes map[string]node
+
+	// Datagrams in flight, by the time they arrive.
+	queue    deliveries
+	sequence uint64
+	running  bool
+	wake     chan struct{}
+}
+
+// node is a destination on the public network: a host or a NAT.
+type node interface {
+	// inbound resolves the host a packet to dst is received by, and the address
+	// it is received at, or false if the packet is dropped.
+	inbound(network string, src, dst *net.UDPAddr) (*Host, *net.UDPAddr, bool)
+}
+
+// packet is a datagram, or a stream being opened, between two addresses.
+type packet struct {
+	network  string
+	src, dst *net.UDPAddr
+	data     []byte
+}
+
+// New creates an empty network without impairments. Random choices, such as
+// which datagrams are lost, are repeated from one run to the next unless the
+// network is given a different Seed.
+func New() *Network {
+	return &Network{
+		random: rand.New(rand.NewSource(1)),
+		nodes:  make(map[string]node),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Seed sets the source of the random choices made by the network.
+func (n *Network) Seed(seed int64) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.random = rand.New(rand.NewSource(seed))
+}
+
+// SetConditions changes the impairments of the links of the network, for
+// datagrams sent from then on.
+func (n *Network) SetConditions(conditions Conditions) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.conditions = conditions
+}
+
+// Host adds a host with public IP addresses to the network.
+func (n *Network) Host(ips ...net.IP) (*Host, error) {
+	h, err := newHost(n, nil, ips)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.attach(h, ips...); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// NAT adds a NAT with a public IP address to the network, behind which hosts
+// with private addresses can be added.
+func (n *Network) NAT(ip net.IP, behavior Behavior) (*NAT, error) {
+	g := newNAT(n, ip, behavior)
+	if err := n.attach(g, ip); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// attach makes a node reachable at public IPs.
+func (n *Network) attach(node node, ips ...net.IP) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for _, ip := range ips {
+		if _, ok := n.nodes[ip.String()]; ok {
+			return errors.New("Address already in use: " + ip.String())
+		}
+	}
+	for _, ip := range ips {
+		n.nodes[ip.String()] = node
+	}
+	return nil
+}
+
+// resolve finds the host a packet is received by. Packets between hosts behind
+// the same NAT, lan, are exchanged without passing through it.
+func (n *Network) resolve(p packet, lan *NAT) (*Host, *net.UDPAddr, bool) {
+	if lan != nil {
+		if h := lan.lanHost(p.dst.IP); h != nil {
+			return h, p.dst, true
+		}
+	}
+	n.lock.Lock()
+	node, ok := n.nodes[p.dst.IP.String()]
+	n.lock.Unlock()
+	if !ok {
+		return nil, nil, false
+	}
+	return node.inbound(p.network, p.src, p.dst)
+}
+
+// transmit sends a datagram across the network, subject to its conditions.
+func (n *Network) transmit(p packet, lan *NAT) {
+	n.lock.Lock()
+	c := n.conditions
+	if c.Loss > 0 && n.random.Float64() < c.Loss {
+		n.lock.Unlock()
+		return
+	}
+	delay := c.Latency
+	if c.Jitter > 0 {
+		delay += time.Duration(n.random.Int63n(int64(c.Jitter)))
+	}
+	if delay <= 0 && len(n.queue) == 0 {
+		n.lock.Unlock()
+		n.deliver(p, lan)
+		return
+	}
+	n.sequence++
+	heap.Push(&n.queue, &delivery{time.Now().Add(delay), n.sequence, p, lan})
+	if !n.running {
+		n.running = true
+		go n.run()
+	}
+	n.lock.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers datagrams in flight as they arrive, until none are left.
+func (n *Network) run() {
+	for {
+		n.lock.Lock()
+		if len(n.queue) == 0 {
+			n.running = false
+			n.lock.Unlock()
+			return
+		}
+		next := n.queue[0]
+		wait := time.Until(next.arrival)
+		if wait <= 0 {
+			heap.Pop(&n.queue)
+			n.lock.Unlock()
+			n.deliver(next.packet, next.lan)
+			continue
+		}
+		n.lock.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-n.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// deliver hands a datagram to the socket it is received by, if there is one.
+func (n *Network) deliver(p packet, lan *NAT) {
+	h, dst, ok := n.resolve(p, lan)
+	if !ok {
+		return
+	}
+	if c := h.packetConn(dst); c != nil {
+		c.receive(p.data, p.src)
+	}
+}
+
+// delivery is a datagram in flight.
+type delivery struct {
+	arrival  time.Time
+	sequence uint64
+	packet   packet
+	lan      *NAT
+}
+
+// deliveries orders datagrams in flight by when they arrive, and otherwise by
+// when they were sent.
+type deliveries []*delivery
+
+func (d deliveries) Len() int { return len(d) }
+
+func (d deliveries) Less(i, j int) bool {
+	if d[i].arrival.Equal(d[j].arrival) {
+		return d[i].sequence < d[j].sequence
+	}
+	return d[i].arrival.Before(d[j].arrival)
+}
+
+func (d deliveries) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+
+func (d *deliveries) Push(x interface{}) { *d = append(*d, x.(*delivery)) }
+
+func (d *deliveries) Pop() interface{} {
+	old := *d
+	x := old[len(old)-1]
+	*d = old[0 : len(old)-1]
+	return x
+}
+
+// parseAddress reads an address of the form "ip:port", where the IP may be
+// omitted. Host names are not resolved.
+func parseAddress(address string) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 0 || number > 65535 {
+		return nil, errors.New("Invalid port: " + port)
+	}
+	ip := net.IPv4zero
+	if host != "" {
+		if ip = net.ParseIP(host); ip == nil {
+			return nil, errors.New("Unknown host: " + host)
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: number}, nil
+}
+
+// udpAddress converts the address of a peer to a UDP address.
+func udpAddress(addr net.Addr) (*net.UDPAddr, error) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a, nil
+	case *net.TCPAddr:
+		return &net.UDPAddr{IP: a.IP, Port: a.Port}, nil
+	}
+	return parseAddress(addr.String())
+}
@@ -0,0 +1,460 @@
+package vnet
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/willscott/goturn"
+	"github.com/willscott/goturn/client"
+	common "github.com/willscott/goturn/common"
+	"github.com/willscott/goturn/server"
+	"github.com/willscott/goturn/stun"
+	"github.com/willscott/goturn/turn"
+)
+
+var (
+	serverIP    = net.IPv4(198, 51, 100, 1)
+	alternateIP = net.IPv4(198, 51, 100, 2)
+	peerIP      = net.IPv4(192, 0, 2, 1)
+	natIP       = net.IPv4(203, 0, 113, 1)
+	privateIP   = net.IPv4(10, 0, 0, 2)
+)
+
+// behaviorServer serves RFC 5780 behavior discovery from a public host with
+// two addresses, on ports 3478 and 3479.
+func behaviorServer(t *testing.T, n *Network) *server.Server {
+	h, err := n.Host(serverIP, alternateIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns [2][2]net.PacketConn
+	for i, ip := range []net.IP{serverIP, alternateIP} {
+		for p, port := range []int{3478, 3479} {
+			address := &net.UDPAddr{IP: ip, Port: port}
+			if conns[i][p], err = h.ListenPacket("udp", address.String()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	s := server.NewServer()
+	go s.ServeBehavior(conns)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// natHost creates a host behind a NAT with behavior.
+func natHost(t *testing.T, n *Network, behavior Behavior) *Host {
+	nat, err := n.NAT(natIP, behavior)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := nat.Host(privateIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestBindThroughNAT(t *testing.T) {
+	n := New()
+	behaviorServer(t, n)
+	h := natHost(t, n, Symmetric)
+
+	c, err := h.Dial("udp", "198.51.100.1:3478")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !c.LocalAddr().(*net.UDPAddr).IP.Equal(privateIP) {
+		t.Errorf("Unexpected local address %s", c.LocalAddr())
+	}
+	stunClient := &client.StunClient{Conn: c, Timeout: time.Second}
+	mapped, err := stunClient.Bind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, _, _ := net.SplitHostPort(mapped.String()); host != natIP.String() {
+		t.Errorf("Mapped to %s rather than the address of the NAT", mapped)
+	}
+}
+
+func TestNATBehaviorDiscovery(t *testing.T) {
+	expected := map[Behavior][2]interface{}{
+		FullCone:           {client.MappingEndpointIndependent, client.FilteringEndpointIndependent},
+		RestrictedCone:     {client.MappingEndpointIndependent, client.FilteringAddressDependent},
+		PortRestrictedCone: {client.MappingEndpointIndependent, client.FilteringAddressPortDependent},
+		Symmetric:          {client.MappingAddressPortDependent, client.FilteringAddressPortDependent},
+	}
+	for behavior, want := range expected {
+		n := New()
+		behaviorServer(t, n)
+		h := natHost(t, n, behavior)
+		conn, err := h.ListenPacket("udp", "0.0.0.0:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		discovered, err := client.DiscoverNATBehavior(conn, &net.UDPAddr{IP: serverIP, Port: 3478}, 100*time.Millisecond)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", behavior, err)
+		}
+		if discovered.Mapping != want[0] || discovered.Filtering != want[1] {
+			t.Errorf("%s NAT discovered as %s mapping, %s filtering", behavior, discovered.Mapping, discovered.Filtering)
+		}
+	}
+}
+
+func TestNATTimeout(t *testing.T) {
+	n := New()
+	peer, err := n.Host(peerIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo, err := peer.ListenPacket("udp", "192.0.2.1:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+
+	nat, err := n.NAT(natIP, FullCone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nat.SetMappingTimeout(20 * time.Millisecond)
+	h, err := nat.Host(privateIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := h.Dial("udp", "192.0.2.1:7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	buffer := make([]byte, 16)
+	c.Write([]byte("hello"))
+	_, from, err := echo.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	echo.WriteTo([]byte("late"), from)
+	c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := c.Read(buffer); err == nil {
+		t.Error("Received data through an expired mapping")
+	}
+}
+
+func TestConditions(t *testing.T) {
+	n := New()
+	a, err := n.Host(serverIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := n.Host(peerIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := b.ListenPacket("udp", ":9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	sender, err := a.Dial("udp", "192.0.2.1:9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	// Every datagram is delayed, and some overtake others.
+	n.SetConditions(Conditions{Latency: 10 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	start := time.Now()
+	for i := 0; i < 50; i++ {
+		sender.Write([]byte{byte(i)})
+	}
+	receiver.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 1)
+	reordered := false
+	for i := 0; i < 50; i++ {
+		if _, _, err := receiver.ReadFrom(buffer); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && time.Since(start) < 10*time.Millisecond {
+			t.Error("Datagram arrived before the latency of the network")
+		}
+		if int(buffer[0]) != i {
+			reordered = true
+		}
+	}
+	if !reordered {
+		t.Error("No datagrams were reordered by jitter")
+	}
+
+	// Datagrams are all lost.
+	n.SetConditions(Conditions{Loss: 1})
+	sender.Write([]byte{0})
+	receiver.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, _, err := receiver.ReadFrom(buffer); err == nil {
+		t.Error("Received a datagram on a lossy network")
+	}
+}
+
+func TestStreamThroughNAT(t *testing.T) {
+	n := New()
+	peer, err := n.Host(peerIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := peer.Listen("tcp", ":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	h := natHost(t, n, PortRestrictedCone)
+
+	c, err := h.Dial("tcp", "192.0.2.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !accepted.RemoteAddr().(*net.TCPAddr).IP.Equal(natIP) {
+		t.Errorf("Stream accepted from %s rather than the NAT", accepted.RemoteAddr())
+	}
+	c.Write([]byte("hello"))
+	c.Close()
+	data, err := io.ReadAll(accepted)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Unexpected stream data %q: %v", data, err)
+	}
+
+	// Streams cannot be opened to hosts behind the NAT.
+	if _, err := h.Listen("tcp", ":80"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.Dial("tcp", "203.0.113.1:80"); err == nil {
+		t.Error("Opened a stream through a NAT")
+	}
+}
+
+func TestRelayThroughNAT(t *testing.T) {
+	n := New()
+	public, err := n.Host(serverIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := public.ListenPacket("udp", "198.51.100.1:3478")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server.Server{
+		ListenPacket: public.ListenPacket,
+		Auth: func(username string) (string, bool) {
+			return "pass", username == "user"
+		},
+	}
+	go s.Serve(conn)
+	defer s.Close()
+
+	peerHost, err := n.Host(peerIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := peerHost.ListenPacket("udp", ":5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	h := natHost(t, n, Symmetric)
+	control, err := h.Dial("udp", "198.51.100.1:3478")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stunClient := &client.StunClient{Conn: control, Timeout: time.Second}
+	credentials := client.LongtermCredentials("user", "pass")
+	relayed, err := stunClient.Allocate(&credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := client.NewRelayConn(stunClient, relayed[0])
+	defer relay.Close()
+
+	if _, err := relay.WriteTo([]byte("ping"), peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 16)
+	count, from, err := peer.ReadFrom(buffer)
+	if err != nil || string(buffer[0:count]) != "ping" {
+		t.Fatalf("Peer did not receive relayed data: %v", err)
+	}
+	if from.String() != relayed[0].String() {
+		t.Errorf("Data relayed from %s rather than %s", from, relayed[0])
+	}
+	peer.WriteTo([]byte("pong"), from)
+	relay.SetReadDeadline(time.Now().Add(time.Second))
+	count, _, err = relay.ReadFrom(buffer)
+	if err != nil || string(buffer[0:count]) != "pong" {
+		t.Errorf("Did not receive data from peer: %v", err)
+	}
+}
+
+// readMessage reads a message from a stream, without verifying its integrity.
+func readMessage(c net.Conn) (*common.Message, error) {
+	data := make([]byte, 20)
+	if _, err := io.ReadFull(c, data); err != nil {
+		return nil, err
+	}
+	data = append(data, make([]byte, binary.BigEndian.Uint16(data[2:]))...)
+	if _, err := io.ReadFull(c, data[20:]); err != nil {
+		return nil, err
+	}
+	attrs := turn.AttributeSet()
+	delete(attrs, stun.MessageIntegrity)
+	return common.Parse(data, nil, attrs)
+}
+
+// tcpTurnServer answers the RFC 6062 requests of a TurnDialer on streams to
+// the public address of the server, relaying each connection it binds to a
+// stream opened to the peer. Requests are challenged for a nonce, but are
+// otherwise not authenticated.
+func tcpTurnServer(t *testing.T, n *Network) {
+	h, err := n.Host(serverIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := h.Listen("tcp", "198.51.100.1:3478")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var lock sync.Mutex
+	peers := make(map[uint32]string)
+	serve := func(c net.Conn) {
+		defer c.Close()
+		for {
+			request, err := readMessage(c)
+			if err != nil {
+				return
+			}
+			var response *goturn.MessageBuilder
+			switch {
+			case len(request.Credentials.Nonce) == 0:
+				response = goturn.NewErrorResponseBuilder(request.Header, 401, "Unauthorized").
+					Credentials(common.Credentials{Realm: "vnet", Nonce: []byte("nonce")}).
+					Attribute(&stun.RealmAttribute{}).
+					Attribute(&stun.NonceAttribute{})
+			case request.Header.Type == goturn.AllocateRequest:
+				response = goturn.NewResponseBuilder(request.Header).
+					XorRelayedAddress(&net.TCPAddr{IP: serverIP, Port: 50000})
+			case request.Header.Type == goturn.CreatePermissionRequest:
+				response = goturn.NewResponseBuilder(request.Header)
+			case request.Header.Type == goturn.ConnectRequest:
+				peer, _ := turn.GetXorPeerAddress(request)
+				lock.Lock()
+				id := uint32(len(peers) + 1)
+				peers[id] = peer.String()
+				lock.Unlock()
+				response = goturn.NewResponseBuilder(request.Header).ConnectionId(id)
+			case request.Header.Type == goturn.ConnectionBindRequest:
+				id, _ := turn.GetConnectionId(request)
+				lock.Lock()
+				peer := peers[id]
+				lock.Unlock()
+				relayed, err := h.Dial("tcp", peer)
+				if err != nil {
+					return
+				}
+				defer relayed.Close()
+				msg, _ := goturn.NewResponseBuilder(request.Header).Build()
+				data, _ := msg.Serialize()
+				c.Write(data)
+				go io.Copy(relayed, c)
+				io.Copy(c, relayed)
+				return
+			default:
+				return
+			}
+			msg, err := response.Build()
+			if err != nil {
+				return
+			}
+			data, err := msg.Serialize()
+			if err != nil {
+				return
+			}
+			c.Write(data)
+		}
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c)
+		}
+	}()
+}
+
+func TestTurnDialerThroughNAT(t *testing.T) {
+	n := New()
+	tcpTurnServer(t, n)
+	peerHost, err := n.Host(peerIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := peerHost.Listen("tcp", ":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	h := natHost(t, n, Symmetric)
+	control, err := h.Dial("tcp", "198.51.100.1:3478")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer control.Close()
+	credentials := client.LongtermCredentials("user", "pass")
+	dialer, err := client.NewDialer(&credentials, control)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Connections for data are opened through the virtual network too.
+	dialer.Dialer = h
+	dialer.Timeout = time.Second
+
+	c, err := dialer.Dial("tcp", "192.0.2.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	if !accepted.RemoteAddr().(*net.TCPAddr).IP.Equal(serverIP) {
+		t.Errorf("Stream accepted from %s rather than the relay", accepted.RemoteAddr())
+	}
+
+	c.Write([]byte("ping"))
+	buffer := make([]byte, 4)
+	if _, err := io.ReadFull(accepted, buffer); err != nil || string(buffer) != "ping" {
+		t.Fatalf("Peer did not receive relayed data %q: %v", buffer, err)
+	}
+	accepted.Write([]byte("pong"))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(c, buffer); err != nil || string(buffer) != "pong" {
+		t.Errorf("Did not receive data from peer %q: %v", buffer, err)
+	}
+}